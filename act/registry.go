@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"sync"
 	"time"
 
 	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
@@ -38,6 +39,9 @@ type Registry struct {
 	DefaultPolicyName string
 	DefaultPolicy     *sdkAct.Policy
 	DefaultSignal     *sdkAct.Signal
+
+	// mu guards the policies and the default policy, which are replaced by ReloadPolicies.
+	mu *sync.RWMutex
 }
 
 type AsyncActionMessage struct {
@@ -116,6 +120,7 @@ func NewActRegistry(
 		DefaultPolicy:        registry.Policies[registry.DefaultPolicyName],
 		DefaultSignal:        registry.Signals[registry.DefaultPolicyName],
 		TaskPublisher:        registry.TaskPublisher,
+		mu:                   &sync.RWMutex{},
 	}
 }
 
@@ -126,6 +131,9 @@ func (r *Registry) Add(policy *sdkAct.Policy) {
 		return
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if _, exists := r.Policies[policy.Name]; exists {
 		r.Logger.Warn().Str("name", policy.Name).Msg("Policy already exists, overwriting")
 	}
@@ -134,12 +142,45 @@ func (r *Registry) Add(policy *sdkAct.Policy) {
 	r.Policies[policy.Name] = policy
 }
 
+// ReloadPolicies replaces the policies with the builtin ones and the given ones, and sets the
+// default policy, e.g. on reloading the plugin configuration. The default policy falls back to
+// passthrough if it doesn't exist, like in NewActRegistry.
+func (r *Registry) ReloadPolicies(policies []*sdkAct.Policy, defaultPolicyName string) {
+	reloaded := BuiltinPolicies()
+	for _, policy := range policies {
+		if policy == nil {
+			r.Logger.Warn().Msg("Policy is nil, not adding")
+			continue
+		}
+		reloaded[policy.Name] = policy
+	}
+
+	if _, exists := reloaded[defaultPolicyName]; !exists || defaultPolicyName == "" {
+		r.Logger.Warn().Str("name", defaultPolicyName).Msgf(
+			"The specified default policy does not exist, using %s", config.DefaultPolicy)
+		defaultPolicyName = config.DefaultPolicy
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Policies = reloaded
+	r.DefaultPolicy = reloaded[defaultPolicyName]
+	r.DefaultSignal = r.Signals[defaultPolicyName]
+	r.Logger.Info().Fields(
+		map[string]interface{}{
+			"policies":      len(reloaded),
+			"defaultPolicy": defaultPolicyName,
+		},
+	).Msg("Reloaded the policies")
+}
+
 // Apply applies the signals to the registry and returns the outputs.
 func (r *Registry) Apply(signals []sdkAct.Signal, hook sdkAct.Hook) []*sdkAct.Output {
 	// If there are no signals, apply the default policy.
 	if len(signals) == 0 {
 		r.Logger.Debug().Msg("No signals provided, applying default signal")
-		return r.Apply([]sdkAct.Signal{*r.DefaultSignal}, hook)
+		return r.Apply([]sdkAct.Signal{r.defaultSignal()}, hook)
 	}
 
 	// Separate terminal and non-terminal signals to find contradictions.
@@ -183,12 +224,20 @@ func (r *Registry) Apply(signals []sdkAct.Signal, hook sdkAct.Hook) []*sdkAct.Ou
 	}
 
 	if len(outputs) == 0 && !evalErr {
-		return r.Apply([]sdkAct.Signal{*r.DefaultSignal}, hook)
+		return r.Apply([]sdkAct.Signal{r.defaultSignal()}, hook)
 	}
 
 	return outputs
 }
 
+// defaultSignal returns the signal of the default policy.
+func (r *Registry) defaultSignal() sdkAct.Signal {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return *r.DefaultSignal
+}
+
 // apply applies the signal to the registry and returns the output.
 func (r *Registry) apply(
 	signal sdkAct.Signal, hook sdkAct.Hook,
@@ -198,7 +247,9 @@ func (r *Registry) apply(
 		return nil, gerr.ErrActionNotMatched
 	}
 
+	r.mu.RLock()
 	policy, exists := r.Policies[action.Name]
+	r.mu.RUnlock()
 	if !exists {
 		return nil, gerr.ErrPolicyNotMatched
 	}
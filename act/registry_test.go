@@ -186,6 +186,35 @@ func Test_Add_ExistentPolicy(t *testing.T) {
 	assert.Contains(t, buf.String(), "Policy already exists, overwriting")
 }
 
+// Test_ReloadPolicies tests that the policies are replaced by the builtin ones and the given ones,
+// and that the default policy falls back to passthrough if it doesn't exist.
+func Test_ReloadPolicies(t *testing.T) {
+	actRegistry := NewActRegistry(
+		Registry{
+			Signals:              BuiltinSignals(),
+			Policies:             BuiltinPolicies(),
+			Actions:              BuiltinActions(),
+			DefaultPolicyName:    config.DefaultPolicy,
+			PolicyTimeout:        config.DefaultPolicyTimeout,
+			DefaultActionTimeout: config.DefaultActionTimeout,
+			Logger:               zerolog.Logger{},
+		})
+	require.NotNil(t, actRegistry)
+	actRegistry.Add(&sdkAct.Policy{Name: "old-policy", Policy: "true"})
+
+	actRegistry.ReloadPolicies(
+		[]*sdkAct.Policy{{Name: "new-policy", Policy: "true"}, nil}, "terminate")
+	assert.Len(t, actRegistry.Policies, len(BuiltinPolicies())+1)
+	assert.Nil(t, actRegistry.Policies["old-policy"])
+	assert.NotNil(t, actRegistry.Policies["new-policy"])
+	assert.Equal(t, "terminate", actRegistry.DefaultPolicy.Name)
+	assert.Equal(t, "terminate", actRegistry.DefaultSignal.Name)
+
+	actRegistry.ReloadPolicies(nil, "non-existent")
+	assert.Len(t, actRegistry.Policies, len(BuiltinPolicies()))
+	assert.Equal(t, config.DefaultPolicy, actRegistry.DefaultPolicy.Name)
+}
+
 // Test_Apply tests the Apply function of the act registry.
 func Test_Apply(t *testing.T) {
 	actRegistry := NewActRegistry(
@@ -5,6 +5,9 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"net"
+	"sync"
+	"time"
 
 	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
 	v1 "github.com/gatewayd-io/gatewayd/api/v1"
@@ -27,6 +30,26 @@ type Options struct {
 	GRPCAddress string
 	HTTPAddress string
 	Servers     map[string]*network.Server
+	// Events are served on /events by the HTTP API.
+	Events *Events
+	// Reload reloads the configuration on POST /reload, if set.
+	Reload func() (*ReloadResult, error)
+	// Drain drains the servers and then shuts GatewayD down on POST /drain, if set.
+	// It returns false if the servers are already draining.
+	Drain func() bool
+	// HTTPListener and GRPCListener are taken over from the GatewayD process that is upgraded,
+	// if set, and the APIs are served on them instead of listening on the addresses.
+	HTTPListener net.Listener
+	GRPCListener net.Listener
+}
+
+// ReloadResult is the outcome of reloading the configuration.
+type ReloadResult struct {
+	// Applied are the changes that are applied to the running gateway.
+	Applied []string `json:"applied"`
+	// RestartRequired are the configuration sections whose changes are ignored until
+	// GatewayD is restarted, e.g. the addresses of the servers.
+	RestartRequired []string `json:"restartRequired"`
 }
 
 type API struct {
@@ -41,6 +64,24 @@ type API struct {
 	Pools          map[string]map[string]*pool.Pool
 	Proxies        map[string]map[string]*network.Proxy
 	Servers        map[string]*network.Server
+
+	// mu guards the configuration, the pools and the proxies, which are replaced by Reload.
+	mu sync.RWMutex
+}
+
+// Reload replaces the configuration, the pools and the proxies that are served by the API,
+// once the configuration is reloaded.
+func (a *API) Reload(
+	conf *config.Config,
+	pools map[string]map[string]*pool.Pool,
+	proxies map[string]map[string]*network.Proxy,
+) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.Config = conf
+	a.Pools = pools
+	a.Proxies = proxies
 }
 
 // Version returns the version information of the GatewayD.
@@ -62,6 +103,9 @@ func (a *API) GetGlobalConfig(_ context.Context, group *v1.Group) (*structpb.Str
 	_, span := otel.Tracer(config.TracerName).Start(a.ctx, "Getting Global Config")
 	defer span.End()
 
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	var (
 		jsonData []byte
 		global   map[string]interface{}
@@ -118,6 +162,9 @@ func (a *API) GetPluginConfig(context.Context, *emptypb.Empty) (*structpb.Struct
 	_, span := otel.Tracer(config.TracerName).Start(a.ctx, "Get GetPlugin Config")
 	defer span.End()
 
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	jsonData, err := json.Marshal(a.Config.Plugin)
 	if err != nil {
 		metrics.APIRequestsErrors.WithLabelValues(
@@ -205,6 +252,9 @@ func (a *API) GetPools(context.Context, *emptypb.Empty) (*structpb.Struct, error
 	_, span := otel.Tracer(config.TracerName).Start(a.ctx, "Get Pools")
 	defer span.End()
 
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	pools := make(map[string]any)
 
 	for configGroupName, configGroupPools := range a.Pools {
@@ -236,6 +286,9 @@ func (a *API) GetProxies(context.Context, *emptypb.Empty) (*structpb.Struct, err
 	_, span := otel.Tracer(config.TracerName).Start(a.ctx, "Get Proxies")
 	defer span.End()
 
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	// Create a new map to hold the flattened proxies data
 	proxies := make(map[string]any)
 
@@ -253,11 +306,40 @@ func (a *API) GetProxies(context.Context, *emptypb.Empty) (*structpb.Struct, err
 				busy = append(busy, conn)
 			}
 
-			groupProxies[name] = map[string]any{
+			proxyInfo := map[string]any{
 				"available": available,
 				"busy":      busy,
 				"total":     len(available) + len(busy),
+				"healthy":   proxy.IsBackendHealthy(),
+			}
+			if proxy.HealthCheck != nil {
+				health := proxy.HealthCheck.Status()
+				lastCheck := ""
+				if !health.LastCheck.IsZero() {
+					lastCheck = health.LastCheck.Format(time.RFC3339)
+				}
+				proxyInfo["healthCheck"] = map[string]any{
+					"method":               proxy.HealthCheck.Method,
+					"consecutiveFailures":  health.ConsecutiveFailures,
+					"consecutiveSuccesses": health.ConsecutiveSuccesses,
+					"lastCheck":            lastCheck,
+					"lastError":            health.LastError,
+				}
+			}
+			if proxy.RoleMonitor != nil {
+				role := proxy.RoleMonitor.Status()
+				lastCheck := ""
+				if !role.LastCheck.IsZero() {
+					lastCheck = role.LastCheck.Format(time.RFC3339)
+				}
+				proxyInfo["roleMonitor"] = map[string]any{
+					"role":       role.Role,
+					"onFailover": proxy.RoleMonitor.OnFailover,
+					"lastCheck":  lastCheck,
+					"lastError":  role.LastError,
+				}
 			}
+			groupProxies[name] = proxyInfo
 		}
 
 		proxies[configGroupName] = groupProxies
@@ -64,6 +64,7 @@ func getAPIConfig() *API {
 			HTTPAddress: "localhost:18080",
 			Logger:      logger,
 			Servers:     servers,
+			Events:      NewEvents(),
 		},
 		Config: config.NewConfig(
 			context.Background(),
@@ -238,6 +238,35 @@ func TestPoolsWithEmptyPools(t *testing.T) {
 	assert.Empty(t, pools.AsMap())
 }
 
+// TestReload tests that the reloaded pools and configuration are served by the API.
+func TestReload(t *testing.T) {
+	api := API{
+		Pools: map[string]map[string]*pool.Pool{},
+		ctx:   context.Background(),
+	}
+	conf := config.NewConfig(context.Background(), config.Config{})
+	conf.Plugin.DefaultPolicy = "passthrough"
+	api.Reload(
+		conf,
+		map[string]map[string]*pool.Pool{
+			config.Default: {config.DefaultConfigurationBlock: pool.NewPool(context.TODO(), 5)},
+		},
+		map[string]map[string]*network.Proxy{},
+	)
+
+	pools, err := api.GetPools(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t,
+		map[string]any{
+			config.DefaultConfigurationBlock: map[string]any{"cap": 5.0, "size": 0.0},
+		},
+		pools.AsMap()[config.Default])
+
+	pluginConfig, err := api.GetPluginConfig(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, "passthrough", pluginConfig.AsMap()["defaultPolicy"])
+}
+
 func TestGetProxies(t *testing.T) {
 	clientConfig := &config.Client{
 		Network: config.DefaultNetwork,
@@ -278,6 +307,8 @@ func TestGetProxies(t *testing.T) {
 			assert.Equal(t, 1.0, defaultProxy["total"])
 			assert.NotEmpty(t, defaultProxy["available"])
 			assert.Empty(t, defaultProxy["busy"])
+			assert.Equal(t, true, defaultProxy["healthy"])
+			assert.Nil(t, defaultProxy["healthCheck"])
 		} else {
 			t.Errorf("proxies.default.%s is not found or not a map", config.DefaultConfigurationBlock)
 		}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types of the admin API.
+const (
+	// EventBackendRoleChanged is recorded when the role monitor of a proxy finds that
+	// its server has become the primary or a standby.
+	EventBackendRoleChanged = "backend_role_changed"
+	// EventConfigReloaded is recorded when the configuration is reloaded.
+	EventConfigReloaded = "config_reloaded"
+	// EventDrainStarted is recorded when the servers start draining before GatewayD is shut down.
+	EventDrainStarted = "drain_started"
+)
+
+// eventsCapacity is the number of the latest events that are kept.
+const eventsCapacity = 1000
+
+// Event is an event of the gateway, which is served by the HTTP API.
+type Event struct {
+	ID   uint64         `json:"id"`
+	Time time.Time      `json:"time"`
+	Type string         `json:"type"`
+	Data map[string]any `json:"data"`
+}
+
+// Events keeps the latest events of the gateway, so that they can be polled on /events.
+type Events struct {
+	mu     sync.Mutex
+	events []Event
+	lastID uint64
+}
+
+// NewEvents creates an empty event log.
+func NewEvents() *Events {
+	return &Events{events: make([]Event, 0)}
+}
+
+// Add records an event of the given type. The oldest events are dropped once the capacity
+// is reached.
+func (e *Events) Add(eventType string, data map[string]any) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastID++
+	e.events = append(e.events, Event{
+		ID:   e.lastID,
+		Time: time.Now(),
+		Type: eventType,
+		Data: data,
+	})
+	if len(e.events) > eventsCapacity {
+		e.events = e.events[len(e.events)-eventsCapacity:]
+	}
+}
+
+// Since returns the events that were recorded after the event with the given ID, oldest first.
+func (e *Events) Since(id uint64) []Event {
+	events := make([]Event, 0)
+	if e == nil {
+		return events
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, event := range e.events {
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+	return events
+}
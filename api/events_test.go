@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEvents tests that the events are returned after the given ID, and that only the
+// latest events are kept.
+func TestEvents(t *testing.T) {
+	events := NewEvents()
+	assert.Empty(t, events.Since(0))
+
+	for i := range eventsCapacity + 10 {
+		events.Add(EventBackendRoleChanged, map[string]any{"index": i})
+	}
+
+	all := events.Since(0)
+	require.Len(t, all, eventsCapacity)
+	assert.Equal(t, uint64(11), all[0].ID)
+	assert.Equal(t, uint64(eventsCapacity+10), all[len(all)-1].ID)
+
+	latest := events.Since(uint64(eventsCapacity + 8))
+	require.Len(t, latest, 2)
+	assert.Equal(t, EventBackendRoleChanged, latest[0].Type)
+	assert.Equal(t, eventsCapacity+9, latest[1].Data["index"])
+
+	// A nil event log is empty.
+	var disabled *Events
+	disabled.Add(EventBackendRoleChanged, nil)
+	assert.Empty(t, disabled.Since(0))
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net"
 
 	v1 "github.com/gatewayd-io/gatewayd/api/v1"
@@ -44,6 +45,11 @@ func (s *GRPCServer) Start() {
 	s.start(s.API, s.grpcServer, s.listener)
 }
 
+// Listener returns the listener of the gRPC server, which is handed off on upgrades.
+func (s *GRPCServer) Listener() net.Listener {
+	return s.listener
+}
+
 // Shutdown shuts down the gRPC server.
 func (s *GRPCServer) Shutdown(_ context.Context) {
 	s.shutdown(s.grpcServer)
@@ -51,10 +57,13 @@ func (s *GRPCServer) Shutdown(_ context.Context) {
 
 // createGRPCAPI creates a new gRPC API server and listener.
 func createGRPCAPI(api *API, healthchecker *HealthChecker) (*grpc.Server, net.Listener) {
-	listener, err := net.Listen(api.Options.GRPCNetwork, api.Options.GRPCAddress)
-	if err != nil {
-		api.Options.Logger.Err(err).Msg("failed to start gRPC API")
-		return nil, nil
+	listener := api.Options.GRPCListener
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen(api.Options.GRPCNetwork, api.Options.GRPCAddress); err != nil {
+			api.Options.Logger.Err(err).Msg("failed to start gRPC API")
+			return nil, nil
+		}
 	}
 
 	grpcServer := grpc.NewServer()
@@ -67,7 +76,8 @@ func createGRPCAPI(api *API, healthchecker *HealthChecker) (*grpc.Server, net.Li
 
 // start starts the gRPC API.
 func (s *GRPCServer) start(api *API, grpcServer *grpc.Server, listener net.Listener) {
-	if err := grpcServer.Serve(listener); err != nil {
+	// The listener is closed once it is handed off on upgrades.
+	if err := grpcServer.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
 		api.Options.Logger.Err(err).Msg("failed to start gRPC API")
 	}
 }
@@ -92,3 +92,20 @@ func Test_Healthchecker(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "rpc error: code = Unimplemented desc = not implemented", err.Error())
 }
+
+// Test_HealthcheckerDraining tests that the servers are not serving once they drain.
+func Test_HealthcheckerDraining(t *testing.T) {
+	api := getAPIConfig()
+	healthchecker := HealthChecker{Servers: api.Servers}
+	server := api.Servers[config.Default]
+	server.Status = config.Running
+
+	hcr, err := healthchecker.Check(context.TODO(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hcr.GetStatus())
+
+	assert.Zero(t, server.Drain())
+	hcr, err = healthchecker.Check(context.TODO(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, hcr.GetStatus())
+}
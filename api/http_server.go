@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"io/fs"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	v1 "github.com/gatewayd-io/gatewayd/api/v1"
@@ -24,6 +26,7 @@ type Healthz struct {
 
 type HTTPServer struct {
 	httpServer *http.Server
+	listener   net.Listener
 	options    *Options
 	logger     zerolog.Logger
 }
@@ -31,8 +34,16 @@ type HTTPServer struct {
 // NewHTTPServer creates a new HTTP server.
 func NewHTTPServer(options *Options) *HTTPServer {
 	httpServer := createHTTPAPI(options)
+	listener := options.HTTPListener
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", options.HTTPAddress); err != nil {
+			options.Logger.Err(err).Msg("failed to start HTTP API")
+		}
+	}
 	return &HTTPServer{
 		httpServer: httpServer,
+		listener:   listener,
 		options:    options,
 		logger:     options.Logger,
 	}
@@ -40,7 +51,12 @@ func NewHTTPServer(options *Options) *HTTPServer {
 
 // Start starts the HTTP server.
 func (s *HTTPServer) Start() {
-	s.start(s.options, s.httpServer)
+	s.start(s.options, s.httpServer, s.listener)
+}
+
+// Listener returns the listener of the HTTP server, which is handed off on upgrades.
+func (s *HTTPServer) Listener() net.Listener {
+	return s.listener
 }
 
 // Shutdown shuts down the HTTP server.
@@ -79,6 +95,65 @@ func createHTTPAPI(options *Options) *http.Server {
 		}
 	})
 
+	// The events are polled with the ID of the last received event, e.g. /events?since=42.
+	mux.HandleFunc("/events", func(writer http.ResponseWriter, request *http.Request) {
+		var since uint64
+		if value := request.URL.Query().Get("since"); value != "" {
+			var err error
+			if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+				http.Error(writer, "invalid since parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(writer).Encode(options.Events.Since(since)); err != nil {
+			options.Logger.Err(err).Msg("failed to serve events")
+		}
+	})
+
+	// The configuration is reloaded from the configuration files, like on SIGHUP.
+	if options.Reload != nil {
+		mux.HandleFunc("/reload", func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodPost {
+				writer.Header().Set("Allow", http.MethodPost)
+				http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			result, err := options.Reload()
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(writer).Encode(result); err != nil {
+				options.Logger.Err(err).Msg("failed to serve reload")
+			}
+		})
+	}
+
+	// The servers are drained and GatewayD is shut down, like on SIGUSR1. The drain takes
+	// up to the drain timeout of the servers, so it isn't waited for.
+	if options.Drain != nil {
+		mux.HandleFunc("/drain", func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodPost {
+				writer.Header().Set("Allow", http.MethodPost)
+				http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !options.Drain() {
+				http.Error(writer, "already draining", http.StatusConflict)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusAccepted)
+			if err := json.NewEncoder(writer).Encode(Healthz{Status: "DRAINING"}); err != nil {
+				options.Logger.Err(err).Msg("failed to serve drain")
+			}
+		})
+	}
+
 	mux.HandleFunc("/version", func(writer http.ResponseWriter, _ *http.Request) {
 		writer.WriteHeader(http.StatusOK)
 		if _, err := writer.Write([]byte(config.Version)); err != nil {
@@ -115,9 +190,16 @@ func createHTTPAPI(options *Options) *http.Server {
 }
 
 // start starts the HTTP API.
-func (s *HTTPServer) start(options *Options, server *http.Server) {
-	// Start HTTP server (and proxy calls to gRPC server endpoint)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+func (s *HTTPServer) start(options *Options, server *http.Server, listener net.Listener) {
+	// The error of listening is logged by NewHTTPServer.
+	if listener == nil {
+		return
+	}
+
+	// Start HTTP server (and proxy calls to gRPC server endpoint). The listener is closed
+	// once it is handed off on upgrades.
+	if err := server.Serve(listener); err != nil &&
+		!errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
 		options.Logger.Err(err).Msg("failed to start HTTP API")
 	}
 }
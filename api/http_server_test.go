@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +17,16 @@ import (
 // Test_HTTP_Server tests the HTTP to gRPC gateway.
 func Test_HTTP_Server(t *testing.T) {
 	api := getAPIConfig()
+	api.Options.Reload = func() (*ReloadResult, error) {
+		return &ReloadResult{
+			Applied:         []string{"loggers"},
+			RestartRequired: []string{"servers.default"},
+		}, nil
+	}
+	var drains atomic.Int32
+	api.Options.Drain = func() bool {
+		return drains.Add(1) == 1
+	}
 	healthchecker := &HealthChecker{Servers: api.Servers}
 	grpcServer := NewGRPCServer(
 		context.Background(), GRPCServer{API: api, HealthChecker: healthchecker})
@@ -87,6 +98,73 @@ func Test_HTTP_Server(t *testing.T) {
 	assert.Equal(t, len(config.Version), len(respBodyBytes))
 	assert.Equal(t, config.Version, string(respBodyBytes))
 
+	// Check the events that were recorded after the first one.
+	api.Options.Events.Add(EventBackendRoleChanged, map[string]any{"role": "standby"})
+	api.Options.Events.Add(EventBackendRoleChanged, map[string]any{"role": "primary"})
+	req, err = http.NewRequestWithContext(
+		context.Background(),
+		http.MethodGet,
+		"http://localhost:18080/events?since=1",
+		nil,
+	)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var events []Event
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+	require.Len(t, events, 1)
+	assert.Equal(t, uint64(2), events[0].ID)
+	assert.Equal(t, EventBackendRoleChanged, events[0].Type)
+	assert.Equal(t, "primary", events[0].Data["role"])
+
+	// Reload the configuration, which is only allowed by POST.
+	req, err = http.NewRequestWithContext(
+		context.Background(),
+		http.MethodGet,
+		"http://localhost:18080/reload",
+		nil,
+	)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	req, err = http.NewRequestWithContext(
+		context.Background(),
+		http.MethodPost,
+		"http://localhost:18080/reload",
+		nil,
+	)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result ReloadResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, []string{"loggers"}, result.Applied)
+	assert.Equal(t, []string{"servers.default"}, result.RestartRequired)
+
+	// Drain the servers, which is only started once.
+	for _, status := range []int{http.StatusAccepted, http.StatusConflict} {
+		req, err = http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			"http://localhost:18080/drain",
+			nil,
+		)
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode)
+	}
+	assert.Equal(t, int32(2), drains.Load())
+
 	grpcServer.Shutdown(context.Background())
 	httpServer.Shutdown(context.Background())
 }
@@ -6,7 +6,8 @@ import (
 
 func liveness(servers map[string]*network.Server) bool {
 	for _, v := range servers {
-		if !v.IsRunning() {
+		// The draining servers take no new connections.
+		if !v.IsRunning() || v.IsDraining() {
 			return false
 		}
 	}
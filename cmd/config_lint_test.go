@@ -33,3 +33,13 @@ func Test_configLintCmd(t *testing.T) {
 	err = os.Remove(globalTestConfigFile)
 	assert.Nil(t, err)
 }
+
+func Test_configLintCmdDefaultConfig(t *testing.T) {
+	// Test configLintCmd with the global config that is shipped with GatewayD.
+	output, err := executeCommandC(rootCmd, "config", "lint", "-c", "../gatewayd.yaml")
+	require.NoError(t, err, "configLintCmd should not return an error")
+	assert.Equal(t,
+		"global config is valid\n",
+		output,
+		"configLintCmd should print the correct output")
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/network"
+	"github.com/rs/zerolog"
+)
+
+// retirePollInterval is the interval of checking whether the proxies that are replaced or removed
+// by reloading the configuration still serve any connections.
+const retirePollInterval = time.Second
+
+// currentConfig returns the configuration that is currently applied. The goroutines that run
+// while the configuration is reloaded, such as the plugin health checks, read it by this.
+func currentConfig() *config.Config {
+	confMu.RLock()
+	defer confMu.RUnlock()
+	return conf
+}
+
+// setConfig replaces the configuration that is currently applied by the reloaded one.
+func setConfig(newConf *config.Config) {
+	confMu.Lock()
+	defer confMu.Unlock()
+	conf = newConf
+}
+
+// logLevel returns the log level of the given name, or the default one if it is unknown.
+func logLevel(level string) zerolog.Level {
+	return config.If(
+		config.Exists(config.LogLevels, level),
+		config.LogLevels[level],
+		config.LogLevels[config.DefaultLogLevel],
+	)
+}
+
+// fillDefaults fills the missing and zero values of the clients of the pools and of the proxies
+// with the default ones, both on start and before comparing the reloaded configuration with the
+// running one.
+func fillDefaults(conf *config.Config) {
+	for configGroupName, configGroup := range conf.Global.Pools {
+		for configBlockName := range configGroup {
+			// The default client config is used if the pool name is not found in the clients section.
+			clientConfig, ok := conf.Global.Clients[configGroupName][configBlockName]
+			if !ok {
+				clientConfig = conf.Global.Clients[config.Default][config.DefaultConfigurationBlock]
+			}
+			if clientConfig == nil {
+				continue
+			}
+
+			clientConfig.TCPKeepAlivePeriod = config.If(
+				clientConfig.TCPKeepAlivePeriod > 0,
+				clientConfig.TCPKeepAlivePeriod,
+				config.DefaultTCPKeepAlivePeriod,
+			)
+			clientConfig.ReceiveDeadline = config.If(
+				clientConfig.ReceiveDeadline > 0,
+				clientConfig.ReceiveDeadline,
+				config.DefaultReceiveDeadline,
+			)
+			clientConfig.ReceiveTimeout = config.If(
+				clientConfig.ReceiveTimeout > 0,
+				clientConfig.ReceiveTimeout,
+				config.DefaultReceiveTimeout,
+			)
+			clientConfig.SendDeadline = config.If(
+				clientConfig.SendDeadline > 0,
+				clientConfig.SendDeadline,
+				config.DefaultSendDeadline,
+			)
+			clientConfig.ReceiveChunkSize = config.If(
+				clientConfig.ReceiveChunkSize > 0,
+				clientConfig.ReceiveChunkSize,
+				config.DefaultChunkSize,
+			)
+			clientConfig.DialTimeout = config.If(
+				clientConfig.DialTimeout > 0,
+				clientConfig.DialTimeout,
+				config.DefaultDialTimeout,
+			)
+		}
+	}
+
+	for _, configGroup := range conf.Global.Proxies {
+		for _, cfg := range configGroup {
+			cfg.HealthCheckPeriod = config.If(
+				cfg.HealthCheckPeriod > 0,
+				cfg.HealthCheckPeriod,
+				config.DefaultHealthCheckPeriod,
+			)
+		}
+	}
+}
+
+// poolSizes returns the size of the pool, and the minimum and maximum number of server
+// connections that are kept in it.
+func poolSizes(cfg *config.Pool) (int, int, int) {
+	// Check if the pool size is greater than zero.
+	size := config.If(
+		cfg.Size > 0,
+		// Check if the pool size is greater than the minimum pool size.
+		config.If(
+			cfg.Size > config.MinimumPoolSize,
+			cfg.Size,
+			config.MinimumPoolSize,
+		),
+		config.DefaultPoolSize,
+	)
+	// The pool is sized between minSize and maxSize, which defaults to the pool
+	// size, if either is set. Otherwise, it keeps the server connections of the pool size.
+	minSize, maxSize := size, size
+	if cfg.MinSize > 0 || cfg.MaxSize > 0 {
+		minSize = cfg.MinSize
+		maxSize = config.If(cfg.MaxSize > 0, cfg.MaxSize, size)
+	}
+	// The per-user pools are created on demand by the proxy,
+	// so the pool doesn't hold any server connections.
+	if cfg.PerUser {
+		return config.EmptyPoolCapacity, config.EmptyPoolCapacity, config.EmptyPoolCapacity
+	}
+	return size, minSize, maxSize
+}
+
+// loadPolicies creates the policies of the plugin configuration. The invalid policies are
+// logged and skipped.
+func loadPolicies(conf *config.Config, logger zerolog.Logger) []*sdkAct.Policy {
+	policies := make([]*sdkAct.Policy, 0, len(conf.Plugin.Policies))
+	for _, plc := range conf.Plugin.Policies {
+		if policy, err := sdkAct.NewPolicy(
+			plc.Name, plc.Policy, plc.Metadata,
+		); err != nil || policy == nil {
+			logger.Error().Err(err).Str("name", plc.Name).Msg("Failed to create policy")
+		} else {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// readingProxies returns the proxies of the configuration that send their reads to any of the
+// given proxies, directly or through each other, and that aren't given. They are replaced
+// along with the proxies they read from.
+func readingProxies(conf *config.Config, proxies []config.ConfigBlock) []config.ConfigBlock {
+	var readers []config.ConfigBlock
+	for found := true; found; {
+		found = false
+		for configGroupName, configGroup := range conf.Global.Proxies {
+			for configBlockName, cfg := range configGroup {
+				proxy := config.ConfigBlock{Group: configGroupName, Block: configBlockName}
+				readProxy := config.ConfigBlock{Group: configGroupName, Block: cfg.ReadWriteSplit.ReadProxy}
+				if cfg.ReadWriteSplit.ReadProxy == "" || slices.Contains(proxies, proxy) ||
+					slices.Contains(readers, proxy) {
+					continue
+				}
+				if slices.Contains(proxies, readProxy) || slices.Contains(readers, readProxy) {
+					readers = append(readers, proxy)
+					found = true
+				}
+			}
+		}
+	}
+	slices.SortFunc(readers, func(a, b config.ConfigBlock) int {
+		return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Block, b.Block))
+	})
+	return readers
+}
+
+// cloneGroups returns a copy of the given configuration groups, so that the copy is changed
+// while the original is still used.
+func cloneGroups[V any](groups map[string]map[string]V) map[string]map[string]V {
+	clone := make(map[string]map[string]V, len(groups))
+	for configGroupName, configGroup := range groups {
+		clone[configGroupName] = make(map[string]V, len(configGroup))
+		for configBlockName, value := range configGroup {
+			clone[configGroupName][configBlockName] = value
+		}
+	}
+	return clone
+}
+
+// retireProxies shuts down the proxies that are replaced or removed by reloading the
+// configuration, once all of them have finished serving their connections. They are waited
+// for together, since the replaced proxies might still read from each other. The connections
+// that are left after the timeout are closed, after telling their clients.
+func retireProxies(ctx context.Context, proxies []*network.Proxy, timeout time.Duration, logger zerolog.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(retirePollInterval)
+	defer ticker.Stop()
+
+wait:
+	for busy := busyConnections(proxies); busy > 0; busy = busyConnections(proxies) {
+		logger.Debug().Fields(map[string]interface{}{
+			"proxies":     len(proxies),
+			"connections": busy,
+		}).Msg("Waiting for the connections of the retired proxies to close")
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			closed := 0
+			for _, proxy := range proxies {
+				closed += proxy.Terminate()
+			}
+			logger.Warn().Int("connections", closed).Msg(
+				"The retire timeout expired, the remaining connections of the retired proxies are closed")
+			break wait
+		}
+	}
+
+	for _, proxy := range proxies {
+		proxy.Shutdown()
+	}
+	logger.Info().Int("proxies", len(proxies)).Msg("Shut down the retired proxies")
+}
+
+// busyConnections returns the number of the connections that are served by the proxies.
+func busyConnections(proxies []*network.Proxy) int {
+	busy := 0
+	for _, proxy := range proxies {
+		busy += proxy.BusyConnectionsCount()
+	}
+	return busy
+}
+
+// describeChanges returns the changes of the configuration that are applied by reloading it.
+func describeChanges(diff config.ConfigDiff, replaced []config.ConfigBlock) []string {
+	changes := make([]string, 0)
+	for _, proxy := range diff.AddedProxies {
+		changes = append(changes, "proxies."+proxy.Group+"."+proxy.Block+": added")
+	}
+	for _, proxy := range replaced {
+		changes = append(changes, "proxies."+proxy.Group+"."+proxy.Block+": replaced")
+	}
+	for _, proxy := range diff.RemovedProxies {
+		changes = append(changes, "proxies."+proxy.Group+"."+proxy.Block+": removed")
+	}
+	for _, proxy := range diff.ResizedPools {
+		changes = append(changes, "pools."+proxy.Group+"."+proxy.Block+": resized")
+	}
+	for _, name := range diff.LoadBalancers {
+		changes = append(changes, "servers."+name+".loadBalancer: changed")
+	}
+	if diff.LogLevel {
+		changes = append(changes, "loggers: level changed")
+	}
+	if diff.Policies {
+		changes = append(changes, "plugins: policies reloaded")
+	}
+	return changes
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/plugin"
+	"github.com/go-co-op/gocron"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_logLevel(t *testing.T) {
+	assert.Equal(t, zerolog.DebugLevel, logLevel("debug"))
+	assert.Equal(t, config.LogLevels[config.DefaultLogLevel], logLevel("unknown"))
+}
+
+func Test_poolSizes(t *testing.T) {
+	size, minSize, maxSize := poolSizes(&config.Pool{Size: 5})
+	assert.Equal(t, []int{5, 5, 5}, []int{size, minSize, maxSize})
+
+	size, minSize, maxSize = poolSizes(&config.Pool{})
+	assert.Equal(t, []int{config.DefaultPoolSize, config.DefaultPoolSize, config.DefaultPoolSize},
+		[]int{size, minSize, maxSize})
+
+	size, minSize, maxSize = poolSizes(&config.Pool{Size: 5, MinSize: 2})
+	assert.Equal(t, []int{5, 2, 5}, []int{size, minSize, maxSize})
+
+	size, minSize, maxSize = poolSizes(&config.Pool{Size: 5, MinSize: 2, MaxSize: 20})
+	assert.Equal(t, []int{5, 2, 20}, []int{size, minSize, maxSize})
+
+	size, minSize, maxSize = poolSizes(&config.Pool{Size: 5, PerUser: true})
+	assert.Equal(t, []int{0, 0, 0}, []int{size, minSize, maxSize})
+}
+
+// Test_fillDefaults tests that the defaults are filled in the clients of the pools, including
+// the default client of the pools that have none.
+func Test_fillDefaults(t *testing.T) {
+	defaultClient := &config.Client{}
+	conf := &config.Config{
+		Global: config.GlobalConfig{
+			Clients: map[string]map[string]*config.Client{
+				config.Default: {config.DefaultConfigurationBlock: defaultClient},
+			},
+			Pools: map[string]map[string]*config.Pool{
+				config.Default: {"reads": {Size: 1}},
+			},
+			Proxies: map[string]map[string]*config.Proxy{
+				config.Default: {"reads": {HealthCheckPeriod: time.Second}, "writes": {}},
+			},
+		},
+	}
+
+	fillDefaults(conf)
+	assert.Equal(t, config.DefaultDialTimeout, defaultClient.DialTimeout)
+	assert.Equal(t, config.DefaultChunkSize, defaultClient.ReceiveChunkSize)
+	assert.Equal(t, time.Second, conf.Global.Proxies[config.Default]["reads"].HealthCheckPeriod)
+	assert.Equal(t, config.DefaultHealthCheckPeriod,
+		conf.Global.Proxies[config.Default]["writes"].HealthCheckPeriod)
+}
+
+// Test_readingProxies tests that the proxies that read from the given ones are found, also
+// through other reading proxies.
+func Test_readingProxies(t *testing.T) {
+	readFrom := func(readProxy string) *config.Proxy {
+		return &config.Proxy{ReadWriteSplit: config.ReadWriteSplit{ReadProxy: readProxy}}
+	}
+	conf := &config.Config{
+		Global: config.GlobalConfig{
+			Proxies: map[string]map[string]*config.Proxy{
+				config.Default: {
+					"reads":   readFrom(""),
+					"writes":  readFrom("reads"),
+					"reports": readFrom("writes"),
+					"other":   readFrom(""),
+				},
+				"test": {"writes": readFrom("reads"), "reads": readFrom("")},
+			},
+		},
+	}
+
+	assert.Equal(t,
+		[]config.ConfigBlock{
+			{Group: config.Default, Block: "reports"},
+			{Group: config.Default, Block: "writes"},
+		},
+		readingProxies(conf, []config.ConfigBlock{{Group: config.Default, Block: "reads"}}))
+	assert.Empty(t, readingProxies(conf, []config.ConfigBlock{
+		{Group: config.Default, Block: "reads"},
+		{Group: config.Default, Block: "writes"},
+		{Group: config.Default, Block: "reports"},
+	}))
+}
+
+func Test_cloneGroups(t *testing.T) {
+	groups := map[string]map[string]int{config.Default: {"writes": 1}}
+	clone := cloneGroups(groups)
+	clone[config.Default]["reads"] = 2
+	assert.Equal(t, map[string]map[string]int{config.Default: {"writes": 1}}, groups)
+	assert.Equal(t, map[string]map[string]int{config.Default: {"writes": 1, "reads": 2}}, clone)
+}
+
+func Test_describeChanges(t *testing.T) {
+	assert.Empty(t, describeChanges(config.ConfigDiff{}, nil))
+	assert.Equal(t,
+		[]string{
+			"proxies.default.reads: added",
+			"proxies.default.writes: replaced",
+			"pools.test.writes: resized",
+			"servers.default.loadBalancer: changed",
+			"loggers: level changed",
+		},
+		describeChanges(
+			config.ConfigDiff{
+				AddedProxies:   []config.ConfigBlock{{Group: config.Default, Block: "reads"}},
+				ChangedProxies: []config.ConfigBlock{{Group: config.Default, Block: "writes"}},
+				ResizedPools:   []config.ConfigBlock{{Group: "test", Block: "writes"}},
+				LoadBalancers:  []string{config.Default},
+				LogLevel:       true,
+			},
+			[]config.ConfigBlock{{Group: config.Default, Block: "writes"}},
+		),
+	)
+}
+
+// Test_checkPluginHealthWhileReloading tests that the plugin health checks read the configuration
+// while it is reloaded without a data race, when run with -race.
+func Test_checkPluginHealthWhileReloading(t *testing.T) {
+	runningConf, runningRegistry := conf, pluginRegistry
+	t.Cleanup(func() {
+		conf, pluginRegistry = runningConf, runningRegistry
+	})
+	conf = &config.Config{Plugin: config.PluginConfig{Timeout: time.Second}}
+	pluginRegistry = plugin.NewRegistry(context.Background(), plugin.Registry{Logger: zerolog.Nop()})
+
+	var checks atomic.Int32
+	scheduler := gocron.NewScheduler(time.UTC)
+	_, err := scheduler.Every(time.Millisecond).SingletonMode().Do(func() {
+		checkPluginHealth(context.Background(), context.Background(), nil, zerolog.Nop())
+		checks.Add(1)
+	})
+	require.NoError(t, err)
+	scheduler.StartAsync()
+	defer scheduler.Stop()
+
+	for attempt := 0; checks.Load() < 10; attempt++ {
+		setConfig(&config.Config{
+			Plugin: config.PluginConfig{Timeout: time.Duration(attempt) * time.Millisecond},
+		})
+		time.Sleep(time.Millisecond)
+	}
+	assert.NotNil(t, currentConfig())
+}
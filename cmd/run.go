@@ -7,17 +7,21 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
-	sdkAct "github.com/gatewayd-io/gatewayd-plugin-sdk/act"
 	sdkPlugin "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin"
 	v1 "github.com/gatewayd-io/gatewayd-plugin-sdk/plugin/v1"
 	"github.com/gatewayd-io/gatewayd/act"
@@ -57,6 +61,13 @@ func (c *cobraCmdWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// The names of the listeners that are handed off on upgrades, other than the ones of the servers.
+const (
+	metricsListenerName = "metrics"
+	httpAPIListenerName = "api/http"
+	grpcAPIListenerName = "api/grpc"
+)
+
 // TODO: Get rid of the global variables.
 // https://github.com/gatewayd-io/gatewayd/issues/324
 var (
@@ -68,10 +79,14 @@ var (
 	enableUsageReport bool
 	pluginConfigFile  string
 	globalConfigFile  string
+	upgradeSocket     string
+	takeOver          bool
 	conf              *config.Config
+	confMu            sync.RWMutex // Guards conf, which is replaced on reloads.
 	pluginRegistry    *plugin.Registry
 	actRegistry       *act.Registry
 	metricsServer     *http.Server
+	metricsListener   net.Listener
 
 	UsageReportURL = "localhost:59091"
 
@@ -81,6 +96,8 @@ var (
 	proxies              = make(map[string]map[string]*network.Proxy)
 	servers              = make(map[string]*network.Server)
 	healthCheckScheduler = gocron.NewScheduler(time.UTC)
+	handoffServer        *network.HandoffServer
+	events               = api.NewEvents()
 
 	stopChan = make(chan struct{})
 )
@@ -103,9 +120,11 @@ func StopGracefully(
 		currentSignal = sig.String()
 	}
 
+	notifySystemd(logger, "STOPPING=1")
+
 	logger.Info().Msg("Notifying the plugins that the server is shutting down")
 	if pluginRegistry != nil {
-		pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), conf.Plugin.Timeout)
+		pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), currentConfig().Plugin.Timeout)
 		defer cancel()
 
 		//nolint:contextcheck
@@ -145,6 +164,11 @@ func StopGracefully(
 			span.AddEvent("Stopped metrics server")
 		}
 	}
+	if handoffServer != nil {
+		handoffServer.Close()
+		logger.Info().Msg("Closed the upgrade socket")
+		span.AddEvent("Closed the upgrade socket")
+	}
 	for name, server := range servers {
 		logger.Info().Str("name", name).Msg("Stopping server")
 		server.Shutdown()
@@ -175,6 +199,50 @@ func StopGracefully(
 	close(stopChan)
 }
 
+// checkPluginHealth pings the plugins, and removes the ones that don't respond, or reloads them if
+// they are configured to be reloaded on crash. The plugin configuration is read on every check,
+// since it is replaced by reloading the configuration.
+func checkPluginHealth(
+	ctx, runCtx context.Context, metricsMerger *metrics.Merger, logger zerolog.Logger,
+) {
+	_, span := otel.Tracer(config.TracerName).Start(ctx, "Run plugin health check")
+	defer span.End()
+
+	pluginConf := currentConfig().Plugin
+	var plugins []string
+	pluginRegistry.ForEach(func(pluginId sdkPlugin.Identifier, plugin *plugin.Plugin) {
+		if err := plugin.Ping(); err != nil {
+			span.RecordError(err)
+			logger.Error().Err(err).Msg("Failed to ping plugin")
+			if pluginConf.EnableMetricsMerger && metricsMerger != nil {
+				metricsMerger.Remove(pluginId.Name)
+			}
+			pluginRegistry.Remove(pluginId)
+
+			if !pluginConf.ReloadOnCrash {
+				return // Do not reload the plugins.
+			}
+
+			// Reload the plugins and register their hooks upon crash.
+			logger.Info().Str("name", pluginId.Name).Msg("Reloading crashed plugin")
+			pluginConfig := pluginConf.GetPlugins(pluginId.Name)
+			if pluginConfig != nil {
+				pluginRegistry.LoadPlugins(runCtx, pluginConfig, pluginConf.StartTimeout)
+			}
+		} else {
+			logger.Trace().Str("name", pluginId.Name).Msg("Successfully pinged plugin")
+			plugins = append(plugins, pluginId.Name)
+		}
+	})
+	span.SetAttributes(attribute.StringSlice("plugins", plugins))
+}
+
+// isServerClosed returns true if serving is stopped by shutting the server down, or by closing
+// its listener once it is handed off on upgrades.
+func isServerClosed(err error) bool {
+	return errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed)
+}
+
 // runCmd represents the run command.
 var runCmd = &cobra.Command{
 	Use:   "run",
@@ -247,11 +315,7 @@ var runCmd = &cobra.Command{
 			loggers[name] = logging.NewLogger(runCtx, logging.LoggerConfig{
 				Output:     cfg.GetOutput(),
 				ConsoleOut: cmdLogger,
-				Level: config.If(
-					config.Exists(config.LogLevels, cfg.Level),
-					config.LogLevels[cfg.Level],
-					config.LogLevels[config.DefaultLogLevel],
-				),
+				Level:      logLevel(cfg.Level),
 				TimeFormat: config.If(
 					config.Exists(config.TimeFormats, cfg.TimeFormat),
 					config.TimeFormats[cfg.TimeFormat],
@@ -322,14 +386,8 @@ var runCmd = &cobra.Command{
 		}
 
 		// Load policies from the configuration file and add them to the registry.
-		for _, plc := range conf.Plugin.Policies {
-			if policy, err := sdkAct.NewPolicy(
-				plc.Name, plc.Policy, plc.Metadata,
-			); err != nil || policy == nil {
-				logger.Error().Err(err).Str("name", plc.Name).Msg("Failed to create policy")
-			} else {
-				actRegistry.Add(policy)
-			}
+		for _, policy := range loadPolicies(conf, logger) {
+			actRegistry.Add(policy)
 		}
 
 		logger.Info().Fields(map[string]interface{}{
@@ -380,35 +438,7 @@ var runCmd = &cobra.Command{
 		startDelay := time.Now().Add(conf.Plugin.HealthCheckPeriod)
 		if _, err := healthCheckScheduler.Every(
 			conf.Plugin.HealthCheckPeriod).SingletonMode().StartAt(startDelay).Do(func() {
-			_, span := otel.Tracer(config.TracerName).Start(ctx, "Run plugin health check")
-			defer span.End()
-
-			var plugins []string
-			pluginRegistry.ForEach(func(pluginId sdkPlugin.Identifier, plugin *plugin.Plugin) {
-				if err := plugin.Ping(); err != nil {
-					span.RecordError(err)
-					logger.Error().Err(err).Msg("Failed to ping plugin")
-					if conf.Plugin.EnableMetricsMerger && metricsMerger != nil {
-						metricsMerger.Remove(pluginId.Name)
-					}
-					pluginRegistry.Remove(pluginId)
-
-					if !conf.Plugin.ReloadOnCrash {
-						return // Do not reload the plugins.
-					}
-
-					// Reload the plugins and register their hooks upon crash.
-					logger.Info().Str("name", pluginId.Name).Msg("Reloading crashed plugin")
-					pluginConfig := conf.Plugin.GetPlugins(pluginId.Name)
-					if pluginConfig != nil {
-						pluginRegistry.LoadPlugins(runCtx, pluginConfig, conf.Plugin.StartTimeout)
-					}
-				} else {
-					logger.Trace().Str("name", pluginId.Name).Msg("Successfully pinged plugin")
-					plugins = append(plugins, pluginId.Name)
-				}
-			})
-			span.SetAttributes(attribute.StringSlice("plugins", plugins))
+			checkPluginHealth(ctx, runCtx, metricsMerger, logger)
 		}); err != nil {
 			logger.Error().Err(err).Msg("Failed to start plugin health check scheduler")
 			span.RecordError(err)
@@ -446,6 +476,35 @@ var runCmd = &cobra.Command{
 			}
 		}
 
+		// Take over the listeners of the running GatewayD process that is upgraded, which keeps
+		// accepting the connections until the servers run on them.
+		var takeover *network.Takeover
+		if takeOver {
+			_, span = otel.Tracer(config.TracerName).Start(runCtx, "Take over listeners")
+			if upgradeSocket == "" {
+				logger.Error().Msg("The upgrade socket is required to take over the listeners")
+				os.Exit(gerr.FailedToTakeOverListeners)
+			}
+			var err *gerr.GatewayDError
+			if takeover, err = network.TakeOver(upgradeSocket); err != nil {
+				logger.Error().Err(err).Str("path", upgradeSocket).Msg("Failed to take over the listeners")
+				span.RecordError(err)
+				os.Exit(gerr.FailedToTakeOverListeners)
+			}
+			logger.Info().Int("listeners", len(takeover.Listeners)).Msg("Took over the listeners")
+			span.End()
+		}
+
+		// Use the sockets that are passed by systemd with socket activation, if any.
+		systemdSockets, activationErr := network.ActivateSockets()
+		if activationErr != nil {
+			logger.Error().Err(activationErr).Msg("Failed to use the sockets passed by systemd")
+			os.Exit(gerr.FailedToActivateSockets)
+		}
+		if systemdSockets != nil {
+			logger.Info().Strs("sockets", systemdSockets.Names).Msg("Using the sockets passed by systemd")
+		}
+
 		// Start the metrics server if enabled.
 		// TODO: Start multiple metrics servers. For now, only one default is supported.
 		// I should first find a use case for those multiple metrics servers.
@@ -520,7 +579,7 @@ var runCmd = &cobra.Command{
 				}
 			})
 
-			if conf.Plugin.EnableMetricsMerger && metricsMerger != nil {
+			if currentConfig().Plugin.EnableMetricsMerger && metricsMerger != nil {
 				handler = mergedMetricsHandler(handler)
 			}
 
@@ -530,8 +589,15 @@ var runCmd = &cobra.Command{
 				config.DefaultReadHeaderTimeout,
 			)
 
-			// Check if the metrics server is already running before registering the handler.
-			if _, err = http.Get(address); err != nil { //nolint:gosec
+			// Check if the metrics server is already running before registering the handler,
+			// unless its listener is taken over from the process that is upgraded.
+			listener := takeover.Listener(metricsListenerName)
+			running := false
+			if listener == nil {
+				_, err = http.Get(address) //nolint:gosec
+				running = err == nil
+			}
+			if !running {
 				// The timeout handler limits the nested handlers from running for too long.
 				mux.Handle(
 					metricsConfig.Path,
@@ -567,6 +633,15 @@ var runCmd = &cobra.Command{
 				"readHeaderTimeout": readHeaderTimeout.String(),
 			}).Msg("Metrics are exposed")
 
+			if listener == nil {
+				if listener, err = net.Listen("tcp", metricsConfig.Address); err != nil {
+					logger.Error().Err(err).Msg("Failed to start metrics server")
+					span.RecordError(err)
+					return
+				}
+			}
+			metricsListener = listener
+
 			if metricsConfig.CertFile != "" && metricsConfig.KeyFile != "" {
 				// Set up TLS.
 				metricsServer.TLSConfig = &tls.Config{
@@ -587,14 +662,14 @@ var runCmd = &cobra.Command{
 				logger.Debug().Msg("Metrics server is running with TLS")
 
 				// Start the metrics server with TLS.
-				if err = metricsServer.ListenAndServeTLS(
-					metricsConfig.CertFile, metricsConfig.KeyFile); !errors.Is(err, http.ErrServerClosed) {
+				if err = metricsServer.ServeTLS(
+					listener, metricsConfig.CertFile, metricsConfig.KeyFile); !isServerClosed(err) {
 					logger.Error().Err(err).Msg("Failed to start metrics server")
 					span.RecordError(err)
 				}
 			} else {
 				// Start the metrics server without TLS.
-				if err = metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				if err = metricsServer.Serve(listener); !isServerClosed(err) {
 					logger.Error().Err(err).Msg("Failed to start metrics server")
 					span.RecordError(err)
 				}
@@ -620,213 +695,463 @@ var runCmd = &cobra.Command{
 		var httpServer *api.HTTPServer
 		var grpcServer *api.GRPCServer
 
-		_, span = otel.Tracer(config.TracerName).Start(runCtx, "Create pools and clients")
-		// Create and initialize pools of connections.
-		for configGroupName, configGroup := range conf.Global.Pools {
-			for configBlockName, cfg := range configGroup {
-				logger := loggers[configGroupName]
-				// Check if the pool size is greater than zero.
-				currentPoolSize := config.If(
-					cfg.Size > 0,
-					// Check if the pool size is greater than the minimum pool size.
-					config.If(
-						cfg.Size > config.MinimumPoolSize,
-						cfg.Size,
-						config.MinimumPoolSize,
-					),
-					config.DefaultPoolSize,
-				)
-
-				if _, ok := pools[configGroupName]; !ok {
-					pools[configGroupName] = make(map[string]*pool.Pool)
-				}
-				pools[configGroupName][configBlockName] = pool.NewPool(runCtx, currentPoolSize)
+		waitQueues := make(map[string]map[string]*network.WaitQueue)
+		poolManagers := make(map[string]map[string]*network.PoolManager)
+		circuitBreakers := make(map[string]map[string]*network.CircuitBreaker)
+		// createPool creates the pool of the given proxy, along with its client configuration, wait
+		// queue, circuit breaker and pool manager, both on start and on reloading the configuration.
+		createPool := func(
+			globalConf *config.Config, span trace.Span, configGroupName, configBlockName string, cfg *config.Pool,
+		) {
+			logger := loggers[configGroupName]
+			pluginTimeout := globalConf.Plugin.Timeout
+			currentPoolSize, minPoolSize, maxPoolSize := poolSizes(cfg)
 
-				span.AddEvent("Create pool", trace.WithAttributes(
-					attribute.String("name", configBlockName),
-					attribute.Int("size", currentPoolSize),
-				))
+			if _, ok := pools[configGroupName]; !ok {
+				pools[configGroupName] = make(map[string]*pool.Pool)
+			}
+			pools[configGroupName][configBlockName] = pool.NewPool(runCtx, maxPoolSize)
+
+			span.AddEvent("Create pool", trace.WithAttributes(
+				attribute.String("name", configBlockName),
+				attribute.Int("size", currentPoolSize),
+				attribute.Int("minSize", minPoolSize),
+				attribute.Int("maxSize", maxPoolSize),
+				attribute.String("idleTimeout", cfg.IdleTimeout.String()),
+				attribute.String("maxLifetime", cfg.MaxLifetime.String()),
+				attribute.Int("maxUses", cfg.MaxUses),
+				attribute.Int("waitQueueSize", cfg.WaitQueueSize),
+				attribute.String("waitTimeout", cfg.WaitTimeout.String()),
+			))
 
-				if _, ok := clients[configGroupName]; !ok {
-					clients[configGroupName] = make(map[string]*config.Client)
-				}
+			if _, ok := clients[configGroupName]; !ok {
+				clients[configGroupName] = make(map[string]*config.Client)
+			}
 
-				// Get client config from the config file.
-				if clientConfig, ok := conf.Global.Clients[configGroupName][configBlockName]; !ok {
-					// This ensures that the default client config is used if the pool name is not
-					// found in the clients section.
-					clients[configGroupName][configBlockName] = conf.Global.Clients[config.Default][config.DefaultConfigurationBlock]
-				} else {
-					// Merge the default client config with the one from the pool.
-					clients[configGroupName][configBlockName] = clientConfig
-				}
+			// Get client config from the config file.
+			if clientConfig, ok := globalConf.Global.Clients[configGroupName][configBlockName]; !ok {
+				// This ensures that the default client config is used if the pool name is not
+				// found in the clients section.
+				clients[configGroupName][configBlockName] = globalConf.Global.Clients[config.Default][config.DefaultConfigurationBlock]
+			} else {
+				// Merge the default client config with the one from the pool.
+				clients[configGroupName][configBlockName] = clientConfig
+			}
 
-				// Fill the missing and zero values with the default ones.
-				clients[configGroupName][configBlockName].TCPKeepAlivePeriod = config.If(
-					clients[configGroupName][configBlockName].TCPKeepAlivePeriod > 0,
-					clients[configGroupName][configBlockName].TCPKeepAlivePeriod,
-					config.DefaultTCPKeepAlivePeriod,
-				)
-				clients[configGroupName][configBlockName].ReceiveDeadline = config.If(
-					clients[configGroupName][configBlockName].ReceiveDeadline > 0,
-					clients[configGroupName][configBlockName].ReceiveDeadline,
-					config.DefaultReceiveDeadline,
-				)
-				clients[configGroupName][configBlockName].ReceiveTimeout = config.If(
-					clients[configGroupName][configBlockName].ReceiveTimeout > 0,
-					clients[configGroupName][configBlockName].ReceiveTimeout,
-					config.DefaultReceiveTimeout,
-				)
-				clients[configGroupName][configBlockName].SendDeadline = config.If(
-					clients[configGroupName][configBlockName].SendDeadline > 0,
-					clients[configGroupName][configBlockName].SendDeadline,
-					config.DefaultSendDeadline,
-				)
-				clients[configGroupName][configBlockName].ReceiveChunkSize = config.If(
-					clients[configGroupName][configBlockName].ReceiveChunkSize > 0,
-					clients[configGroupName][configBlockName].ReceiveChunkSize,
-					config.DefaultChunkSize,
+			// Queue the clients while the pool is exhausted, instead of disconnecting them.
+			var waitQueue *network.WaitQueue
+			if !cfg.PerUser && cfg.WaitQueueSize > 0 {
+				waitQueue = network.NewWaitQueue(
+					runCtx,
+					network.WaitQueue{
+						GroupName: configGroupName,
+						ProxyName: configBlockName,
+						MaxLength: cfg.WaitQueueSize,
+						Timeout:   cfg.WaitTimeout,
+					},
 				)
-				clients[configGroupName][configBlockName].DialTimeout = config.If(
-					clients[configGroupName][configBlockName].DialTimeout > 0,
-					clients[configGroupName][configBlockName].DialTimeout,
-					config.DefaultDialTimeout,
+			}
+			if _, ok := waitQueues[configGroupName]; !ok {
+				waitQueues[configGroupName] = make(map[string]*network.WaitQueue)
+			}
+			waitQueues[configGroupName][configBlockName] = waitQueue
+
+			// Fail fast while the server of the proxy is failing, instead of retrying the dials.
+			var circuitBreaker *network.CircuitBreaker
+			if proxyConfig := globalConf.Global.Proxies[configGroupName][configBlockName]; proxyConfig != nil &&
+				proxyConfig.CircuitBreaker.Enabled {
+				circuitBreaker = network.NewCircuitBreaker(
+					runCtx,
+					network.CircuitBreaker{
+						GroupName:        configGroupName,
+						ProxyName:        configBlockName,
+						FailureRate:      proxyConfig.CircuitBreaker.FailureRate,
+						MinRequests:      proxyConfig.CircuitBreaker.MinRequests,
+						Window:           proxyConfig.CircuitBreaker.Window,
+						OpenTimeout:      proxyConfig.CircuitBreaker.OpenTimeout,
+						HalfOpenRequests: proxyConfig.CircuitBreaker.HalfOpenRequests,
+						Logger:           logger,
+					},
 				)
+			}
+			if _, ok := circuitBreakers[configGroupName]; !ok {
+				circuitBreakers[configGroupName] = make(map[string]*network.CircuitBreaker)
+			}
+			circuitBreakers[configGroupName][configBlockName] = circuitBreaker
 
-				// Add clients to the pool.
-				for range currentPoolSize {
-					clientConfig := clients[configGroupName][configBlockName]
-					client := network.NewClient(
-						runCtx, clientConfig, logger,
-						network.NewRetry(
-							network.Retry{
-								Retries: clientConfig.Retries,
-								Backoff: config.If(
-									clientConfig.Backoff > 0,
-									clientConfig.Backoff,
-									config.DefaultBackoff,
-								),
-								BackoffMultiplier:  clientConfig.BackoffMultiplier,
-								DisableBackoffCaps: clientConfig.DisableBackoffCaps,
-								Logger:             loggers[configBlockName],
-							},
+			// Dial the server connections of the pool on demand. The pool is filled to its
+			// minimum size in the background while the server is unreachable, so that
+			// GatewayD doesn't have to start after the server.
+			if _, ok := poolManagers[configGroupName]; !ok {
+				poolManagers[configGroupName] = make(map[string]*network.PoolManager)
+			}
+			poolManagers[configGroupName][configBlockName] = nil
+			if !cfg.PerUser {
+				clientConfig := clients[configGroupName][configBlockName]
+				poolManager := network.NewPoolManager(
+					runCtx,
+					network.PoolManager{
+						Pool:           pools[configGroupName][configBlockName],
+						WaitQueue:      waitQueue,
+						CircuitBreaker: circuitBreaker,
+						ClientConfig:   clientConfig,
+						MinSize:        minPoolSize,
+						MaxSize:        maxPoolSize,
+						IdleTimeout: config.If(
+							cfg.IdleTimeout > 0,
+							cfg.IdleTimeout,
+							config.DefaultIdleTimeout,
 						),
-					)
-
-					if client != nil {
-						eventOptions := trace.WithAttributes(
-							attribute.String("name", configBlockName),
-							attribute.String("network", client.Network),
-							attribute.String("address", client.Address),
-							attribute.Int("receiveChunkSize", client.ReceiveChunkSize),
-							attribute.String("receiveDeadline", client.ReceiveDeadline.String()),
-							attribute.String("receiveTimeout", client.ReceiveTimeout.String()),
-							attribute.String("sendDeadline", client.SendDeadline.String()),
-							attribute.String("dialTimeout", client.DialTimeout.String()),
-							attribute.Bool("tcpKeepAlive", client.TCPKeepAlive),
-							attribute.String("tcpKeepAlivePeriod", client.TCPKeepAlivePeriod.String()),
-							attribute.String("localAddress", client.LocalAddr()),
-							attribute.String("remoteAddress", client.RemoteAddr()),
-							attribute.Int("retries", clientConfig.Retries),
-							attribute.String("backoff", client.Retry().Backoff.String()),
-							attribute.Float64("backoffMultiplier", clientConfig.BackoffMultiplier),
-							attribute.Bool("disableBackoffCaps", clientConfig.DisableBackoffCaps),
-						)
-						if client.ID != "" {
-							eventOptions = trace.WithAttributes(
-								attribute.String("id", client.ID),
+						MaxLifetime: cfg.MaxLifetime,
+						MaxUses:     cfg.MaxUses,
+						OnNewClient: func(client *network.Client) {
+							_, span := otel.Tracer(config.TracerName).Start(runCtx, "Create client")
+							defer span.End()
+
+							eventOptions := trace.WithAttributes(
+								attribute.String("name", configBlockName),
+								attribute.String("network", client.Network),
+								attribute.String("address", client.Address),
+								attribute.Int("receiveChunkSize", client.ReceiveChunkSize),
+								attribute.String("receiveDeadline", client.ReceiveDeadline.String()),
+								attribute.String("receiveTimeout", client.ReceiveTimeout.String()),
+								attribute.String("sendDeadline", client.SendDeadline.String()),
+								attribute.String("dialTimeout", client.DialTimeout.String()),
+								attribute.Bool("tcpKeepAlive", client.TCPKeepAlive),
+								attribute.String("tcpKeepAlivePeriod", client.TCPKeepAlivePeriod.String()),
+								attribute.String("localAddress", client.LocalAddr()),
+								attribute.String("remoteAddress", client.RemoteAddr()),
+								attribute.Int("retries", clientConfig.Retries),
+								attribute.String("backoff", client.Retry().Backoff.String()),
+								attribute.Float64("backoffMultiplier", clientConfig.BackoffMultiplier),
+								attribute.Bool("disableBackoffCaps", clientConfig.DisableBackoffCaps),
+								attribute.String("sslMode", client.SSLMode),
+								attribute.String("proxyProtocol", client.ProxyProtocol),
 							)
-						}
+							if client.ID != "" {
+								eventOptions = trace.WithAttributes(
+									attribute.String("id", client.ID),
+								)
+							}
+
+							span.AddEvent("Create client", eventOptions)
+
+							pluginTimeoutCtx, cancel := context.WithTimeout(
+								context.Background(), pluginTimeout)
+							defer cancel()
+
+							clientCfg := map[string]interface{}{
+								"id":                 client.ID,
+								"network":            client.Network,
+								"address":            client.Address,
+								"receiveChunkSize":   client.ReceiveChunkSize,
+								"receiveDeadline":    client.ReceiveDeadline.String(),
+								"receiveTimeout":     client.ReceiveTimeout.String(),
+								"sendDeadline":       client.SendDeadline.String(),
+								"dialTimeout":        client.DialTimeout.String(),
+								"tcpKeepAlive":       client.TCPKeepAlive,
+								"tcpKeepAlivePeriod": client.TCPKeepAlivePeriod.String(),
+								"localAddress":       client.LocalAddr(),
+								"remoteAddress":      client.RemoteAddr(),
+								"retries":            clientConfig.Retries,
+								"backoff":            client.Retry().Backoff.String(),
+								"backoffMultiplier":  clientConfig.BackoffMultiplier,
+								"disableBackoffCaps": clientConfig.DisableBackoffCaps,
+							}
+							_, err := pluginRegistry.Run(
+								pluginTimeoutCtx, clientCfg, v1.HookName_HOOK_NAME_ON_NEW_CLIENT)
+							if err != nil {
+								logger.Error().Err(err).Msg("Failed to run OnNewClient hooks")
+								span.RecordError(err)
+							}
+						},
+						Logger: logger,
+					},
+				)
+				poolManagers[configGroupName][configBlockName] = poolManager
+
+				if !poolManager.Fill() {
+					logger.Warn().Fields(map[string]interface{}{
+						"name":    configBlockName,
+						"minSize": minPoolSize,
+					}).Msg("Failed to fill the pool, either because the clients cannot connect " +
+						"due to no network connectivity or the server is not running. " +
+						"Retrying in the background...")
+				}
+			}
 
-						span.AddEvent("Create client", eventOptions)
-
-						pluginTimeoutCtx, cancel = context.WithTimeout(
-							context.Background(), conf.Plugin.Timeout)
-						defer cancel()
-
-						clientCfg := map[string]interface{}{
-							"id":                 client.ID,
-							"network":            client.Network,
-							"address":            client.Address,
-							"receiveChunkSize":   client.ReceiveChunkSize,
-							"receiveDeadline":    client.ReceiveDeadline.String(),
-							"receiveTimeout":     client.ReceiveTimeout.String(),
-							"sendDeadline":       client.SendDeadline.String(),
-							"dialTimeout":        client.DialTimeout.String(),
-							"tcpKeepAlive":       client.TCPKeepAlive,
-							"tcpKeepAlivePeriod": client.TCPKeepAlivePeriod.String(),
-							"localAddress":       client.LocalAddr(),
-							"remoteAddress":      client.RemoteAddr(),
-							"retries":            clientConfig.Retries,
-							"backoff":            client.Retry().Backoff.String(),
-							"backoffMultiplier":  clientConfig.BackoffMultiplier,
-							"disableBackoffCaps": clientConfig.DisableBackoffCaps,
-						}
-						_, err := pluginRegistry.Run(
-							pluginTimeoutCtx, clientCfg, v1.HookName_HOOK_NAME_ON_NEW_CLIENT)
-						if err != nil {
-							logger.Error().Err(err).Msg("Failed to run OnNewClient hooks")
-							span.RecordError(err)
-						}
+			// Verify that the pool is properly populated.
+			logger.Info().Fields(map[string]interface{}{
+				"name":  configBlockName,
+				"count": strconv.Itoa(pools[configGroupName][configBlockName].Size()),
+			}).Msg("There are clients available in the pool")
+
+			pluginTimeoutCtx, cancel := context.WithTimeout(
+				context.Background(), pluginTimeout)
+			defer cancel()
+
+			_, err := pluginRegistry.Run(
+				pluginTimeoutCtx,
+				map[string]interface{}{
+					"name":    configBlockName,
+					"size":    currentPoolSize,
+					"minSize": minPoolSize,
+					"maxSize": maxPoolSize,
+				},
+				v1.HookName_HOOK_NAME_ON_NEW_POOL)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to run OnNewPool hooks")
+				span.RecordError(err)
+			}
+		}
+
+		// The keys for canceling the requests are shared by the proxies, since the cancel
+		// requests are sent on new connections, which might be assigned to other proxies.
+		cancelKeys := network.NewCancelKeys()
+		// createProxy creates the given proxy with its pool, both on start and on reloading the
+		// configuration. The read proxies are linked by the caller, once all the proxies of the
+		// group are created.
+		createProxy := func(
+			globalConf *config.Config, span trace.Span, configGroupName, configBlockName string, cfg *config.Proxy,
+		) *gerr.GatewayDError {
+			logger := loggers[configGroupName]
+			pluginTimeout := globalConf.Plugin.Timeout
+			clientConfig := clients[configGroupName][configBlockName]
+
+			if _, ok := proxies[configGroupName]; !ok {
+				proxies[configGroupName] = make(map[string]*network.Proxy)
+			}
+
+			// Create the pools of the databases and users of the clients on demand, if configured.
+			var userPools *network.UserPools
+			if poolConfig := globalConf.Global.Pools[configGroupName][configBlockName]; poolConfig != nil && poolConfig.PerUser {
+				userPools = network.NewUserPools(runCtx, network.UserPools{
+					ClientConfig:   clientConfig,
+					CircuitBreaker: circuitBreakers[configGroupName][configBlockName],
+					PoolSize: config.If(
+						poolConfig.UserPoolSize > 0,
+						poolConfig.UserPoolSize,
+						config.DefaultUserPoolSize,
+					),
+					MaxServerConnections: config.If(
+						poolConfig.MaxServerConnections > 0,
+						poolConfig.MaxServerConnections,
+						config.DefaultMaxServerConnections,
+					),
+					IdleTimeout: config.If(
+						poolConfig.IdleTimeout > 0,
+						poolConfig.IdleTimeout,
+						config.DefaultIdleTimeout,
+					),
+					Logger: logger,
+				})
+			}
 
-						err = pools[configGroupName][configBlockName].Put(client.ID, client)
-						if err != nil {
-							logger.Error().Err(err).Msg("Failed to add client to the pool")
+			// Authenticate the clients at the proxy, if configured.
+			var authenticator *network.Authenticator
+			if auth := cfg.Authentication; auth.Method != "" && auth.Method != config.AuthMethodNone {
+				var userList map[string]string
+				if auth.UserList != "" {
+					var err *gerr.GatewayDError
+					if userList, err = network.LoadUserList(auth.UserList); err != nil {
+						logger.Error().Err(err).Str("userList", auth.UserList).Msg(
+							"Failed to load the user list")
+						span.RecordError(err)
+						return err
+					}
+				}
+
+				// The auth query runs on a dedicated pool, so that it never
+				// waits for the server connections of the incoming connections.
+				var authPool pool.IPool
+				if auth.AuthQuery != "" {
+					authPoolSize := config.If(
+						auth.AuthPoolSize > 0, auth.AuthPoolSize, config.DefaultAuthPoolSize)
+					authPool = pool.NewPool(runCtx, authPoolSize)
+					for range authPoolSize {
+						client := network.NewClient(
+							runCtx, clientConfig, logger,
+							network.NewRetry(
+								network.Retry{
+									Retries: clientConfig.Retries,
+									Backoff: config.If(
+										clientConfig.Backoff > 0,
+										clientConfig.Backoff,
+										config.DefaultBackoff,
+									),
+									BackoffMultiplier:  clientConfig.BackoffMultiplier,
+									DisableBackoffCaps: clientConfig.DisableBackoffCaps,
+									Logger:             logger,
+								},
+							),
+						)
+						if client == nil {
+							logger.Error().Msg("Failed to create client for the auth query")
+							authPool.ForEach(func(_, value interface{}) bool {
+								if client, ok := value.(*network.Client); ok {
+									client.Close()
+								}
+								return true
+							})
+							return gerr.ErrClientConnectionFailed
+						}
+						if err := authPool.Put(client.ID, client); err != nil {
+							logger.Error().Err(err).Msg("Failed to add client to the auth pool")
 							span.RecordError(err)
 						}
-					} else {
-						logger.Error().Msg("Failed to create client, please check the configuration")
-						go func() {
-							// Wait for the stop signal to exit gracefully.
-							// This prevents the program from waiting indefinitely
-							// after the StopGracefully function is called.
-							<-stopChan
-							os.Exit(gerr.FailedToCreateClient)
-						}()
-						StopGracefully(
-							runCtx,
-							nil,
-							metricsMerger,
-							metricsServer,
-							pluginRegistry,
-							logger,
-							servers,
-							stopChan,
-							httpServer,
-							grpcServer,
-						)
 					}
 				}
 
-				// Verify that the pool is properly populated.
-				logger.Info().Fields(map[string]interface{}{
-					"name":  configBlockName,
-					"count": strconv.Itoa(pools[configGroupName][configBlockName].Size()),
-				}).Msg("There are clients available in the pool")
-
-				if pools[configGroupName][configBlockName].Size() != currentPoolSize {
-					logger.Error().Msg(
-						"The pool size is incorrect, either because " +
-							"the clients cannot connect due to no network connectivity " +
-							"or the server is not running. exiting...")
-					pluginRegistry.Shutdown()
-					os.Exit(gerr.FailedToInitializePool)
-				}
+				authenticator = network.NewAuthenticator(
+					runCtx,
+					network.Authenticator{
+						Method:    auth.Method,
+						UserList:  userList,
+						AuthQuery: auth.AuthQuery,
+						AuthPool:  authPool,
+						Logger:    logger,
+					},
+				)
+			}
 
-				pluginTimeoutCtx, cancel = context.WithTimeout(
-					context.Background(), conf.Plugin.Timeout)
-				defer cancel()
+			// Probe the server of the proxy, so that the load balancers skip it while it is unhealthy.
+			var healthCheck *network.HealthCheck
+			if hc := cfg.HealthCheck; hc.Method != "" && hc.Method != config.HealthCheckNone {
+				healthCheck = network.NewHealthCheck(
+					runCtx,
+					network.HealthCheck{
+						GroupName:          configGroupName,
+						ProxyName:          configBlockName,
+						Method:             hc.Method,
+						Interval:           hc.Interval,
+						Timeout:            hc.Timeout,
+						HealthyThreshold:   hc.HealthyThreshold,
+						UnhealthyThreshold: hc.UnhealthyThreshold,
+						ClientConfig:       clientConfig,
+						Logger:             logger,
+					},
+				)
+			}
 
-				_, err = pluginRegistry.Run(
-					pluginTimeoutCtx,
-					map[string]interface{}{"name": configBlockName, "size": currentPoolSize},
-					v1.HookName_HOOK_NAME_ON_NEW_POOL)
+			// Check the role of the server of the proxy, so that the load balancers only route
+			// to the primary. The role changes are sent to the plugins and recorded as events.
+			var roleMonitor *network.RoleMonitor
+			if rm := cfg.RoleMonitor; rm.Enabled {
+				roleMonitor = network.NewRoleMonitor(
+					runCtx,
+					network.RoleMonitor{
+						GroupName:    configGroupName,
+						ProxyName:    configBlockName,
+						Interval:     rm.Interval,
+						Timeout:      rm.Timeout,
+						OnFailover:   rm.OnFailover,
+						ClientConfig: clientConfig,
+						OnChange: func(change network.RoleChange) {
+							_, span := otel.Tracer(config.TracerName).Start(runCtx, "Backend role changed")
+							defer span.End()
+
+							data := map[string]interface{}{
+								"group":    change.GroupName,
+								"proxy":    change.ProxyName,
+								"address":  change.Address,
+								"previous": change.Previous,
+								"role":     change.Current,
+							}
+							events.Add(api.EventBackendRoleChanged, data)
+							span.AddEvent("Backend role changed", trace.WithAttributes(
+								attribute.String("proxy", change.ProxyName),
+								attribute.String("previous", change.Previous),
+								attribute.String("role", change.Current),
+							))
+
+							pluginTimeoutCtx, cancel := context.WithTimeout(
+								context.Background(), pluginTimeout)
+							defer cancel()
+
+							hookData := map[string]interface{}{"hookName": network.RoleChangedHook}
+							for key, value := range data {
+								hookData[key] = value
+							}
+							_, err := pluginRegistry.Run(
+								pluginTimeoutCtx, hookData, v1.HookName_HOOK_NAME_ON_HOOK)
+							if err != nil {
+								logger.Error().Err(err).Msg("Failed to run OnHook hooks")
+								span.RecordError(err)
+							}
+						},
+						Logger: logger,
+					},
+				)
+			}
+
+			proxies[configGroupName][configBlockName] = network.NewProxy(
+				runCtx,
+				network.Proxy{
+					Name:                 configBlockName,
+					AvailableConnections: pools[configGroupName][configBlockName],
+					PluginRegistry:       pluginRegistry,
+					HealthCheckPeriod:    cfg.HealthCheckPeriod,
+					PoolMode:             cfg.PoolMode,
+					Authenticator:        authenticator,
+					UserPools:            userPools,
+					CancelKeys:           cancelKeys,
+					WriteFunctions:       cfg.ReadWriteSplit.WriteFunctions,
+					HealthCheck:          healthCheck,
+					RoleMonitor:          roleMonitor,
+					CircuitBreaker:       circuitBreakers[configGroupName][configBlockName],
+					WaitQueue:            waitQueues[configGroupName][configBlockName],
+					PoolManager:          poolManagers[configGroupName][configBlockName],
+					ResetStrategy:        cfg.ResetStrategy,
+					ResetQuery:           cfg.ResetQuery,
+					ClientConfig:         clientConfig,
+					Logger:               logger,
+					PluginTimeout:        pluginTimeout,
+				},
+			)
+
+			span.AddEvent("Create proxy", trace.WithAttributes(
+				attribute.String("name", configBlockName),
+				attribute.String("healthCheckPeriod", cfg.HealthCheckPeriod.String()),
+				attribute.String("poolMode", cfg.PoolMode),
+				attribute.String("authMethod", cfg.Authentication.Method),
+				attribute.Bool("perUserPools", userPools != nil),
+				attribute.String("readProxy", cfg.ReadWriteSplit.ReadProxy),
+				attribute.String("healthCheck", cfg.HealthCheck.Method),
+				attribute.Bool("roleMonitor", cfg.RoleMonitor.Enabled),
+				attribute.Bool("circuitBreaker", cfg.CircuitBreaker.Enabled),
+				attribute.String("resetStrategy", cfg.ResetStrategy),
+			))
+
+			pluginTimeoutCtx, cancel := context.WithTimeout(
+				context.Background(), pluginTimeout)
+			defer cancel()
+
+			if data, ok := globalConf.GlobalKoanf.Get("proxies").(map[string]interface{}); ok {
+				_, err := pluginRegistry.Run(
+					pluginTimeoutCtx, data, v1.HookName_HOOK_NAME_ON_NEW_PROXY)
 				if err != nil {
-					logger.Error().Err(err).Msg("Failed to run OnNewPool hooks")
+					logger.Error().Err(err).Msg("Failed to run OnNewProxy hooks")
 					span.RecordError(err)
 				}
+			} else {
+				logger.Error().Msg("Failed to get proxy from config")
+			}
+
+			return nil
+		}
+
+		// Fill the missing and zero values of the clients and the proxies with the default ones.
+		fillDefaults(conf)
+
+		_, span = otel.Tracer(config.TracerName).Start(runCtx, "Create pools and clients")
+		// Create and initialize pools of connections.
+		for configGroupName, configGroup := range conf.Global.Pools {
+			for configBlockName, cfg := range configGroup {
+				createPool(conf, span, configGroupName, configBlockName, cfg)
 			}
 		}
+		// The pools that aren't filled on start are reported once GatewayD is ready.
+		unfilled := unfilledPools(poolManagers)
 
 		span.End()
 
@@ -834,53 +1159,18 @@ var runCmd = &cobra.Command{
 		// Create and initialize prefork proxies with each pool of clients.
 		for configGroupName, configGroup := range conf.Global.Proxies {
 			for configBlockName, cfg := range configGroup {
-				logger := loggers[configGroupName]
-				clientConfig := clients[configGroupName][configBlockName]
-
-				// Fill the missing and zero value with the default one.
-				cfg.HealthCheckPeriod = config.If(
-					cfg.HealthCheckPeriod > 0,
-					cfg.HealthCheckPeriod,
-					config.DefaultHealthCheckPeriod,
-				)
-
-				if _, ok := proxies[configGroupName]; !ok {
-					proxies[configGroupName] = make(map[string]*network.Proxy)
+				if err := createProxy(conf, span, configGroupName, configBlockName, cfg); err != nil {
+					pluginRegistry.Shutdown()
+					os.Exit(gerr.FailedToCreateAuthenticator)
 				}
+			}
 
-				proxies[configGroupName][configBlockName] = network.NewProxy(
-					runCtx,
-					network.Proxy{
-						Name:                 configBlockName,
-						AvailableConnections: pools[configGroupName][configBlockName],
-						PluginRegistry:       pluginRegistry,
-						HealthCheckPeriod:    cfg.HealthCheckPeriod,
-						ClientConfig:         clientConfig,
-						Logger:               logger,
-						PluginTimeout:        conf.Plugin.Timeout,
-					},
-				)
-
-				span.AddEvent("Create proxy", trace.WithAttributes(
-					attribute.String("name", configBlockName),
-					attribute.String("healthCheckPeriod", cfg.HealthCheckPeriod.String()),
-				))
-
-				pluginTimeoutCtx, cancel = context.WithTimeout(
-					context.Background(), conf.Plugin.Timeout)
-				defer cancel()
-
-				if data, ok := conf.GlobalKoanf.Get("proxies").(map[string]interface{}); ok {
-					_, err = pluginRegistry.Run(
-						pluginTimeoutCtx, data, v1.HookName_HOOK_NAME_ON_NEW_PROXY)
-					if err != nil {
-						logger.Error().Err(err).Msg("Failed to run OnNewProxy hooks")
-						span.RecordError(err)
-					}
-				} else {
-					logger.Error().Msg("Failed to get proxy from config")
+			// The read proxies are linked once all the proxies of the group are created.
+			for configBlockName, cfg := range configGroup {
+				if cfg.ReadWriteSplit.ReadProxy != "" {
+					proxies[configGroupName][configBlockName].ReadProxy =
+						proxies[configGroupName][cfg.ReadWriteSplit.ReadProxy]
 				}
-
 			}
 		}
 
@@ -896,6 +1186,11 @@ var runCmd = &cobra.Command{
 				serverProxies = append(serverProxies, proxy)
 			}
 
+			listener := takeover.ServerListener(name)
+			if listener == nil {
+				listener = systemdSockets.Listener(name, cfg.Network, cfg.Address)
+			}
+
 			servers[name] = network.NewServer(
 				runCtx,
 				network.Server{
@@ -918,6 +1213,16 @@ var runCmd = &cobra.Command{
 					CertFile:                   cfg.CertFile,
 					KeyFile:                    cfg.KeyFile,
 					HandshakeTimeout:           cfg.HandshakeTimeout,
+					MinTLSVersion:              cfg.MinTLSVersion,
+					MaxTLSVersion:              cfg.MaxTLSVersion,
+					CipherSuites:               cfg.CipherSuites,
+					ClientCAFile:               cfg.ClientCAFile,
+					ClientAuth:                 cfg.ClientAuth,
+					AcceptProxyProtocol:        cfg.AcceptProxyProtocol,
+					TrustedProxies:             cfg.TrustedProxies,
+					DrainTimeout:               cfg.DrainTimeout,
+					Listener:                   listener,
+					ErrorResponses:             cfg.ErrorResponses,
 					LoadbalancerStrategyName:   cfg.LoadBalancer.Strategy,
 					LoadbalancerRules:          cfg.LoadBalancer.LoadBalancingRules,
 					LoadbalancerConsistentHash: cfg.LoadBalancer.ConsistentHash,
@@ -934,6 +1239,14 @@ var runCmd = &cobra.Command{
 				attribute.String("certFile", cfg.CertFile),
 				attribute.String("keyFile", cfg.KeyFile),
 				attribute.String("handshakeTimeout", cfg.HandshakeTimeout.String()),
+				attribute.String("minTLSVersion", cfg.MinTLSVersion),
+				attribute.String("maxTLSVersion", cfg.MaxTLSVersion),
+				attribute.StringSlice("cipherSuites", cfg.CipherSuites),
+				attribute.String("clientCAFile", cfg.ClientCAFile),
+				attribute.String("clientAuth", cfg.ClientAuth),
+				attribute.Bool("acceptProxyProtocol", cfg.AcceptProxyProtocol),
+				attribute.StringSlice("trustedProxies", cfg.TrustedProxies),
+				attribute.String("drainTimeout", cfg.DrainTimeout.String()),
 			))
 
 			pluginTimeoutCtx, cancel = context.WithTimeout(
@@ -952,8 +1265,264 @@ var runCmd = &cobra.Command{
 			}
 		}
 
+		// The sockets of the servers that are removed from the configuration are closed.
+		if unused := systemdSockets.CloseUnused(); len(unused) > 0 {
+			logger.Warn().Strs("sockets", unused).Msg("The sockets passed by systemd aren't used by any server")
+		}
+
 		span.End()
 
+		// The configuration is reloaded on SIGHUP and by the API, without dropping the connections.
+		// The proxies whose configuration is changed are replaced, so that the new connections are
+		// served by the new proxies while the old ones finish serving theirs. The changes that
+		// can't be applied without a restart, such as the addresses of the servers, are reported.
+		var apiObj *api.API
+		reloadMu := &sync.Mutex{}
+		reloadConfig := func() (*api.ReloadResult, error) {
+			reloadMu.Lock()
+			defer reloadMu.Unlock()
+
+			reloadCtx, span := otel.Tracer(config.TracerName).Start(runCtx, "Reload configuration")
+			defer span.End()
+
+			fail := func(err error) (*api.ReloadResult, error) {
+				logger.Error().Err(err).Msg("Failed to reload the configuration")
+				span.RecordError(err)
+				return nil, err
+			}
+
+			logger.Info().Msg("Reloading the configuration")
+			if enableLinting {
+				if err := lintConfig(Global, globalConfigFile); err != nil {
+					return fail(err)
+				}
+				if err := lintConfig(Plugins, pluginConfigFile); err != nil {
+					return fail(err)
+				}
+			}
+
+			newConf := config.NewConfig(reloadCtx, config.Config{
+				GlobalConfigFile: globalConfigFile,
+				PluginConfigFile: pluginConfigFile,
+			})
+			if err := newConf.InitConfig(reloadCtx); err != nil {
+				return fail(err)
+			}
+
+			// The plugins can modify the reloaded config, like the one that is loaded on start.
+			pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), conf.Plugin.Timeout)
+			defer cancel()
+			updatedGlobalConfig, err := pluginRegistry.Run(
+				pluginTimeoutCtx, newConf.GlobalKoanf.All(), v1.HookName_HOOK_NAME_ON_CONFIG_LOADED)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to run OnConfigLoaded hooks")
+				span.RecordError(err)
+			}
+			if updatedGlobalConfig != nil {
+				if err := newConf.MergeGlobalConfig(reloadCtx, updatedGlobalConfig); err != nil {
+					return fail(err)
+				}
+			}
+
+			fillDefaults(newConf)
+			diff := conf.Diff(newConf)
+
+			// The proxies that read from the replaced or removed proxies are replaced as well.
+			replaced := slices.Concat(diff.ChangedProxies, readingProxies(
+				newConf, slices.Concat(diff.AddedProxies, diff.ChangedProxies, diff.RemovedProxies)))
+			created := slices.Concat(diff.AddedProxies, replaced)
+
+			// The pools and the proxies are created on copies of the maps, which replace the
+			// running ones once all of them are created. Once the servers run, the maps are only
+			// read and replaced by the reloads, which are serialized, and the API gets them by
+			// API.Reload, while the configuration is also read by other goroutines.
+			runningPools, runningProxies, runningClients := pools, proxies, clients
+			runningWaitQueues, runningPoolManagers, runningCircuitBreakers := waitQueues, poolManagers, circuitBreakers
+			pools, proxies, clients = cloneGroups(pools), cloneGroups(proxies), cloneGroups(clients)
+			waitQueues, poolManagers, circuitBreakers =
+				cloneGroups(waitQueues), cloneGroups(poolManagers), cloneGroups(circuitBreakers)
+			var newProxies []*network.Proxy
+			rollback := func() {
+				for _, proxy := range newProxies {
+					proxy.Shutdown()
+				}
+				pools, proxies, clients = runningPools, runningProxies, runningClients
+				waitQueues, poolManagers, circuitBreakers =
+					runningWaitQueues, runningPoolManagers, runningCircuitBreakers
+			}
+
+			for _, proxy := range created {
+				poolConfig := newConf.Global.Pools[proxy.Group][proxy.Block]
+				if poolConfig == nil {
+					poolConfig = &config.Pool{}
+				}
+				createPool(newConf, span, proxy.Group, proxy.Block, poolConfig)
+				if err := createProxy(
+					newConf, span, proxy.Group, proxy.Block, newConf.Global.Proxies[proxy.Group][proxy.Block],
+				); err != nil {
+					poolManagers[proxy.Group][proxy.Block].Shutdown()
+					rollback()
+					return fail(err)
+				}
+				newProxies = append(newProxies, proxies[proxy.Group][proxy.Block])
+			}
+			for _, proxy := range created {
+				if readProxy := newConf.Global.Proxies[proxy.Group][proxy.Block].ReadWriteSplit.ReadProxy; readProxy != "" {
+					proxies[proxy.Group][proxy.Block].ReadProxy = proxies[proxy.Group][readProxy]
+				}
+			}
+			for _, proxy := range diff.RemovedProxies {
+				delete(pools[proxy.Group], proxy.Block)
+				delete(proxies[proxy.Group], proxy.Block)
+				delete(clients[proxy.Group], proxy.Block)
+				delete(waitQueues[proxy.Group], proxy.Block)
+				delete(poolManagers[proxy.Group], proxy.Block)
+				delete(circuitBreakers[proxy.Group], proxy.Block)
+			}
+
+			// The servers route the new connections to their new proxies, by their new load
+			// balancers. The reloaded servers are reverted if the load balancer of any other
+			// server can't be created.
+			reloadServer := func(
+				name string, globalConf *config.Config, groupProxies map[string]*network.Proxy,
+			) *gerr.GatewayDError {
+				serverProxies := make([]network.IProxy, 0, len(groupProxies))
+				for _, proxy := range groupProxies {
+					serverProxies = append(serverProxies, proxy)
+				}
+				loadBalancer := globalConf.Global.Servers[name].LoadBalancer
+				return servers[name].Reload(network.Server{
+					Proxies:                    serverProxies,
+					LoadbalancerStrategyName:   loadBalancer.Strategy,
+					LoadbalancerRules:          loadBalancer.LoadBalancingRules,
+					LoadbalancerConsistentHash: loadBalancer.ConsistentHash,
+				})
+			}
+			changedServers := make(map[string]bool)
+			for _, proxy := range slices.Concat(created, diff.RemovedProxies) {
+				changedServers[proxy.Group] = true
+			}
+			for _, name := range diff.LoadBalancers {
+				changedServers[name] = true
+			}
+			serverNames := maps.Keys(changedServers)
+			slices.Sort(serverNames)
+			var reloadedServers []string
+			for _, name := range serverNames {
+				if servers[name] == nil || newConf.Global.Servers[name] == nil {
+					continue
+				}
+				if err := reloadServer(name, newConf, proxies[name]); err != nil {
+					for _, reloadedName := range reloadedServers {
+						if err := reloadServer(reloadedName, conf, runningProxies[reloadedName]); err != nil {
+							logger.Error().Err(err).Str("name", reloadedName).Msg("Failed to revert the server")
+						}
+					}
+					rollback()
+					return fail(err)
+				}
+				reloadedServers = append(reloadedServers, name)
+			}
+
+			for _, proxy := range diff.ResizedPools {
+				_, minPoolSize, maxPoolSize := poolSizes(newConf.Global.Pools[proxy.Group][proxy.Block])
+				poolManagers[proxy.Group][proxy.Block].Resize(minPoolSize, maxPoolSize)
+			}
+
+			// The level is global to the loggers, so the level of the default logger is used.
+			if loggerConfig := newConf.Global.Loggers[config.Default]; diff.LogLevel && loggerConfig != nil {
+				zerolog.SetGlobalLevel(logLevel(loggerConfig.Level))
+			}
+
+			if diff.Policies {
+				actRegistry.ReloadPolicies(loadPolicies(newConf, logger), newConf.Plugin.DefaultPolicy)
+			}
+
+			// The replaced and removed proxies are shut down once their connections are closed,
+			// or once the drain timeout of their servers expires.
+			var retired []*network.Proxy
+			var retireTimeout time.Duration
+			for _, proxy := range slices.Concat(replaced, diff.RemovedProxies) {
+				if runningProxy := runningProxies[proxy.Group][proxy.Block]; runningProxy != nil {
+					retired = append(retired, runningProxy)
+					if serverConfig := conf.Global.Servers[proxy.Group]; serverConfig != nil {
+						retireTimeout = max(retireTimeout, serverConfig.DrainTimeout)
+					}
+				}
+			}
+			if len(retired) > 0 {
+				go retireProxies(runCtx, retired, config.If(
+					retireTimeout > 0, retireTimeout, config.DefaultDrainTimeout), logger)
+			}
+
+			setConfig(newConf)
+			if apiObj != nil {
+				apiObj.Reload(conf, pools, proxies)
+			}
+
+			result := &api.ReloadResult{
+				Applied:         describeChanges(diff, replaced),
+				RestartRequired: append([]string{}, diff.RestartRequired...),
+			}
+			events.Add(api.EventConfigReloaded, map[string]interface{}{
+				"applied":         result.Applied,
+				"restartRequired": result.RestartRequired,
+			})
+			span.SetAttributes(
+				attribute.StringSlice("applied", result.Applied),
+				attribute.StringSlice("restartRequired", result.RestartRequired),
+			)
+			logger.Info().Strs("applied", result.Applied).Msg("Reloaded the configuration")
+			if len(result.RestartRequired) > 0 {
+				logger.Warn().Strs("sections", result.RestartRequired).Msg(
+					"The changes of these configuration sections are ignored until GatewayD is restarted")
+			}
+
+			return result, nil
+		}
+
+		// The servers are drained on the drain signals and by the API before GatewayD is shut down,
+		// e.g. on rolling deploys, so that the clients finish their transactions instead of having
+		// them aborted. The connections that are left after the drain timeout are closed.
+		draining := &atomic.Bool{}
+		drainServers := func(sig os.Signal) bool {
+			if !draining.CompareAndSwap(false, true) {
+				return false
+			}
+
+			names := maps.Keys(servers)
+			slices.Sort(names)
+			events.Add(api.EventDrainStarted, map[string]interface{}{"servers": names})
+			notifySystemd(logger, "STATUS=Draining the servers")
+
+			go func() {
+				var wait sync.WaitGroup
+				for _, server := range servers {
+					wait.Add(1)
+					go func(server *network.Server) {
+						defer wait.Done()
+						server.Drain()
+					}(server)
+				}
+				wait.Wait()
+
+				StopGracefully(
+					runCtx,
+					sig,
+					metricsMerger,
+					metricsServer,
+					pluginRegistry,
+					logger,
+					servers,
+					stopChan,
+					httpServer,
+					grpcServer,
+				)
+				os.Exit(0)
+			}()
+			return true
+		}
+
 		// Start the HTTP and gRPC APIs.
 		if conf.Global.API.Enabled {
 			apiOptions := api.Options{
@@ -962,9 +1531,16 @@ var runCmd = &cobra.Command{
 				GRPCAddress: conf.Global.API.GRPCAddress,
 				HTTPAddress: conf.Global.API.HTTPAddress,
 				Servers:     servers,
+				Events:      events,
+				Reload:      reloadConfig,
+				Drain: func() bool {
+					return drainServers(nil)
+				},
+				HTTPListener: takeover.Listener(httpAPIListenerName),
+				GRPCListener: takeover.Listener(grpcAPIListenerName),
 			}
 
-			apiObj := &api.API{
+			apiObj = &api.API{
 				Options:        &apiOptions,
 				Config:         conf,
 				PluginRegistry: pluginRegistry,
@@ -1053,7 +1629,6 @@ var runCmd = &cobra.Command{
 			syscall.SIGTERM,
 			syscall.SIGABRT,
 			syscall.SIGQUIT,
-			syscall.SIGHUP,
 			syscall.SIGINT,
 		)
 		signalsCh := make(chan os.Signal, 1)
@@ -1088,6 +1663,26 @@ var runCmd = &cobra.Command{
 			}
 		}(pluginRegistry, logger, servers, metricsMerger, metricsServer, stopChan, httpServer, grpcServer)
 
+		// Reload the configuration on SIGHUP. The errors are logged by the reload.
+		reloadCh := make(chan os.Signal, 1)
+		signal.Notify(reloadCh, syscall.SIGHUP)
+		go func() {
+			for range reloadCh {
+				_, _ = reloadConfig()
+			}
+		}()
+
+		// Drain the servers on the drain signals, e.g. SIGUSR1, and shut down afterwards.
+		if len(drainSignals) > 0 {
+			drainCh := make(chan os.Signal, 1)
+			signal.Notify(drainCh, drainSignals...)
+			go func() {
+				for sig := range drainCh {
+					drainServers(sig)
+				}
+			}()
+		}
+
 		_, span = otel.Tracer(config.TracerName).Start(runCtx, "Start servers")
 		// Start the server.
 		for name, server := range servers {
@@ -1117,6 +1712,66 @@ var runCmd = &cobra.Command{
 		}
 		span.End()
 
+		// The servers are ready once they accept the connections on their listeners.
+		if !waitForServers(servers, serversStartTimeout) {
+			logger.Warn().Msg("The servers aren't listening yet")
+		}
+
+		// The process that is taken over stops accepting connections and drains, once the servers
+		// run on its listeners. The clients that connect meanwhile are queued by the listeners.
+		if takeover != nil {
+			if err := takeover.Ready(); err != nil {
+				logger.Error().Err(err).Msg("Failed to take over the running GatewayD process")
+			} else {
+				logger.Info().Msg("Took over the running GatewayD process")
+			}
+		}
+
+		// Hand off the listeners to the new GatewayD process on upgrades, and drain the servers
+		// once it runs on them.
+		if upgradeSocket != "" {
+			listeners := make(map[string]net.Listener)
+			if metricsListener != nil {
+				listeners[metricsListenerName] = metricsListener
+			}
+			if httpServer != nil && httpServer.Listener() != nil {
+				listeners[httpAPIListenerName] = httpServer.Listener()
+			}
+			if grpcServer != nil {
+				listeners[grpcAPIListenerName] = grpcServer.Listener()
+			}
+			handoffServer = network.NewHandoffServer(runCtx, network.HandoffServer{
+				Path:      upgradeSocket,
+				Servers:   servers,
+				Listeners: listeners,
+				Logger:    logger,
+				OnHandoff: func() {
+					drainServers(nil)
+				},
+			})
+			go func() {
+				if err := handoffServer.Run(); err != nil {
+					logger.Error().Err(err).Msg("Failed to serve the upgrade socket")
+				}
+			}()
+		}
+
+		// Tell systemd that GatewayD is ready, and ping its watchdog while the servers are running.
+		// The pools are filled before the servers start, but the ones whose servers can't be
+		// reached are filled in the background, so GatewayD is reported ready without them and
+		// their clients wait for the server connections. The status of systemd tells which.
+		if len(unfilled) > 0 {
+			logger.Warn().Strs("pools", unfilled).Msg(
+				"GatewayD is ready, but the pools aren't filled yet and are filled in the background")
+			notifySystemd(logger, "STATUS=Filling the pools in the background: "+strings.Join(unfilled, ", "))
+		}
+		notifySystemd(logger, "READY=1")
+		if interval, err := network.WatchdogInterval(); err != nil {
+			logger.Error().Err(err).Msg("Failed to enable the watchdog of systemd")
+		} else if interval > 0 {
+			go pingWatchdog(logger, servers, interval)
+		}
+
 		// Wait for the server to shut down.
 		<-stopChan
 	},
@@ -1146,4 +1801,10 @@ func init() {
 		&enableUsageReport, "usage-report", true, "Enable usage report")
 	runCmd.Flags().BoolVar(
 		&enableLinting, "lint", true, "Enable linting of configuration files")
+	runCmd.Flags().StringVar(
+		&upgradeSocket, "upgrade-socket", "",
+		"Unix socket on which the listeners are handed off to the new process on upgrades")
+	runCmd.Flags().BoolVar(
+		&takeOver, "takeover", false,
+		"Take over the listeners of the running process on the upgrade socket")
 }
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignals are the signals on which the servers are drained before GatewayD is shut down.
+var drainSignals = []os.Signal{syscall.SIGUSR1}
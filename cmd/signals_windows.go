@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import "os"
+
+// drainSignals are the signals on which the servers are drained before GatewayD is shut down.
+// Windows has no user-defined signals, so the servers are only drained by the API.
+var drainSignals []os.Signal
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"slices"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/network"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// serversStartTimeout is how long the servers may take to accept the connections on their
+	// listeners, before GatewayD is reported to be ready nonetheless.
+	serversStartTimeout = 10 * time.Second
+	// serversStartPollInterval is the interval of checking whether the servers are listening.
+	serversStartPollInterval = 10 * time.Millisecond
+)
+
+// waitForServers waits until all the servers accept the connections on their listeners, and
+// returns false if they don't within the timeout.
+func waitForServers(servers map[string]*network.Server, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		listening := true
+		for _, server := range servers {
+			listening = listening && server.IsListening()
+		}
+		if listening {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(serversStartPollInterval)
+	}
+}
+
+// serversRunning returns true if all the servers are running.
+func serversRunning(servers map[string]*network.Server) bool {
+	for _, server := range servers {
+		if !server.IsRunning() {
+			return false
+		}
+	}
+	return true
+}
+
+// unfilledPools returns the names of the pools that have less than their minimum number of
+// server connections, which are then filled in the background.
+func unfilledPools(poolManagers map[string]map[string]*network.PoolManager) []string {
+	var names []string
+	for configGroupName, configGroup := range poolManagers {
+		for configBlockName, poolManager := range configGroup {
+			if !poolManager.Filled() {
+				names = append(names, configGroupName+"."+configBlockName)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// notifySystemd sends the state to systemd, if GatewayD is run by a service of Type=notify.
+func notifySystemd(logger zerolog.Logger, state string) {
+	if _, err := network.SdNotify(state); err != nil {
+		logger.Error().Err(err).Str("state", state).Msg("Failed to notify systemd")
+	}
+}
+
+// pingWatchdog pings the watchdog of systemd with the given interval as long as all the servers
+// are running, so that systemd restarts GatewayD once they aren't.
+func pingWatchdog(logger zerolog.Logger, servers map[string]*network.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if serversRunning(servers) {
+			notifySystemd(logger, "WATCHDOG=1")
+		} else {
+			logger.Warn().Msg("The servers aren't running, the watchdog of systemd isn't pinged")
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net"
 	"os"
 	"reflect"
 	"sort"
@@ -145,14 +146,48 @@ func (c *Config) LoadDefaults(ctx context.Context) *gerr.GatewayDError {
 		Backoff:            DefaultBackoff,
 		BackoffMultiplier:  DefaultBackoffMultiplier,
 		DisableBackoffCaps: DefaultDisableBackoffCaps,
+		SSLMode:            DefaultSSLMode,
 	}
 
 	defaultPool := Pool{
-		Size: DefaultPoolSize,
+		Size:                 DefaultPoolSize,
+		PerUser:              false,
+		UserPoolSize:         DefaultUserPoolSize,
+		MaxServerConnections: DefaultMaxServerConnections,
+		IdleTimeout:          DefaultIdleTimeout,
+		WaitQueueSize:        DefaultWaitQueueSize,
+		WaitTimeout:          DefaultWaitTimeout,
 	}
 
 	defaultProxy := Proxy{
 		HealthCheckPeriod: DefaultHealthCheckPeriod,
+		PoolMode:          DefaultPoolMode,
+		ResetStrategy:     DefaultResetStrategy,
+		Authentication: Authentication{
+			Method:       DefaultAuthMethod,
+			AuthPoolSize: DefaultAuthPoolSize,
+		},
+		HealthCheck: HealthCheck{
+			Method:             DefaultHealthCheckMethod,
+			Interval:           DefaultHealthCheckInterval,
+			Timeout:            DefaultHealthCheckTimeout,
+			HealthyThreshold:   DefaultHealthyThreshold,
+			UnhealthyThreshold: DefaultUnhealthyThreshold,
+		},
+		RoleMonitor: RoleMonitor{
+			Enabled:    false,
+			Interval:   DefaultRoleMonitorInterval,
+			Timeout:    DefaultRoleMonitorTimeout,
+			OnFailover: DefaultFailoverAction,
+		},
+		CircuitBreaker: CircuitBreaker{
+			Enabled:          false,
+			FailureRate:      DefaultCircuitFailureRate,
+			MinRequests:      DefaultCircuitMinRequests,
+			Window:           DefaultCircuitWindow,
+			OpenTimeout:      DefaultCircuitOpenTimeout,
+			HalfOpenRequests: DefaultCircuitHalfOpenRequests,
+		},
 	}
 
 	defaultServer := Server{
@@ -164,7 +199,10 @@ func (c *Config) LoadDefaults(ctx context.Context) *gerr.GatewayDError {
 		CertFile:         "",
 		KeyFile:          "",
 		HandshakeTimeout: DefaultHandshakeTimeout,
+		MinTLSVersion:    DefaultMinTLSVersion,
+		ClientAuth:       DefaultClientAuth,
 		LoadBalancer:     LoadBalancer{Strategy: DefaultLoadBalancerStrategy},
+		DrainTimeout:     DefaultDrainTimeout,
 	}
 
 	c.globalDefaults = GlobalConfig{
@@ -640,6 +678,12 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) *gerr.GatewayDError {
 				span.RecordError(err)
 				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
 			}
+			for _, err := range validateClientTLS(
+				globalConfig.Clients[configGroupName][configBlockName], configGroupName, configBlockName,
+			) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
 		}
 	}
 
@@ -658,6 +702,15 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) *gerr.GatewayDError {
 			span.RecordError(err)
 			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
 		}
+		for configBlockName, poolConfig := range globalConfig.Pools[configGroup] {
+			if poolConfig == nil {
+				continue
+			}
+			for _, err := range validatePool(poolConfig, configGroup, configBlockName) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+		}
 	}
 
 	if len(globalConfig.Pools) > 1 {
@@ -675,6 +728,75 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) *gerr.GatewayDError {
 			span.RecordError(err)
 			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
 		}
+		for configBlockName, proxyConfig := range globalConfig.Proxies[configGroup] {
+			if proxyConfig == nil {
+				continue
+			}
+			for _, err := range validatePoolMode(
+				proxyConfig,
+				globalConfig.Pools[configGroup][configBlockName],
+				configGroup,
+				configBlockName,
+			) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			if proxyConfig.ResetStrategy != "" && !slices.Contains(
+				[]string{ResetReconnect, ResetDiscardAll, ResetQuery},
+				proxyConfig.ResetStrategy) {
+				err := fmt.Errorf(`"proxies.%s.%s.resetStrategy" is invalid: %s`,
+					configGroup, configBlockName, proxyConfig.ResetStrategy)
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			if proxyConfig.ResetStrategy == ResetQuery && strings.TrimSpace(proxyConfig.ResetQuery) == "" {
+				err := fmt.Errorf(`"proxies.%s.%s.resetQuery" is required by the query reset strategy`,
+					configGroup, configBlockName)
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			for _, err := range validateAuthentication(
+				proxyConfig.Authentication,
+				globalConfig.Clients[configGroup][configBlockName],
+				globalConfig.Pools[configGroup][configBlockName],
+				configGroup,
+				configBlockName,
+			) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			for _, err := range validateReadWriteSplit(
+				proxyConfig, globalConfig.Proxies[configGroup], globalConfig.Pools[configGroup],
+				configGroup, configBlockName,
+			) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			for _, err := range validateHealthCheck(
+				proxyConfig.HealthCheck,
+				globalConfig.Clients[configGroup][configBlockName],
+				configGroup,
+				configBlockName,
+			) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			if cb := proxyConfig.CircuitBreaker; cb.Enabled && (cb.FailureRate < 0 || cb.FailureRate > 1) {
+				err := fmt.Errorf(`"proxies.%s.%s.circuitBreaker.failureRate" must be between 0 and 1`,
+					configGroup, configBlockName)
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+			for _, err := range validateRoleMonitor(
+				proxyConfig.RoleMonitor,
+				globalConfig.Clients[configGroup][configBlockName],
+				configGroup,
+				configBlockName,
+			) {
+				span.RecordError(err)
+				errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+			}
+		}
 	}
 
 	if len(globalConfig.Proxies) > 1 {
@@ -696,6 +818,27 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) *gerr.GatewayDError {
 			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
 		}
 
+		for _, err := range validateServerTLS(serverConfig, configGroup) {
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+
+		if serverConfig.DrainTimeout < 0 {
+			err := fmt.Errorf(`"servers.%s.drainTimeout" must not be negative`, configGroup)
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+
+		for _, err := range validateErrorResponses(serverConfig, configGroup) {
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+
+		for _, err := range validateConsistentHash(serverConfig, configGroup) {
+			span.RecordError(err)
+			errors = append(errors, gerr.ErrValidationFailed.Wrap(err))
+		}
+
 		// Validate Load Balancing Rules
 		validatelBRulesErrors := ValidateLoadBalancingRules(serverConfig, configGroup, clientConfigGroups)
 		for _, err := range validatelBRulesErrors {
@@ -784,6 +927,276 @@ func (c *Config) ValidateGlobalConfig(ctx context.Context) *gerr.GatewayDError {
 	return nil
 }
 
+// validatePoolMode validates the pool mode of a proxy. The server connections are shared between
+// the clients in the transaction and statement pool modes, so the clients must either be
+// authenticated by the proxy or have per-user pools. Otherwise, a server connection on which a
+// client has authenticated as its own user would be shared with the clients of any other user.
+func validatePoolMode(proxyConfig *Proxy, poolConfig *Pool, configGroup, configBlock string) []error {
+	var errors []error
+
+	switch proxyConfig.PoolMode {
+	case "", SessionPoolMode:
+	case TransactionPoolMode, StatementPoolMode:
+		method := proxyConfig.Authentication.Method
+		perUser := poolConfig != nil && poolConfig.PerUser
+		if (method == "" || method == AuthMethodNone) && !perUser {
+			errors = append(errors, fmt.Errorf(
+				`"proxies.%s.%s.poolMode" %s requires the authentication by the proxy or per-user pools`,
+				configGroup, configBlock, proxyConfig.PoolMode))
+		}
+	default:
+		errors = append(errors, fmt.Errorf(`"proxies.%s.%s.poolMode" is invalid: %s`,
+			configGroup, configBlock, proxyConfig.PoolMode))
+	}
+
+	return errors
+}
+
+// validateAuthentication validates the authentication of the incoming connections of a proxy.
+// The server connections must be authenticated with the configured credentials, since the
+// clients don't authenticate to the server themselves, unless the pools are per user.
+func validateAuthentication(
+	auth Authentication, clientConfig *Client, poolConfig *Pool, configGroup, configBlock string,
+) []error {
+	var errors []error
+
+	if auth.Method == "" || auth.Method == AuthMethodNone {
+		return errors
+	}
+
+	if !slices.Contains([]string{AuthMethodMD5, AuthMethodSCRAMSHA256}, auth.Method) {
+		errors = append(errors, fmt.Errorf(`"proxies.%s.%s.authentication.method" is invalid: %s`,
+			configGroup, configBlock, auth.Method))
+		return errors
+	}
+
+	if auth.UserList == "" && auth.AuthQuery == "" {
+		errors = append(errors, fmt.Errorf(
+			`"proxies.%s.%s.authentication" requires either a userList or an authQuery`,
+			configGroup, configBlock))
+	}
+
+	// The server connections of the per-user pools are authenticated as the users of the
+	// incoming connections, so the configured user is only needed for the auth query.
+	perUser := poolConfig != nil && poolConfig.PerUser
+	if (!perUser || auth.AuthQuery != "") && (clientConfig == nil || clientConfig.User == "") {
+		errors = append(errors, fmt.Errorf(
+			`"clients.%s.%s.user" is required when the clients are authenticated by the proxy`,
+			configGroup, configBlock))
+	}
+
+	return errors
+}
+
+// validateReadWriteSplit validates the read proxy of a proxy, which must be another proxy of the
+// same group. The server connections are only shared in the transaction and statement pool modes,
+// and the pools of both proxies must be authenticated the same way.
+func validateReadWriteSplit(
+	proxyConfig *Proxy, groupProxies map[string]*Proxy, groupPools map[string]*Pool,
+	configGroup, configBlock string,
+) []error {
+	var errors []error
+
+	split := proxyConfig.ReadWriteSplit
+	if split.ReadProxy == "" {
+		return errors
+	}
+
+	if split.ReadProxy == configBlock || groupProxies[split.ReadProxy] == nil {
+		errors = append(errors, fmt.Errorf(
+			`"proxies.%s.%s.readWriteSplit.readProxy" must be another proxy of the group: %s`,
+			configGroup, configBlock, split.ReadProxy))
+	}
+
+	if proxyConfig.PoolMode != TransactionPoolMode && proxyConfig.PoolMode != StatementPoolMode {
+		errors = append(errors, fmt.Errorf(
+			`"proxies.%s.%s.readWriteSplit" requires the transaction or statement pool mode`,
+			configGroup, configBlock))
+	}
+
+	perUser := func(name string) bool {
+		return groupPools[name] != nil && groupPools[name].PerUser
+	}
+	if perUser(configBlock) != perUser(split.ReadProxy) {
+		errors = append(errors, fmt.Errorf(
+			`"pools.%s.%s.perUser" and "pools.%s.%s.perUser" must be the same for the read/write split`,
+			configGroup, configBlock, configGroup, split.ReadProxy))
+	}
+
+	for _, name := range split.WriteFunctions {
+		if strings.TrimSpace(name) == "" {
+			errors = append(errors, fmt.Errorf(
+				`"proxies.%s.%s.readWriteSplit.writeFunctions" contains an empty function name`,
+				configGroup, configBlock))
+			break
+		}
+	}
+
+	return errors
+}
+
+// validatePool validates the sizes, the limits of the server connections and the wait
+// queue of a pool.
+func validatePool(poolConfig *Pool, configGroup, configBlock string) []error {
+	var errors []error
+
+	if poolConfig.MinSize < 0 || poolConfig.MaxSize < 0 {
+		errors = append(errors, fmt.Errorf(
+			`"pools.%s.%s" sizes must not be negative`, configGroup, configBlock))
+	}
+
+	if maxSize := If(poolConfig.MaxSize > 0, poolConfig.MaxSize, poolConfig.Size); maxSize > 0 &&
+		poolConfig.MinSize > maxSize {
+		errors = append(errors, fmt.Errorf(
+			`"pools.%s.%s.minSize" must not be greater than the maximum size`, configGroup, configBlock))
+	}
+
+	if poolConfig.MaxLifetime < 0 || poolConfig.MaxUses < 0 {
+		errors = append(errors, fmt.Errorf(
+			`"pools.%s.%s" maxLifetime and maxUses must not be negative`, configGroup, configBlock))
+	}
+
+	if poolConfig.WaitQueueSize < 0 {
+		errors = append(errors, fmt.Errorf(
+			`"pools.%s.%s.waitQueueSize" must not be negative`, configGroup, configBlock))
+	}
+
+	if poolConfig.WaitTimeout < 0 {
+		errors = append(errors, fmt.Errorf(
+			`"pools.%s.%s.waitTimeout" must not be negative`, configGroup, configBlock))
+	}
+
+	return errors
+}
+
+// validateHealthCheck validates the health check of the server of a proxy. The query is run on
+// a server connection that is authenticated with the credentials of the client configuration.
+func validateHealthCheck(
+	healthCheck HealthCheck, clientConfig *Client, configGroup, configBlock string,
+) []error {
+	var errors []error
+
+	if healthCheck.Method == "" || healthCheck.Method == HealthCheckNone {
+		return errors
+	}
+
+	if !slices.Contains([]string{HealthCheckTCP, HealthCheckSSL, HealthCheckQuery}, healthCheck.Method) {
+		errors = append(errors, fmt.Errorf(`"proxies.%s.%s.healthCheck.method" is invalid: %s`,
+			configGroup, configBlock, healthCheck.Method))
+		return errors
+	}
+
+	if healthCheck.Method == HealthCheckQuery && (clientConfig == nil || clientConfig.User == "") {
+		errors = append(errors, fmt.Errorf(
+			`"clients.%s.%s.user" is required by the query health check`, configGroup, configBlock))
+	}
+
+	if healthCheck.HealthyThreshold < 0 || healthCheck.UnhealthyThreshold < 0 {
+		errors = append(errors, fmt.Errorf(
+			`"proxies.%s.%s.healthCheck" thresholds must not be negative`, configGroup, configBlock))
+	}
+
+	return errors
+}
+
+// validateRoleMonitor validates the role monitor of the server of a proxy, which runs its query on
+// a server connection that is authenticated with the credentials of the client configuration.
+func validateRoleMonitor(
+	roleMonitor RoleMonitor, clientConfig *Client, configGroup, configBlock string,
+) []error {
+	var errors []error
+
+	if !roleMonitor.Enabled {
+		return errors
+	}
+
+	if clientConfig == nil || clientConfig.User == "" {
+		errors = append(errors, fmt.Errorf(
+			`"clients.%s.%s.user" is required by the role monitor`, configGroup, configBlock))
+	}
+
+	if roleMonitor.OnFailover != "" &&
+		!slices.Contains([]string{FailoverDrain, FailoverKill}, roleMonitor.OnFailover) {
+		errors = append(errors, fmt.Errorf(`"proxies.%s.%s.roleMonitor.onFailover" is invalid: %s`,
+			configGroup, configBlock, roleMonitor.OnFailover))
+	}
+
+	return errors
+}
+
+// validateClientTLS validates the TLS and the PROXY protocol settings of the server connections.
+func validateClientTLS(clientConfig *Client, configGroup, configBlock string) []error {
+	var errors []error
+
+	if clientConfig == nil {
+		return errors
+	}
+
+	if clientConfig.SSLMode != "" && !slices.Contains(
+		[]string{SSLModeDisable, SSLModePrefer, SSLModeRequire, SSLModeVerifyCA, SSLModeVerifyFull},
+		clientConfig.SSLMode) {
+		errors = append(errors, fmt.Errorf(`"clients.%s.%s.sslMode" is invalid: %s`,
+			configGroup, configBlock, clientConfig.SSLMode))
+	}
+
+	if (clientConfig.SSLCert == "") != (clientConfig.SSLKey == "") {
+		errors = append(errors, fmt.Errorf(
+			`"clients.%s.%s" requires both an sslCert and an sslKey for client certificates`,
+			configGroup, configBlock))
+	}
+
+	if clientConfig.ProxyProtocol != "" && !slices.Contains(
+		[]string{ProxyProtocolNone, ProxyProtocolV1, ProxyProtocolV2}, clientConfig.ProxyProtocol) {
+		errors = append(errors, fmt.Errorf(`"clients.%s.%s.proxyProtocol" is invalid: %s`,
+			configGroup, configBlock, clientConfig.ProxyProtocol))
+	}
+
+	return errors
+}
+
+// validateServerTLS validates the TLS and the PROXY protocol settings of the incoming connections.
+func validateServerTLS(serverConfig *Server, configGroup string) []error {
+	var errors []error
+
+	tlsVersions := []string{TLSVersion10, TLSVersion11, TLSVersion12, TLSVersion13}
+	if serverConfig.MinTLSVersion != "" && !slices.Contains(tlsVersions, serverConfig.MinTLSVersion) {
+		errors = append(errors, fmt.Errorf(`"servers.%s.minTLSVersion" is invalid: %s`,
+			configGroup, serverConfig.MinTLSVersion))
+	}
+	if serverConfig.MaxTLSVersion != "" && !slices.Contains(tlsVersions, serverConfig.MaxTLSVersion) {
+		errors = append(errors, fmt.Errorf(`"servers.%s.maxTLSVersion" is invalid: %s`,
+			configGroup, serverConfig.MaxTLSVersion))
+	}
+	// The versions have a single digit after the dot, so they can be compared as strings.
+	if serverConfig.MinTLSVersion != "" && serverConfig.MaxTLSVersion != "" &&
+		serverConfig.MinTLSVersion > serverConfig.MaxTLSVersion {
+		errors = append(errors, fmt.Errorf(
+			`"servers.%s.minTLSVersion" is greater than "servers.%s.maxTLSVersion"`,
+			configGroup, configGroup))
+	}
+
+	if serverConfig.ClientAuth != "" && !slices.Contains(
+		[]string{ClientAuthNone, ClientAuthRequest, ClientAuthRequire, ClientAuthVerify},
+		serverConfig.ClientAuth) {
+		errors = append(errors, fmt.Errorf(`"servers.%s.clientAuth" is invalid: %s`,
+			configGroup, serverConfig.ClientAuth))
+	}
+
+	if serverConfig.AcceptProxyProtocol && len(serverConfig.TrustedProxies) == 0 {
+		errors = append(errors, fmt.Errorf(`"servers.%s.trustedProxies" is required by acceptProxyProtocol`,
+			configGroup))
+	}
+
+	for _, cidr := range serverConfig.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errors = append(errors, fmt.Errorf(`"servers.%s.trustedProxies" is invalid: %w`,
+				configGroup, err))
+		}
+	}
+
+	return errors
+}
+
 // generateTagMapping generates a map of JSON tags to lower case json tags.
 func generateTagMapping(structs []interface{}, tagMapping map[string]string) {
 	for _, s := range structs {
@@ -809,6 +1222,69 @@ func generateTagMapping(structs []interface{}, tagMapping map[string]string) {
 	}
 }
 
+// validateErrorResponses validates the names of the errors and the SQLSTATEs of the
+// ErrorResponses, which are five digits or uppercase letters.
+func validateErrorResponses(serverConfig *Server, configGroup string) []error {
+	var errors []error
+	for name, response := range serverConfig.ErrorResponses {
+		if _, ok := gerr.SessionErrCodes[name]; !ok {
+			errors = append(errors, fmt.Errorf(
+				`"servers.%s.errorResponses.%s" is not an error that ends the sessions`, configGroup, name))
+		}
+		if response.SQLState != "" && !isSQLState(response.SQLState) {
+			errors = append(errors, fmt.Errorf(
+				`"servers.%s.errorResponses.%s.sqlState" is invalid: %s`, configGroup, name, response.SQLState))
+		}
+	}
+	return errors
+}
+
+// isSQLState returns true if the code is a valid SQLSTATE.
+func isSQLState(code string) bool {
+	if len(code) != SQLStateLength {
+		return false
+	}
+	for _, char := range code {
+		if (char < '0' || char > '9') && (char < 'A' || char > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateConsistentHash validates the consistent hashing of the load balancer.
+func validateConsistentHash(serverConfig *Server, configGroup string) []error {
+	consistentHash := serverConfig.LoadBalancer.ConsistentHash
+	if consistentHash == nil {
+		return nil
+	}
+
+	var errors []error
+	switch consistentHash.HashKey {
+	case "", HashKeySourceIP, HashKeyUser, HashKeyDatabase:
+	case HashKeyParameter:
+		if consistentHash.Parameter == "" {
+			errors = append(errors, fmt.Errorf(
+				`"servers.%s.loadBalancer.consistentHash.parameter" is required by the parameter hash key`,
+				configGroup))
+		}
+	default:
+		errors = append(errors, fmt.Errorf(
+			`"servers.%s.loadBalancer.consistentHash.hashKey" is invalid: %s`,
+			configGroup, consistentHash.HashKey))
+	}
+	if consistentHash.VirtualNodes < 0 {
+		errors = append(errors, fmt.Errorf(
+			`"servers.%s.loadBalancer.consistentHash.virtualNodes" must not be negative`, configGroup))
+	}
+	if consistentHash.LoadFactor != 0 && consistentHash.LoadFactor < 1 {
+		errors = append(errors, fmt.Errorf(
+			`"servers.%s.loadBalancer.consistentHash.loadFactor" must be at least 1`, configGroup))
+	}
+
+	return errors
+}
+
 // ValidateLoadBalancingRules validates the load balancing rules in the server configuration.
 func ValidateLoadBalancingRules(
 	serverConfig *Server,
@@ -843,12 +1319,19 @@ func ValidateLoadBalancingRules(
 	return errors
 }
 
-// validateRuleCondition checks if the rule condition is empty for LoadBalancingRules.
+// validateRuleCondition checks if the rule condition is empty or invalid for LoadBalancingRules.
 func validateRuleCondition(condition string, configGroup string) error {
 	if condition == "" {
 		err := fmt.Errorf(`"servers.%s.loadBalancer.loadBalancingRules.condition" is nil or empty`, configGroup)
 		return err
 	}
+	if condition == DefaultLoadBalancerCondition {
+		return nil
+	}
+	if _, err := CompileCondition(condition); err != nil {
+		return fmt.Errorf(
+			`"servers.%s.loadBalancer.loadBalancingRules.condition" is invalid: %w`, configGroup, err)
+	}
 	return nil
 }
 
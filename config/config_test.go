@@ -257,3 +257,68 @@ func TestConvertKeysToLowercaseSuccess(t *testing.T) {
 		}
 	}
 }
+
+func Test_validateErrorResponses(t *testing.T) {
+	assert.Empty(t, validateErrorResponses(&Server{
+		ErrorResponses: map[string]ErrorResponse{
+			"poolExhausted": {SQLState: "53400", Message: "the pool is exhausted"},
+			"unknown":       {Message: "internal error"},
+		},
+	}, Default))
+
+	errs := validateErrorResponses(&Server{
+		ErrorResponses: map[string]ErrorResponse{
+			"poolexhausted":      {SQLState: "53300"},
+			"noProxiesAvailable": {SQLState: "08p01"},
+		},
+	}, Default)
+	require.Len(t, errs, 2)
+	assert.ElementsMatch(t,
+		[]string{
+			`"servers.default.errorResponses.poolexhausted" is not an error that ends the sessions`,
+			`"servers.default.errorResponses.noProxiesAvailable.sqlState" is invalid: 08p01`,
+		},
+		[]string{errs[0].Error(), errs[1].Error()})
+}
+
+func Test_validatePoolMode(t *testing.T) {
+	assert.Empty(t, validatePoolMode(&Proxy{}, nil, Default, DefaultConfigurationBlock))
+	assert.Empty(t, validatePoolMode(&Proxy{PoolMode: SessionPoolMode}, nil, Default, DefaultConfigurationBlock))
+	assert.Empty(t, validatePoolMode(&Proxy{
+		PoolMode:       TransactionPoolMode,
+		Authentication: Authentication{Method: AuthMethodSCRAMSHA256},
+	}, nil, Default, DefaultConfigurationBlock))
+	assert.Empty(t, validatePoolMode(
+		&Proxy{PoolMode: StatementPoolMode}, &Pool{PerUser: true}, Default, DefaultConfigurationBlock))
+
+	// The server connections on which the clients authenticate themselves must not be shared.
+	errs := validatePoolMode(&Proxy{
+		PoolMode:       TransactionPoolMode,
+		Authentication: Authentication{Method: AuthMethodNone},
+	}, &Pool{}, Default, DefaultConfigurationBlock)
+	require.Len(t, errs, 1)
+	assert.Equal(t,
+		`"proxies.default.writes.poolMode" transaction requires the authentication by the proxy or per-user pools`,
+		errs[0].Error())
+
+	errs = validatePoolMode(&Proxy{PoolMode: "connection"}, nil, Default, DefaultConfigurationBlock)
+	require.Len(t, errs, 1)
+	assert.Equal(t, `"proxies.default.writes.poolMode" is invalid: connection`, errs[0].Error())
+}
+
+func Test_validateServerTLSTrustedProxies(t *testing.T) {
+	assert.Empty(t, validateServerTLS(&Server{}, Default))
+	assert.Empty(t, validateServerTLS(&Server{
+		AcceptProxyProtocol: true,
+		TrustedProxies:      []string{"10.0.0.0/8"},
+	}, Default))
+
+	// The PROXY protocol headers are never trusted from any address.
+	errs := validateServerTLS(&Server{AcceptProxyProtocol: true}, Default)
+	require.Len(t, errs, 1)
+	assert.Equal(t, `"servers.default.trustedProxies" is required by acceptProxyProtocol`, errs[0].Error())
+
+	errs = validateServerTLS(&Server{TrustedProxies: []string{"10.0.0.0"}}, Default)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), `"servers.default.trustedProxies" is invalid`)
+}
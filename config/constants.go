@@ -82,20 +82,56 @@ const (
 	DefaultBackoff            = 1 * time.Second
 	DefaultBackoffMultiplier  = 2.0
 	DefaultDisableBackoffCaps = false
+	DefaultSSLMode            = SSLModeDisable
 
 	// Pool constants.
-	EmptyPoolCapacity        = 0
-	DefaultPoolSize          = 10
-	MinimumPoolSize          = 2
-	DefaultHealthCheckPeriod = 60 * time.Second // This must match PostgreSQL authentication timeout.
+	EmptyPoolCapacity           = 0
+	DefaultPoolSize             = 10
+	MinimumPoolSize             = 2
+	DefaultHealthCheckPeriod    = 60 * time.Second // This must match PostgreSQL authentication timeout.
+	DefaultPoolMode             = SessionPoolMode
+	DefaultResetStrategy        = ResetReconnect
+	DefaultAuthMethod           = AuthMethodNone
+	DefaultAuthPoolSize         = 1
+	DefaultUserPoolSize         = 10
+	DefaultMaxServerConnections = 100 // This matches the default max_connections of PostgreSQL.
+	DefaultIdleTimeout          = 10 * time.Minute
+	DefaultPoolMaintenance      = 5 * time.Second
+	DefaultWaitQueueSize        = 100
+	DefaultWaitTimeout          = 5 * time.Second
+
+	// Health check constants.
+	DefaultHealthCheckMethod   = HealthCheckNone
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 5 * time.Second
+	DefaultHealthyThreshold    = 2
+	DefaultUnhealthyThreshold  = 3
+
+	// Role monitor constants.
+	DefaultRoleMonitorInterval = 5 * time.Second
+	DefaultRoleMonitorTimeout  = 5 * time.Second
+	DefaultFailoverAction      = FailoverDrain
+
+	// Circuit breaker constants.
+	DefaultCircuitFailureRate      = 0.5
+	DefaultCircuitMinRequests      = 10
+	DefaultCircuitWindow           = 10 * time.Second
+	DefaultCircuitOpenTimeout      = 30 * time.Second
+	DefaultCircuitHalfOpenRequests = 1
 
 	// Server constants.
 	DefaultListenNetwork         = "tcp"
 	DefaultListenAddress         = "0.0.0.0:15432"
 	DefaultTickInterval          = 5 * time.Second
 	DefaultHandshakeTimeout      = 5 * time.Second
+	DefaultMinTLSVersion         = TLSVersion13
+	DefaultClientAuth            = ClientAuthRequest
 	DefaultLoadBalancerStrategy  = "ROUND_ROBIN"
 	DefaultLoadBalancerCondition = "DEFAULT"
+	DefaultVirtualNodes          = 160
+	DefaultDrainTimeout          = 30 * time.Second
+	// SQLStateLength is the length of the SQLSTATEs of the ErrorResponses.
+	SQLStateLength = 5
 
 	// Utility constants.
 	DefaultSeed = 1000
@@ -128,9 +164,115 @@ const (
 	DefaultRedisChannel       = "gatewayd-actions"
 )
 
+// Pool modes.
+const (
+	// SessionPoolMode assigns a server connection to a client for the whole session.
+	SessionPoolMode = "session"
+	// TransactionPoolMode assigns a server connection to a client for a transaction.
+	TransactionPoolMode = "transaction"
+	// StatementPoolMode assigns a server connection to a client for a single statement.
+	// Transaction blocks are rejected in this mode.
+	StatementPoolMode = "statement"
+)
+
+// Reset strategies of the server connections that are released by the clients.
+const (
+	// ResetReconnect closes the server connection and opens a new one.
+	ResetReconnect = "reconnect"
+	// ResetDiscardAll runs DISCARD ALL on the server connection.
+	ResetDiscardAll = "discard_all"
+	// ResetQuery runs the reset query of the proxy on the server connection.
+	ResetQuery = "query"
+)
+
+// Authentication methods of the incoming connections.
+const (
+	// AuthMethodNone passes the authentication through to the server connection.
+	AuthMethodNone = "none"
+	// AuthMethodMD5 authenticates the clients at the gateway with md5.
+	// Users with SCRAM secrets are authenticated with SCRAM-SHA-256 instead.
+	AuthMethodMD5 = "md5"
+	// AuthMethodSCRAMSHA256 authenticates the clients at the gateway with SCRAM-SHA-256.
+	AuthMethodSCRAMSHA256 = "scram-sha-256"
+)
+
+// Health check methods of the servers of the proxies.
+const (
+	// HealthCheckNone doesn't probe the server, so the proxy is always healthy.
+	HealthCheckNone = "none"
+	// HealthCheckTCP dials the server.
+	HealthCheckTCP = "tcp"
+	// HealthCheckSSL sends an SSLRequest to the server and expects an answer.
+	HealthCheckSSL = "ssl"
+	// HealthCheckQuery runs SELECT 1 on a server connection that is authenticated
+	// with the credentials of the client configuration.
+	HealthCheckQuery = "query"
+)
+
+// Actions on the incoming connections of a proxy whose server becomes a standby.
+const (
+	// FailoverDrain lets the incoming connections finish their sessions, while the new
+	// ones are routed to the primary.
+	FailoverDrain = "drain"
+	// FailoverKill closes the incoming connections.
+	FailoverKill = "kill"
+)
+
+// SSL modes of the server connections, which match the sslmode of libpq.
+const (
+	// SSLModeDisable only uses plaintext server connections.
+	SSLModeDisable = "disable"
+	// SSLModePrefer uses TLS if the server supports it, without verifying the certificate.
+	SSLModePrefer = "prefer"
+	// SSLModeRequire requires TLS, and only verifies the certificate if a root
+	// certificate is configured.
+	SSLModeRequire = "require"
+	// SSLModeVerifyCA requires TLS and verifies that the certificate is signed by a trusted CA.
+	SSLModeVerifyCA = "verify-ca"
+	// SSLModeVerifyFull requires TLS and verifies the certificate and the server name.
+	SSLModeVerifyFull = "verify-full"
+)
+
+// TLS versions of the incoming connections.
+const (
+	TLSVersion10 = "1.0"
+	TLSVersion11 = "1.1"
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// Client certificate authentication modes of the incoming connections.
+const (
+	// ClientAuthNone doesn't request client certificates.
+	ClientAuthNone = "none"
+	// ClientAuthRequest requests a client certificate and verifies it, if it is sent.
+	ClientAuthRequest = "request"
+	// ClientAuthRequire requires a client certificate, without verifying it.
+	ClientAuthRequire = "require"
+	// ClientAuthVerify requires a client certificate that is signed by the client CA.
+	ClientAuthVerify = "verify"
+)
+
+// PROXY protocol versions of the server connections.
+const (
+	ProxyProtocolNone = "none"
+	ProxyProtocolV1   = "v1"
+	ProxyProtocolV2   = "v2"
+)
+
 // Load balancing strategies.
 const (
 	RoundRobinStrategy         = "ROUND_ROBIN"
 	RANDOMStrategy             = "RANDOM"
 	WeightedRoundRobinStrategy = "WEIGHTED_ROUND_ROBIN"
+	LeastConnectionsStrategy   = "LEAST_CONNECTIONS"
+	P2CEWMAStrategy            = "P2C_EWMA"
+)
+
+// Hash keys of the consistent hashing.
+const (
+	HashKeySourceIP  = "sourceIp"
+	HashKeyUser      = "user"
+	HashKeyDatabase  = "database"
+	HashKeyParameter = "parameter"
 )
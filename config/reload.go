@@ -0,0 +1,176 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+
+	"golang.org/x/exp/maps"
+)
+
+// ConfigBlock identifies a configuration block of a configuration group, e.g. a proxy.
+//
+//nolint:revive
+type ConfigBlock struct {
+	Group string
+	Block string
+}
+
+// ConfigDiff is the outcome of comparing the running configuration with the one that is
+// reloaded from the configuration files.
+//
+//nolint:revive
+type ConfigDiff struct {
+	// AddedProxies, RemovedProxies and ChangedProxies are the proxies whose configuration,
+	// or the configuration of whose pool or client, is added, removed or changed.
+	AddedProxies   []ConfigBlock
+	RemovedProxies []ConfigBlock
+	ChangedProxies []ConfigBlock
+	// ResizedPools are the pools of the unchanged proxies whose sizes are changed.
+	ResizedPools []ConfigBlock
+	// LoadBalancers are the servers whose load balancers are changed.
+	LoadBalancers []string
+	// LogLevel is true if the level of any logger is changed.
+	LogLevel bool
+	// Policies is true if the policies or the default policy are changed.
+	Policies bool
+	// RestartRequired are the configuration sections whose changes can't be applied
+	// without restarting GatewayD.
+	RestartRequired []string
+}
+
+// IsEmpty returns true if the configuration isn't changed.
+func (d ConfigDiff) IsEmpty() bool {
+	return reflect.DeepEqual(d, ConfigDiff{})
+}
+
+// Diff compares the configuration with the updated one, and returns the changes. The changes
+// that can't be applied without a restart are reverted in the updated configuration, so that it
+// reflects the running configuration once it replaces this one, and they are reported again by
+// the next reload.
+func (c *Config) Diff(updated *Config) ConfigDiff {
+	var diff ConfigDiff
+	current, next := &c.Global, &updated.Global
+
+	// The servers are only reloaded by their load balancers, since the listeners are kept.
+	for _, name := range sortedKeys(current.Servers, next.Servers) {
+		currentServer, nextServer := current.Servers[name], next.Servers[name]
+		switch {
+		case nextServer == nil:
+			diff.RestartRequired = append(diff.RestartRequired, "servers."+name)
+			next.Servers[name] = currentServer
+		case currentServer == nil:
+			diff.RestartRequired = append(diff.RestartRequired, "servers."+name)
+			delete(next.Servers, name)
+		default:
+			if !reflect.DeepEqual(currentServer.LoadBalancer, nextServer.LoadBalancer) {
+				diff.LoadBalancers = append(diff.LoadBalancers, name)
+			}
+			server := *currentServer
+			server.LoadBalancer = nextServer.LoadBalancer
+			if !reflect.DeepEqual(&server, nextServer) {
+				diff.RestartRequired = append(diff.RestartRequired, "servers."+name)
+				next.Servers[name] = &server
+			}
+		}
+	}
+
+	// The loggers are only reloaded by their levels, since the loggers are kept.
+	for _, name := range sortedKeys(current.Loggers, next.Loggers) {
+		currentLogger, nextLogger := current.Loggers[name], next.Loggers[name]
+		switch {
+		case nextLogger == nil:
+			diff.RestartRequired = append(diff.RestartRequired, "loggers."+name)
+			next.Loggers[name] = currentLogger
+		case currentLogger == nil:
+			diff.RestartRequired = append(diff.RestartRequired, "loggers."+name)
+			delete(next.Loggers, name)
+		default:
+			if currentLogger.Level != nextLogger.Level {
+				diff.LogLevel = true
+			}
+			logger := *currentLogger
+			logger.Level = nextLogger.Level
+			if !reflect.DeepEqual(&logger, nextLogger) {
+				diff.RestartRequired = append(diff.RestartRequired, "loggers."+name)
+				next.Loggers[name] = &logger
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(current.Metrics, next.Metrics) {
+		diff.RestartRequired = append(diff.RestartRequired, "metrics")
+		next.Metrics = current.Metrics
+	}
+	if current.API != next.API {
+		diff.RestartRequired = append(diff.RestartRequired, "api")
+		next.API = current.API
+	}
+
+	// The proxies are replaced if anything but the sizes of their pools is changed.
+	for _, group := range sortedKeys(current.Proxies, next.Proxies) {
+		for _, block := range sortedKeys(current.Proxies[group], next.Proxies[group]) {
+			proxy := ConfigBlock{Group: group, Block: block}
+			currentProxy, nextProxy := current.Proxies[group][block], next.Proxies[group][block]
+			switch {
+			case currentProxy == nil:
+				diff.AddedProxies = append(diff.AddedProxies, proxy)
+			case nextProxy == nil:
+				diff.RemovedProxies = append(diff.RemovedProxies, proxy)
+			case !reflect.DeepEqual(currentProxy, nextProxy) ||
+				!reflect.DeepEqual(current.client(group, block), next.client(group, block)):
+				diff.ChangedProxies = append(diff.ChangedProxies, proxy)
+			default:
+				currentPool, nextPool := current.Pools[group][block], next.Pools[group][block]
+				if reflect.DeepEqual(currentPool, nextPool) {
+					continue
+				}
+				if currentPool == nil || nextPool == nil || nextPool.PerUser {
+					diff.ChangedProxies = append(diff.ChangedProxies, proxy)
+					continue
+				}
+				pool := *currentPool
+				pool.Size, pool.MinSize, pool.MaxSize = nextPool.Size, nextPool.MinSize, nextPool.MaxSize
+				if reflect.DeepEqual(&pool, nextPool) {
+					diff.ResizedPools = append(diff.ResizedPools, proxy)
+				} else {
+					diff.ChangedProxies = append(diff.ChangedProxies, proxy)
+				}
+			}
+		}
+	}
+
+	// Only the policies are reloaded, since the plugins are kept.
+	if !reflect.DeepEqual(c.Plugin.Policies, updated.Plugin.Policies) ||
+		c.Plugin.DefaultPolicy != updated.Plugin.DefaultPolicy {
+		diff.Policies = true
+	}
+	plugin := c.Plugin
+	plugin.Policies, plugin.DefaultPolicy = updated.Plugin.Policies, updated.Plugin.DefaultPolicy
+	if !reflect.DeepEqual(plugin, updated.Plugin) {
+		diff.RestartRequired = append(diff.RestartRequired, "plugins")
+		updated.Plugin = plugin
+	}
+
+	return diff
+}
+
+// client returns the client configuration of the given proxy, which is the default one
+// if the proxy has none.
+func (gc GlobalConfig) client(group, block string) *Client {
+	if client, ok := gc.Clients[group][block]; ok {
+		return client
+	}
+	return gc.Clients[Default][DefaultConfigurationBlock]
+}
+
+// sortedKeys returns the keys of both maps in order.
+func sortedKeys[V any](current, next map[string]V) []string {
+	keys := maps.Keys(current)
+	for key := range next {
+		if _, ok := current[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
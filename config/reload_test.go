@@ -0,0 +1,95 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadTestConfig loads the configuration of the tests of the run command.
+func loadTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	ctx := context.Background()
+	config := NewConfig(ctx,
+		Config{
+			GlobalConfigFile: parentDir + "cmd/testdata/gatewayd.yaml",
+			PluginConfigFile: parentDir + PluginsConfigFilename,
+		},
+	)
+	require.Nil(t, config.InitConfig(ctx))
+	return config
+}
+
+// TestDiff tests that the changes of the configuration are classified by how they are
+// applied, and that the ones that require a restart are reverted.
+func TestDiff(t *testing.T) {
+	current := loadTestConfig(t)
+	updated := loadTestConfig(t)
+	assert.True(t, current.Diff(updated).IsEmpty())
+
+	defaultBlock := ConfigBlock{Group: Default, Block: DefaultConfigurationBlock}
+	testBlock := ConfigBlock{Group: "test", Block: "write"}
+	addedBlock := ConfigBlock{Group: Default, Block: "added"}
+
+	// The load balancer is reloaded, but not the listener.
+	address := current.Global.Servers[Default].Address
+	updated.Global.Servers[Default].Address = "localhost:25432"
+	updated.Global.Servers[Default].LoadBalancer.Strategy = RANDOMStrategy
+	// The level of the logger is reloaded, but not its output.
+	updated.Global.Loggers[Default].Level = "debug"
+	updated.Global.Loggers["test"].NoColor = !current.Global.Loggers["test"].NoColor
+	// The pool of the default proxy is resized, and the test proxy is changed.
+	updated.Global.Pools[Default][DefaultConfigurationBlock].Size = 20
+	updated.Global.Proxies["test"]["write"].PoolMode = TransactionPoolMode
+	updated.Global.Proxies[Default]["added"] = updated.Global.Proxies[Default][DefaultConfigurationBlock]
+	// Only the policies of the plugins are reloaded.
+	updated.Plugin.DefaultPolicy = "terminate"
+	updated.Plugin.Timeout = time.Hour
+
+	diff := current.Diff(updated)
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, []string{Default}, diff.LoadBalancers)
+	assert.True(t, diff.LogLevel)
+	assert.True(t, diff.Policies)
+	assert.Equal(t, []ConfigBlock{defaultBlock}, diff.ResizedPools)
+	assert.Equal(t, []ConfigBlock{testBlock}, diff.ChangedProxies)
+	assert.Equal(t, []ConfigBlock{addedBlock}, diff.AddedProxies)
+	assert.Empty(t, diff.RemovedProxies)
+	assert.Equal(t, []string{"servers." + Default, "loggers.test", "plugins"}, diff.RestartRequired)
+
+	assert.Equal(t, address, updated.Global.Servers[Default].Address)
+	assert.Equal(t, RANDOMStrategy, updated.Global.Servers[Default].LoadBalancer.Strategy)
+	assert.Equal(t, current.Global.Loggers["test"].NoColor, updated.Global.Loggers["test"].NoColor)
+	assert.Equal(t, "debug", updated.Global.Loggers[Default].Level)
+	assert.Equal(t, current.Plugin.Timeout, updated.Plugin.Timeout)
+	assert.Equal(t, "terminate", updated.Plugin.DefaultPolicy)
+
+	// The changes that require a restart are reported again.
+	next := loadTestConfig(t)
+	next.Global.Servers[Default].Address = "localhost:25432"
+	delete(next.Global.Proxies["test"], "write")
+	diff = current.Diff(next)
+	assert.Equal(t, []string{"servers." + Default}, diff.RestartRequired)
+	assert.Equal(t, []ConfigBlock{testBlock}, diff.RemovedProxies)
+}
+
+// TestDiffClients tests that the proxies are changed by the changes of their clients, and
+// of their pools other than the sizes.
+func TestDiffClients(t *testing.T) {
+	current := loadTestConfig(t)
+	updated := loadTestConfig(t)
+	updated.Global.Clients[Default][DefaultConfigurationBlock].Address = "localhost:25432"
+	updated.Global.Pools["test"]["write"].IdleTimeout = time.Hour
+
+	diff := current.Diff(updated)
+	assert.Equal(t, []ConfigBlock{
+		{Group: Default, Block: DefaultConfigurationBlock},
+		{Group: "test", Block: "write"},
+	}, diff.ChangedProxies)
+	assert.Empty(t, diff.ResizedPools)
+	assert.Empty(t, diff.RestartRequired)
+}
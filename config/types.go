@@ -57,6 +57,15 @@ type Client struct {
 	Backoff            time.Duration `json:"backoff" jsonschema:"oneof_type=string;integer" yaml:"backoff"`
 	BackoffMultiplier  float64       `json:"backoffMultiplier" yaml:"backoffMultiplier"`
 	DisableBackoffCaps bool          `json:"disableBackoffCaps" yaml:"disableBackoffCaps"`
+	User               string        `json:"user" yaml:"user"`
+	Password           string        `json:"password" yaml:"password"`
+	Database           string        `json:"database" yaml:"database"`
+	SSLMode            string        `json:"sslMode" jsonschema:"enum=disable,enum=prefer,enum=require,enum=verify-ca,enum=verify-full,enum=" yaml:"sslMode"`
+	SSLRootCert        string        `json:"sslRootCert" yaml:"sslRootCert"`
+	SSLCert            string        `json:"sslCert" yaml:"sslCert"`
+	SSLKey             string        `json:"sslKey" yaml:"sslKey"`
+	SSLServerName      string        `json:"sslServerName" yaml:"sslServerName"`
+	ProxyProtocol      string        `json:"proxyProtocol" jsonschema:"enum=none,enum=v1,enum=v2,enum=" yaml:"proxyProtocol"`
 }
 
 type Logger struct {
@@ -89,11 +98,84 @@ type Metrics struct {
 }
 
 type Pool struct {
-	Size int `json:"size" yaml:"size"`
+	Size                 int           `json:"size" yaml:"size"`
+	PerUser              bool          `json:"perUser" yaml:"perUser"`
+	UserPoolSize         int           `json:"userPoolSize" yaml:"userPoolSize"`
+	MaxServerConnections int           `json:"maxServerConnections" yaml:"maxServerConnections"`
+	IdleTimeout          time.Duration `json:"idleTimeout" jsonschema:"oneof_type=string;integer" yaml:"idleTimeout"`
+	// MinSize and MaxSize bound the number of server connections of the pool. The server
+	// connections are dialed on demand up to MaxSize, and the idle ones are closed after
+	// IdleTimeout down to MinSize. Without them, the pool keeps Size server connections.
+	MinSize int `json:"minSize" yaml:"minSize"`
+	MaxSize int `json:"maxSize" yaml:"maxSize"`
+	// MaxLifetime and MaxUses retire the server connections that are older, or that have
+	// been assigned to the clients more often, when they are returned to the pool.
+	MaxLifetime time.Duration `json:"maxLifetime" jsonschema:"oneof_type=string;integer" yaml:"maxLifetime"`
+	MaxUses     int           `json:"maxUses" yaml:"maxUses"`
+	// WaitQueueSize is the maximum number of clients that wait for a server connection
+	// while the pool is exhausted, for at most WaitTimeout. Zero disables the queue.
+	WaitQueueSize int           `json:"waitQueueSize" yaml:"waitQueueSize"`
+	WaitTimeout   time.Duration `json:"waitTimeout" jsonschema:"oneof_type=string;integer" yaml:"waitTimeout"`
+}
+
+type Authentication struct {
+	Method       string `json:"method" jsonschema:"enum=none,enum=md5,enum=scram-sha-256,enum=" yaml:"method"`
+	UserList     string `json:"userList" yaml:"userList"`
+	AuthQuery    string `json:"authQuery" yaml:"authQuery"`
+	AuthPoolSize int    `json:"authPoolSize" yaml:"authPoolSize"`
+}
+
+// ReadWriteSplit sends the read-only statements outside of transactions to another proxy
+// of the same configuration group.
+type ReadWriteSplit struct {
+	ReadProxy      string   `json:"readProxy" yaml:"readProxy"`
+	WriteFunctions []string `json:"writeFunctions,omitempty" yaml:"writeFunctions"`
+}
+
+// HealthCheck probes the server of a proxy, which is skipped by the load balancers after
+// unhealthyThreshold consecutive failures, until it passes healthyThreshold consecutive probes.
+type HealthCheck struct {
+	Method             string        `json:"method" jsonschema:"enum=none,enum=tcp,enum=ssl,enum=query,enum=" yaml:"method"`
+	Interval           time.Duration `json:"interval" jsonschema:"oneof_type=string;integer" yaml:"interval"`
+	Timeout            time.Duration `json:"timeout" jsonschema:"oneof_type=string;integer" yaml:"timeout"`
+	HealthyThreshold   int           `json:"healthyThreshold" yaml:"healthyThreshold"`
+	UnhealthyThreshold int           `json:"unhealthyThreshold" yaml:"unhealthyThreshold"`
+}
+
+// RoleMonitor checks whether the server of a proxy is the primary or a standby, so that the load
+// balancers only route to the primary. The incoming connections of a proxy whose server becomes
+// a standby either finish their sessions (drain) or are closed (kill), by onFailover.
+type RoleMonitor struct {
+	Enabled    bool          `json:"enabled" yaml:"enabled"`
+	Interval   time.Duration `json:"interval" jsonschema:"oneof_type=string;integer" yaml:"interval"`
+	Timeout    time.Duration `json:"timeout" jsonschema:"oneof_type=string;integer" yaml:"timeout"`
+	OnFailover string        `json:"onFailover" jsonschema:"enum=drain,enum=kill,enum=" yaml:"onFailover"`
+}
+
+// CircuitBreaker fails the new sessions of a proxy fast while its server is known to be down.
+// It opens when at least failureRate of the dials and receives fail within a window, after
+// minRequests, and lets halfOpenRequests dials through after openTimeout to test the server.
+type CircuitBreaker struct {
+	Enabled          bool          `json:"enabled" yaml:"enabled"`
+	FailureRate      float64       `json:"failureRate" yaml:"failureRate"`
+	MinRequests      int           `json:"minRequests" yaml:"minRequests"`
+	Window           time.Duration `json:"window" jsonschema:"oneof_type=string;integer" yaml:"window"`
+	OpenTimeout      time.Duration `json:"openTimeout" jsonschema:"oneof_type=string;integer" yaml:"openTimeout"`
+	HalfOpenRequests int           `json:"halfOpenRequests" yaml:"halfOpenRequests"`
 }
 
 type Proxy struct {
-	HealthCheckPeriod time.Duration `json:"healthCheckPeriod" jsonschema:"oneof_type=string;integer" yaml:"healthCheckPeriod"`
+	HealthCheckPeriod time.Duration  `json:"healthCheckPeriod" jsonschema:"oneof_type=string;integer" yaml:"healthCheckPeriod"`
+	PoolMode          string         `json:"poolMode" jsonschema:"enum=session,enum=transaction,enum=statement,enum=" yaml:"poolMode"`
+	Authentication    Authentication `json:"authentication" yaml:"authentication"`
+	ReadWriteSplit    ReadWriteSplit `json:"readWriteSplit" yaml:"readWriteSplit"`
+	HealthCheck       HealthCheck    `json:"healthCheck" yaml:"healthCheck"`
+	RoleMonitor       RoleMonitor    `json:"roleMonitor" yaml:"roleMonitor"`
+	CircuitBreaker    CircuitBreaker `json:"circuitBreaker" yaml:"circuitBreaker"`
+	// ResetStrategy resets the server connections that are released by the clients before
+	// they are shared with other clients: reconnect, discard_all or query, which runs ResetQuery.
+	ResetStrategy string `json:"resetStrategy" jsonschema:"enum=reconnect,enum=discard_all,enum=query,enum=" yaml:"resetStrategy"`
+	ResetQuery    string `json:"resetQuery" yaml:"resetQuery"`
 }
 
 type Distribution struct {
@@ -106,8 +188,32 @@ type LoadBalancingRule struct {
 	Distribution []Distribution `json:"distribution"`
 }
 
+// LoadBalancingRuleEnv is the environment of the conditions of the load balancing rules,
+// which are evaluated against the StartupMessage and the connection of each client,
+// e.g. `database == "analytics" && tls`.
+type LoadBalancingRuleEnv struct {
+	Database        string            `expr:"database"`
+	User            string            `expr:"user"`
+	ApplicationName string            `expr:"application_name"`
+	ClientIP        string            `expr:"client_ip"`
+	TLS             bool              `expr:"tls"`
+	Parameters      map[string]string `expr:"parameters"`
+}
+
 type ConsistentHash struct {
+	// Deprecated: UseSourceIP maps to the sourceIp hash key, which is also the default, unless
+	// HashKey is set, which takes precedence. A warning is logged if it is enabled.
 	UseSourceIP bool `json:"useSourceIp"`
+	// HashKey is the key of the clients on the ring: sourceIp, user, database, or the
+	// startup parameter of the given name. It defaults to the source IP, and takes
+	// precedence over useSourceIp.
+	HashKey   string `json:"hashKey"`
+	Parameter string `json:"parameter"`
+	// VirtualNodes is the number of points of each proxy on the ring, on average.
+	VirtualNodes int `json:"virtualNodes"`
+	// LoadFactor bounds the busy connections of each proxy to the given factor of the
+	// average, if greater than zero, by moving the excess clients to the next proxies.
+	LoadFactor float64 `json:"loadFactor"`
 }
 
 type LoadBalancer struct {
@@ -125,7 +231,29 @@ type Server struct {
 	CertFile         string        `json:"certFile"`
 	KeyFile          string        `json:"keyFile"`
 	HandshakeTimeout time.Duration `json:"handshakeTimeout" jsonschema:"oneof_type=string;integer"`
-	LoadBalancer     LoadBalancer  `json:"loadBalancer"`
+	MinTLSVersion    string        `json:"minTLSVersion" jsonschema:"enum=1.0,enum=1.1,enum=1.2,enum=1.3,enum="` //nolint:tagliatelle
+	MaxTLSVersion    string        `json:"maxTLSVersion" jsonschema:"enum=1.0,enum=1.1,enum=1.2,enum=1.3,enum="` //nolint:tagliatelle
+	CipherSuites     []string      `json:"cipherSuites,omitempty"`
+	ClientCAFile     string        `json:"clientCAFile"` //nolint:tagliatelle
+	ClientAuth       string        `json:"clientAuth" jsonschema:"enum=none,enum=request,enum=require,enum=verify,enum="`
+	// AcceptProxyProtocol requires a PROXY protocol header on the connections from
+	// the TrustedProxies, which are CIDRs and are required.
+	AcceptProxyProtocol bool         `json:"acceptProxyProtocol"`
+	TrustedProxies      []string     `json:"trustedProxies,omitempty"`
+	LoadBalancer        LoadBalancer `json:"loadBalancer"`
+	// DrainTimeout is how long the clients may finish their transactions once the server is
+	// drained, before their connections are closed.
+	DrainTimeout time.Duration `json:"drainTimeout" jsonschema:"oneof_type=string;integer"`
+	// ErrorResponses override the ErrorResponses that are sent to the clients whose sessions are
+	// ended by GatewayD errors, by the names of the errors, e.g. poolExhausted.
+	ErrorResponses map[string]ErrorResponse `json:"errorResponses,omitempty"`
+}
+
+// ErrorResponse is the SQLSTATE and the message of an ErrorResponse. The default ones are used
+// if they are empty.
+type ErrorResponse struct {
+	SQLState string `json:"sqlState"`
+	Message  string `json:"message"`
 }
 
 type API struct {
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
 // If returns truthy if predicate is true, falsy otherwise.
 func If[T any](predicate bool, truthy, falsy T) T {
 	if predicate {
@@ -15,3 +20,9 @@ func Exists[T comparable, V any](map_ map[T]V, key T) bool {
 	_, ok := map_[key]
 	return ok
 }
+
+// CompileCondition compiles the condition of a load balancing rule, which must evaluate to
+// a boolean in the environment of LoadBalancingRuleEnv.
+func CompileCondition(condition string) (*vm.Program, error) {
+	return expr.Compile(condition, expr.Env(LoadBalancingRuleEnv{}), expr.AsBool()) //nolint:wrapcheck
+}
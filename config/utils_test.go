@@ -20,3 +20,20 @@ func TestExists(t *testing.T) {
 		t.Error("Exists(m, \"c\") != false")
 	}
 }
+
+func TestCompileCondition(t *testing.T) {
+	for _, condition := range []string{
+		`database == "analytics" && tls`,
+		`parameters["options"] contains "reports"`,
+	} {
+		if _, err := CompileCondition(condition); err != nil {
+			t.Errorf("CompileCondition(%q) error = %v", condition, err)
+		}
+	}
+	// The condition must be a boolean expression on the known variables.
+	for _, condition := range []string{`database`, `unknown == "value"`} {
+		if _, err := CompileCondition(condition); err == nil {
+			t.Errorf("CompileCondition(%q) error = nil", condition)
+		}
+	}
+}
@@ -56,6 +56,19 @@ const (
 	ErrCodeLoadBalancerStrategyNotFound
 	ErrCodeNoProxiesAvailable
 	ErrCodeNoLoadBalancerRules
+	ErrCodeInvalidMessage
+	ErrCodeTransactionNotAllowed
+	ErrCodeAuthenticationFailed
+	ErrCodeServerAuthenticationFailed
+	ErrCodeLoadUserListFailed
+	ErrCodeServerTLSFailed
+	ErrCodeCancelRequestFailed
+	ErrCodeInvalidLoadBalancerRule
+	ErrCodeProxyProtocolFailed
+	ErrCodeCircuitOpen
+	ErrCodeListenerHandoffFailed
+	ErrCodeSocketActivationFailed
+	ErrCodeSystemdNotifyFailed
 )
 
 var (
@@ -209,14 +222,73 @@ var (
 		ErrCodeNoLoadBalancerRules, "No load balancer rules provided.", nil,
 	}
 
+	ErrInvalidMessage = &GatewayDError{
+		ErrCodeInvalidMessage, "invalid message received", nil,
+	}
+
+	ErrTransactionNotAllowed = &GatewayDError{
+		ErrCodeTransactionNotAllowed, "transaction blocks are not allowed in statement pool mode", nil,
+	}
+
+	ErrAuthenticationFailed = &GatewayDError{
+		ErrCodeAuthenticationFailed, "failed to authenticate the client", nil,
+	}
+	ErrServerAuthenticationFailed = &GatewayDError{
+		ErrCodeServerAuthenticationFailed, "failed to authenticate to the server", nil,
+	}
+	ErrLoadUserListFailed = &GatewayDError{
+		ErrCodeLoadUserListFailed, "failed to load the user list", nil,
+	}
+	ErrServerTLSFailed = &GatewayDError{
+		ErrCodeServerTLSFailed, "failed to negotiate TLS with the server", nil,
+	}
+	ErrCancelRequestFailed = &GatewayDError{
+		ErrCodeCancelRequestFailed, "failed to send the cancel request to the server", nil,
+	}
+	ErrInvalidLoadBalancerRule = &GatewayDError{
+		ErrCodeInvalidLoadBalancerRule, "invalid load balancer rule", nil,
+	}
+	ErrProxyProtocolFailed = &GatewayDError{
+		ErrCodeProxyProtocolFailed, "failed to read or write the PROXY protocol header", nil,
+	}
+	ErrCircuitOpen = &GatewayDError{
+		ErrCodeCircuitOpen, "the server is unavailable, since the circuit breaker is open", nil,
+	}
+	ErrListenerHandoffFailed = &GatewayDError{
+		ErrCodeListenerHandoffFailed, "failed to hand off the listeners", nil,
+	}
+	ErrSocketActivationFailed = &GatewayDError{
+		ErrCodeSocketActivationFailed, "failed to use the sockets passed by systemd", nil,
+	}
+	ErrSystemdNotifyFailed = &GatewayDError{
+		ErrCodeSystemdNotifyFailed, "failed to notify systemd", nil,
+	}
+
 	// Unwrapped errors.
 	ErrLoggerRequired = errors.New("terminate action requires a logger parameter")
 )
 
+// SessionErrCodes are the codes of the errors that end the sessions of the clients by their
+// names, by which the ErrorResponses that are sent to the clients are configured. The unknown
+// code stands for the other errors.
+var SessionErrCodes = map[string]ErrCode{
+	"unknown":                  ErrCodeUnknown,
+	"noProxiesAvailable":       ErrCodeNoProxiesAvailable,
+	"poolExhausted":            ErrCodePoolExhausted,
+	"circuitOpen":              ErrCodeCircuitOpen,
+	"clientConnectionFailed":   ErrCodeClientConnectionFailed,
+	"clientReceiveFailed":      ErrCodeClientReceiveFailed,
+	"clientSendFailed":         ErrCodeClientSendFailed,
+	"hookTerminatedConnection": ErrCodeHookTerminatedConnection,
+}
+
 const (
-	FailedToCreateClient      = 1
-	FailedToInitializePool    = 2
-	FailedToStartServer       = 3
-	FailedToStartTracer       = 4
-	FailedToCreateActRegistry = 5
+	FailedToCreateClient        = 1
+	FailedToInitializePool      = 2
+	FailedToStartServer         = 3
+	FailedToStartTracer         = 4
+	FailedToCreateActRegistry   = 5
+	FailedToCreateAuthenticator = 6
+	FailedToTakeOverListeners   = 7
+	FailedToActivateSockets     = 8
 )
@@ -75,6 +75,47 @@ var (
 		Name:      "proxy_health_checks_total",
 		Help:      "Number of proxy health checks",
 	})
+	ProxyBackendHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "proxy_backend_healthy",
+		Help:      "Whether the server of the proxy passes the active health checks (1) or not (0)",
+	}, []string{"group", "proxy"})
+	ProxyBackendHealthCheckFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_backend_health_check_failures_total",
+		Help:      "Number of failed active health checks of the server of the proxy",
+	}, []string{"group", "proxy", "method"})
+	ProxyBackendPrimary = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "proxy_backend_primary",
+		Help:      "Whether the server of the proxy is the primary (1) or a standby (0), by the role monitor",
+	}, []string{"group", "proxy"})
+	ProxyBackendRoleChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_backend_role_changes_total",
+		Help:      "Number of role changes of the server of the proxy",
+	}, []string{"group", "proxy", "role"})
+	ProxyCircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "proxy_circuit_breaker_state",
+		Help:      "State of the circuit breaker of the proxy: closed (0), half-open (1) or open (2)",
+	}, []string{"group", "proxy"})
+	ProxyCircuitBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "proxy_circuit_breaker_transitions_total",
+		Help:      "Number of state transitions of the circuit breaker of the proxy, by the new state",
+	}, []string{"group", "proxy", "state"})
+	PoolWaitQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "pool_wait_queue_length",
+		Help:      "Number of client connections that wait for a server connection of the exhausted pool",
+	}, []string{"group", "proxy"})
+	PoolWaitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "pool_wait_duration_seconds",
+		Help:      "Time that the client connections wait for a server connection of the exhausted pool",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15), //nolint:mnd
+	}, []string{"group", "proxy", "result"})
 	ProxiedConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Namespace: Namespace,
 		Name:      "proxied_connections",
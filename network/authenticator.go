@@ -0,0 +1,493 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+var (
+	errUnknownUser      = errors.New("unknown user")
+	errUnexpectedPacket = errors.New("unexpected message during authentication")
+)
+
+// Authenticator authenticates the incoming connections at the proxy, instead of passing
+// the startup and authentication messages through to the server connections. The users
+// are validated against a user list or against the result of an auth query, which is run
+// on a dedicated pool of server connections. The server connections of the proxy are then
+// authenticated with the configured credentials, so they can be shared between users.
+type Authenticator struct {
+	// Method is either md5 or scram-sha-256.
+	Method string
+	// UserList maps the user names to their passwords or secrets.
+	UserList map[string]string
+	// AuthQuery returns the password or secret of the user, given the user name as $1.
+	AuthQuery string
+	// AuthPool holds the server connections that run the auth query.
+	AuthPool pool.IPool
+	Logger   zerolog.Logger
+
+	ctx context.Context //nolint:containedctx
+	// cond signals that a server connection is put back in the auth pool.
+	cond *sync.Cond
+}
+
+// NewAuthenticator creates a new authenticator.
+func NewAuthenticator(ctx context.Context, auth Authenticator) *Authenticator {
+	authCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewAuthenticator")
+	defer span.End()
+
+	authenticator := &Authenticator{
+		Method:    config.If(auth.Method != "", auth.Method, config.AuthMethodSCRAMSHA256),
+		UserList:  auth.UserList,
+		AuthQuery: auth.AuthQuery,
+		AuthPool:  auth.AuthPool,
+		Logger:    auth.Logger,
+		ctx:       authCtx,
+		cond:      sync.NewCond(&sync.Mutex{}),
+	}
+
+	return authenticator
+}
+
+// LoadUserList loads a user list file in the format of PgBouncer, in which every line
+// contains a double-quoted user name and a double-quoted password or secret. Double quotes
+// are escaped by doubling them. Empty lines and lines starting with ; or # are ignored.
+func LoadUserList(path string) (map[string]string, *gerr.GatewayDError) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, gerr.ErrLoadUserListFailed.Wrap(err)
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		user, rest, ok := readQuoted(line)
+		if !ok {
+			return nil, gerr.ErrLoadUserListFailed.Wrap(
+				fmt.Errorf("invalid user name on line %d", lineNumber))
+		}
+		password, _, ok := readQuoted(strings.TrimLeft(rest, " \t"))
+		if !ok {
+			return nil, gerr.ErrLoadUserListFailed.Wrap(
+				fmt.Errorf("invalid password on line %d", lineNumber))
+		}
+		users[user] = password
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, gerr.ErrLoadUserListFailed.Wrap(err)
+	}
+
+	return users, nil
+}
+
+// readQuoted reads a double-quoted string from the beginning of the line and returns
+// it together with the rest of the line.
+func readQuoted(line string) (string, string, bool) {
+	if !strings.HasPrefix(line, `"`) {
+		return "", line, false
+	}
+
+	var value strings.Builder
+	for idx := 1; idx < len(line); idx++ {
+		if line[idx] != '"' {
+			value.WriteByte(line[idx])
+			continue
+		}
+		if idx+1 < len(line) && line[idx+1] == '"' {
+			value.WriteByte('"')
+			idx++
+			continue
+		}
+		return value.String(), line[idx+1:], true
+	}
+
+	return "", line, false
+}
+
+// Login is an incoming connection that is authenticated by the proxy.
+type Login struct {
+	// Parameters are the parameters of the StartupMessage, e.g. the user and the database.
+	Parameters map[string]string
+	// credentials authenticate the server connections as the user, if the password or
+	// the keys of the user are known to the proxy.
+	credentials *credentials
+}
+
+// Authenticate authenticates the incoming connection, given its StartupMessage. On success,
+// it returns the login of the client, whose startup phase is then completed by Complete.
+// On failure, it sends an error response to the client.
+func (a *Authenticator) Authenticate(conn *ConnWrapper, startup []byte) (*Login, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(a.ctx, "Authenticate")
+	defer span.End()
+
+	var message pgproto3.StartupMessage
+	if !isStartupMessage(startup) || message.Decode(startup[UntypedHeaderLength:]) != nil {
+		span.RecordError(errUnexpectedPacket)
+		return nil, a.fail(conn, "invalid startup packet", ProtocolViolationCode, errUnexpectedPacket)
+	}
+
+	user := message.Parameters["user"]
+	if user == "" {
+		span.RecordError(errUnknownUser)
+		return nil, a.fail(conn,
+			"no PostgreSQL user name specified in startup packet", InvalidPasswordCode, errUnknownUser)
+	}
+
+	secret, err := a.lookup(user)
+	if err != nil && !errors.Is(err, errUnknownUser) {
+		a.Logger.Error().Err(err).Str("user", user).Msg("Failed to look up the user")
+		span.RecordError(err)
+	}
+	// The exchange runs even if the user is unknown, so that
+	// the clients can't tell the unknown users apart.
+	known := err == nil
+
+	var verified bool
+	var authErr error
+	login := &Login{Parameters: message.Parameters}
+	if scramSec, isSCRAM := parseSCRAMSecret(secret); a.Method == config.AuthMethodSCRAMSHA256 || isSCRAM {
+		if !isSCRAM {
+			// A SCRAM exchange can't be verified against an md5 secret.
+			known = known && !isMD5Secret(secret)
+			if scramSec, authErr = a.plainSCRAMSecret(secret); authErr != nil {
+				span.RecordError(authErr)
+				return nil, a.fail(conn, "authentication failed", InvalidPasswordCode, authErr)
+			}
+			login.credentials = &credentials{password: secret}
+		}
+		server := &scramServer{secret: scramSec}
+		verified, authErr = a.authenticateSCRAM(conn, server)
+		if isSCRAM {
+			login.credentials = &credentials{scramKeys: server.keys}
+		}
+	} else {
+		verified, authErr = a.authenticateMD5(conn, user, secret)
+		login.credentials = &credentials{password: secret}
+	}
+
+	if authErr != nil || !verified || !known {
+		a.Logger.Warn().Fields(
+			map[string]interface{}{
+				"user":   user,
+				"method": a.Method,
+				"remote": RemoteAddr(conn.Conn()),
+			},
+		).Msg("Failed to authenticate the client")
+		span.RecordError(gerr.ErrAuthenticationFailed)
+		return nil, a.fail(conn,
+			fmt.Sprintf("password authentication failed for user %q", user),
+			InvalidPasswordCode, authErr)
+	}
+
+	return login, nil
+}
+
+// Complete completes the startup phase of an authenticated incoming connection, as if it
+// was authenticated by the server, by sending the run-time parameters of the server, the
+// key for canceling the requests and a ReadyForQuery message.
+func (a *Authenticator) Complete(
+	conn *ConnWrapper, login *Login, serverParameters map[string]string,
+	backendKey pgproto3.BackendKeyData,
+) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(a.ctx, "Complete")
+	defer span.End()
+
+	response, encodeErr := (&pgproto3.AuthenticationOk{}).Encode(nil)
+	for name, value := range serverParameters {
+		if encodeErr != nil {
+			break
+		}
+		response, encodeErr = (&pgproto3.ParameterStatus{Name: name, Value: value}).Encode(response)
+	}
+	if encodeErr == nil {
+		response, encodeErr = backendKey.Encode(response)
+	}
+	if encodeErr == nil {
+		response, encodeErr = (&pgproto3.ReadyForQuery{TxStatus: TransactionStatusIdle}).Encode(response)
+	}
+	if encodeErr != nil {
+		span.RecordError(encodeErr)
+		return gerr.ErrMsgEncodeError.Wrap(encodeErr)
+	}
+	if _, err := conn.Write(response); err != nil {
+		span.RecordError(err)
+		return gerr.ErrServerSendFailed.Wrap(err)
+	}
+
+	a.Logger.Info().Fields(
+		map[string]interface{}{
+			"user":     login.Parameters["user"],
+			"database": login.Parameters["database"],
+			"method":   a.Method,
+			"remote":   RemoteAddr(conn.Conn()),
+		},
+	).Msg("Authenticated the client")
+	span.AddEvent("Authenticated the client")
+
+	return nil
+}
+
+// fail sends a fatal error response to the client and returns the authentication error.
+func (a *Authenticator) fail(conn *ConnWrapper, message, code string, err error) *gerr.GatewayDError {
+	if writeErr := conn.sendErrorResponse(&ErrorResponse{
+		Code:     gerr.ErrCodeAuthenticationFailed,
+		SQLState: code,
+		Message:  message,
+		Detail:   errorDetail(gerr.ErrAuthenticationFailed),
+	}); writeErr != nil {
+		a.Logger.Debug().Err(writeErr).Msg("Failed to send the error response to the client")
+	}
+
+	if err == nil {
+		err = errors.New(message)
+	}
+	return gerr.ErrAuthenticationFailed.Wrap(err)
+}
+
+// plainSCRAMSecret derives a SCRAM-SHA-256 secret from a plain text password.
+func (a *Authenticator) plainSCRAMSecret(password string) (*scramSecret, error) {
+	salt, err := randomBytes(scramSaltLength)
+	if err != nil {
+		return nil, err
+	}
+	return newSCRAMSecret(password, salt, SCRAMIterations), nil
+}
+
+// authenticateSCRAM runs a SCRAM-SHA-256 exchange with the client.
+func (a *Authenticator) authenticateSCRAM(conn *ConnWrapper, server *scramServer) (bool, error) {
+	if err := a.send(conn, &pgproto3.AuthenticationSASL{AuthMechanisms: []string{SCRAMSHA256}}); err != nil {
+		return false, err
+	}
+
+	body, err := a.receivePassword(conn)
+	if err != nil {
+		return false, err
+	}
+	var initial pgproto3.SASLInitialResponse
+	if len(body) < len(SCRAMSHA256)+5 || initial.Decode(body) != nil || initial.AuthMechanism != SCRAMSHA256 {
+		return false, errInvalidSCRAMMessage
+	}
+	serverFirst, err := server.ServerFirst(initial.Data)
+	if err != nil {
+		return false, err
+	}
+	if err := a.send(conn, &pgproto3.AuthenticationSASLContinue{Data: serverFirst}); err != nil {
+		return false, err
+	}
+
+	body, err = a.receivePassword(conn)
+	if err != nil {
+		return false, err
+	}
+	serverFinal, err := server.ServerFinal(body)
+	if err != nil {
+		return false, nil //nolint:nilerr
+	}
+	if err := a.send(conn, &pgproto3.AuthenticationSASLFinal{Data: serverFinal}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// authenticateMD5 runs an md5 challenge with the client.
+func (a *Authenticator) authenticateMD5(conn *ConnWrapper, user, secret string) (bool, error) {
+	salt, err := randomBytes(4) //nolint:mnd
+	if err != nil {
+		return false, err
+	}
+
+	request := &pgproto3.AuthenticationMD5Password{}
+	copy(request.Salt[:], salt)
+	if err := a.send(conn, request); err != nil {
+		return false, err
+	}
+
+	body, err := a.receivePassword(conn)
+	if err != nil {
+		return false, err
+	}
+
+	return secret != "" && verifyMD5(user, secret, salt, body), nil
+}
+
+// send sends an authentication request to the client.
+func (a *Authenticator) send(conn *ConnWrapper, message pgproto3.BackendMessage) error {
+	data, err := message.Encode(nil)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	_, err = conn.Write(data)
+	return err //nolint:wrapcheck
+}
+
+// receivePassword receives a password message from the client and returns its body.
+func (a *Authenticator) receivePassword(conn *ConnWrapper) ([]byte, error) {
+	data, err := conn.ReadMessages(config.DefaultChunkSize)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	var body []byte
+	forEachMessage(data, func(msgType byte, msgBody []byte) bool {
+		if msgType == PasswordMessage {
+			body = msgBody
+		}
+		return false
+	})
+	if body == nil {
+		return nil, errUnexpectedPacket
+	}
+
+	return body, nil
+}
+
+// lookup returns the password or secret of the user, either from the user list,
+// or by running the auth query.
+func (a *Authenticator) lookup(user string) (string, error) {
+	if secret, ok := a.UserList[user]; ok {
+		return secret, nil
+	}
+
+	if a.AuthQuery == "" || a.AuthPool == nil {
+		return "", errUnknownUser
+	}
+
+	client := a.popClient()
+	if client == nil {
+		return "", gerr.ErrPoolExhausted
+	}
+	defer a.putClient(client)
+
+	return a.runAuthQuery(client, user)
+}
+
+// runAuthQuery runs the auth query on the server connection with the extended query
+// protocol, so that the user name is passed as a parameter rather than in the query.
+func (a *Authenticator) runAuthQuery(client *Client, user string) (string, error) {
+	var request []byte
+	var err error
+	for _, message := range []pgproto3.FrontendMessage{
+		&pgproto3.Parse{Query: a.AuthQuery},
+		&pgproto3.Bind{Parameters: [][]byte{[]byte(user)}},
+		&pgproto3.Execute{},
+		&pgproto3.Sync{},
+	} {
+		if request, err = message.Encode(request); err != nil {
+			return "", err //nolint:wrapcheck
+		}
+	}
+
+	// The server connection is out of sync after an I/O error, so it is
+	// closed and then recycled when it is put back in the auth pool.
+	if _, err := client.Send(request); err != nil {
+		client.Close()
+		return "", err
+	}
+
+	var secret *string
+	var queryErr error
+	for ready := false; !ready; {
+		_, response, err := client.Receive()
+		if err != nil {
+			client.Close()
+			return "", err
+		}
+
+		forEachMessage(response, func(msgType byte, body []byte) bool {
+			switch msgType {
+			case DataRowMessage:
+				var row pgproto3.DataRow
+				if err := row.Decode(body); err != nil {
+					queryErr = err
+				} else if len(row.Values) > 0 && row.Values[len(row.Values)-1] != nil {
+					// The secret is the last column, e.g. SELECT usename, passwd.
+					value := string(row.Values[len(row.Values)-1])
+					secret = &value
+				}
+			case ErrorResponseMessage:
+				var errResponse pgproto3.ErrorResponse
+				if err := errResponse.Decode(body); err != nil {
+					queryErr = err
+				} else {
+					queryErr = fmt.Errorf("%s: %s", errResponse.Severity, errResponse.Message)
+				}
+			case ReadyForQueryMessage:
+				ready = true
+				return false
+			}
+			return true
+		})
+	}
+
+	if queryErr != nil {
+		return "", queryErr
+	}
+	if secret == nil {
+		return "", errUnknownUser
+	}
+	return *secret, nil
+}
+
+// popClient removes a server connection from the auth pool, and waits
+// for one to be put back if there is none.
+func (a *Authenticator) popClient() *Client {
+	a.cond.L.Lock()
+	defer a.cond.L.Unlock()
+
+	for a.ctx.Err() == nil {
+		var client *Client
+		a.AuthPool.ForEach(func(key, value interface{}) bool {
+			if cl, ok := a.AuthPool.Pop(key).(*Client); ok {
+				client = cl
+				return false
+			}
+			return true
+		})
+		if client != nil {
+			return client
+		}
+		if a.AuthPool.Cap() == 0 && a.AuthPool.Size() == 0 {
+			return nil
+		}
+		a.cond.Wait()
+	}
+
+	return nil
+}
+
+// putClient puts the server connection back in the auth pool,
+// and recycles it if it is broken.
+func (a *Authenticator) putClient(client *Client) {
+	if !client.IsConnected() {
+		if err := client.Reconnect(); err != nil {
+			a.Logger.Error().Err(err).Msg("Failed to reconnect to the auth query server")
+		}
+	}
+
+	a.cond.L.Lock()
+	defer a.cond.L.Unlock()
+
+	if err := a.AuthPool.Put(client.ID, client); err != nil {
+		a.Logger.Error().Err(err).Msg("Failed to put the client back in the auth pool")
+	}
+	a.cond.Signal()
+}
@@ -0,0 +1,251 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadUserList tests loading a user list in the format of PgBouncer.
+func TestLoadUserList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "userlist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`; comment
+# comment
+
+"alice" "password"
+"bob"	"md5c2bd0d3e2d6a0b5a1e4d2f3e1a0b9c8d"
+"quo""te" "pass""word" extra
+`), 0o600))
+
+	users, err := LoadUserList(path)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"alice":  "password",
+		"bob":    "md5c2bd0d3e2d6a0b5a1e4d2f3e1a0b9c8d",
+		`quo"te`: `pass"word`,
+	}, users)
+
+	require.NoError(t, os.WriteFile(path, []byte(`"alice" "password`), 0o600))
+	_, err = LoadUserList(path)
+	assert.NotNil(t, err)
+
+	_, err = LoadUserList(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.NotNil(t, err)
+}
+
+// connectThroughProxy connects a database client to the proxy with the given credentials,
+// and passes the traffic through the proxy in the background, like the server does.
+func connectThroughProxy(t *testing.T, proxy *Proxy, user, password string) (*pgconn.PgConn, error) {
+	t.Helper()
+
+	return connectToDatabase(t, proxy, user, password, "postgres")
+}
+
+// connectToDatabase connects a database client to the given database through the proxy.
+func connectToDatabase(
+	t *testing.T, proxy *Proxy, user, password, database string,
+) (*pgconn.PgConn, error) {
+	t.Helper()
+
+	conn, client := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(conn))
+
+	stack := NewStack()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for proxy.PassThroughToServer(conn, stack) == nil {
+		}
+		conn.Close()
+		proxy.Disconnect(conn)
+	}()
+	go func() {
+		defer wg.Done()
+		for proxy.PassThroughToClient(conn, stack) == nil {
+		}
+	}()
+	// Stop passing the traffic before the proxy is shut down.
+	t.Cleanup(func() {
+		client.Close()
+		wg.Wait()
+	})
+
+	pgConfig, err := pgconn.ParseConfig(
+		fmt.Sprintf("postgres://%s:%s@127.0.0.1/%s?sslmode=disable", user, password, database))
+	require.NoError(t, err)
+	pgConfig.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+		return client, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return pgconn.ConnectConfig(ctx, pgConfig)
+}
+
+// assertAuthenticated asserts that the client is authenticated and can run queries.
+func assertAuthenticated(t *testing.T, pgConn *pgconn.PgConn, err error) {
+	t.Helper()
+
+	require.NoError(t, err)
+	assert.Equal(t, "16.0", pgConn.ParameterStatus("server_version"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	assert.NoError(t, err)
+}
+
+// assertNotAuthenticated asserts that the client is rejected with an invalid password error.
+func assertNotAuthenticated(t *testing.T, err error) {
+	t.Helper()
+
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, InvalidPasswordCode, pgErr.Code)
+}
+
+// TestProxyAuthentication tests that the incoming connections are authenticated by the
+// proxy against a user list, while the server connections are authenticated with the
+// configured credentials.
+func TestProxyAuthentication(t *testing.T) {
+	scramSecret := newSCRAMSecret("scram-password", []byte("0123456789abcdef"), SCRAMIterations)
+	users := map[string]string{
+		"plain": "plain-password",
+		"md5":   md5Secret("md5", "md5-password"),
+		"scram": scramSecret.String(),
+	}
+
+	tests := []struct {
+		method   string
+		poolMode string
+		user     string
+		password string
+		valid    bool
+	}{
+		{config.AuthMethodSCRAMSHA256, config.TransactionPoolMode, "plain", "plain-password", true},
+		{config.AuthMethodSCRAMSHA256, config.TransactionPoolMode, "scram", "scram-password", true},
+		{config.AuthMethodSCRAMSHA256, config.TransactionPoolMode, "scram", "wrong", false},
+		{config.AuthMethodSCRAMSHA256, config.TransactionPoolMode, "unknown", "plain-password", false},
+		{config.AuthMethodSCRAMSHA256, config.SessionPoolMode, "plain", "plain-password", true},
+		{config.AuthMethodSCRAMSHA256, config.SessionPoolMode, "md5", "md5-password", false},
+		{config.AuthMethodMD5, config.SessionPoolMode, "md5", "md5-password", true},
+		{config.AuthMethodMD5, config.SessionPoolMode, "plain", "plain-password", true},
+		{config.AuthMethodMD5, config.SessionPoolMode, "plain", "wrong", false},
+		{config.AuthMethodMD5, config.StatementPoolMode, "scram", "scram-password", true},
+		{config.AuthMethodMD5, config.StatementPoolMode, "unknown", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s/%s/%s", tt.method, tt.poolMode, tt.user, tt.password), func(t *testing.T) {
+			backend := NewFakeAuthBackend(t, "backend-password", nil)
+			proxy := newTestAuthProxy(t, backend, 2, tt.poolMode, NewAuthenticator(
+				context.Background(),
+				Authenticator{
+					Method:   tt.method,
+					UserList: users,
+					Logger:   zerolog.Nop(),
+				},
+			))
+			// The server connections are authenticated with the configured credentials.
+			proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
+				client, ok := value.(*Client)
+				require.True(t, ok)
+				assert.True(t, client.IsAuthenticated())
+				return true
+			})
+
+			pgConn, err := connectThroughProxy(t, proxy, tt.user, tt.password)
+			if !tt.valid {
+				assertNotAuthenticated(t, err)
+				return
+			}
+			assertAuthenticated(t, pgConn, err)
+
+			// The startup messages of the incoming connections are not sent to the server.
+			assert.Equal(t, 2, backend.Connections())
+		})
+	}
+}
+
+// TestProxyAuthenticationAuthQuery tests that the incoming connections are authenticated
+// against the secrets that are returned by the auth query on a dedicated pool.
+func TestProxyAuthenticationAuthQuery(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "backend-password", map[string]string{
+		"scram": newSCRAMSecret("scram-password", []byte("salt"), SCRAMIterations).String(),
+	})
+
+	authPool := pool.NewPool(context.Background(), 1)
+	client := NewClient(
+		context.Background(),
+		&config.Client{
+			Network:          "tcp",
+			Address:          backend.Address(),
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      config.DefaultDialTimeout,
+			User:             "gatewayd",
+			Password:         "backend-password",
+		},
+		zerolog.Nop(),
+		nil,
+	)
+	require.NotNil(t, client)
+	require.Nil(t, authPool.Put(client.ID, client))
+
+	proxy := newTestAuthProxy(t, backend, 2, config.TransactionPoolMode, NewAuthenticator(
+		context.Background(),
+		Authenticator{
+			Method:    config.AuthMethodSCRAMSHA256,
+			AuthQuery: "SELECT usename, passwd FROM pg_shadow WHERE usename=$1",
+			AuthPool:  authPool,
+			Logger:    zerolog.Nop(),
+		},
+	))
+
+	pgConn, err := connectThroughProxy(t, proxy, "scram", "scram-password")
+	assertAuthenticated(t, pgConn, err)
+
+	_, err = connectThroughProxy(t, proxy, "scram", "wrong")
+	assertNotAuthenticated(t, err)
+
+	_, err = connectThroughProxy(t, proxy, "unknown", "scram-password")
+	assertNotAuthenticated(t, err)
+
+	// The auth query server connection is put back in the auth pool.
+	assert.Equal(t, 1, authPool.Size())
+	assert.Equal(t, 3, backend.Connections())
+}
+
+// TestClientAuthenticationFailed tests that no client is created if the server connection
+// can't be authenticated with the configured credentials.
+func TestClientAuthenticationFailed(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "backend-password", nil)
+
+	client := NewClient(
+		context.Background(),
+		&config.Client{
+			Network:          "tcp",
+			Address:          backend.Address(),
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      config.DefaultDialTimeout,
+			User:             "gatewayd",
+			Password:         "wrong",
+		},
+		zerolog.Nop(),
+		nil,
+	)
+	assert.Nil(t, client)
+}
@@ -0,0 +1,143 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// serverKey is the BackendKeyData of a server connection, together with the address of the
+// server, to which the CancelRequests for the server connection are sent.
+type serverKey struct {
+	key     pgproto3.BackendKeyData
+	network string
+	address string
+}
+
+// cancelTarget is the incoming connection that a key is issued to, and its proxy.
+type cancelTarget struct {
+	proxy *Proxy
+	conn  *ConnWrapper
+}
+
+// CancelKeys issues the keys of the BackendKeyData messages that are sent to the incoming
+// connections, and maps the keys of the CancelRequests back to the incoming connections.
+// The keys are issued by the gateway instead of the servers, since a server connection is
+// shared by many incoming connections in the pooled modes, and an incoming connection is
+// assigned many server connections. The keys can be shared by the proxies, since the
+// CancelRequest is sent on a new connection, which might be assigned to another proxy.
+type CancelKeys struct {
+	mu      *sync.Mutex
+	targets map[pgproto3.BackendKeyData]cancelTarget
+	keys    map[*ConnWrapper]pgproto3.BackendKeyData
+}
+
+// NewCancelKeys creates a new registry of the keys of the incoming connections.
+func NewCancelKeys() *CancelKeys {
+	return &CancelKeys{
+		mu:      &sync.Mutex{},
+		targets: make(map[pgproto3.BackendKeyData]cancelTarget),
+		keys:    make(map[*ConnWrapper]pgproto3.BackendKeyData),
+	}
+}
+
+// issue returns the key of the incoming connection, and issues a new random key if the
+// incoming connection doesn't have one yet.
+func (ck *CancelKeys) issue(proxy *Proxy, conn *ConnWrapper) (pgproto3.BackendKeyData, error) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	if key, ok := ck.keys[conn]; ok {
+		return key, nil
+	}
+
+	for {
+		random, err := randomBytes(8) //nolint:mnd
+		if err != nil {
+			return pgproto3.BackendKeyData{}, err
+		}
+		key := pgproto3.BackendKeyData{
+			ProcessID: binary.BigEndian.Uint32(random[:4]),
+			SecretKey: binary.BigEndian.Uint32(random[4:]),
+		}
+		if _, ok := ck.targets[key]; ok {
+			continue
+		}
+
+		ck.targets[key] = cancelTarget{proxy: proxy, conn: conn}
+		ck.keys[conn] = key
+		return key, nil
+	}
+}
+
+// revoke removes the key of the incoming connection, when it is closed.
+func (ck *CancelKeys) revoke(conn *ConnWrapper) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	if key, ok := ck.keys[conn]; ok {
+		delete(ck.targets, key)
+		delete(ck.keys, conn)
+	}
+}
+
+// lookup returns the incoming connection that the key is issued to.
+func (ck *CancelKeys) lookup(key pgproto3.BackendKeyData) (cancelTarget, bool) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	target, ok := ck.targets[key]
+	return target, ok
+}
+
+// isCancelRequest returns true if the request is a CancelRequest.
+func isCancelRequest(request []byte) bool {
+	return len(request) == UntypedHeaderLength+12 &&
+		binary.BigEndian.Uint32(request[UntypedHeaderLength:]) == CancelRequestCode
+}
+
+// setBackendKey records the BackendKeyData of the server connection.
+func (c *Client) setBackendKey(key pgproto3.BackendKeyData) {
+	c.backendKey.Store(&serverKey{key: key, network: c.Network, address: c.Address})
+}
+
+// cancelRequest cancels the query in progress on the server connection, by sending a
+// CancelRequest with its BackendKeyData on a new connection to the same server.
+// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-CANCELING-REQUESTS
+func (c *Client) cancelRequest() *gerr.GatewayDError {
+	backendKey := c.backendKey.Load()
+	if backendKey == nil {
+		return gerr.ErrCancelRequestFailed.Wrap(errors.New("the server connection has no BackendKeyData"))
+	}
+
+	conn, err := c.dialAddress(backendKey.network, backendKey.address)
+	if err != nil {
+		return gerr.ErrCancelRequestFailed.Wrap(err)
+	}
+	defer conn.Close()
+
+	request, err := (&pgproto3.CancelRequest{
+		ProcessID: backendKey.key.ProcessID,
+		SecretKey: backendKey.key.SecretKey,
+	}).Encode(nil)
+	if err != nil {
+		return gerr.ErrCancelRequestFailed.Wrap(err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return gerr.ErrCancelRequestFailed.Wrap(err)
+	}
+
+	// The server closes the connection without a response, after processing the request.
+	// The errors are ignored, since the request is already sent.
+	if c.DialTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(c.DialTimeout))
+	}
+	_, _ = io.Copy(io.Discard, conn)
+
+	return nil
+}
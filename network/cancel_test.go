@@ -0,0 +1,146 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCancelKeys tests issuing, looking up and revoking the keys of the incoming connections.
+func TestCancelKeys(t *testing.T) {
+	cancelKeys := NewCancelKeys()
+	proxy := &Proxy{}
+	first, _ := NewTestIncomingConnection(t)
+	second, _ := NewTestIncomingConnection(t)
+
+	key, err := cancelKeys.issue(proxy, first)
+	require.NoError(t, err)
+	// The key of an incoming connection is issued once.
+	again, err := cancelKeys.issue(proxy, first)
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+	other, err := cancelKeys.issue(proxy, second)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	target, ok := cancelKeys.lookup(key)
+	require.True(t, ok)
+	assert.Same(t, proxy, target.proxy)
+	assert.Same(t, first, target.conn)
+
+	cancelKeys.revoke(first)
+	_, ok = cancelKeys.lookup(key)
+	assert.False(t, ok)
+	_, ok = cancelKeys.lookup(other)
+	assert.True(t, ok)
+}
+
+// Test_isCancelRequest tests detecting the CancelRequest among the untyped messages.
+func Test_isCancelRequest(t *testing.T) {
+	request, err := (&pgproto3.CancelRequest{ProcessID: 1, SecretKey: 2}).Encode(nil)
+	require.NoError(t, err)
+	assert.True(t, isCancelRequest(request))
+
+	sslRequest, err := (&pgproto3.SSLRequest{}).Encode(nil)
+	require.NoError(t, err)
+	assert.False(t, isCancelRequest(sslRequest))
+	assert.False(t, isCancelRequest(CreatePgStartupPacket()))
+}
+
+// sendCancelRequest sends a CancelRequest with the key through a new incoming connection,
+// like the database clients do.
+func sendCancelRequest(t *testing.T, proxy *Proxy, key pgproto3.BackendKeyData) {
+	t.Helper()
+
+	conn, client := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(conn))
+	defer proxy.Disconnect(conn)
+
+	request, err := (&pgproto3.CancelRequest{
+		ProcessID: key.ProcessID,
+		SecretKey: key.SecretKey,
+	}).Encode(nil)
+	require.NoError(t, err)
+	_, err = client.Write(request)
+	require.NoError(t, err)
+
+	assert.Equal(t, gerr.ErrClientNotConnected, proxy.PassThroughToServer(conn, NewStack()))
+}
+
+// TestProxyCancelRequest tests that the clients get a key of the proxy instead of the key of
+// the server connection, and that their CancelRequests cancel the queries in progress on the
+// server connections that are assigned to them.
+func TestProxyCancelRequest(t *testing.T) {
+	tests := []struct {
+		poolMode      string
+		authenticated bool
+	}{
+		{config.SessionPoolMode, false},
+		{config.TransactionPoolMode, false},
+		{config.SessionPoolMode, true},
+		{config.TransactionPoolMode, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%t", tt.poolMode, tt.authenticated), func(t *testing.T) {
+			var backend *FakeBackend
+			var authenticator *Authenticator
+			if tt.authenticated {
+				// The server connections are authenticated by the proxy, which records their keys.
+				backend = NewFakeAuthBackend(t, "backend-password", nil)
+				authenticator = NewAuthenticator(context.Background(), Authenticator{
+					Method:   config.AuthMethodMD5,
+					UserList: map[string]string{"alice": "secret"},
+					Logger:   zerolog.Nop(),
+				})
+			} else {
+				backend = NewFakeBackend(t)
+			}
+			proxy := newTestAuthProxy(t, backend, 3, tt.poolMode, authenticator)
+
+			pgConn, err := connectThroughProxy(t, proxy, "alice", "secret")
+			require.NoError(t, err)
+			key := pgproto3.BackendKeyData{ProcessID: pgConn.PID(), SecretKey: pgConn.SecretKey()}
+			_, ok := proxy.CancelKeys.lookup(key)
+			require.True(t, ok)
+
+			// A key that isn't issued by the proxy is ignored.
+			sendCancelRequest(t, proxy, pgproto3.BackendKeyData{ProcessID: 1, SecretKey: fakeSecretKey(1)})
+
+			result := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				_, err := pgConn.Exec(ctx, "SELECT pg_sleep(10)").ReadAll()
+				result <- err
+			}()
+			require.Eventually(t, func() bool { return backend.Sleeping() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+			sendCancelRequest(t, proxy, key)
+
+			select {
+			case err := <-result:
+				var pgErr *pgconn.PgError
+				require.True(t, errors.As(err, &pgErr), err)
+				assert.Equal(t, "57014", pgErr.Code)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "the query is not canceled")
+			}
+			assert.Equal(t, 1, backend.CancelRequests())
+
+			// The session is usable after the query is canceled.
+			_, err = pgConn.Exec(context.Background(), "SELECT 1").ReadAll()
+			assert.NoError(t, err)
+		})
+	}
+}
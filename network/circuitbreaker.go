@@ -0,0 +1,241 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/metrics"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+// States of the circuit breakers.
+const (
+	// CircuitClosed lets all the requests through.
+	CircuitClosed = "closed"
+	// CircuitOpen fails all the requests, until the open timeout elapses.
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets a few requests through to test the server.
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker tracks the failures of the dials and the receives of the server connections of
+// a proxy. It opens when at least FailureRate of them fail within a Window, after MinRequests,
+// so that the new sessions fail fast instead of waiting for the retries of the dials. After the
+// OpenTimeout, it lets HalfOpenRequests dials through, and closes if they all succeed, or opens
+// again if any of them fails.
+type CircuitBreaker struct {
+	// GroupName and ProxyName label the metrics of the circuit breaker.
+	GroupName        string
+	ProxyName        string
+	FailureRate      float64
+	MinRequests      int
+	Window           time.Duration
+	OpenTimeout      time.Duration
+	HalfOpenRequests int
+	Logger           zerolog.Logger
+
+	ctx         context.Context //nolint:containedctx
+	mu          *sync.Mutex
+	state       string
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	// probes and successes count the requests that are let through in the half-open state.
+	probes    int
+	successes int
+}
+
+// NewCircuitBreaker creates a new circuit breaker, which is closed.
+func NewCircuitBreaker(ctx context.Context, cb CircuitBreaker) *CircuitBreaker {
+	circuitBreakerCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewCircuitBreaker")
+	defer span.End()
+
+	circuitBreaker := &CircuitBreaker{
+		GroupName: cb.GroupName,
+		ProxyName: cb.ProxyName,
+		FailureRate: config.If(
+			cb.FailureRate > 0, cb.FailureRate, config.DefaultCircuitFailureRate),
+		MinRequests: config.If(
+			cb.MinRequests > 0, cb.MinRequests, config.DefaultCircuitMinRequests),
+		Window: config.If(
+			cb.Window > 0, cb.Window, config.DefaultCircuitWindow),
+		OpenTimeout: config.If(
+			cb.OpenTimeout > 0, cb.OpenTimeout, config.DefaultCircuitOpenTimeout),
+		HalfOpenRequests: config.If(
+			cb.HalfOpenRequests > 0, cb.HalfOpenRequests, config.DefaultCircuitHalfOpenRequests),
+		Logger:      cb.Logger,
+		ctx:         circuitBreakerCtx,
+		mu:          &sync.Mutex{},
+		state:       CircuitClosed,
+		windowStart: time.Now(),
+	}
+	metrics.ProxyCircuitBreakerState.WithLabelValues(
+		circuitBreaker.GroupName, circuitBreaker.ProxyName).Set(circuitStateValue(CircuitClosed))
+
+	return circuitBreaker
+}
+
+// State returns the state of the circuit breaker, which is closed if there is none.
+func (c *CircuitBreaker) State() string {
+	if c == nil {
+		return CircuitClosed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// Ready returns false while the circuit breaker is open and the open timeout hasn't elapsed,
+// that is while the server is known to be down.
+func (c *CircuitBreaker) Ready() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state != CircuitOpen || time.Since(c.openedAt) >= c.OpenTimeout
+}
+
+// Allow returns true if a request may be sent to the server. Once the open timeout elapses,
+// the circuit breaker becomes half-open and allows the first HalfOpenRequests requests.
+func (c *CircuitBreaker) Allow() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < c.OpenTimeout {
+			return false
+		}
+		c.transition(CircuitHalfOpen)
+		c.probes = 1
+		return true
+	case CircuitHalfOpen:
+		if c.probes >= c.HalfOpenRequests {
+			return false
+		}
+		c.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful request. The half-open circuit breaker closes once all
+// its requests have succeeded.
+func (c *CircuitBreaker) Success() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case CircuitHalfOpen:
+		c.successes++
+		if c.successes >= c.HalfOpenRequests {
+			c.transition(CircuitClosed)
+		}
+	case CircuitClosed:
+		c.rotate()
+		c.requests++
+	}
+}
+
+// Failure records a failed request, and opens the circuit breaker if the failure rate is
+// reached, or if it is half-open.
+func (c *CircuitBreaker) Failure() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case CircuitHalfOpen:
+		c.transition(CircuitOpen)
+	case CircuitClosed:
+		c.rotate()
+		c.requests++
+		c.failures++
+		if c.requests >= c.MinRequests && float64(c.failures)/float64(c.requests) >= c.FailureRate {
+			c.transition(CircuitOpen)
+		}
+	}
+}
+
+// rotate starts a new window of requests once the current one has elapsed.
+func (c *CircuitBreaker) rotate() {
+	if time.Since(c.windowStart) >= c.Window {
+		c.windowStart = time.Now()
+		c.requests = 0
+		c.failures = 0
+	}
+}
+
+// transition changes the state of the circuit breaker, and resets its counters.
+func (c *CircuitBreaker) transition(state string) {
+	fields := map[string]interface{}{
+		"group":    c.GroupName,
+		"proxy":    c.ProxyName,
+		"previous": c.state,
+		"state":    state,
+		"requests": c.requests,
+		"failures": c.failures,
+	}
+
+	c.state = state
+	c.requests = 0
+	c.failures = 0
+	c.windowStart = time.Now()
+	c.probes = 0
+	c.successes = 0
+	if state == CircuitOpen {
+		c.openedAt = time.Now()
+	}
+
+	metrics.ProxyCircuitBreakerState.WithLabelValues(c.GroupName, c.ProxyName).Set(circuitStateValue(state))
+	metrics.ProxyCircuitBreakerTransitions.WithLabelValues(c.GroupName, c.ProxyName, state).Inc()
+	if state == CircuitOpen {
+		c.Logger.Warn().Fields(fields).Msg(
+			"The circuit breaker of the proxy is open, so the new sessions fail fast")
+	} else {
+		c.Logger.Info().Fields(fields).Msg("The circuit breaker of the proxy has changed its state")
+	}
+}
+
+// circuitStateValue returns the value of the state of the circuit breakers in the metrics.
+func circuitStateValue(state string) float64 {
+	switch state {
+	case CircuitOpen:
+		return 2 //nolint:mnd
+	case CircuitHalfOpen:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// isServerFailure returns true if the error shows that the server closed or reset the
+// connection, as opposed to the proxy interrupting or closing it.
+func isServerFailure(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,194 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCircuitBreaker creates a circuit breaker that opens after two failures out of
+// four requests, and that lets a request through after the open timeout.
+func newTestCircuitBreaker(openTimeout time.Duration) *CircuitBreaker {
+	return NewCircuitBreaker(context.Background(), CircuitBreaker{
+		GroupName:   config.Default,
+		ProxyName:   config.DefaultConfigurationBlock,
+		FailureRate: 0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		OpenTimeout: openTimeout,
+		Logger:      zerolog.Nop(),
+	})
+}
+
+// TestCircuitBreaker tests the transitions of the circuit breaker between its states.
+func TestCircuitBreaker(t *testing.T) {
+	circuitBreaker := newTestCircuitBreaker(50 * time.Millisecond)
+	assert.Equal(t, config.DefaultCircuitHalfOpenRequests, circuitBreaker.HalfOpenRequests)
+	assert.Equal(t, CircuitClosed, circuitBreaker.State())
+
+	// The failure rate is reached, but not the minimum number of requests.
+	circuitBreaker.Success()
+	circuitBreaker.Failure()
+	assert.Equal(t, CircuitClosed, circuitBreaker.State())
+	circuitBreaker.Success()
+	circuitBreaker.Failure()
+	assert.Equal(t, CircuitOpen, circuitBreaker.State())
+	assert.False(t, circuitBreaker.Ready())
+	assert.False(t, circuitBreaker.Allow())
+
+	// The first request after the open timeout is let through, and reopens the circuit
+	// breaker if it fails.
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, circuitBreaker.Ready())
+	assert.True(t, circuitBreaker.Allow())
+	assert.Equal(t, CircuitHalfOpen, circuitBreaker.State())
+	assert.False(t, circuitBreaker.Allow())
+	circuitBreaker.Failure()
+	assert.Equal(t, CircuitOpen, circuitBreaker.State())
+	assert.False(t, circuitBreaker.Ready())
+
+	// The circuit breaker closes if the request succeeds.
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, circuitBreaker.Allow())
+	circuitBreaker.Success()
+	assert.Equal(t, CircuitClosed, circuitBreaker.State())
+	assert.True(t, circuitBreaker.Allow())
+}
+
+// TestCircuitBreakerWindow tests that the requests of the elapsed windows aren't counted.
+func TestCircuitBreakerWindow(t *testing.T) {
+	circuitBreaker := newTestCircuitBreaker(time.Minute)
+	circuitBreaker.Window = 50 * time.Millisecond
+
+	for range 3 {
+		circuitBreaker.Failure()
+	}
+	time.Sleep(60 * time.Millisecond)
+	circuitBreaker.Success()
+	circuitBreaker.Success()
+	circuitBreaker.Failure()
+	assert.Equal(t, CircuitClosed, circuitBreaker.State())
+	circuitBreaker.Failure()
+	assert.Equal(t, CircuitOpen, circuitBreaker.State())
+}
+
+// TestCircuitBreakerNil tests that a nil circuit breaker lets all the requests through.
+func TestCircuitBreakerNil(t *testing.T) {
+	var circuitBreaker *CircuitBreaker
+	circuitBreaker.Failure()
+	circuitBreaker.Success()
+	assert.Equal(t, CircuitClosed, circuitBreaker.State())
+	assert.True(t, circuitBreaker.Ready())
+	assert.True(t, circuitBreaker.Allow())
+}
+
+// TestRetryCircuitBreaker tests that the retries stop as soon as the circuit breaker opens.
+func TestRetryCircuitBreaker(t *testing.T) {
+	circuitBreaker := newTestCircuitBreaker(time.Minute)
+	retry := NewRetry(Retry{
+		Retries:        10,
+		Backoff:        time.Millisecond,
+		Logger:         zerolog.Nop(),
+		CircuitBreaker: circuitBreaker,
+	})
+
+	attempts := 0
+	_, err := retry.Retry(func() (any, error) {
+		attempts++
+		return nil, errors.New("connection refused")
+	})
+	require.ErrorIs(t, err, gerr.ErrCircuitOpen)
+	assert.Equal(t, 4, attempts)
+	assert.Equal(t, CircuitOpen, circuitBreaker.State())
+
+	// The dials of the server connections fail fast.
+	client := NewClient(
+		context.Background(),
+		&config.Client{Network: "tcp", Address: closedAddress(t), DialTimeout: time.Second},
+		zerolog.Nop(),
+		retry,
+	)
+	assert.Nil(t, client)
+	assert.Equal(t, 4, attempts)
+}
+
+// TestProxyCircuitBreaker tests that the proxy fails fast while its circuit breaker is open,
+// and that the load balancers skip it.
+func TestProxyCircuitBreaker(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+	proxy.CircuitBreaker = newTestCircuitBreaker(time.Minute)
+	assert.True(t, proxy.IsBackendHealthy())
+
+	for range 4 {
+		proxy.CircuitBreaker.Failure()
+	}
+	assert.False(t, proxy.IsBackendHealthy())
+	conn, _ := NewTestIncomingConnection(t)
+	connectErr := proxy.Connect(conn)
+	require.NotNil(t, connectErr)
+	assert.Equal(t, gerr.ErrCodeCircuitOpen, connectErr.Code)
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:15432",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+		},
+	)
+	require.NotNil(t, server)
+	server.Status = config.Running
+
+	// The client receives an error instead of waiting for the server.
+	conn, client := NewTestIncomingConnection(t)
+	_, action := server.OnOpen(conn)
+	require.Equal(t, None, action)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.OnTraffic(conn, make(chan struct{}, 2))
+		server.OnClose(conn, nil)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	pgConfig, err := pgconn.ParseConfig("postgres://alice@127.0.0.1/postgres?sslmode=disable")
+	require.NoError(t, err)
+	pgConfig.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+		return client, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = pgconn.ConnectConfig(ctx, pgConfig)
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, ConnectionFailureCode, pgErr.Code)
+}
+
+func Test_isServerFailure(t *testing.T) {
+	assert.True(t, isServerFailure(io.EOF))
+	assert.True(t, isServerFailure(gerr.ErrReadFailed.Wrap(syscall.ECONNRESET)))
+	assert.False(t, isServerFailure(nil))
+	assert.False(t, isServerFailure(net.ErrClosed))
+	assert.False(t, isServerFailure(errors.New("i/o timeout")))
+}
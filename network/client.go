@@ -1,10 +1,16 @@
 package network
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,6 +18,7 @@ import (
 	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/gatewayd-io/gatewayd/metrics"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
 )
@@ -34,6 +41,34 @@ type Client struct {
 	connected atomic.Bool
 	mu        sync.Mutex
 	retry     IRetry
+	reader    *MessageReader
+	// receiveMu is held while the server connection is read, so that the reset of the server
+	// connection can wait for the pending Receive of the incoming connection to be interrupted.
+	receiveMu sync.Mutex
+	// authenticated is true if the server connection has completed the startup phase.
+	// Authenticated connections are shared between the incoming connections when the
+	// proxy is not in the session pool mode.
+	authenticated atomic.Bool
+	// statements is the set of the prepared statements on the server connection,
+	// which are created by the sessions that share the server connection.
+	statements   map[string]bool
+	statementsMu sync.Mutex
+	// password is used for authenticating the server connection with the configured user.
+	// It is either a plain text password or an md5 secret.
+	password string
+	// scramKeys are used instead of the password, if set.
+	scramKeys *scramClientKeys
+	// parameters are the run-time parameters that are reported by the server
+	// after the server connection is authenticated.
+	parameters atomic.Pointer[map[string]string]
+	// backendKey is the BackendKeyData of the server connection, which is used for
+	// canceling the queries of the incoming connections that it is assigned to.
+	backendKey atomic.Pointer[serverKey]
+	// tlsConfig is used for upgrading the server connection to TLS, unless the SSL mode is disable.
+	tlsConfig *tls.Config
+	// proxyProtocolAddrs are the addresses of the incoming connection that the server connection
+	// is dedicated to, which are sent in the PROXY protocol header, if any.
+	proxyProtocolAddrs atomic.Pointer[[2]net.Addr]
 
 	TCPKeepAlive       bool
 	TCPKeepAlivePeriod time.Duration
@@ -45,6 +80,14 @@ type Client struct {
 	ID                 string
 	Network            string // tcp/udp/unix
 	Address            string
+	// User and Database are used for authenticating the server connection,
+	// if the incoming connections are authenticated by the proxy.
+	User     string
+	Database string
+	SSLMode  string
+	// ProxyProtocol is the version of the PROXY protocol header that is sent on the new
+	// server connections, if any.
+	ProxyProtocol string
 }
 
 var _ IClient = (*Client)(nil)
@@ -90,14 +133,22 @@ func NewClient(
 		}
 	}
 
+	client.ProxyProtocol = clientConfig.ProxyProtocol
+
+	// Load the TLS config of the server connection, if the SSL mode requires it.
+	client.SSLMode = clientConfig.SSLMode
+	if tlsConfig, err := NewClientTLSConfig(clientConfig); err != nil {
+		logger.Error().Err(err).Msg("Failed to load the TLS config of the server connection")
+		span.RecordError(err)
+		return nil
+	} else {
+		client.tlsConfig = tlsConfig
+	}
+
 	var origErr error
 	// Create a new connection and retry a few times if needed.
 	if conn, err := client.retry.Retry(func() (any, error) {
-		if client.DialTimeout > 0 {
-			return net.DialTimeout(client.Network, client.Address, client.DialTimeout)
-		} else {
-			return net.Dial(client.Network, client.Address)
-		}
+		return client.dial()
 	}); err != nil {
 		origErr = err
 	} else {
@@ -120,7 +171,11 @@ func NewClient(
 	client.TCPKeepAlive = clientConfig.TCPKeepAlive
 	client.TCPKeepAlivePeriod = clientConfig.TCPKeepAlivePeriod
 
-	if c, ok := client.conn.(*net.TCPConn); ok {
+	netConn := client.conn
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	if c, ok := netConn.(*net.TCPConn); ok {
 		if err := c.SetKeepAlive(client.TCPKeepAlive); err != nil {
 			logger.Error().Err(err).Msg("Failed to set keep alive")
 			span.RecordError(err)
@@ -159,6 +214,22 @@ func NewClient(
 	// Set the receive chunk size. This is the size of the buffer that is read from the connection
 	// in chunks.
 	client.ReceiveChunkSize = clientConfig.ReceiveChunkSize
+	client.reader = NewMessageReader(client.conn, client.ReceiveChunkSize, false)
+
+	// Authenticate the server connection with the configured credentials, if any.
+	client.User = clientConfig.User
+	client.Database = clientConfig.Database
+	client.password = clientConfig.Password
+	if client.User != "" {
+		if err := client.authenticate(); err != nil {
+			logger.Error().Err(err).Msg("Failed to authenticate the new connection")
+			span.RecordError(err)
+			if err := client.conn.Close(); err != nil {
+				logger.Error().Err(err).Msg("Failed to close connection")
+			}
+			return nil
+		}
+	}
 
 	logger.Trace().Str("address", client.Address).Msg("New client created")
 	client.ID = GetID(
@@ -231,28 +302,25 @@ func (c *Client) Receive() (int, []byte, *gerr.GatewayDError) {
 		ctx = context.Background()
 	}
 
-	var received int
-	buffer := bytes.NewBuffer(nil)
-	// Read the data in chunks.
-	for ctx.Err() == nil {
-		chunk := make([]byte, c.ReceiveChunkSize)
-		read, err := c.conn.Read(chunk)
-		if err != nil {
-			c.logger.Error().Err(err).Msg("Couldn't receive data from the server")
-			span.RecordError(err)
-			return received, buffer.Bytes(), gerr.ErrClientReceiveFailed.Wrap(err)
-		}
-		received += read
-		buffer.Write(chunk[:read])
+	if ctx.Err() != nil {
+		span.RecordError(ctx.Err())
+		return 0, nil, gerr.ErrClientReceiveFailed.Wrap(ctx.Err())
+	}
 
-		if read == 0 || read < c.ReceiveChunkSize {
-			break
-		}
+	// Read whole messages, so that the response is never truncated or merged
+	// with a partial message, regardless of the size of the network reads.
+	c.receiveMu.Lock()
+	response, err := c.reader.ReadMessages()
+	c.receiveMu.Unlock()
+	if err != nil {
+		c.logger.Error().Err(err).Msg("Couldn't receive data from the server")
+		span.RecordError(err)
+		return len(response), response, gerr.ErrClientReceiveFailed.Wrap(err)
 	}
 
 	span.AddEvent("Received data from server")
 
-	return received, buffer.Bytes(), nil
+	return len(response), response, nil
 }
 
 // Reconnect reconnects to the server.
@@ -270,6 +338,9 @@ func (c *Client) Reconnect() error {
 		metrics.ServerConnections.Dec()
 	}
 	c.connected.Store(false)
+	c.authenticated.Store(false)
+	c.backendKey.Store(nil)
+	c.resetStatements()
 
 	// Restore the address and network.
 	c.Address = address
@@ -278,16 +349,13 @@ func (c *Client) Reconnect() error {
 	var origErr error
 	// Create a new connection and retry a few times if needed.
 	if conn, err := c.retry.Retry(func() (any, error) {
-		if c.DialTimeout > 0 {
-			return net.DialTimeout(c.Network, c.Address, c.DialTimeout)
-		} else {
-			return net.Dial(c.Network, c.Address)
-		}
+		return c.dial()
 	}); err != nil {
 		origErr = err
 	} else {
 		if netConn, ok := conn.(net.Conn); ok {
 			c.conn = netConn
+			c.reader = NewMessageReader(c.conn, c.ReceiveChunkSize, false)
 		} else {
 			origErr = fmt.Errorf("unexpected connection type: %T", conn)
 		}
@@ -298,6 +366,18 @@ func (c *Client) Reconnect() error {
 		return gerr.ErrClientConnectionFailed.Wrap(origErr)
 	}
 
+	if c.User != "" {
+		if err := c.authenticate(); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to authenticate the connection")
+			span.RecordError(err)
+			if err := c.conn.Close(); err != nil {
+				c.logger.Error().Err(err).Msg("Failed to close connection")
+			}
+			c.conn = nil
+			return err
+		}
+	}
+
 	c.ID = GetID(
 		c.conn.LocalAddr().Network(),
 		c.conn.LocalAddr().String(),
@@ -371,6 +451,471 @@ func (c *Client) IsConnected() bool {
 	return c.connected.Load()
 }
 
+// IsAuthenticated checks if the client has completed the startup phase with the server.
+func (c *Client) IsAuthenticated() bool {
+	if c == nil {
+		return false
+	}
+
+	return c.authenticated.Load()
+}
+
+// ServerParameters returns the run-time parameters that are reported by the server
+// when the server connection is authenticated with the configured credentials.
+func (c *Client) ServerParameters() map[string]string {
+	if c == nil {
+		return nil
+	}
+
+	if parameters := c.parameters.Load(); parameters != nil {
+		return *parameters
+	}
+	return nil
+}
+
+// NewClientTLSConfig returns the TLS config of the server connections for the SSL mode
+// of the client configuration, or nil if the SSL mode is disable. The modes behave like
+// the sslmode of libpq.
+func NewClientTLSConfig(clientConfig *config.Client) (*tls.Config, *gerr.GatewayDError) {
+	if clientConfig.SSLMode == "" || clientConfig.SSLMode == config.SSLModeDisable {
+		return nil, nil
+	}
+
+	// The server name is sent with SNI and verified in the verify-full mode.
+	serverName := clientConfig.SSLServerName
+	if serverName == "" {
+		serverName = clientConfig.Address
+		if host, _, err := net.SplitHostPort(clientConfig.Address); err == nil {
+			serverName = host
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if clientConfig.SSLRootCert != "" {
+		rootCert, err := os.ReadFile(clientConfig.SSLRootCert)
+		if err != nil {
+			return nil, gerr.ErrGetTLSConfigFailed.Wrap(err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(rootCert) {
+			return nil, gerr.ErrGetTLSConfigFailed.Wrap(
+				fmt.Errorf("no certificates found in %s", clientConfig.SSLRootCert))
+		}
+	}
+
+	if clientConfig.SSLCert != "" || clientConfig.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(clientConfig.SSLCert, clientConfig.SSLKey)
+		if err != nil {
+			return nil, gerr.ErrGetTLSConfigFailed.Wrap(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch clientConfig.SSLMode {
+	case config.SSLModePrefer, config.SSLModeRequire:
+		// Like libpq, the require mode verifies the certificate chain if a root certificate
+		// is configured, and otherwise only encrypts the connection.
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+		if clientConfig.SSLMode == config.SSLModeRequire && clientConfig.SSLRootCert != "" {
+			tlsConfig.VerifyConnection = verifyCertificateChain(tlsConfig.RootCAs)
+		}
+	case config.SSLModeVerifyCA:
+		// The certificate chain is verified, but not the server name.
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+		tlsConfig.VerifyConnection = verifyCertificateChain(tlsConfig.RootCAs)
+	case config.SSLModeVerifyFull:
+	default:
+		return nil, gerr.ErrGetTLSConfigFailed.Wrap(
+			fmt.Errorf("invalid SSL mode: %s", clientConfig.SSLMode))
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyCertificateChain returns a function that verifies the certificate chain of the
+// server against the root certificates, or the system roots if they are nil.
+func verifyCertificateChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("the server did not send a certificate")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err //nolint:wrapcheck
+	}
+}
+
+// dial connects to the server and upgrades the connection to TLS, if the SSL mode requires it.
+func (c *Client) dial() (net.Conn, error) {
+	return c.dialAddress(c.Network, c.Address)
+}
+
+// dialAddress connects to the server at the given address with the settings of the client.
+func (c *Client) dialAddress(network, address string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if c.DialTimeout > 0 {
+		conn, err = net.DialTimeout(network, address, c.DialTimeout)
+	} else {
+		conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return conn, err //nolint:wrapcheck
+	}
+
+	// The PROXY protocol header precedes everything else, including the SSLRequest.
+	if sendsProxyProtocol(c.ProxyProtocol) {
+		var source, destination net.Addr
+		if addrs := c.proxyProtocolAddrs.Load(); addrs != nil {
+			source, destination = addrs[0], addrs[1]
+		}
+		if _, err := conn.Write(encodeProxyProtocolHeader(c.ProxyProtocol, source, destination)); err != nil {
+			conn.Close()
+			return nil, gerr.ErrProxyProtocolFailed.Wrap(err)
+		}
+	}
+
+	if c.tlsConfig == nil {
+		return conn, nil
+	}
+
+	tlsConn, err := c.negotiateTLS(conn)
+	if err != nil {
+		conn.Close()
+		return nil, gerr.ErrServerTLSFailed.Wrap(err)
+	}
+	return tlsConn, nil
+}
+
+// setProxyProtocolAddrs sets the addresses of the incoming connection that the server connection
+// is dedicated to, which are sent in the PROXY protocol header of the next connection, or
+// resets them if nil, in which case the header has the LOCAL command.
+func (c *Client) setProxyProtocolAddrs(source, destination net.Addr) {
+	if source == nil {
+		c.proxyProtocolAddrs.Store(nil)
+		return
+	}
+	c.proxyProtocolAddrs.Store(&[2]net.Addr{source, destination})
+}
+
+// negotiateTLS sends an SSLRequest to the server and performs the TLS handshake if the
+// server accepts it. The plaintext connection is kept in the prefer mode, if the server
+// doesn't support TLS.
+// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
+func (c *Client) negotiateTLS(conn net.Conn) (net.Conn, error) {
+	if c.DialTimeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(c.DialTimeout)); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		defer func() {
+			if err := conn.SetDeadline(time.Time{}); err != nil {
+				c.logger.Error().Err(err).Msg("Failed to reset deadline")
+			}
+		}()
+	}
+
+	request, err := (&pgproto3.SSLRequest{}).Encode(nil)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	// The server responds with a single byte, before any TLS handshake.
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	switch response[0] {
+	case 'S':
+		tlsConn := tls.Client(conn, c.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		c.logger.Debug().Fields(
+			map[string]interface{}{
+				"address": c.Address,
+				"sslMode": c.SSLMode,
+			},
+		).Msg("Upgraded the server connection to TLS")
+		return tlsConn, nil
+	case 'N':
+		if c.SSLMode == config.SSLModePrefer {
+			c.logger.Debug().Str("address", c.Address).Msg(
+				"The server does not support TLS, using a plaintext connection")
+			return conn, nil
+		}
+		return nil, errors.New("the server does not support TLS")
+	default:
+		return nil, fmt.Errorf("unexpected response to the SSL request: %q", response[0])
+	}
+}
+
+// authenticate runs the startup phase on the server connection with the configured
+// credentials, so that the server connection can be shared between the incoming
+// connections that are authenticated by the proxy.
+func (c *Client) authenticate() *gerr.GatewayDError {
+	parameters := map[string]string{"user": c.User}
+	if c.Database != "" {
+		parameters["database"] = c.Database
+	}
+	startup, err := (&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      parameters,
+	}).Encode(nil)
+	if err != nil {
+		return gerr.ErrMsgEncodeError.Wrap(err)
+	}
+	if _, err := c.conn.Write(startup); err != nil {
+		return gerr.ErrClientSendFailed.Wrap(err)
+	}
+
+	var scram *scramClient
+	serverParameters := make(map[string]string)
+	for {
+		response, err := c.reader.ReadMessages()
+		if err != nil {
+			return gerr.ErrClientReceiveFailed.Wrap(err)
+		}
+
+		var authErr error
+		ready := false
+		forEachMessage(response, func(msgType byte, body []byte) bool {
+			switch msgType {
+			case AuthenticationMessage:
+				authErr = c.respondToAuthentication(body, &scram)
+			case ParameterStatusMessage:
+				var status pgproto3.ParameterStatus
+				if err := status.Decode(body); err == nil {
+					serverParameters[status.Name] = status.Value
+				}
+			case BackendKeyDataMessage:
+				var key pgproto3.BackendKeyData
+				if err := key.Decode(body); err == nil {
+					c.setBackendKey(key)
+				}
+			case ErrorResponseMessage:
+				var errResponse pgproto3.ErrorResponse
+				if err := errResponse.Decode(body); err != nil {
+					authErr = err
+				} else {
+					authErr = fmt.Errorf("%s: %s", errResponse.Severity, errResponse.Message)
+				}
+			case ReadyForQueryMessage:
+				ready = true
+			}
+			return authErr == nil && !ready
+		})
+
+		if authErr != nil {
+			return gerr.ErrServerAuthenticationFailed.Wrap(authErr)
+		}
+		if ready {
+			break
+		}
+	}
+
+	c.parameters.Store(&serverParameters)
+	c.authenticated.Store(true)
+	c.logger.Debug().Fields(
+		map[string]interface{}{
+			"address":  c.Address,
+			"user":     c.User,
+			"database": c.Database,
+		},
+	).Msg("Authenticated to server")
+
+	return nil
+}
+
+// respondToAuthentication responds to an authentication request of the server.
+func (c *Client) respondToAuthentication(body []byte, scram **scramClient) error {
+	if len(body) < 4 { //nolint:mnd
+		return gerr.ErrInvalidMessage
+	}
+
+	var response pgproto3.FrontendMessage
+	switch authType := binary.BigEndian.Uint32(body); authType {
+	case pgproto3.AuthTypeOk:
+		return nil
+	case pgproto3.AuthTypeCleartextPassword:
+		response = &pgproto3.PasswordMessage{Password: c.password}
+	case pgproto3.AuthTypeMD5Password:
+		var request pgproto3.AuthenticationMD5Password
+		if err := request.Decode(body); err != nil {
+			return err //nolint:wrapcheck
+		}
+		secret := c.password
+		if !isMD5Secret(secret) {
+			secret = md5Secret(c.User, c.password)
+		}
+		response = &pgproto3.PasswordMessage{Password: md5Response(secret, request.Salt[:])}
+	case pgproto3.AuthTypeSASL:
+		var request pgproto3.AuthenticationSASL
+		if err := request.Decode(body); err != nil {
+			return err //nolint:wrapcheck
+		}
+		if !slices.Contains(request.AuthMechanisms, SCRAMSHA256) {
+			return fmt.Errorf("unsupported SASL mechanisms: %v", request.AuthMechanisms)
+		}
+		*scram = &scramClient{password: c.password, keys: c.scramKeys}
+		clientFirst, err := (*scram).ClientFirst()
+		if err != nil {
+			return err
+		}
+		response = &pgproto3.SASLInitialResponse{AuthMechanism: SCRAMSHA256, Data: clientFirst}
+	case pgproto3.AuthTypeSASLContinue:
+		if *scram == nil {
+			return errInvalidSCRAMMessage
+		}
+		clientFinal, err := (*scram).ClientFinal(body[4:])
+		if err != nil {
+			return err
+		}
+		response = &pgproto3.SASLResponse{Data: clientFinal}
+	case pgproto3.AuthTypeSASLFinal:
+		if *scram == nil {
+			return errInvalidSCRAMMessage
+		}
+		return (*scram).Verify(body[4:])
+	default:
+		return fmt.Errorf("unsupported authentication type: %d", authType)
+	}
+
+	message, err := response.Encode(nil)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	if _, err := c.conn.Write(message); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return nil
+}
+
+// reset runs the reset query on the server connection, after rolling back the transaction
+// in progress, if rollback is true. It returns an error if the server reports an error or
+// isn't idle afterwards, in which case the server connection must be reconnected. The
+// prepared statements are forgotten, since the reset query is expected to deallocate them.
+// The pending Receive of another goroutine is interrupted first, and the reset is bounded
+// by the dial timeout.
+func (c *Client) reset(query string, rollback bool) error {
+	if err := c.conn.SetReadDeadline(time.Now()); err != nil {
+		return err //nolint:wrapcheck
+	}
+	c.receiveMu.Lock()
+	c.receiveMu.Unlock() //nolint:staticcheck
+
+	deadline := time.Time{}
+	if c.DialTimeout > 0 {
+		deadline = time.Now().Add(c.DialTimeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer func() {
+		if err := c.conn.SetDeadline(time.Time{}); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to reset deadline")
+		}
+	}()
+
+	queries := []string{query}
+	if rollback {
+		queries = []string{"ROLLBACK", query}
+	}
+
+	for _, query := range queries {
+		request, err := (&pgproto3.Query{String: query}).Encode(nil)
+		if err != nil {
+			return gerr.ErrMsgEncodeError.Wrap(err)
+		}
+		if _, err := c.Send(request); err != nil {
+			return err
+		}
+
+		var queryErr error
+		txStatus := byte(0)
+		for txStatus == 0 {
+			_, response, err := c.Receive()
+			if err != nil {
+				return err
+			}
+
+			forEachMessage(response, func(msgType byte, body []byte) bool {
+				switch msgType {
+				case ErrorResponseMessage:
+					var errResponse pgproto3.ErrorResponse
+					if err := errResponse.Decode(body); err != nil {
+						queryErr = err
+					} else {
+						queryErr = fmt.Errorf("%s: %s", errResponse.Severity, errResponse.Message)
+					}
+				case ReadyForQueryMessage:
+					if len(body) > 0 {
+						txStatus = body[0]
+					}
+					return false
+				}
+				return true
+			})
+		}
+
+		if queryErr != nil {
+			return queryErr
+		}
+		if txStatus != TransactionStatusIdle {
+			return fmt.Errorf("the server connection isn't idle after %q", query)
+		}
+	}
+
+	c.resetStatements()
+	return nil
+}
+
+// hasStatement checks if the prepared statement exists on the server connection.
+func (c *Client) hasStatement(name string) bool {
+	c.statementsMu.Lock()
+	defer c.statementsMu.Unlock()
+
+	return c.statements[name]
+}
+
+// setStatement marks the prepared statement as existing or not on the server connection.
+func (c *Client) setStatement(name string, exists bool) {
+	c.statementsMu.Lock()
+	defer c.statementsMu.Unlock()
+
+	if !exists {
+		delete(c.statements, name)
+		return
+	}
+
+	if c.statements == nil {
+		c.statements = make(map[string]bool)
+	}
+	c.statements[name] = true
+}
+
+// resetStatements forgets the prepared statements, e.g. when the server connection is recreated.
+func (c *Client) resetStatements() {
+	c.statementsMu.Lock()
+	defer c.statementsMu.Unlock()
+
+	c.statements = nil
+}
+
 // RemoteAddr returns the remote address of the client safely.
 func (c *Client) RemoteAddr() string {
 	if !c.connected.Load() {
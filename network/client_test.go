@@ -2,6 +2,7 @@ package network
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -129,6 +130,147 @@ func TestReconnect(t *testing.T) {
 	assert.NotEqual(t, localAddr, client.LocalAddr()) // This is a new connection.
 }
 
+// TestClientTLS tests that the server connections are upgraded to TLS and that the
+// server certificates are verified according to the SSL mode.
+func TestClientTLS(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	clientCert, clientKey := ca.issue(t, "gatewayd")
+
+	tests := []struct {
+		name      string
+		useTLS    bool
+		config    config.Client
+		connected bool
+		tls       bool
+	}{
+		{"disable", true, config.Client{SSLMode: config.SSLModeDisable}, true, false},
+		{"prefer", true, config.Client{SSLMode: config.SSLModePrefer}, true, true},
+		{"prefer without TLS", false, config.Client{SSLMode: config.SSLModePrefer}, true, false},
+		{"require", true, config.Client{SSLMode: config.SSLModeRequire}, true, true},
+		{"require without TLS", false, config.Client{SSLMode: config.SSLModeRequire}, false, false},
+		{
+			"require with an untrusted root certificate", true,
+			config.Client{SSLMode: config.SSLModeRequire, SSLRootCert: otherCA.certFile}, false, false,
+		},
+		{
+			"verify-ca", true,
+			config.Client{SSLMode: config.SSLModeVerifyCA, SSLRootCert: ca.certFile}, true, true,
+		},
+		{
+			"verify-ca with an untrusted root certificate", true,
+			config.Client{SSLMode: config.SSLModeVerifyCA, SSLRootCert: otherCA.certFile}, false, false,
+		},
+		{
+			"verify-full with another server name", true,
+			config.Client{SSLMode: config.SSLModeVerifyFull, SSLRootCert: ca.certFile}, false, false,
+		},
+		{
+			"verify-full", true,
+			config.Client{
+				SSLMode: config.SSLModeVerifyFull, SSLRootCert: ca.certFile, SSLServerName: "localhost",
+			}, true, true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backend *FakeBackend
+			if tt.useTLS {
+				backend = NewFakeTLSBackend(t, ca.serverTLSConfig(t, "localhost"))
+			} else {
+				backend = NewFakeBackend(t)
+			}
+
+			clientConfig := tt.config
+			clientConfig.Network = "tcp"
+			clientConfig.Address = backend.Address()
+			clientConfig.ReceiveChunkSize = config.DefaultChunkSize
+			clientConfig.DialTimeout = config.DefaultDialTimeout
+			client := NewClient(context.Background(), &clientConfig, zerolog.Nop(), nil)
+			if !tt.connected {
+				assert.Nil(t, client)
+				assert.Equal(t, 0, backend.TLSConnections())
+				return
+			}
+			require.NotNil(t, client)
+			defer client.Close()
+
+			expected := 0
+			if tt.tls {
+				expected = 1
+			}
+			assert.Eventually(t, func() bool {
+				return backend.TLSConnections() == expected
+			}, time.Second, 10*time.Millisecond)
+			assert.Equal(t, 0, backend.ClientCertificates())
+
+			// The server connection is upgraded to TLS again when it is recreated.
+			require.NoError(t, client.Reconnect())
+			assert.Eventually(t, func() bool {
+				return backend.TLSConnections() == 2*expected
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+
+	t.Run("client certificate", func(t *testing.T) {
+		backend := NewFakeTLSBackend(t, ca.serverTLSConfig(t, "localhost"))
+		client := NewClient(context.Background(), &config.Client{
+			Network:          "tcp",
+			Address:          backend.Address(),
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      config.DefaultDialTimeout,
+			User:             "gatewayd",
+			SSLMode:          config.SSLModeVerifyCA,
+			SSLRootCert:      ca.certFile,
+			SSLCert:          clientCert,
+			SSLKey:           clientKey,
+		}, zerolog.Nop(), nil)
+		require.NotNil(t, client)
+		defer client.Close()
+
+		// The server connection is authenticated over TLS.
+		assert.True(t, client.IsAuthenticated())
+		assert.Equal(t, 1, backend.TLSConnections())
+		assert.Equal(t, 1, backend.ClientCertificates())
+		assert.Equal(t, 1, backend.Logins("gatewayd", ""))
+	})
+}
+
+// TestNewClientTLSConfig tests loading the TLS config of the server connections.
+func TestNewClientTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+
+	tlsConfig, err := NewClientTLSConfig(&config.Client{Address: "localhost:5432"})
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+
+	tlsConfig, err = NewClientTLSConfig(&config.Client{
+		Address: "db.example.com:5432", SSLMode: config.SSLModeVerifyFull, SSLRootCert: ca.certFile,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "db.example.com", tlsConfig.ServerName)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	assert.NotNil(t, tlsConfig.RootCAs)
+
+	tlsConfig, err = NewClientTLSConfig(&config.Client{
+		Address: "db.example.com:5432", SSLMode: config.SSLModeRequire, SSLServerName: "sni.example.com",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "sni.example.com", tlsConfig.ServerName)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	assert.Nil(t, tlsConfig.VerifyConnection)
+
+	for _, invalid := range []config.Client{
+		{SSLMode: "always"},
+		{SSLMode: config.SSLModeVerifyCA, SSLRootCert: filepath.Join(t.TempDir(), "missing.pem")},
+		{SSLMode: config.SSLModeRequire, SSLCert: ca.certFile},
+	} {
+		_, err := NewClientTLSConfig(&invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
 func BenchmarkNewClient(b *testing.B) {
 	cfg := logging.LoggerConfig{
 		Output:            []config.LogOutput{config.Console},
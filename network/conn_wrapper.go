@@ -4,10 +4,16 @@ package network
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgproto3"
 )
 
 // UpgraderFunc is a function that upgrades a connection to TLS.
@@ -27,6 +33,7 @@ type IConnWrapper interface {
 	RemoteAddr() net.Addr
 	LocalAddr() net.Addr
 	IsTLSEnabled() bool
+	StartupParameters() map[string]string
 }
 
 type ConnWrapper struct {
@@ -35,6 +42,29 @@ type ConnWrapper struct {
 	TLSConfig        *tls.Config
 	isTLSEnabled     bool
 	HandshakeTimeout time.Duration
+
+	reader *MessageReader
+	// authenticated is true after the incoming connection is authenticated by the proxy.
+	// It is only accessed by the goroutine that reads from the incoming connection.
+	authenticated bool
+	// startupResponded is true once the server has sent the first ReadyForQuery message
+	// to the incoming connection, after which no BackendKeyData message is expected.
+	// It is only accessed by the goroutine that writes to the incoming connection.
+	startupResponded bool
+	// startup is the StartupMessage that is read by the server for selecting the proxy,
+	// which is returned by the next read of the proxy. startupParameters are its parameters.
+	startup           []byte
+	startupParameters map[string]string
+	// activity tracks the requests and the responses of the incoming connection when the server
+	// connection is dedicated to it, like the sessions of the other pool modes, so that it is only
+	// closed between transactions while the server drains.
+	activity *session
+
+	// sessionMu guards the end of the session of the client, which is ended once, by the first
+	// error of either direction or by the first ErrorResponse, so that only one is sent to it.
+	sessionMu     *sync.Mutex
+	ended         bool
+	errorResponse *ErrorResponse
 }
 
 var _ IConnWrapper = (*ConnWrapper)(nil)
@@ -71,6 +101,8 @@ func (cw *ConnWrapper) UpgradeToTLS(upgrader UpgraderFunc) *gerr.GatewayDError {
 	}
 	cw.tlsConn = tlsConn
 	cw.isTLSEnabled = true
+	// The message reader must read from the TLS connection from now on.
+	cw.reader = nil
 	return nil
 }
 
@@ -98,6 +130,69 @@ func (cw *ConnWrapper) Read(data []byte) (int, error) {
 	return cw.NetConn.Read(data)
 }
 
+// endSession ends the session of the client with the ErrorResponse, if any. It returns false if
+// the session is already ended, in which case the ErrorResponse must not be sent.
+func (cw *ConnWrapper) endSession(response *ErrorResponse) bool {
+	cw.sessionMu.Lock()
+	defer cw.sessionMu.Unlock()
+	if cw.ended {
+		return false
+	}
+	cw.ended = true
+	cw.errorResponse = response
+	return true
+}
+
+// sendErrorResponse ends the session of the client with the ErrorResponse, and sends it to the
+// client unless the session is already ended.
+func (cw *ConnWrapper) sendErrorResponse(response *ErrorResponse) error {
+	if !cw.endSession(response) || response == nil {
+		return nil
+	}
+	_, err := cw.Write(response.encode())
+	return err
+}
+
+// ErrorResponse returns the ErrorResponse that ended the session of the client, if any.
+func (cw *ConnWrapper) ErrorResponse() *ErrorResponse {
+	cw.sessionMu.Lock()
+	defer cw.sessionMu.Unlock()
+	return cw.errorResponse
+}
+
+// ReadMessages reads whole PostgreSQL messages from the connection. The message reader
+// is created lazily, so that it reads from the TLS connection after the upgrade.
+func (cw *ConnWrapper) ReadMessages(bufferSize int) ([]byte, error) {
+	if cw.startup != nil {
+		startup := cw.startup
+		cw.startup = nil
+		return startup, nil
+	}
+	if cw.reader == nil {
+		// Database clients start with untyped startup-phase messages, and
+		// the connection is only upgraded to TLS in the startup phase.
+		cw.reader = NewMessageReader(cw.Conn(), bufferSize, true)
+	}
+	return cw.reader.ReadMessages()
+}
+
+// unreadStartup keeps the StartupMessage that is read before the proxy is selected, so that
+// the proxy reads it again, and records its parameters.
+func (cw *ConnWrapper) unreadStartup(startup []byte) {
+	cw.startup = startup
+
+	var message pgproto3.StartupMessage
+	if isStartupMessage(startup) && message.Decode(startup[UntypedHeaderLength:]) == nil {
+		cw.startupParameters = message.Parameters
+	}
+}
+
+// StartupParameters returns the parameters of the StartupMessage, if it is read
+// before the proxy is selected.
+func (cw *ConnWrapper) StartupParameters() map[string]string {
+	return cw.startupParameters
+}
+
 // RemoteAddr returns the remote address.
 func (cw *ConnWrapper) RemoteAddr() net.Addr {
 	if cw.tlsConn != nil {
@@ -129,21 +224,159 @@ func NewConnWrapper(
 		TLSConfig:        connWrapper.TLSConfig,
 		isTLSEnabled:     connWrapper.TLSConfig != nil && connWrapper.TLSConfig.Certificates != nil,
 		HandshakeTimeout: connWrapper.HandshakeTimeout,
+		sessionMu:        &sync.Mutex{},
 	}
 }
 
-// CreateTLSConfig returns a TLS config from the given cert and key.
-// TODO: Make this more generic and configurable.
-func CreateTLSConfig(certFile, keyFile string) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+// TLSOptions are the options of the TLS config of the incoming connections.
+type TLSOptions struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion string
+	MaxVersion string
+	// CipherSuites are the names of the cipher suites of TLS 1.2 and earlier.
+	// The cipher suites of TLS 1.3 are not configurable.
+	CipherSuites []string
+	// ClientCAFile is the CA bundle for verifying the client certificates.
+	// The system roots are used if it is empty.
+	ClientCAFile string
+	ClientAuth   string
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		config.TLSVersion10: tls.VersionTLS10,
+		config.TLSVersion11: tls.VersionTLS11,
+		config.TLSVersion12: tls.VersionTLS12,
+		config.TLSVersion13: tls.VersionTLS13,
+	}
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		config.ClientAuthNone:    tls.NoClientCert,
+		config.ClientAuthRequest: tls.VerifyClientCertIfGiven,
+		config.ClientAuthRequire: tls.RequireAnyClientCert,
+		config.ClientAuthVerify:  tls.RequireAndVerifyClientCert,
+	}
+)
+
+// CreateTLSConfig returns a TLS config from the given options. The minimum TLS version
+// defaults to TLS 1.3, and the client certificates are verified if they are sent.
+func CreateTLSConfig(options TLSOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
 	if err != nil {
 		return nil, err
 	}
 
-	return &tls.Config{
+	tlsConfig := &tls.Config{
 		MinVersion:               tls.VersionTLS13,
 		Certificates:             []tls.Certificate{cert},
 		ClientAuth:               tls.VerifyClientCertIfGiven,
 		PreferServerCipherSuites: true,
-	}, nil
+	}
+
+	if options.MinVersion != "" {
+		version, ok := tlsVersions[options.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid minimum TLS version: %s", options.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if options.MaxVersion != "" {
+		version, ok := tlsVersions[options.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid maximum TLS version: %s", options.MaxVersion)
+		}
+		tlsConfig.MaxVersion = version
+	}
+
+	if len(options.CipherSuites) > 0 {
+		cipherSuites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			cipherSuites[suite.Name] = suite.ID
+		}
+		for _, name := range options.CipherSuites {
+			id, ok := cipherSuites[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid cipher suite: %s", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+
+	if options.ClientAuth != "" {
+		clientAuth, ok := clientAuthTypes[options.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("invalid client authentication mode: %s", options.ClientAuth)
+		}
+		tlsConfig.ClientAuth = clientAuth
+	}
+
+	if options.ClientCAFile != "" {
+		clientCAs, err := os.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(clientCAs) {
+			return nil, fmt.Errorf("no certificates found in %s", options.ClientCAFile)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// peerCertificate returns the subject fields of the client certificate of the connection,
+// if the connection is upgraded to TLS and the certificate is verified.
+func peerCertificate(conn net.Conn) map[string]interface{} {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+	dnsNames := make([]interface{}, 0, len(cert.DNSNames))
+	for _, name := range cert.DNSNames {
+		dnsNames = append(dnsNames, name)
+	}
+	emailAddresses := make([]interface{}, 0, len(cert.EmailAddresses))
+	for _, address := range cert.EmailAddresses {
+		emailAddresses = append(emailAddresses, address)
+	}
+	ipAddresses := make([]interface{}, 0, len(cert.IPAddresses))
+	for _, address := range cert.IPAddresses {
+		ipAddresses = append(ipAddresses, address.String())
+	}
+	uris := make([]interface{}, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	return map[string]interface{}{
+		"subject":        cert.Subject.String(),
+		"commonName":     cert.Subject.CommonName,
+		"dnsNames":       dnsNames,
+		"emailAddresses": emailAddresses,
+		"ipAddresses":    ipAddresses,
+		"uris":           uris,
+	}
+}
+
+// clientData returns the addresses of the incoming connection for the hooks,
+// and the subject fields of its verified client certificate, if any. The certificate
+// is only verified in the TLS handshake, after the SSLRequest of the client, so the
+// OnOpening, OnOpened and OnTraffic hooks of the server, which run before it, never
+// carry it. The traffic hooks of the proxy and the OnClosing and OnClosed hooks do.
+func clientData(conn net.Conn) map[string]interface{} {
+	data := map[string]interface{}{
+		"local":  LocalAddr(conn),
+		"remote": RemoteAddr(conn),
+	}
+	if certificate := peerCertificate(conn); certificate != nil {
+		data["certificate"] = certificate
+	}
+	return data
 }
@@ -2,6 +2,8 @@ package network
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"net"
 	"testing"
 
@@ -40,10 +42,126 @@ func Test_ConnWrapper_NoTLS(t *testing.T) {
 // Test_ConnWrapper_TLS tests that the CreateTLSConfig function correctly
 // creates a TLS config given a certificate and a private key.
 func Test_CreateTLSConfig(t *testing.T) {
-	tlsConfig, err := CreateTLSConfig(
-		"../cmd/testdata/localhost.crt", "../cmd/testdata/localhost.key")
+	tlsConfig, err := CreateTLSConfig(TLSOptions{
+		CertFile: "../cmd/testdata/localhost.crt",
+		KeyFile:  "../cmd/testdata/localhost.key",
+	})
 	require.NoError(t, err)
 	assert.Equal(t, tlsConfig.ClientAuth, tls.VerifyClientCertIfGiven)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
 	assert.NotEmpty(t, tlsConfig.Certificates[0].Certificate)
 	assert.NotEmpty(t, tlsConfig.Certificates[0].PrivateKey)
+
+	ca := newTestCA(t)
+	tlsConfig, err = CreateTLSConfig(TLSOptions{
+		CertFile:     "../cmd/testdata/localhost.crt",
+		KeyFile:      "../cmd/testdata/localhost.key",
+		MinVersion:   config.TLSVersion12,
+		MaxVersion:   config.TLSVersion12,
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		ClientCAFile: ca.certFile,
+		ClientAuth:   config.ClientAuthVerify,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	for _, invalid := range []TLSOptions{
+		{MinVersion: "1.4"},
+		{CipherSuites: []string{"TLS_NULL"}},
+		{ClientAuth: "always"},
+		{ClientCAFile: "../cmd/testdata/missing.crt"},
+	} {
+		invalid.CertFile = "../cmd/testdata/localhost.crt"
+		invalid.KeyFile = "../cmd/testdata/localhost.key"
+		_, err := CreateTLSConfig(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// Test_ConnWrapper_ClientCertificate tests that the subject fields of the verified client
+// certificates are passed to the hooks, and that the certificates are required in the
+// verify mode.
+func Test_ConnWrapper_ClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost")
+	clientCert, clientKey := ca.issue(t, "service.internal")
+	rootCAs := x509.NewCertPool()
+	rootCAs.AppendCertsFromPEM(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+
+	tests := []struct {
+		name        string
+		clientAuth  string
+		certificate bool
+		connected   bool
+	}{
+		{"verify", config.ClientAuthVerify, true, true},
+		{"verify without a certificate", config.ClientAuthVerify, false, false},
+		{"request without a certificate", config.ClientAuthRequest, false, true},
+		{"none", config.ClientAuthNone, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := CreateTLSConfig(TLSOptions{
+				CertFile:     serverCert,
+				KeyFile:      serverKey,
+				ClientCAFile: ca.certFile,
+				ClientAuth:   tt.clientAuth,
+			})
+			require.NoError(t, err)
+
+			incoming, client := NewTestIncomingConnection(t)
+			serverWrapper := NewConnWrapper(ConnWrapper{
+				NetConn:          incoming.NetConn,
+				TLSConfig:        tlsConfig,
+				HandshakeTimeout: config.DefaultHandshakeTimeout,
+			})
+			defer serverWrapper.Close()
+
+			clientConfig := &tls.Config{
+				MinVersion: tls.VersionTLS13,
+				ServerName: "localhost",
+				RootCAs:    rootCAs,
+			}
+			if tt.certificate {
+				cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+				require.NoError(t, err)
+				clientConfig.Certificates = []tls.Certificate{cert}
+			}
+			tlsClient := tls.Client(client, clientConfig)
+			defer tlsClient.Close()
+			go func() {
+				// The client reads the session tickets and the alerts of the server.
+				if tlsClient.Handshake() == nil {
+					_, _ = tlsClient.Read(make([]byte, 1))
+				}
+			}()
+
+			err = serverWrapper.UpgradeToTLS(nil)
+			if !tt.connected {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+
+			data := clientData(serverWrapper.Conn())
+			assert.Equal(t, LocalAddr(serverWrapper.Conn()), data["local"])
+			if !tt.certificate || tt.clientAuth == config.ClientAuthNone {
+				assert.NotContains(t, data, "certificate")
+				return
+			}
+			assert.Equal(t, map[string]interface{}{
+				"subject":        "CN=service.internal",
+				"commonName":     "service.internal",
+				"dnsNames":       []interface{}{"service.internal"},
+				"emailAddresses": []interface{}{},
+				"ipAddresses":    []interface{}{},
+				"uris":           []interface{}{},
+			}, data["certificate"])
+		})
+	}
 }
@@ -1,83 +1,208 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
-	"sync"
+	"sort"
+	"strconv"
 
+	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/spaolacci/murmur3"
 )
 
+// ringNode is a virtual node of a proxy on the hash ring.
+type ringNode struct {
+	hash  uint64
+	proxy IProxy
+}
+
 // ConsistentHash implements a load balancing strategy based on consistent hashing.
-// It routes client connections to specific proxies by hashing the client's IP address or the full connection address.
+// Each proxy is placed on a hash ring by its virtual nodes, and each client is routed to
+// the proxy of the first virtual node that follows the hash of its key, e.g. its source IP
+// or its user, so that the clients with the same key are routed to the same proxy, and
+// removing a proxy only remaps its share of the keys. The proxies with unhealthy servers,
+// and those that exceed the bounded load, if enabled, are skipped along the ring.
 type ConsistentHash struct {
 	originalStrategy LoadBalancerStrategy
-	useSourceIP      bool
-	hashMap          map[uint64]IProxy
-	mu               sync.Mutex
+	hashKey          string
+	parameter        string
+	loadFactor       float64
+	proxies          []IProxy
+	ring             []ringNode
 }
 
 // NewConsistentHash creates a new ConsistentHash instance. It requires a server configuration and an original
-// load balancing strategy. The consistent hash can use either the source IP or the full connection address
-// as the key for hashing.
+// load balancing strategy. The proxies are placed on the ring by the distribution of the original strategy,
+// if it is weighted, or else evenly. The original strategy selects the proxy of the clients without a key.
 func NewConsistentHash(server *Server, originalStrategy LoadBalancerStrategy) *ConsistentHash {
-	return &ConsistentHash{
-		originalStrategy: originalStrategy,
-		useSourceIP:      server.LoadbalancerConsistentHash.UseSourceIP,
-		hashMap:          make(map[uint64]IProxy),
+	// The clients are routed by their source IP by default, since the source port differs
+	// on every connection of the same client. The deprecated useSourceIp selects the same key.
+	consistentHash := &ConsistentHash{originalStrategy: originalStrategy, hashKey: config.HashKeySourceIP}
+
+	virtualNodes := config.DefaultVirtualNodes
+	if hashConfig := server.LoadbalancerConsistentHash; hashConfig != nil {
+		if hashConfig.HashKey != "" {
+			consistentHash.hashKey = hashConfig.HashKey
+		}
+		if hashConfig.UseSourceIP {
+			server.Logger.Warn().Str("hashKey", consistentHash.hashKey).Msg(
+				"The useSourceIp option of the consistent hash is deprecated, use hashKey instead, " +
+					"which takes precedence and defaults to sourceIp")
+		}
+		consistentHash.parameter = hashConfig.Parameter
+		consistentHash.loadFactor = hashConfig.LoadFactor
+		if hashConfig.VirtualNodes > 0 {
+			virtualNodes = hashConfig.VirtualNodes
+		}
+	}
+
+	// The number of virtual nodes of each proxy is proportional to its weight.
+	weights := map[IProxy]int{}
+	if weighted, ok := originalStrategy.(*WeightedRoundRobin); ok {
+		for _, p := range weighted.proxies {
+			if _, exists := weights[p.proxy]; !exists {
+				consistentHash.proxies = append(consistentHash.proxies, p.proxy)
+			}
+			weights[p.proxy] += p.effectiveWeight
+		}
+	} else {
+		for _, proxy := range server.Proxies {
+			if _, exists := weights[proxy]; !exists {
+				consistentHash.proxies = append(consistentHash.proxies, proxy)
+			}
+			weights[proxy] = 1
+		}
+	}
+
+	totalWeight := 0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	for index, proxy := range consistentHash.proxies {
+		nodes := 1
+		if totalWeight > 0 {
+			nodes = max(1, int(math.Round(
+				float64(virtualNodes*len(consistentHash.proxies)*weights[proxy])/float64(totalWeight))))
+		}
+		// The virtual nodes are placed by the name of the proxy, so that the ring
+		// doesn't depend on the order of the proxies.
+		name := proxy.GetName()
+		if name == "" {
+			name = strconv.Itoa(index)
+		}
+		for node := range nodes {
+			consistentHash.ring = append(consistentHash.ring, ringNode{
+				hash:  hashKey(name + "#" + strconv.Itoa(node)),
+				proxy: proxy,
+			})
+		}
 	}
+	sort.Slice(consistentHash.ring, func(i, j int) bool {
+		return consistentHash.ring[i].hash < consistentHash.ring[j].hash
+	})
+
+	return consistentHash
 }
 
-// NextProxy selects the appropriate proxy for a given client connection. It first tries to find an existing
-// proxy in the hash map based on the hashed key (either the source IP or the full address). If no match is found,
-// it falls back to the original load balancing strategy, adds the selected proxy to the hash map, and returns it.
+// NextProxy selects the proxy of the first virtual node on the ring that follows the hash of the key
+// of the client, skipping the proxies with unhealthy servers and those that exceed the bounded load.
+// If the client has no key, e.g. if it doesn't send the startup parameter, it falls back to the
+// original load balancing strategy.
 func (ch *ConsistentHash) NextProxy(conn IConnWrapper) (IProxy, *gerr.GatewayDError) {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
-
-	var key string
+	if len(ch.ring) == 0 {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("proxy list is empty"))
+	}
 
-	if ch.useSourceIP {
-		sourceIP, err := extractIPFromConn(conn)
+	key, err := ch.key(conn)
+	if err != nil {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(err)
+	}
+	if key == "" {
+		proxy, err := ch.originalStrategy.NextProxy(conn)
 		if err != nil {
 			return nil, gerr.ErrNoProxiesAvailable.Wrap(err)
 		}
-		key = sourceIP
-	} else {
-		key = conn.LocalAddr().String() // Fallback to use full address as the key if `useSourceIp` is false
+		return proxy, nil
 	}
 
+	healthy := healthyProxies(ch.proxies)
+	if len(healthy) == 0 {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errNoHealthyProxies)
+	}
+	capacity := ch.capacity(healthy)
+
 	hash := hashKey(key)
+	start := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= hash })
+	skipped := map[IProxy]bool{}
+	for index := range ch.ring {
+		proxy := ch.ring[(start+index)%len(ch.ring)].proxy
+		if skipped[proxy] {
+			continue
+		}
+		if proxy.IsBackendHealthy() && (capacity == 0 || proxy.BusyConnectionsCount() < capacity) {
+			return proxy, nil
+		}
+		skipped[proxy] = true
+	}
 
-	proxy, exists := ch.hashMap[hash]
+	return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("all the proxies exceed the bounded load"))
+}
 
-	if exists {
-		return proxy, nil
+// key returns the key of the client on the ring, which is empty if the client doesn't have one.
+func (ch *ConsistentHash) key(conn IConnWrapper) (string, error) {
+	switch ch.hashKey {
+	case config.HashKeyUser:
+		return conn.StartupParameters()["user"], nil
+	case config.HashKeyDatabase:
+		// The database defaults to the user name, like in PostgreSQL.
+		parameters := conn.StartupParameters()
+		if database := parameters["database"]; database != "" {
+			return database, nil
+		}
+		return parameters["user"], nil
+	case config.HashKeyParameter:
+		return conn.StartupParameters()[ch.parameter], nil
+	default:
+		return extractIPFromConn(conn)
 	}
+}
 
-	// If no hash exists, fallback to the original strategy
-	proxy, err := ch.originalStrategy.NextProxy(conn)
-	if err != nil {
-		return nil, gerr.ErrNoProxiesAvailable.Wrap(err)
+// capacity returns the maximum number of busy connections of each proxy with the bounded
+// load, that is the load factor times the average including the new connection, or zero
+// if the load isn't bounded.
+func (ch *ConsistentHash) capacity(healthy []IProxy) int {
+	if ch.loadFactor <= 0 {
+		return 0
 	}
 
-	// Add the selected proxy to the hash map for future requests
-	ch.hashMap[hash] = proxy
-
-	return proxy, nil
+	busy := 1
+	for _, proxy := range healthy {
+		busy += proxy.BusyConnectionsCount()
+	}
+	return int(math.Ceil(ch.loadFactor * float64(busy) / float64(len(healthy))))
 }
 
 // hashKey hashes a given key using the MurmurHash3 algorithm. It is used to generate consistent hash values
-// for IP addresses or connection strings.
+// for the keys of the clients and the virtual nodes of the proxies.
 func hashKey(key string) uint64 {
 	return murmur3.Sum64([]byte(key))
 }
 
-// extractIPFromConn extracts the IP address from the connection's local address. It splits the address
-// into IP and port components and returns the IP part. This is useful for hashing based on the source IP.
+// extractIPFromConn extracts the IP address of the client from the connection's remote address. It splits
+// the address into IP and port components and returns the IP part. This is useful for hashing based on
+// the source IP. The clients of the Unix domain sockets have no IP, so it is empty for them.
 func extractIPFromConn(con IConnWrapper) (string, error) {
-	addr := con.LocalAddr().String()
+	remoteAddr := con.RemoteAddr()
+	if remoteAddr == nil {
+		return "", nil
+	}
+	if _, ok := remoteAddr.(*net.UnixAddr); ok {
+		return "", nil
+	}
+	addr := remoteAddr.String()
 	// addr will be in the format "IP:port"
 	ip, _, err := net.SplitHostPort(addr)
 	if err != nil {
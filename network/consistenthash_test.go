@@ -1,19 +1,35 @@
 package network
 
 import (
+	"bytes"
+	"fmt"
 	"net"
 	"sync"
 	"testing"
 
 	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// newHashConn mocks a connection from the given address with the given startup parameters.
+func newHashConn(address string, parameters map[string]string) *MockConnWrapper {
+	addr, _ := net.ResolveTCPAddr("tcp", address)
+	conn := new(MockConnWrapper)
+	conn.On("RemoteAddr").Return(addr).Maybe()
+	conn.On("StartupParameters").Return(parameters).Maybe()
+	return conn
+}
+
 // TestNewConsistentHash verifies that a new ConsistentHash instance is properly created.
 // It checks that the original load balancing strategy is preserved, that the useSourceIp
-// setting is correctly applied, and that the hashMap is initialized.
+// setting selects the source IP as the hash key, and that the ring has the virtual nodes
+// of all the proxies in order.
 func TestNewConsistentHash(t *testing.T) {
 	server := &Server{
+		Proxies:                    []IProxy{MockProxy{name: "proxy1"}, MockProxy{name: "proxy2"}},
 		LoadbalancerConsistentHash: &config.ConsistentHash{UseSourceIP: true},
 	}
 	originalStrategy := NewRandom(server)
@@ -21,82 +37,253 @@ func TestNewConsistentHash(t *testing.T) {
 
 	assert.NotNil(t, consistentHash)
 	assert.Equal(t, originalStrategy, consistentHash.originalStrategy)
-	assert.True(t, consistentHash.useSourceIP)
-	assert.NotNil(t, consistentHash.hashMap)
+	assert.Equal(t, config.HashKeySourceIP, consistentHash.hashKey)
+	assert.Len(t, consistentHash.ring, 2*config.DefaultVirtualNodes)
+	for i := 1; i < len(consistentHash.ring); i++ {
+		assert.LessOrEqual(t, consistentHash.ring[i-1].hash, consistentHash.ring[i].hash)
+	}
+
+	server.LoadbalancerConsistentHash = &config.ConsistentHash{VirtualNodes: 10}
+	assert.Len(t, NewConsistentHash(server, originalStrategy).ring, 20)
 }
 
-// TestConsistentHashNextProxyUseSourceIpExists ensures that when useSourceIp is enabled,
-// and the hashed IP exists in the hashMap, the correct proxy is returned.
-// It mocks a connection with a specific IP and verifies the proxy retrieval from the hashMap.
-func TestConsistentHashNextProxyUseSourceIpExists(t *testing.T) {
-	proxies := []IProxy{
-		MockProxy{name: "proxy1"},
-		MockProxy{name: "proxy2"},
-		MockProxy{name: "proxy3"},
+// TestConsistentHashNextProxyUseSourceIp ensures that when useSourceIp is enabled, the clients
+// are routed by the IP of their remote address, regardless of the port.
+func TestConsistentHashNextProxyUseSourceIp(t *testing.T) {
+	server := &Server{
+		Proxies: []IProxy{
+			MockProxy{name: "proxy1"},
+			MockProxy{name: "proxy2"},
+			MockProxy{name: "proxy3"},
+		},
+		LoadbalancerConsistentHash: &config.ConsistentHash{UseSourceIP: true},
 	}
+	consistentHash := NewConsistentHash(server, NewRandom(server))
+
+	expected, err := consistentHash.NextProxy(newHashConn("192.168.1.1:1234", nil))
+	require.Nil(t, err)
+	for port := range 20 {
+		proxy, err := consistentHash.NextProxy(newHashConn(fmt.Sprintf("192.168.1.1:%d", 2000+port), nil))
+		require.Nil(t, err)
+		assert.Equal(t, expected, proxy)
+	}
+}
+
+// TestConsistentHashUseSourceIpDeprecated ensures that the deprecated useSourceIp maps to the
+// sourceIp hash key with a warning, and that the hashKey takes precedence over it.
+func TestConsistentHashUseSourceIpDeprecated(t *testing.T) {
+	var logs bytes.Buffer
 	server := &Server{
-		Proxies:                    proxies,
+		Proxies:                    []IProxy{MockProxy{name: "proxy1"}, MockProxy{name: "proxy2"}},
+		Logger:                     zerolog.New(&logs),
 		LoadbalancerConsistentHash: &config.ConsistentHash{UseSourceIP: true},
 	}
-	originalStrategy := NewRandom(server)
-	consistentHash := NewConsistentHash(server, originalStrategy)
-	mockConn := new(MockConnWrapper)
+	assert.Equal(t, config.HashKeySourceIP, NewConsistentHash(server, NewRandom(server)).hashKey)
+	assert.Contains(t, logs.String(), "useSourceIp option of the consistent hash is deprecated")
 
-	// Mock LocalAddr to return a specific IP:port format
-	mockAddr := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}
-	mockConn.On("LocalAddr").Return(mockAddr)
+	logs.Reset()
+	server.LoadbalancerConsistentHash = &config.ConsistentHash{UseSourceIP: true, HashKey: config.HashKeyUser}
+	assert.Equal(t, config.HashKeyUser, NewConsistentHash(server, NewRandom(server)).hashKey)
+	assert.Contains(t, logs.String(), "deprecated")
 
-	key := "192.168.1.1"
-	hash := hashKey(key)
+	logs.Reset()
+	server.LoadbalancerConsistentHash = &config.ConsistentHash{HashKey: config.HashKeyUser}
+	assert.Equal(t, config.HashKeyUser, NewConsistentHash(server, NewRandom(server)).hashKey)
+	assert.Empty(t, logs.String())
+}
 
-	consistentHash.hashMap[hash] = proxies[2]
+// TestConsistentHashNextProxyDefaultKey ensures that the clients are routed by their source
+// IP by default, so that the connections from the same IP with different ports are routed to
+// the same proxy, and that the clients of the Unix domain sockets fall back to the strategy.
+func TestConsistentHashNextProxyDefaultKey(t *testing.T) {
+	server := &Server{
+		Proxies: []IProxy{
+			MockProxy{name: "proxy1"},
+			MockProxy{name: "proxy2"},
+			MockProxy{name: "proxy3"},
+		},
+		LoadbalancerConsistentHash: &config.ConsistentHash{UseSourceIP: false},
+	}
+	consistentHash := NewConsistentHash(server, NewRoundRobin(server))
+
+	conn := newHashConn("192.168.1.1:1234", nil)
+	key, err := consistentHash.key(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.1", key)
 
-	proxy, err := consistentHash.NextProxy(mockConn)
-	assert.Nil(t, err)
-	assert.Equal(t, proxies[2], proxy)
+	proxy, nextErr := consistentHash.NextProxy(conn)
+	require.Nil(t, nextErr)
+	other, nextErr := consistentHash.NextProxy(newHashConn("192.168.1.1:5678", nil))
+	require.Nil(t, nextErr)
+	assert.Equal(t, proxy, other)
+	conn.AssertExpectations(t)
 
-	// Clean up
-	mockConn.AssertExpectations(t)
+	unixConn := new(MockConnWrapper)
+	unixConn.On("RemoteAddr").Return(&net.UnixAddr{Name: "@", Net: "unix"})
+	key, err = consistentHash.key(unixConn)
+	require.NoError(t, err)
+	assert.Empty(t, key)
+	proxy, nextErr = consistentHash.NextProxy(unixConn)
+	require.Nil(t, nextErr)
+	assert.NotNil(t, proxy)
 }
 
-// TestConsistentHashNextProxyUseFullAddress verifies the behavior when useSourceIp is disabled.
-// It ensures that the full connection address is used for hashing, and the correct proxy is returned
-// and cached in the hashMap. The test also checks that the hash value is computed based on the full address.
-func TestConsistentHashNextProxyUseFullAddress(t *testing.T) {
-	mockConn := new(MockConnWrapper)
+// TestConsistentHashRemapping tests that the keys are spread across the proxies, and that
+// only the keys of a proxy are remapped when it is removed from the ring or skipped.
+func TestConsistentHashRemapping(t *testing.T) {
 	proxies := []IProxy{
 		MockProxy{name: "proxy1"},
 		MockProxy{name: "proxy2"},
 		MockProxy{name: "proxy3"},
+		MockProxy{name: "proxy4"},
+	}
+	hashConfig := &config.ConsistentHash{HashKey: config.HashKeyUser}
+	server := &Server{Proxies: proxies, LoadbalancerConsistentHash: hashConfig}
+	consistentHash := NewConsistentHash(server, NewRoundRobin(server))
+
+	const keys = 2000
+	conns := make([]*MockConnWrapper, keys)
+	assigned := make([]IProxy, keys)
+	counts := map[string]int{}
+	for index := range keys {
+		conns[index] = newHashConn("10.0.0.1:5000", map[string]string{"user": fmt.Sprintf("tenant%d", index)})
+		proxy, err := consistentHash.NextProxy(conns[index])
+		require.Nil(t, err)
+		assigned[index] = proxy
+		counts[proxy.GetName()]++
+	}
+	for _, proxy := range proxies {
+		assert.InDelta(t, keys/len(proxies), counts[proxy.GetName()], keys/10, proxy.GetName())
 	}
+
+	// The keys of the removed proxy are spread across the others, and the rest stay.
+	server.Proxies = []IProxy{proxies[0], proxies[1], proxies[3]}
+	removed := NewConsistentHash(server, NewRoundRobin(server))
+	// The keys of the unhealthy proxy are moved the same way.
+	server.Proxies = []IProxy{proxies[0], proxies[1], MockProxy{name: "proxy3", unhealthy: true}, proxies[3]}
+	unhealthy := NewConsistentHash(server, NewRoundRobin(server))
+	for index := range keys {
+		proxy, err := removed.NextProxy(conns[index])
+		require.Nil(t, err)
+		if assigned[index] != proxies[2] {
+			assert.Equal(t, assigned[index], proxy)
+		} else {
+			assert.NotEqual(t, proxies[2], proxy)
+		}
+
+		skipped, err := unhealthy.NextProxy(conns[index])
+		require.Nil(t, err)
+		assert.Equal(t, proxy, skipped)
+	}
+}
+
+// TestConsistentHashWeights tests that the proxies of a weighted distribution are placed on
+// the ring by their weights.
+func TestConsistentHashWeights(t *testing.T) {
 	server := &Server{
-		Proxies: proxies,
+		Proxies: []IProxy{MockProxy{name: "proxy1"}, MockProxy{name: "proxy2"}},
 		LoadbalancerConsistentHash: &config.ConsistentHash{
-			UseSourceIP: false,
+			HashKey:   config.HashKeyParameter,
+			Parameter: "application_name",
+		},
+	}
+	rule := config.LoadBalancingRule{
+		Distribution: []config.Distribution{
+			{ProxyName: "proxy1", Weight: 3},
+			{ProxyName: "proxy2", Weight: 1},
 		},
 	}
-	mockStrategy := NewRoundRobin(server)
+	consistentHash := NewConsistentHash(server, NewWeightedRoundRobin(server, rule))
+	assert.Len(t, consistentHash.ring, 2*config.DefaultVirtualNodes)
 
-	// Mock LocalAddr to return full address
-	mockAddr := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}
-	mockConn.On("LocalAddr").Return(mockAddr)
+	counts := map[string]int{}
+	for index := range 2000 {
+		proxy, err := consistentHash.NextProxy(
+			newHashConn("10.0.0.1:5000", map[string]string{"application_name": fmt.Sprintf("app%d", index)}))
+		require.Nil(t, err)
+		counts[proxy.GetName()]++
+	}
+	assert.InDelta(t, 1500, counts["proxy1"], 200)
+	assert.InDelta(t, 500, counts["proxy2"], 200)
+}
 
-	consistentHash := NewConsistentHash(server, mockStrategy)
+// TestConsistentHashStartupKeys tests the keys from the StartupMessage, and that the clients
+// without a key are routed by the original strategy.
+func TestConsistentHashStartupKeys(t *testing.T) {
+	proxies := []IProxy{MockProxy{name: "proxy1"}, MockProxy{name: "proxy2"}, MockProxy{name: "proxy3"}}
+	tests := []struct {
+		hashConfig config.ConsistentHash
+		parameters map[string]string
+		key        string
+	}{
+		{config.ConsistentHash{HashKey: config.HashKeyUser}, map[string]string{"user": "alice"}, "alice"},
+		{
+			config.ConsistentHash{HashKey: config.HashKeyDatabase},
+			map[string]string{"user": "alice", "database": "sales"},
+			"sales",
+		},
+		{config.ConsistentHash{HashKey: config.HashKeyDatabase}, map[string]string{"user": "alice"}, "alice"},
+		{
+			config.ConsistentHash{HashKey: config.HashKeyParameter, Parameter: "options"},
+			map[string]string{"user": "alice", "options": "-c tenant=1"},
+			"-c tenant=1",
+		},
+		{config.ConsistentHash{HashKey: config.HashKeyParameter, Parameter: "options"}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hashConfig.HashKey+"/"+tt.key, func(t *testing.T) {
+			server := &Server{Proxies: proxies, LoadbalancerConsistentHash: &tt.hashConfig}
+			consistentHash := NewConsistentHash(server, NewRoundRobin(server))
+			key, err := consistentHash.key(newHashConn("10.0.0.1:5000", tt.parameters))
+			require.NoError(t, err)
+			assert.Equal(t, tt.key, key)
+		})
+	}
 
-	proxy, err := consistentHash.NextProxy(mockConn)
-	assert.Nil(t, err)
-	assert.NotNil(t, proxy)
-	assert.Equal(t, proxies[1], proxy)
+	// The clients without the parameter are distributed by the round-robin.
+	server := &Server{
+		Proxies:                    proxies,
+		LoadbalancerConsistentHash: &config.ConsistentHash{HashKey: config.HashKeyParameter, Parameter: "tenant"},
+	}
+	consistentHash := NewConsistentHash(server, NewRoundRobin(server))
+	selected := map[string]bool{}
+	for range 3 {
+		proxy, err := consistentHash.NextProxy(newHashConn("10.0.0.1:5000", nil))
+		require.Nil(t, err)
+		selected[proxy.GetName()] = true
+	}
+	assert.Len(t, selected, 3)
+}
 
-	// Hash should be calculated using the full address and cached in hashMap
-	hash := hashKey("192.168.1.1:1234")
-	cachedProxy, exists := consistentHash.hashMap[hash]
+// TestConsistentHashBoundedLoad tests that the clients are moved to the next proxies on the
+// ring while their proxy has more busy connections than the bounded load.
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	hashConfig := &config.ConsistentHash{HashKey: config.HashKeyUser, LoadFactor: 1.25}
+	conn := newHashConn("10.0.0.1:5000", map[string]string{"user": "alice"})
 
-	assert.True(t, exists)
-	assert.Equal(t, proxies[1], cachedProxy)
+	server := &Server{
+		Proxies:                    []IProxy{MockProxy{name: "proxy1"}, MockProxy{name: "proxy2"}},
+		LoadbalancerConsistentHash: hashConfig,
+	}
+	home, err := NewConsistentHash(server, NewRoundRobin(server)).NextProxy(conn)
+	require.Nil(t, err)
+	other := map[string]string{"proxy1": "proxy2", "proxy2": "proxy1"}[home.GetName()]
 
-	// Clean up
-	mockConn.AssertExpectations(t)
+	// The capacity is the load factor times (5 + 3 + 1) / 2, rounded up, that is 6.
+	server.Proxies = []IProxy{MockProxy{name: home.GetName(), busy: 5}, MockProxy{name: other, busy: 3}}
+	proxy, err := NewConsistentHash(server, NewRoundRobin(server)).NextProxy(conn)
+	require.Nil(t, err)
+	assert.Equal(t, home.GetName(), proxy.GetName())
+
+	server.Proxies = []IProxy{MockProxy{name: home.GetName(), busy: 6}, MockProxy{name: other, busy: 2}}
+	proxy, err = NewConsistentHash(server, NewRoundRobin(server)).NextProxy(conn)
+	require.Nil(t, err)
+	assert.Equal(t, other, proxy.GetName())
+
+	server.Proxies = []IProxy{MockProxy{name: home.GetName(), unhealthy: true}, MockProxy{name: other, unhealthy: true}}
+	_, err = NewConsistentHash(server, NewRoundRobin(server)).NextProxy(conn)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
 }
 
 // TestConsistentHashNextProxyConcurrency tests the concurrency safety of the NextProxy method
@@ -104,8 +291,8 @@ func TestConsistentHashNextProxyUseFullAddress(t *testing.T) {
 // NextProxy without causing race conditions or inconsistent behavior.
 func TestConsistentHashNextProxyConcurrency(t *testing.T) {
 	// Setup mocks
-	conn1 := new(MockConnWrapper)
-	conn2 := new(MockConnWrapper)
+	conn1 := newHashConn("192.168.1.1:1234", nil)
+	conn2 := newHashConn("192.168.1.2:1234", nil)
 	proxies := []IProxy{
 		MockProxy{name: "proxy1"},
 		MockProxy{name: "proxy2"},
@@ -117,14 +304,12 @@ func TestConsistentHashNextProxyConcurrency(t *testing.T) {
 	}
 	originalStrategy := NewRoundRobin(server)
 
-	// Mock IP addresses
-	mockAddr1 := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}
-	mockAddr2 := &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 1234}
-	conn1.On("LocalAddr").Return(mockAddr1)
-	conn2.On("LocalAddr").Return(mockAddr2)
-
 	// Initialize the ConsistentHash
 	consistentHash := NewConsistentHash(server, originalStrategy)
+	expected1, err := consistentHash.NextProxy(conn1)
+	require.Nil(t, err)
+	expected2, err := consistentHash.NextProxy(conn2)
+	require.Nil(t, err)
 
 	// Run the test concurrently
 	var waitGroup sync.WaitGroup
@@ -136,19 +321,12 @@ func TestConsistentHashNextProxyConcurrency(t *testing.T) {
 			defer waitGroup.Done()
 			p, err := consistentHash.NextProxy(conn1)
 			assert.Nil(t, err)
-			assert.Equal(t, proxies[1], p)
+			assert.Equal(t, expected1, p)
+			p, err = consistentHash.NextProxy(conn2)
+			assert.Nil(t, err)
+			assert.Equal(t, expected2, p)
 		}()
 	}
 
 	waitGroup.Wait()
-
-	// Ensure that the proxy is consistently the same
-	proxy, err := consistentHash.NextProxy(conn1)
-	assert.Nil(t, err)
-	assert.Equal(t, proxies[1], proxy)
-
-	// Ensure that connecting from a different address returns a different proxy
-	proxy, err = consistentHash.NextProxy(conn2)
-	assert.Nil(t, err)
-	assert.Equal(t, proxies[2], proxy)
 }
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/gatewayd-io/gatewayd-plugin-sdk/databases/postgres"
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"golang.org/x/exp/maps"
+)
+
+// ErrorResponse is the FATAL ErrorResponse that ends the session of a client, so that the client
+// is told why its connection is closed, instead of finding it closed unexpectedly.
+type ErrorResponse struct {
+	// Code is the code of the GatewayD error that ended the session, if any.
+	Code     gerr.ErrCode
+	SQLState string
+	Message  string
+	Detail   string
+}
+
+// DefaultErrorResponses are the SQLSTATEs and the messages of the ErrorResponses of the errors
+// that end the sessions, by the codes of the errors. The unknown code is of the other errors.
+var DefaultErrorResponses = map[gerr.ErrCode]config.ErrorResponse{
+	gerr.ErrCodeUnknown: {
+		SQLState: InternalErrorCode, Message: "the connection is closed due to an internal error",
+	},
+	gerr.ErrCodeNoProxiesAvailable: {
+		SQLState: ConnectionFailureCode, Message: "the server is unavailable",
+	},
+	gerr.ErrCodePoolExhausted: {
+		SQLState: TooManyConnectionsCode, Message: "sorry, too many clients already",
+	},
+	gerr.ErrCodeCircuitOpen: {
+		SQLState: ConnectionFailureCode, Message: "the server is unavailable",
+	},
+	gerr.ErrCodeClientConnectionFailed: {
+		SQLState: ConnectionFailureCode, Message: "could not connect to the server",
+	},
+	gerr.ErrCodeClientReceiveFailed: {
+		SQLState: ConnectionFailureCode, Message: "the connection to the server was lost",
+	},
+	gerr.ErrCodeClientSendFailed: {
+		SQLState: ConnectionFailureCode, Message: "the connection to the server was lost",
+	},
+	gerr.ErrCodeHookTerminatedConnection: {
+		SQLState: AdminShutdownCode, Message: "terminating connection due to a plugin",
+	},
+}
+
+// newErrorResponses returns the ErrorResponses of the errors that end the sessions, where the
+// configured ones, by the names of the errors, override the default ones.
+func newErrorResponses(configured map[string]config.ErrorResponse) map[gerr.ErrCode]config.ErrorResponse {
+	responses := maps.Clone(DefaultErrorResponses)
+	for name, response := range configured {
+		code, ok := gerr.SessionErrCodes[name]
+		if !ok {
+			continue
+		}
+		responses[code] = config.ErrorResponse{
+			SQLState: cmp.Or(response.SQLState, responses[code].SQLState),
+			Message:  cmp.Or(response.Message, responses[code].Message),
+		}
+	}
+	return responses
+}
+
+// newErrorResponse returns the ErrorResponse of the error that ends the session of a client, or
+// nil if the client isn't told, since it closed the connection itself.
+func newErrorResponse(
+	responses map[gerr.ErrCode]config.ErrorResponse, err *gerr.GatewayDError,
+) *ErrorResponse {
+	if err == nil {
+		return nil
+	}
+	switch err.Code {
+	case gerr.ErrCodeClientNotConnected, gerr.ErrCodeReadFailed, gerr.ErrCodeServerSendFailed:
+		return nil
+	}
+
+	response, ok := responses[err.Code]
+	if !ok {
+		response = responses[gerr.ErrCodeUnknown]
+	}
+	return &ErrorResponse{
+		Code:     err.Code,
+		SQLState: response.SQLState,
+		Message:  response.Message,
+		Detail:   errorDetail(err),
+	}
+}
+
+// errorDetail returns the detail of the ErrorResponse of the GatewayD error, which includes its
+// code, but not the original error, since that might reveal the servers to the clients.
+func errorDetail(err *gerr.GatewayDError) string {
+	return fmt.Sprintf("GatewayD error %d: %s", err.Code, err.Message)
+}
+
+// encode returns the ErrorResponse message.
+func (r *ErrorResponse) encode() []byte {
+	return postgres.ErrorResponse(r.Message, "FATAL", r.SQLState, r.Detail)
+}
+
+// data returns the ErrorResponse for the hooks.
+func (r *ErrorResponse) data() map[string]interface{} {
+	return map[string]interface{}{
+		"code":     int(r.Code),
+		"sqlState": r.SQLState,
+		"message":  r.Message,
+		"detail":   r.Detail,
+	}
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_newErrorResponses tests that the configured ErrorResponses override the default ones,
+// and that every error that ends the sessions has a default ErrorResponse.
+func Test_newErrorResponses(t *testing.T) {
+	for name, code := range gerr.SessionErrCodes {
+		response, ok := DefaultErrorResponses[code]
+		require.True(t, ok, name)
+		assert.Len(t, response.SQLState, config.SQLStateLength, name)
+		assert.NotEmpty(t, response.Message, name)
+	}
+
+	responses := newErrorResponses(map[string]config.ErrorResponse{
+		"poolExhausted": {SQLState: "53400"},
+		"circuitOpen":   {Message: "the server is down"},
+		"invalid":       {SQLState: "XX001"},
+	})
+	assert.Equal(t,
+		config.ErrorResponse{SQLState: "53400", Message: "sorry, too many clients already"},
+		responses[gerr.ErrCodePoolExhausted])
+	assert.Equal(t,
+		config.ErrorResponse{SQLState: ConnectionFailureCode, Message: "the server is down"},
+		responses[gerr.ErrCodeCircuitOpen])
+	assert.Len(t, responses, len(DefaultErrorResponses))
+	// The default ones are left as they are.
+	assert.Equal(t, "53300", DefaultErrorResponses[gerr.ErrCodePoolExhausted].SQLState)
+}
+
+func Test_newErrorResponse(t *testing.T) {
+	responses := newErrorResponses(nil)
+
+	assert.Nil(t, newErrorResponse(responses, nil))
+	assert.Nil(t, newErrorResponse(responses, gerr.ErrClientNotConnected))
+	assert.Nil(t, newErrorResponse(responses, gerr.ErrReadFailed.Wrap(net.ErrClosed)))
+
+	response := newErrorResponse(responses, gerr.ErrPoolExhausted)
+	require.NotNil(t, response)
+	assert.Equal(t, gerr.ErrCodePoolExhausted, response.Code)
+	assert.Equal(t, TooManyConnectionsCode, response.SQLState)
+	assert.Equal(t, "GatewayD error "+strconv.Itoa(int(gerr.ErrCodePoolExhausted))+": pool is exhausted",
+		response.Detail)
+
+	response = newErrorResponse(responses, gerr.ErrHookTerminatedConnection)
+	require.NotNil(t, response)
+	assert.Equal(t, AdminShutdownCode, response.SQLState)
+
+	// The original error isn't sent to the client.
+	response = newErrorResponse(responses, gerr.ErrCastFailed.Wrap(errors.New("10.0.0.1:5432")))
+	require.NotNil(t, response)
+	assert.Equal(t, gerr.ErrCodeCastFailed, response.Code)
+	assert.Equal(t, InternalErrorCode, response.SQLState)
+	assert.NotContains(t, response.Detail, "10.0.0.1")
+}
+
+// TestServerErrorResponse tests that the client is sent the configured ErrorResponse once its
+// server connection is lost, instead of finding its connection closed.
+func TestServerErrorResponse(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	lost := config.ErrorResponse{Message: "the server went away"}
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:15432",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+			ErrorResponses: map[string]config.ErrorResponse{
+				"clientReceiveFailed": lost,
+				"clientSendFailed":    lost,
+			},
+		},
+	)
+	require.NotNil(t, server)
+	server.Status = config.Running
+
+	conn, client := NewTestIncomingConnection(t)
+	_, action := server.OnOpen(conn)
+	require.Equal(t, None, action)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.OnTraffic(conn, make(chan struct{}, 2))
+		server.OnClose(conn, nil)
+	}()
+
+	pgConfig, err := pgconn.ParseConfig("postgres://alice@127.0.0.1/postgres?sslmode=disable")
+	require.NoError(t, err)
+	pgConfig.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+		return client, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pgConn, err := pgconn.ConnectConfig(ctx, pgConfig)
+	require.NoError(t, err)
+
+	// The server connection is lost.
+	serverConn, ok := proxy.busyConnections.Get(conn).(*Client)
+	require.True(t, ok)
+	require.NoError(t, serverConn.conn.Close())
+
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, "FATAL", pgErr.Severity)
+	assert.Equal(t, ConnectionFailureCode, pgErr.Code)
+	assert.Equal(t, "the server went away", pgErr.Message)
+	assert.Contains(t, pgErr.Detail, "GatewayD error")
+
+	client.Close()
+	<-done
+	response := conn.ErrorResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, ConnectionFailureCode, response.SQLState)
+	assert.Equal(t, pgErr.Detail, response.Detail)
+
+	// The server connection is recycled before the proxy is shut down.
+	require.Eventually(t, func() bool {
+		return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,338 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// handoffReady is sent by the process that takes over the listeners, once its servers
+	// accept the connections on them.
+	handoffReady byte = 'R'
+	// handoffTimeout is how long the process that takes over the listeners waits for the
+	// process that is upgraded to stop accepting connections.
+	handoffTimeout = 10 * time.Second
+	// maxHandoffListeners is the maximum number of the listeners that are handed off, which
+	// sizes the buffer of the file descriptors.
+	maxHandoffListeners = 128
+	// handoffMessageSize is the size of the buffer of the names of the listeners.
+	handoffMessageSize = 64 * 1024
+	// serverListenerPrefix prefixes the names of the listeners of the servers, so that they are
+	// distinct from the names of the other listeners.
+	serverListenerPrefix = "servers/"
+)
+
+// HandoffServer serves the listeners of the servers on a unix socket to a new GatewayD process,
+// e.g. a new version, that takes them over, in the style of the seamless reloads of nginx and
+// HAProxy. The listeners are sent with SCM_RIGHTS, and once the new process confirms that its
+// servers accept the connections on them, the servers stop accepting connections and OnHandoff
+// is called, e.g. to drain them. The clients are never refused, since the listening sockets are
+// kept open all along.
+//
+// The other listeners, e.g. of the APIs, are handed off along with the ones of the servers, and
+// they are closed once they are taken over.
+type HandoffServer struct {
+	Path      string
+	Servers   map[string]*Server
+	Listeners map[string]net.Listener
+	Logger    zerolog.Logger
+	OnHandoff func()
+
+	ctx      context.Context //nolint:containedctx
+	mu       *sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+// NewHandoffServer creates a new handoff server.
+func NewHandoffServer(ctx context.Context, srv HandoffServer) *HandoffServer {
+	handoffCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewHandoffServer")
+	defer span.End()
+
+	return &HandoffServer{
+		Path:      srv.Path,
+		Servers:   srv.Servers,
+		Listeners: srv.Listeners,
+		Logger:    srv.Logger,
+		OnHandoff: srv.OnHandoff,
+		ctx:       handoffCtx,
+		mu:        &sync.Mutex{},
+	}
+}
+
+// Run listens on the unix socket and blocks until the listeners are handed off or the handoff
+// server is closed. The takeovers that are aborted leave the servers as they are.
+func (h *HandoffServer) Run() *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(h.ctx, "Run handoff server")
+	defer span.End()
+
+	if err := removeStaleSocket(h.Path); err != nil {
+		span.RecordError(err)
+		return gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+
+	listener, err := net.Listen("unix", h.Path)
+	if err != nil {
+		span.RecordError(err)
+		return gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+	// Only the owner of GatewayD may take over its listeners.
+	if err := os.Chmod(h.Path, 0o600); err != nil {
+		h.Logger.Error().Err(err).Str("path", h.Path).Msg("Failed to restrict the upgrade socket")
+		span.RecordError(err)
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		_ = listener.Close()
+		return nil
+	}
+	h.listener = listener
+	h.mu.Unlock()
+	h.Logger.Info().Str("path", h.Path).Msg("Listening for the GatewayD process that takes over")
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if h.isClosed() {
+				return nil
+			}
+			span.RecordError(err)
+			return gerr.ErrListenerHandoffFailed.Wrap(err)
+		}
+		if h.handoff(conn) {
+			return nil
+		}
+	}
+}
+
+// handoff sends the listeners of the servers to the process that takes them over, and returns
+// true once it accepts the connections on them.
+func (h *HandoffServer) handoff(conn net.Conn) bool {
+	_, span := otel.Tracer(config.TracerName).Start(h.ctx, "Handoff")
+	defer span.End()
+	defer conn.Close()
+
+	servers := maps.Keys(h.Servers)
+	slices.Sort(servers)
+	names := make([]string, 0, len(servers)+len(h.Listeners))
+	files := make([]*os.File, 0, len(servers)+len(h.Listeners))
+	handedOff := make([]string, 0, len(servers))
+	for _, name := range servers {
+		file, err := h.Servers[name].ListenerFile()
+		if err != nil {
+			h.Logger.Warn().Err(err).Str("name", name).Msg("The listener of the server isn't handed off")
+			continue
+		}
+		names = append(names, serverListenerPrefix+name)
+		files = append(files, file)
+		handedOff = append(handedOff, name)
+	}
+	listeners := maps.Keys(h.Listeners)
+	slices.Sort(listeners)
+	for _, name := range listeners {
+		file, err := listenerFile(h.Listeners[name])
+		if err != nil {
+			h.Logger.Warn().Err(err).Str("name", name).Msg("The listener isn't handed off")
+			continue
+		}
+		names = append(names, name)
+		files = append(files, file)
+	}
+
+	err := sendListeners(conn, names, files)
+	// The duplicates of the listeners are kept open by the new process.
+	for _, file := range files {
+		_ = file.Close()
+	}
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to hand off the listeners")
+		span.RecordError(err)
+		return false
+	}
+	h.Logger.Info().Strs("listeners", names).Msg(
+		"Handed off the listeners, waiting for the servers of the new process")
+
+	ready := make([]byte, 1)
+	if _, err := io.ReadFull(conn, ready); err != nil || ready[0] != handoffReady {
+		h.Logger.Warn().Err(err).Msg("The takeover is aborted, the servers keep accepting connections")
+		return false
+	}
+
+	for _, name := range handedOff {
+		h.Servers[name].StopAccepting()
+	}
+	for _, name := range listeners {
+		if err := closeListener(h.Listeners[name]); err != nil {
+			h.Logger.Error().Err(err).Str("name", name).Msg("Failed to close the listener that is handed off")
+		}
+	}
+	// The socket is closed before the new process is released, so that it can listen on it.
+	h.Close()
+	_ = conn.Close()
+	h.Logger.Info().Msg("The listeners are taken over by the new process")
+
+	if h.OnHandoff != nil {
+		h.OnHandoff()
+	}
+	return true
+}
+
+// Close stops listening on the unix socket, which is removed.
+func (h *HandoffServer) Close() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	if h.listener != nil {
+		if err := h.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			h.Logger.Error().Err(err).Msg("Failed to close the upgrade socket")
+		}
+	}
+}
+
+// isClosed returns true if the handoff server is closed.
+func (h *HandoffServer) isClosed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+// listenerFile returns a duplicate of the file descriptor of the listener.
+func listenerFile(listener net.Listener) (*os.File, error) {
+	filer, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("the listener has no file descriptor")
+	}
+	return filer.File()
+}
+
+// closeListener closes the listener that is handed off, whose socket is kept open by the
+// process that took it over.
+func closeListener(listener net.Listener) error {
+	// The socket file is used by the listener that is taken over.
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+	return listener.Close()
+}
+
+// removeStaleSocket removes the unix socket that is left by a GatewayD process that didn't
+// shut down gracefully. The socket of a running process is kept.
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("another GatewayD process listens on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// Takeover holds the listeners of a running GatewayD process, which are taken over by their
+// names, and by the names of the servers for the listeners of the servers.
+type Takeover struct {
+	Listeners map[string]net.Listener
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// TakeOver connects to the unix socket of the handoff server of a running GatewayD process and
+// receives its listeners. The process keeps accepting connections until Ready
+// is called.
+func TakeOver(path string) (*Takeover, *gerr.GatewayDError) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+
+	listeners, err := receiveListeners(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+
+	return &Takeover{Listeners: listeners, conn: conn}, nil
+}
+
+// ServerListener returns the listener of the server of the given name, if it is taken over.
+func (t *Takeover) ServerListener(name string) net.Listener {
+	return t.Listener(serverListenerPrefix + name)
+}
+
+// Listener returns the listener of the given name, if it is taken over.
+func (t *Takeover) Listener(name string) net.Listener {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	listener, ok := t.Listeners[name]
+	if !ok {
+		return nil
+	}
+	// The listeners that aren't used, e.g. of the servers that are removed, are closed once
+	// the takeover is ready.
+	delete(t.Listeners, name)
+	return listener
+}
+
+// Ready tells the process that is taken over that the servers accept the connections on the
+// listeners, and waits until it stops accepting connections and closes its unix socket. The
+// listeners that are left, e.g. since their servers are removed, are closed.
+func (t *Takeover) Ready() *gerr.GatewayDError {
+	defer t.conn.Close()
+
+	t.mu.Lock()
+	for name, listener := range t.Listeners {
+		_ = listener.Close()
+		delete(t.Listeners, name)
+	}
+	t.mu.Unlock()
+
+	if err := t.conn.SetDeadline(time.Now().Add(handoffTimeout)); err != nil {
+		return gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+	if _, err := t.conn.Write([]byte{handoffReady}); err != nil {
+		return gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+	if _, err := io.Copy(io.Discard, t.conn); err != nil {
+		return gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+	return nil
+}
+
+// Close aborts the takeover, so that the process that is taken over keeps its listeners.
+// The listeners that are taken over are closed.
+func (t *Takeover) Close() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, listener := range t.Listeners {
+		_ = listener.Close()
+	}
+	_ = t.conn.Close()
+}
@@ -0,0 +1,153 @@
+//go:build !windows
+// +build !windows
+
+package network
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHandoff tests that the listener of a server is taken over by another server, which
+// accepts the new clients once it is ready, while the clients of the server that hands it off
+// are still served, and that the aborted takeovers leave the server as it is.
+func TestHandoff(t *testing.T) {
+	backend := NewFakeBackend(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	run := func(listener net.Listener) (*Server, string, chan struct{}) {
+		proxy := newTestPooledProxy(t, backend, 2, config.SessionPoolMode)
+		server := NewServer(context.Background(), Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+			Listener:                 listener,
+		})
+		require.NotNil(t, server)
+
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			assert.Nil(t, server.Run())
+		}()
+		var address string
+		require.Eventually(t, func() bool {
+			server.mu.RLock()
+			defer server.mu.RUnlock()
+			if server.listener != nil {
+				address = server.listener.Addr().String()
+			}
+			return address != "" && server.running.Load()
+		}, 5*time.Second, 10*time.Millisecond)
+		return server, address, stopped
+	}
+	var clients []*pgconn.PgConn
+	connect := func(address string) *pgconn.PgConn {
+		conn, err := pgconn.Connect(ctx, "postgres://alice@"+address+"/postgres?sslmode=disable")
+		require.NoError(t, err)
+		clients = append(clients, conn)
+		return conn
+	}
+
+	old, address, oldStopped := run(nil)
+	oldClient := connect(address)
+
+	api, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer api.Close()
+
+	path := filepath.Join(t.TempDir(), "upgrade.sock")
+	handedOff := make(chan struct{})
+	handoffServer := NewHandoffServer(context.Background(), HandoffServer{
+		Path:      path,
+		Servers:   map[string]*Server{config.Default: old},
+		Listeners: map[string]net.Listener{"api": api},
+		Logger:    zerolog.Nop(),
+		OnHandoff: func() { close(handedOff) },
+	})
+	handoffStopped := make(chan struct{})
+	go func() {
+		defer close(handoffStopped)
+		assert.Nil(t, handoffServer.Run())
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The aborted takeover leaves the server accepting the connections.
+	takeover, takeoverErr := TakeOver(path)
+	require.Nil(t, takeoverErr)
+	require.Contains(t, takeover.Listeners, serverListenerPrefix+config.Default)
+	takeover.Close()
+	connect(address)
+	assert.Eventually(t, func() bool { return old.CountConnections() == 2 },
+		5*time.Second, 10*time.Millisecond)
+
+	takeover, takeoverErr = TakeOver(path)
+	require.Nil(t, takeoverErr)
+	require.Len(t, takeover.Listeners, 2)
+	assert.Equal(t, address, takeover.Listeners[serverListenerPrefix+config.Default].Addr().String())
+
+	next, nextAddress, nextStopped := run(takeover.ServerListener(config.Default))
+	assert.Equal(t, address, nextAddress)
+	assert.Nil(t, takeover.ServerListener("removed"))
+	nextAPI := takeover.Listener("api")
+	require.NotNil(t, nextAPI)
+	defer nextAPI.Close()
+	assert.Equal(t, api.Addr().String(), nextAPI.Addr().String())
+	require.Nil(t, takeover.Ready())
+	for _, done := range []chan struct{}{handedOff, oldStopped, handoffStopped} {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the listener isn't handed off")
+		}
+	}
+	assert.NoFileExists(t, path)
+	_, err = api.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+
+	// The new clients are accepted by the server that took over the listener, while the
+	// clients of the server that handed it off are still served.
+	_, err = connect(address).Exec(ctx, "SELECT 1").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, 1, next.CountConnections())
+	_, err = oldClient.Exec(ctx, "SELECT 1").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, 2, old.CountConnections())
+
+	// The server connections are recycled before the proxies are shut down by the cleanup,
+	// so only the listener is closed.
+	for _, client := range clients {
+		require.NoError(t, client.Close(ctx))
+	}
+	for _, server := range []*Server{old, next} {
+		proxy, ok := server.Proxies[0].(*Proxy)
+		require.True(t, ok)
+		require.Eventually(t, func() bool {
+			return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 2
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	next.running.Store(false)
+	next.mu.RLock()
+	require.NoError(t, next.listener.Close())
+	next.mu.RUnlock()
+	<-nextStopped
+}
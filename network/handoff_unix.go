@@ -0,0 +1,105 @@
+//go:build !windows
+// +build !windows
+
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendListeners sends the file descriptors of the listeners with SCM_RIGHTS, along with their
+// names in the same order.
+func sendListeners(conn net.Conn, names []string, files []*os.File) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("the listeners are only handed off over unix sockets")
+	}
+
+	payload, err := json.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("failed to encode the names of the listeners: %w", err)
+	}
+
+	var rights []byte
+	if len(files) > 0 {
+		fds := make([]int, 0, len(files))
+		for _, file := range files {
+			fds = append(fds, int(file.Fd()))
+		}
+		rights = syscall.UnixRights(fds...)
+	}
+
+	_, _, err = unixConn.WriteMsgUnix(payload, rights, nil)
+	return err
+}
+
+// receiveListeners receives the listeners that are sent by sendListeners by their names.
+func receiveListeners(conn net.Conn) (map[string]net.Listener, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("the listeners are only handed off over unix sockets")
+	}
+
+	payload := make([]byte, handoffMessageSize)
+	oob := make([]byte, syscall.CmsgSpace(maxHandoffListeners*4)) //nolint:mnd
+	received, oobReceived, flags, _, err := unixConn.ReadMsgUnix(payload, oob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive the listeners: %w", err)
+	}
+
+	var fds []int
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobReceived])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the listeners: %w", err)
+	}
+	for i := range messages {
+		rights, err := syscall.ParseUnixRights(&messages[i])
+		if err != nil {
+			closeFds(fds)
+			return nil, fmt.Errorf("failed to parse the listeners: %w", err)
+		}
+		fds = append(fds, rights...)
+	}
+
+	var names []string
+	if flags&syscall.MSG_CTRUNC != 0 {
+		err = fmt.Errorf("more than %d listeners are handed off", maxHandoffListeners)
+	} else if err = json.Unmarshal(payload[:received], &names); err != nil {
+		err = fmt.Errorf("failed to decode the names of the listeners: %w", err)
+	} else if len(names) != len(fds) {
+		err = fmt.Errorf("received %d listeners for %d names", len(fds), len(names))
+	}
+	if err != nil {
+		closeFds(fds)
+		return nil, err
+	}
+
+	listeners := make(map[string]net.Listener, len(names))
+	for i, name := range names {
+		// The listener gets its own duplicate of the file descriptor.
+		file := os.NewFile(uintptr(fds[i]), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			closeFds(fds[i+1:])
+			for _, listener := range listeners {
+				_ = listener.Close()
+			}
+			return nil, fmt.Errorf("failed to take over the listener of %s: %w", name, err)
+		}
+		listeners[name] = listener
+	}
+	return listeners, nil
+}
+
+// closeFds closes the file descriptors that aren't taken over.
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		_ = syscall.Close(fd)
+	}
+}
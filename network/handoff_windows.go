@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package network
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// errHandoffNotSupported is returned on Windows, which can't pass the listeners to
+// other processes over unix sockets.
+var errHandoffNotSupported = errors.New("the listeners can't be handed off on Windows")
+
+// sendListeners isn't supported on Windows.
+func sendListeners(net.Conn, []string, []*os.File) error {
+	return errHandoffNotSupported
+}
+
+// receiveListeners isn't supported on Windows.
+func receiveListeners(net.Conn) (map[string]net.Listener, error) {
+	return nil, errHandoffNotSupported
+}
@@ -0,0 +1,270 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/metrics"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+// HealthCheck actively probes the server of a proxy, either by dialing it, by sending an
+// SSLRequest, or by running SELECT 1 on an authenticated server connection. The proxy is
+// marked as unhealthy after UnhealthyThreshold consecutive failed probes, and as healthy
+// again after HealthyThreshold consecutive successful probes. The load balancers skip the
+// proxies that are unhealthy.
+type HealthCheck struct {
+	// GroupName and ProxyName label the metrics of the health check.
+	GroupName          string
+	ProxyName          string
+	Method             string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// ClientConfig is used for connecting to the server, and for authenticating
+	// the server connection of the query probe.
+	ClientConfig *config.Client
+	Logger       zerolog.Logger
+
+	ctx    context.Context //nolint:containedctx
+	mu     *sync.Mutex
+	status HealthStatus
+}
+
+// HealthStatus is the outcome of the health checks of the server of a proxy.
+type HealthStatus struct {
+	Healthy bool
+	// ConsecutiveFailures and ConsecutiveSuccesses count the probes with the same outcome.
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	LastCheck            time.Time
+	LastError            string
+}
+
+// NewHealthCheck creates a new health check. The server is considered healthy until
+// it fails the probes.
+func NewHealthCheck(ctx context.Context, hc HealthCheck) *HealthCheck {
+	healthCheckCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewHealthCheck")
+	defer span.End()
+
+	healthCheck := &HealthCheck{
+		GroupName: hc.GroupName,
+		ProxyName: hc.ProxyName,
+		Method:    config.If(hc.Method != "", hc.Method, config.DefaultHealthCheckMethod),
+		Interval: config.If(
+			hc.Interval > 0, hc.Interval, config.DefaultHealthCheckInterval),
+		Timeout: config.If(
+			hc.Timeout > 0, hc.Timeout, config.DefaultHealthCheckTimeout),
+		HealthyThreshold: config.If(
+			hc.HealthyThreshold > 0, hc.HealthyThreshold, config.DefaultHealthyThreshold),
+		UnhealthyThreshold: config.If(
+			hc.UnhealthyThreshold > 0, hc.UnhealthyThreshold, config.DefaultUnhealthyThreshold),
+		ClientConfig: hc.ClientConfig,
+		Logger:       hc.Logger,
+		ctx:          healthCheckCtx,
+		mu:           &sync.Mutex{},
+		status:       HealthStatus{Healthy: true},
+	}
+	metrics.ProxyBackendHealthy.WithLabelValues(healthCheck.GroupName, healthCheck.ProxyName).Set(1)
+
+	return healthCheck
+}
+
+// IsHealthy returns false if the server has failed the last UnhealthyThreshold probes, and
+// hasn't passed HealthyThreshold probes since.
+func (h *HealthCheck) IsHealthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.status.Healthy
+}
+
+// Status returns the outcome of the health checks.
+func (h *HealthCheck) Status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.status
+}
+
+// Check probes the server once, and updates the health of the proxy.
+func (h *HealthCheck) Check() {
+	_, span := otel.Tracer(config.TracerName).Start(h.ctx, "HealthCheck")
+	defer span.End()
+
+	err := h.probe()
+	if err != nil {
+		span.RecordError(err)
+		metrics.ProxyBackendHealthCheckFailures.WithLabelValues(
+			h.GroupName, h.ProxyName, h.Method).Inc()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	wasHealthy := h.status.Healthy
+	h.status.LastCheck = time.Now()
+	if err == nil {
+		h.status.ConsecutiveSuccesses++
+		h.status.ConsecutiveFailures = 0
+		h.status.LastError = ""
+		if h.status.ConsecutiveSuccesses >= h.HealthyThreshold {
+			h.status.Healthy = true
+		}
+	} else {
+		h.status.ConsecutiveFailures++
+		h.status.ConsecutiveSuccesses = 0
+		h.status.LastError = err.Error()
+		if h.status.ConsecutiveFailures >= h.UnhealthyThreshold {
+			h.status.Healthy = false
+		}
+	}
+
+	if wasHealthy == h.status.Healthy {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"group":   h.GroupName,
+		"proxy":   h.ProxyName,
+		"method":  h.Method,
+		"address": h.ClientConfig.Address,
+	}
+	if h.status.Healthy {
+		metrics.ProxyBackendHealthy.WithLabelValues(h.GroupName, h.ProxyName).Set(1)
+		h.Logger.Info().Fields(fields).Msg("The server of the proxy is healthy again")
+	} else {
+		metrics.ProxyBackendHealthy.WithLabelValues(h.GroupName, h.ProxyName).Set(0)
+		h.Logger.Error().Err(err).Fields(fields).Msg(
+			"The server of the proxy is unhealthy and is skipped by the load balancer")
+	}
+}
+
+// probe probes the server by the method of the health check.
+func (h *HealthCheck) probe() error {
+	switch h.Method {
+	case config.HealthCheckTCP:
+		conn, err := h.dial()
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case config.HealthCheckSSL:
+		return h.probeSSL()
+	case config.HealthCheckQuery:
+		return h.probeQuery()
+	default:
+		return nil
+	}
+}
+
+// dial connects to the server within the timeout, and sends the PROXY protocol header
+// with the LOCAL command, if enabled.
+func (h *HealthCheck) dial() (net.Conn, error) {
+	dialer := net.Dialer{Timeout: h.Timeout}
+	conn, err := dialer.DialContext(h.ctx, h.ClientConfig.Network, h.ClientConfig.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial the server: %w", err)
+	}
+	if sendsProxyProtocol(h.ClientConfig.ProxyProtocol) {
+		if _, err := conn.Write(encodeProxyProtocolHeader(h.ClientConfig.ProxyProtocol, nil, nil)); err != nil {
+			conn.Close()
+			return nil, gerr.ErrProxyProtocolFailed.Wrap(err)
+		}
+	}
+	return conn, nil
+}
+
+// probeSSL sends an SSLRequest to the server, which must answer it with either S or N.
+func (h *HealthCheck) probeSSL() error {
+	conn, err := h.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(h.Timeout)); err != nil {
+		return fmt.Errorf("failed to set the deadline: %w", err)
+	}
+
+	request, err := (&pgproto3.SSLRequest{}).Encode(nil)
+	if err != nil {
+		return gerr.ErrMsgEncodeError.Wrap(err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed to send the SSL request: %w", err)
+	}
+
+	response := make([]byte, 1)
+	if _, err := conn.Read(response); err != nil {
+		return fmt.Errorf("failed to receive the answer to the SSL request: %w", err)
+	}
+	if response[0] != 'S' && response[0] != 'N' {
+		return fmt.Errorf("unexpected answer to the SSL request: %q", response[0])
+	}
+	return nil
+}
+
+// probeQuery runs SELECT 1 on a new server connection, which is authenticated with the
+// credentials of the client configuration.
+func (h *HealthCheck) probeQuery() error {
+	clientConfig := *h.ClientConfig
+	clientConfig.DialTimeout = h.Timeout
+	clientConfig.ReceiveDeadline = h.Timeout
+	clientConfig.SendDeadline = h.Timeout
+	if clientConfig.User == "" {
+		return errors.New("the query health check requires a user")
+	}
+
+	// The failures are reported by the health check.
+	client := NewClient(h.ctx, &clientConfig, zerolog.Nop(), nil)
+	if client == nil {
+		return errors.New("failed to connect to the server or to authenticate")
+	}
+	defer client.Close()
+
+	query, err := (&pgproto3.Query{String: "SELECT 1"}).Encode(nil)
+	if err != nil {
+		return gerr.ErrMsgEncodeError.Wrap(err)
+	}
+	if _, err := client.Send(query); err != nil {
+		return err
+	}
+
+	for {
+		_, response, err := client.Receive()
+		if err != nil {
+			return err
+		}
+
+		var queryErr error
+		ready := false
+		forEachMessage(response, func(msgType byte, body []byte) bool {
+			switch msgType {
+			case ErrorResponseMessage:
+				var errResponse pgproto3.ErrorResponse
+				if err := errResponse.Decode(body); err != nil {
+					queryErr = err
+				} else {
+					queryErr = fmt.Errorf("%s: %s", errResponse.Severity, errResponse.Message)
+				}
+			case ReadyForQueryMessage:
+				ready = true
+			}
+			return !ready
+		})
+
+		if ready || queryErr != nil {
+			return queryErr
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closedAddress returns the address of a local port on which nothing listens.
+func closedAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
+
+// TestHealthCheckThresholds tests that the server is marked as unhealthy after the consecutive
+// failed probes, and as healthy again after the consecutive successful probes.
+func TestHealthCheckThresholds(t *testing.T) {
+	for _, method := range []string{config.HealthCheckTCP, config.HealthCheckSSL} {
+		t.Run(method, func(t *testing.T) {
+			backend := NewFakeBackend(t)
+			clientConfig := &config.Client{Network: "tcp", Address: backend.Address()}
+			healthCheck := NewHealthCheck(context.Background(), HealthCheck{
+				GroupName:          config.Default,
+				ProxyName:          method,
+				Method:             method,
+				Timeout:            time.Second,
+				HealthyThreshold:   2,
+				UnhealthyThreshold: 2,
+				ClientConfig:       clientConfig,
+				Logger:             zerolog.Nop(),
+			})
+			assert.True(t, healthCheck.IsHealthy())
+
+			healthCheck.Check()
+			status := healthCheck.Status()
+			assert.True(t, status.Healthy)
+			assert.Equal(t, 1, status.ConsecutiveSuccesses)
+			assert.False(t, status.LastCheck.IsZero())
+
+			clientConfig.Address = closedAddress(t)
+			healthCheck.Check()
+			assert.True(t, healthCheck.IsHealthy())
+			healthCheck.Check()
+			status = healthCheck.Status()
+			assert.False(t, status.Healthy)
+			assert.Equal(t, 2, status.ConsecutiveFailures)
+			assert.NotEmpty(t, status.LastError)
+
+			clientConfig.Address = backend.Address()
+			healthCheck.Check()
+			assert.False(t, healthCheck.IsHealthy())
+			healthCheck.Check()
+			status = healthCheck.Status()
+			assert.True(t, status.Healthy)
+			assert.Empty(t, status.LastError)
+		})
+	}
+}
+
+// TestHealthCheckQuery tests that the query probe authenticates with the credentials of the
+// client configuration and runs the query.
+func TestHealthCheckQuery(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "backend-password", nil)
+	clientConfig := &config.Client{
+		Network:  "tcp",
+		Address:  backend.Address(),
+		User:     "gatewayd",
+		Password: "backend-password",
+	}
+	healthCheck := NewHealthCheck(context.Background(), HealthCheck{
+		Method:             config.HealthCheckQuery,
+		UnhealthyThreshold: 1,
+		ClientConfig:       clientConfig,
+		Logger:             zerolog.Nop(),
+	})
+	assert.Equal(t, config.DefaultHealthCheckTimeout, healthCheck.Timeout)
+	assert.Equal(t, config.DefaultHealthyThreshold, healthCheck.HealthyThreshold)
+
+	healthCheck.Check()
+	assert.True(t, healthCheck.IsHealthy())
+	assert.Empty(t, healthCheck.Status().LastError)
+	assert.Equal(t, 1, backend.Executed("SELECT 1"))
+
+	clientConfig.Password = "wrong-password"
+	healthCheck.Check()
+	assert.False(t, healthCheck.IsHealthy())
+	assert.NotEmpty(t, healthCheck.Status().LastError)
+}
+
+// TestProxyHealthCheck tests that the proxy runs the health checks of its server periodically.
+func TestProxyHealthCheck(t *testing.T) {
+	healthCheck := NewHealthCheck(context.Background(), HealthCheck{
+		Method:             config.HealthCheckTCP,
+		Interval:           10 * time.Millisecond,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 1,
+		ClientConfig:       &config.Client{Network: "tcp", Address: closedAddress(t)},
+		Logger:             zerolog.Nop(),
+	})
+	proxy := NewProxy(
+		context.Background(),
+		Proxy{
+			AvailableConnections: pool.NewPool(context.Background(), config.EmptyPoolCapacity),
+			HealthCheckPeriod:    config.DefaultHealthCheckPeriod,
+			HealthCheck:          healthCheck,
+			Logger:               zerolog.Nop(),
+		},
+	)
+	defer proxy.Shutdown()
+
+	assert.Eventually(t, func() bool { return !proxy.IsBackendHealthy() }, 5*time.Second, 10*time.Millisecond)
+
+	// The proxy is healthy if its server isn't probed.
+	assert.True(t, (&Proxy{}).IsBackendHealthy())
+}
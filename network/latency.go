@@ -0,0 +1,102 @@
+package network
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// latencyDecay is the time constant of the moving average of the round-trip latency.
+// The weight of a sample is halved after about 0.7 times the decay, and the average
+// decays towards zero while the proxy receives no requests, so that it is tried again.
+const latencyDecay = 10 * time.Second
+
+// latency tracks the round-trip latency of the requests of the incoming connections,
+// that is the time between sending a request to the server and receiving the first
+// response, as an exponentially weighted moving average over time.
+type latency struct {
+	mu      sync.Mutex
+	pending map[*ConnWrapper]time.Time
+	average float64 // In nanoseconds.
+	updated time.Time
+}
+
+// newLatency creates a new latency tracker.
+func newLatency() *latency {
+	return &latency{pending: make(map[*ConnWrapper]time.Time)}
+}
+
+// sent records the time of a request of the incoming connection, unless an earlier
+// request is still waiting for its response.
+func (l *latency) sent(conn *ConnWrapper) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.pending[conn]; !ok {
+		l.pending[conn] = time.Now()
+	}
+}
+
+// received adds the round-trip latency of the pending request of the incoming connection
+// to the moving average.
+func (l *latency) received(conn *ConnWrapper) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sent, ok := l.pending[conn]
+	if !ok {
+		return
+	}
+	delete(l.pending, conn)
+
+	now := time.Now()
+	l.observe(now.Sub(sent), now)
+}
+
+// forget drops the pending request of the incoming connection.
+func (l *latency) forget(conn *ConnWrapper) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.pending, conn)
+}
+
+// observe adds a sample to the moving average. The weight of the previous average
+// decreases with the time since it was last updated.
+func (l *latency) observe(sample time.Duration, now time.Time) {
+	if l.updated.IsZero() {
+		l.average = float64(sample)
+	} else {
+		weight := math.Exp(-float64(now.Sub(l.updated)) / float64(latencyDecay))
+		l.average = l.average*weight + float64(sample)*(1-weight)
+	}
+	l.updated = now
+}
+
+// value returns the moving average, which decays since the last sample.
+func (l *latency) value() time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.updated.IsZero() {
+		return 0
+	}
+	weight := math.Exp(-float64(time.Since(l.updated)) / float64(latencyDecay))
+	return time.Duration(l.average * weight)
+}
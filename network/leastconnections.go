@@ -0,0 +1,49 @@
+package network
+
+import (
+	"errors"
+	"sync/atomic"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// LeastConnections selects the proxy with the fewest busy connections, so that the long
+// sessions of some clients don't pile up on the same server. The ties are broken in the
+// round-robin order.
+type LeastConnections struct {
+	proxies []IProxy
+	next    atomic.Uint32
+}
+
+// NewLeastConnections creates a new LeastConnections instance with the given server's proxies.
+func NewLeastConnections(server *Server) *LeastConnections {
+	return &LeastConnections{proxies: server.Proxies}
+}
+
+// NextProxy returns the proxy with the fewest busy connections.
+func (l *LeastConnections) NextProxy(_ IConnWrapper) (IProxy, *gerr.GatewayDError) {
+	proxiesLen := len(l.proxies)
+	if proxiesLen == 0 {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("proxy list is empty"))
+	}
+
+	// The proxies with unhealthy servers are skipped.
+	start := int(l.next.Add(1) % uint32(proxiesLen))
+	var selected IProxy
+	fewest := 0
+	for index := range proxiesLen {
+		proxy := l.proxies[(start+index)%proxiesLen]
+		if !proxy.IsBackendHealthy() {
+			continue
+		}
+		if busy := proxy.BusyConnectionsCount(); selected == nil || busy < fewest {
+			selected = proxy
+			fewest = busy
+		}
+	}
+	if selected == nil {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errNoHealthyProxies)
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"testing"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLeastConnections tests that the proxy with the fewest busy connections is selected,
+// and that the proxies with unhealthy servers are skipped.
+func TestLeastConnections(t *testing.T) {
+	server := &Server{
+		Proxies: []IProxy{
+			MockProxy{name: "proxy1", busy: 5},
+			MockProxy{name: "proxy2", busy: 1},
+			MockProxy{name: "proxy3", busy: 0, unhealthy: true},
+			MockProxy{name: "proxy4", busy: 3},
+		},
+	}
+	leastConnections := NewLeastConnections(server)
+
+	for range 5 {
+		proxy, err := leastConnections.NextProxy(nil)
+		require.Nil(t, err)
+		assert.Equal(t, "proxy2", proxy.GetName())
+	}
+
+	server.Proxies[1] = MockProxy{name: "proxy2", unhealthy: true}
+	proxy, err := leastConnections.NextProxy(nil)
+	require.Nil(t, err)
+	assert.Equal(t, "proxy4", proxy.GetName())
+
+	_, err = NewLeastConnections(&Server{}).NextProxy(nil)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+
+	_, err = NewLeastConnections(&Server{Proxies: []IProxy{MockProxy{unhealthy: true}}}).NextProxy(nil)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+}
+
+// TestLeastConnectionsTies tests that the ties are broken in the round-robin order.
+func TestLeastConnectionsTies(t *testing.T) {
+	server := &Server{
+		Proxies: []IProxy{
+			MockProxy{name: "proxy1", busy: 2},
+			MockProxy{name: "proxy2", busy: 2},
+			MockProxy{name: "proxy3", busy: 2},
+		},
+	}
+	leastConnections := NewLeastConnections(server)
+
+	selected := map[string]int{}
+	for range 6 {
+		proxy, err := leastConnections.NextProxy(nil)
+		require.Nil(t, err)
+		selected[proxy.GetName()]++
+	}
+	assert.Equal(t, map[string]int{"proxy1": 2, "proxy2": 2, "proxy3": 2}, selected)
+}
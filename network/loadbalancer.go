@@ -1,17 +1,24 @@
 package network
 
 import (
+	"errors"
+
 	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
 )
 
+// errNoHealthyProxies is returned by the strategies when the servers of all the proxies
+// fail the health checks.
+var errNoHealthyProxies = errors.New("no proxy with a healthy server")
+
 type LoadBalancerStrategy interface {
 	NextProxy(conn IConnWrapper) (IProxy, *gerr.GatewayDError)
 }
 
 // NewLoadBalancerStrategy returns a LoadBalancerStrategy based on the server's load balancer strategy name.
 // If the server's load balancer strategy is weighted round-robin,
-// it selects a load balancer rule before returning the strategy.
+// it selects a load balancer rule before returning the strategy,
+// unless the rules have conditions, which are then evaluated for each client.
 // Returns an error if the strategy is not found or if there are no load balancer rules when required.
 func NewLoadBalancerStrategy(server *Server) (LoadBalancerStrategy, *gerr.GatewayDError) {
 	var strategy LoadBalancerStrategy
@@ -20,10 +27,22 @@ func NewLoadBalancerStrategy(server *Server) (LoadBalancerStrategy, *gerr.Gatewa
 		strategy = NewRoundRobin(server)
 	case config.RANDOMStrategy:
 		strategy = NewRandom(server)
+	case config.LeastConnectionsStrategy:
+		strategy = NewLeastConnections(server)
+	case config.P2CEWMAStrategy:
+		strategy = NewP2CEWMA(server)
 	case config.WeightedRoundRobinStrategy:
 		if server.LoadbalancerRules == nil {
 			return nil, gerr.ErrNoLoadBalancerRules
 		}
+		if hasConditions(server.LoadbalancerRules) {
+			ruleBased, err := NewRuleBased(server)
+			if err != nil {
+				return nil, err
+			}
+			// The consistent hashing is applied by each rule to its own distribution.
+			return ruleBased, nil
+		}
 		loadbalancerRule := selectLoadBalancerRule(server.LoadbalancerRules)
 		strategy = NewWeightedRoundRobin(server, loadbalancerRule)
 	default:
@@ -49,3 +68,14 @@ func selectLoadBalancerRule(rules []config.LoadBalancingRule) config.LoadBalanci
 	// Return the first rule as a fallback
 	return rules[0]
 }
+
+// healthyProxies returns the proxies whose servers pass the health checks.
+func healthyProxies(proxies []IProxy) []IProxy {
+	healthy := make([]IProxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if proxy.IsBackendHealthy() {
+			healthy = append(healthy, proxy)
+		}
+	}
+	return healthy
+}
@@ -28,6 +28,24 @@ func TestNewLoadBalancerStrategy(t *testing.T) {
 		t.Errorf("Expected strategy to be of type RoundRobin")
 	}
 
+	serverValid.LoadbalancerStrategyName = config.LeastConnectionsStrategy
+	strategy, err = NewLoadBalancerStrategy(serverValid)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, ok := strategy.(*LeastConnections); !ok {
+		t.Errorf("Expected strategy to be of type LeastConnections")
+	}
+
+	serverValid.LoadbalancerStrategyName = config.P2CEWMAStrategy
+	strategy, err = NewLoadBalancerStrategy(serverValid)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, ok := strategy.(*P2CEWMA); !ok {
+		t.Errorf("Expected strategy to be of type P2CEWMA")
+	}
+
 	// Test case 2: InValid strategy name
 	serverInvalid := &Server{
 		LoadbalancerStrategyName: "InvalidStrategy",
@@ -0,0 +1,315 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const (
+	// TypedHeaderLength is the length of the header of a typed message, that is
+	// the 1-byte message type followed by the 4-byte message length.
+	TypedHeaderLength = 5
+	// UntypedHeaderLength is the length of the header of an untyped message, that
+	// is the 4-byte message length. Untyped messages are only sent by the client
+	// before the StartupMessage is sent.
+	UntypedHeaderLength = 4
+	// MaxMessageLength is the maximum length of a message that is accepted.
+	// PostgreSQL limits the message length to 1GB.
+	MaxMessageLength = 1 << 30
+
+	// Request codes of the untyped messages:
+	// https://www.postgresql.org/docs/current/protocol-message-formats.html
+	SSLRequestCode    = 80877103
+	GSSENCRequestCode = 80877104
+	CancelRequestCode = 80877102
+)
+
+// Message types that are used for tracking the state of the connections:
+// https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	QueryMessage         = 'Q'
+	ParseMessage         = 'P'
+	BindMessage          = 'B'
+	DescribeMessage      = 'D'
+	ExecuteMessage       = 'E'
+	CloseMessage         = 'C'
+	FlushMessage         = 'H'
+	SyncMessage          = 'S'
+	FunctionCallMessage  = 'F'
+	TerminateMessage     = 'X'
+	ReadyForQueryMessage = 'Z'
+
+	// Message types of the startup and authentication phase.
+	AuthenticationMessage  = 'R'
+	PasswordMessage        = 'p'
+	ParameterStatusMessage = 'S'
+	BackendKeyDataMessage  = 'K'
+	ErrorResponseMessage   = 'E'
+	DataRowMessage         = 'D'
+)
+
+// SQLSTATE codes of the errors that are sent to the client by the proxy.
+const (
+	// FeatureNotSupportedCode is the code of the errors that are sent to the
+	// client when a request is not supported by the proxy.
+	FeatureNotSupportedCode = "0A000"
+	// InvalidPasswordCode is the code of the errors that are sent to the
+	// client when the client can't be authenticated by the proxy.
+	InvalidPasswordCode = "28P01"
+	// InvalidAuthorizationCode is the code of the errors that are sent to the
+	// client when it connects without the required client certificate.
+	InvalidAuthorizationCode = "28000"
+	// ProtocolViolationCode is the code of the errors that are sent to the
+	// client when the client sends an unexpected message.
+	ProtocolViolationCode = "08P01"
+	// TooManyConnectionsCode is the code of the errors that are sent to the
+	// client when no more server connections can be assigned to it.
+	TooManyConnectionsCode = "53300"
+	// ConnectionFailureCode is the code of the errors that are sent to the
+	// client when the proxy fails to connect to the server.
+	ConnectionFailureCode = "08006"
+	// AdminShutdownCode is the code of the errors that are sent to the
+	// client when its connection is closed since the server drains.
+	AdminShutdownCode = "57P01"
+	// CannotConnectNowCode is the code of the errors that are sent to the
+	// client when it connects while the server drains.
+	CannotConnectNowCode = "57P03"
+	// InternalErrorCode is the code of the errors that are sent to the
+	// client when its session is ended by an unexpected error.
+	InternalErrorCode = "XX000"
+)
+
+// Transaction status indicators of the ReadyForQuery message.
+const (
+	TransactionStatusIdle   = 'I'
+	TransactionStatusActive = 'T'
+	TransactionStatusFailed = 'E'
+)
+
+// MessageReader reads whole PostgreSQL wire-protocol messages from a connection.
+// Messages are framed by their length header rather than by the size of the reads,
+// so the caller never receives a partial message or a message that is merged with
+// a partial one, regardless of how the bytes arrive on the network.
+type MessageReader struct {
+	reader *bufio.Reader
+	// startup is true while the reader expects untyped messages, that is
+	// the SSLRequest, GSSENCRequest, CancelRequest and StartupMessage.
+	startup bool
+}
+
+// NewMessageReader creates a new message reader. The buffer size is the size of the
+// buffer used for reading from the connection, and the startup flag determines
+// whether the first message is expected to be an untyped startup-phase message,
+// which is the case for connections coming from database clients.
+func NewMessageReader(reader io.Reader, bufferSize int, startup bool) *MessageReader {
+	if bufferSize < TypedHeaderLength {
+		bufferSize = TypedHeaderLength
+	}
+
+	return &MessageReader{
+		reader:  bufio.NewReaderSize(reader, bufferSize),
+		startup: startup,
+	}
+}
+
+// ReadMessages blocks until a whole message is read and returns it together with every
+// other whole message that is already buffered. This keeps the batching behavior of the
+// network reads, while guaranteeing that the returned bytes are correctly bounded
+// protocol messages. Untyped startup-phase messages are always returned one at a time,
+// since the connection might be upgraded to TLS after them.
+func (mr *MessageReader) ReadMessages() ([]byte, error) {
+	if mr.startup {
+		return mr.readUntypedMessage()
+	}
+
+	message, err := mr.readTypedMessage()
+	if err != nil {
+		return message, err
+	}
+
+	// Read the rest of the messages that are already fully buffered.
+	for mr.reader.Buffered() >= TypedHeaderLength {
+		header, err := mr.reader.Peek(TypedHeaderLength)
+		if err != nil {
+			break
+		}
+		length := int(binary.BigEndian.Uint32(header[1:TypedHeaderLength]))
+		if length < UntypedHeaderLength || mr.reader.Buffered() < length+1 {
+			break
+		}
+
+		next, err := mr.readTypedMessage()
+		if err != nil {
+			return message, err
+		}
+		message = append(message, next...)
+	}
+
+	return message, nil
+}
+
+// IsStartup returns true if the reader expects an untyped startup-phase message.
+func (mr *MessageReader) IsStartup() bool {
+	return mr.startup
+}
+
+// readTypedMessage reads a message that starts with a 1-byte message type.
+func (mr *MessageReader) readTypedMessage() ([]byte, error) {
+	header := make([]byte, TypedHeaderLength)
+	if read, err := io.ReadFull(mr.reader, header); err != nil {
+		return header[:read], err //nolint:wrapcheck
+	}
+
+	length := int(binary.BigEndian.Uint32(header[1:TypedHeaderLength]))
+	if length < UntypedHeaderLength || length > MaxMessageLength {
+		return header, gerr.ErrInvalidMessage.Wrap(
+			fmt.Errorf("invalid length %d for message type %q", length, header[0]))
+	}
+
+	message := make([]byte, length+1)
+	copy(message, header)
+	if read, err := io.ReadFull(mr.reader, message[TypedHeaderLength:]); err != nil {
+		return message[:TypedHeaderLength+read], err //nolint:wrapcheck
+	}
+
+	return message, nil
+}
+
+// readUntypedMessage reads a startup-phase message that has no message type. The reader
+// switches to typed messages after a StartupMessage is read.
+func (mr *MessageReader) readUntypedMessage() ([]byte, error) {
+	header := make([]byte, UntypedHeaderLength)
+	if read, err := io.ReadFull(mr.reader, header); err != nil {
+		return header[:read], err //nolint:wrapcheck
+	}
+
+	length := int(binary.BigEndian.Uint32(header))
+	if length < UntypedHeaderLength+4 || length > MaxMessageLength {
+		return header, gerr.ErrInvalidMessage.Wrap(
+			fmt.Errorf("invalid length %d for startup message", length))
+	}
+
+	message := make([]byte, length)
+	copy(message, header)
+	if read, err := io.ReadFull(mr.reader, message[UntypedHeaderLength:]); err != nil {
+		return message[:UntypedHeaderLength+read], err //nolint:wrapcheck
+	}
+
+	switch binary.BigEndian.Uint32(message[UntypedHeaderLength:]) {
+	case SSLRequestCode, GSSENCRequestCode, CancelRequestCode:
+		// The client either waits for the encryption response and sends
+		// another startup-phase message, or closes the connection.
+	default:
+		mr.startup = false
+	}
+
+	return message, nil
+}
+
+// forEachMessage calls the callback for every whole typed message in the data, until the
+// callback returns false. The data is expected to be framed by the MessageReader.
+func forEachMessage(data []byte, callback func(msgType byte, body []byte) bool) {
+	for len(data) >= TypedHeaderLength {
+		length := int(binary.BigEndian.Uint32(data[1:TypedHeaderLength]))
+		if length < UntypedHeaderLength || len(data) < length+1 {
+			return
+		}
+		if !callback(data[0], data[TypedHeaderLength:length+1]) {
+			return
+		}
+		data = data[length+1:]
+	}
+}
+
+// isStartupMessage returns true if the request is a StartupMessage of protocol version 3.0.
+func isStartupMessage(request []byte) bool {
+	return len(request) >= UntypedHeaderLength+4 &&
+		int(binary.BigEndian.Uint32(request)) == len(request) &&
+		binary.BigEndian.Uint32(request[UntypedHeaderLength:]) == pgproto3.ProtocolVersionNumber
+}
+
+// isTerminateRequest returns true if the request consists of a Terminate message.
+func isTerminateRequest(request []byte) bool {
+	return len(request) == TypedHeaderLength && request[0] == TerminateMessage
+}
+
+// isTransactionRequest returns true if a simple query or a prepared statement in the request
+// starts a transaction block. Only the first statement of a multi-statement query is checked.
+func isTransactionRequest(request []byte) bool {
+	found := false
+	forEachMessage(request, func(msgType byte, body []byte) bool {
+		var query []byte
+		switch msgType {
+		case QueryMessage:
+			query = body
+		case ParseMessage:
+			// The statement name precedes the query.
+			if idx := bytes.IndexByte(body, 0); idx >= 0 {
+				query = body[idx+1:]
+			}
+		default:
+			return true
+		}
+
+		switch firstKeyword(query) {
+		case "BEGIN", "START":
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// expectsReadyForQuery returns true if the server responds to the request with a
+// ReadyForQuery message.
+func expectsReadyForQuery(request []byte) bool {
+	found := false
+	forEachMessage(request, func(msgType byte, _ []byte) bool {
+		switch msgType {
+		case QueryMessage, SyncMessage, FunctionCallMessage:
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// firstKeyword returns the first keyword of the query in upper case, skipping
+// the leading whitespaces and comments.
+func firstKeyword(query []byte) string {
+	for len(query) > 0 {
+		switch {
+		case unicode.IsSpace(rune(query[0])):
+			query = query[1:]
+		case bytes.HasPrefix(query, []byte("--")):
+			idx := bytes.IndexByte(query, '\n')
+			if idx < 0 {
+				return ""
+			}
+			query = query[idx+1:]
+		case bytes.HasPrefix(query, []byte("/*")):
+			idx := bytes.Index(query, []byte("*/"))
+			if idx < 0 {
+				return ""
+			}
+			query = query[idx+2:]
+		default:
+			end := 0
+			for end < len(query) && unicode.IsLetter(rune(query[end])) {
+				end++
+			}
+			return strings.ToUpper(string(query[:end]))
+		}
+	}
+	return ""
+}
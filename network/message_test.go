@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_MessageReader_SlowNetwork tests that a message that arrives one byte at a time
+// is returned as a whole message.
+func Test_MessageReader_SlowNetwork(t *testing.T) {
+	query := CreatePostgreSQLPacket('Q', []byte("SELECT 1\x00"))
+	reader := NewMessageReader(
+		iotest.OneByteReader(bytes.NewReader(query)), config.DefaultChunkSize, false)
+
+	message, err := reader.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, query, message)
+
+	_, err = reader.ReadMessages()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// Test_MessageReader_ChunkBoundary tests that a message that is larger than the buffer
+// and ends exactly on a chunk boundary is read correctly.
+func Test_MessageReader_ChunkBoundary(t *testing.T) {
+	const chunkSize = 64
+	dataRow := CreatePostgreSQLPacket('D', bytes.Repeat([]byte{'x'}, 4*chunkSize-TypedHeaderLength))
+	require.Len(t, dataRow, 4*chunkSize)
+	commandComplete := CreatePostgreSQLPacket('C', []byte("SELECT 1\x00"))
+
+	reader := NewMessageReader(
+		bytes.NewReader(append(dataRow, commandComplete...)), chunkSize, false)
+
+	message, err := reader.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, dataRow, message[:len(dataRow)])
+
+	if len(message) == len(dataRow) {
+		message, err = reader.ReadMessages()
+		require.NoError(t, err)
+		assert.Equal(t, commandComplete, message)
+	} else {
+		assert.Equal(t, append(dataRow, commandComplete...), message)
+	}
+}
+
+// Test_MessageReader_Pipelined tests that pipelined messages are returned as whole
+// messages and a partial message is never returned.
+func Test_MessageReader_Pipelined(t *testing.T) {
+	parse := CreatePostgreSQLPacket('P', []byte("\x00SELECT 1\x00\x00\x00"))
+	sync := CreatePostgreSQLPacket('S', nil)
+	data := append(append([]byte{}, parse...), sync...)
+	// Only the first few bytes of the third message have arrived.
+	data = append(data, parse[:3]...)
+
+	reader := NewMessageReader(bytes.NewReader(data), config.DefaultChunkSize, false)
+	message, err := reader.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, parse...), sync...), message)
+
+	message, err = reader.ReadMessages()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, parse[:3], message)
+}
+
+// Test_MessageReader_Startup tests that the untyped startup-phase messages are framed
+// correctly, and that the reader switches to typed messages after the StartupMessage.
+func Test_MessageReader_Startup(t *testing.T) {
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest, 8)
+	binary.BigEndian.PutUint32(sslRequest[4:], SSLRequestCode)
+	startup := CreatePgStartupPacket()
+	terminate := CreatePgTerminatePacket()
+
+	data := append(append(append([]byte{}, sslRequest...), startup...), terminate...)
+	reader := NewMessageReader(bytes.NewReader(data), config.DefaultChunkSize, true)
+
+	message, err := reader.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, sslRequest, message)
+	assert.True(t, reader.IsStartup())
+
+	message, err = reader.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, startup, message)
+	assert.False(t, reader.IsStartup())
+
+	message, err = reader.ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, terminate, message)
+}
+
+// Test_MessageReader_InvalidLength tests that a message with an invalid length is rejected.
+func Test_MessageReader_InvalidLength(t *testing.T) {
+	reader := NewMessageReader(
+		bytes.NewReader([]byte{'Q', 0, 0, 0, 1}), config.DefaultChunkSize, false)
+	_, err := reader.ReadMessages()
+	var gErr *gerr.GatewayDError
+	require.ErrorAs(t, err, &gErr)
+	assert.Equal(t, gerr.ErrCodeInvalidMessage, gErr.Code)
+}
+
+// Test_isTransactionRequest tests the detection of the requests that start a transaction block.
+func Test_isTransactionRequest(t *testing.T) {
+	tests := []struct {
+		request  []byte
+		expected bool
+	}{
+		{CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00")), true},
+		{CreatePostgreSQLPacket(QueryMessage, []byte("\n  begin isolation level serializable\x00")), true},
+		{CreatePostgreSQLPacket(QueryMessage, []byte("-- comment\nSTART TRANSACTION\x00")), true},
+		{CreatePostgreSQLPacket(QueryMessage, []byte("/* comment */ BEGIN;\x00")), true},
+		{CreatePostgreSQLPacket(ParseMessage, []byte("stmt\x00BEGIN\x00\x00\x00")), true},
+		{CreatePostgreSQLPacket(QueryMessage, []byte("SELECT 'BEGIN'\x00")), false},
+		{CreatePostgreSQLPacket(QueryMessage, []byte("BEGINNING\x00")), false},
+		{CreatePostgreSQLPacket(QueryMessage, []byte("-- BEGIN\x00")), false},
+		{CreatePostgreSQLPacket(SyncMessage, nil), false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isTransactionRequest(test.request), string(test.request))
+	}
+}
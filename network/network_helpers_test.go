@@ -1,13 +1,28 @@
 package network
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/mock"
@@ -22,7 +37,10 @@ type WriteBuffer struct {
 
 // MockProxy implements the IProxy interface for testing purposes.
 type MockProxy struct {
-	name string
+	name      string
+	unhealthy bool
+	busy      int
+	latency   time.Duration
 }
 
 // writeStartupMsg writes a PostgreSQL startup message to the buffer.
@@ -207,6 +225,31 @@ func (m MockProxy) BusyConnectionsString() []string {
 	return nil
 }
 
+// IsBackendHealthy is a mock implementation of the IsBackendHealthy method in the IProxy interface.
+func (m MockProxy) IsBackendHealthy() bool {
+	return !m.unhealthy
+}
+
+// BusyConnectionsCount is a mock implementation of the BusyConnectionsCount method in the IProxy interface.
+func (m MockProxy) BusyConnectionsCount() int {
+	return m.busy
+}
+
+// Drain is a mock implementation of the Drain method in the IProxy interface.
+func (m MockProxy) Drain(_ *ConnWrapper) bool {
+	return false
+}
+
+// CancelRequest is a mock implementation of the CancelRequest method in the IProxy interface.
+func (m MockProxy) CancelRequest(_ []byte) bool {
+	return false
+}
+
+// Latency is a mock implementation of the Latency method in the IProxy interface.
+func (m MockProxy) Latency() time.Duration {
+	return m.latency
+}
+
 // GetName returns the name of the MockProxy.
 func (m MockProxy) GetName() string {
 	return m.name
@@ -275,3 +318,481 @@ func (m *MockConnWrapper) IsTLSEnabled() bool {
 	args := m.Called()
 	return args.Bool(0)
 }
+
+func (m *MockConnWrapper) StartupParameters() map[string]string {
+	args := m.Called()
+	parameters, _ := args.Get(0).(map[string]string)
+	return parameters
+}
+
+// FakeBackend is a minimal PostgreSQL server that accepts any startup message and
+// responds to simple queries and to the extended query protocol. It keeps track of the
+// transaction status and the prepared statements of each connection, so it can be used
+// for testing the pool modes without a real database.
+type FakeBackend struct {
+	listener    net.Listener
+	connections atomic.Int32
+	parsed      sync.Map
+	// password is the password that the users must send with md5, if any.
+	password string
+	// secrets are returned by the queries on pg_shadow, that is by the auth query.
+	secrets map[string]string
+	// logins counts the successful startups of each user@database.
+	logins   map[string]int
+	loginsMu sync.Mutex
+	// tlsConfig is used for accepting SSL requests, which are refused if it is nil.
+	tlsConfig *tls.Config
+	// tlsConnections counts the connections that are upgraded to TLS, and
+	// clientCertificates counts the ones on which the client sent a certificate.
+	tlsConnections     atomic.Int32
+	clientCertificates atomic.Int32
+	// processIDs issues the process IDs of the connections, and canceled maps them to the
+	// channels that receive the valid CancelRequests. The queries on pg_sleep wait for them.
+	processIDs     atomic.Uint32
+	canceled       sync.Map
+	cancelRequests atomic.Int32
+	sleeping       atomic.Int32
+	// executed counts the simple queries and the executed statements by their queries.
+	executed   map[string]int
+	executedMu sync.Mutex
+	// inRecovery is returned by the queries on pg_is_in_recovery().
+	inRecovery atomic.Bool
+}
+
+// NewFakeBackend starts a fake backend on a random local port.
+func NewFakeBackend(t *testing.T) *FakeBackend {
+	t.Helper()
+
+	return NewFakeAuthBackend(t, "", nil)
+}
+
+// NewFakeAuthBackend starts a fake backend that authenticates the users with md5 and the
+// given password, and that returns the given secrets to the queries on pg_shadow.
+func NewFakeAuthBackend(t *testing.T, password string, secrets map[string]string) *FakeBackend {
+	t.Helper()
+
+	return startFakeBackend(t, &FakeBackend{
+		password: password,
+		secrets:  secrets,
+	})
+}
+
+// NewFakeTLSBackend starts a fake backend that accepts SSL requests with the given TLS config.
+func NewFakeTLSBackend(t *testing.T, tlsConfig *tls.Config) *FakeBackend {
+	t.Helper()
+
+	return startFakeBackend(t, &FakeBackend{tlsConfig: tlsConfig})
+}
+
+func startFakeBackend(t *testing.T, backend *FakeBackend) *FakeBackend {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	backend.listener = listener
+	backend.logins = make(map[string]int)
+	backend.executed = make(map[string]int)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			backend.connections.Add(1)
+			go backend.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+
+	return backend
+}
+
+// Address returns the address of the fake backend.
+func (fb *FakeBackend) Address() string {
+	return fb.listener.Addr().String()
+}
+
+// Connections returns the number of connections that the fake backend has accepted.
+func (fb *FakeBackend) Connections() int {
+	return int(fb.connections.Load())
+}
+
+// Logins returns the number of successful startups of the user on the database.
+func (fb *FakeBackend) Logins(user, database string) int {
+	fb.loginsMu.Lock()
+	defer fb.loginsMu.Unlock()
+
+	return fb.logins[user+"@"+database]
+}
+
+func (fb *FakeBackend) login(user, database string) {
+	fb.loginsMu.Lock()
+	defer fb.loginsMu.Unlock()
+
+	fb.logins[user+"@"+database]++
+}
+
+// Executed returns the number of times the query is run, either as a simple query or
+// by executing a statement.
+func (fb *FakeBackend) Executed(query string) int {
+	fb.executedMu.Lock()
+	defer fb.executedMu.Unlock()
+
+	return fb.executed[query]
+}
+
+func (fb *FakeBackend) execute(query string) {
+	fb.executedMu.Lock()
+	defer fb.executedMu.Unlock()
+
+	fb.executed[query]++
+}
+
+// SetInRecovery sets whether the fake backend is a standby, as returned by pg_is_in_recovery().
+func (fb *FakeBackend) SetInRecovery(inRecovery bool) {
+	fb.inRecovery.Store(inRecovery)
+}
+
+// TLSConnections returns the number of connections that are upgraded to TLS.
+func (fb *FakeBackend) TLSConnections() int {
+	return int(fb.tlsConnections.Load())
+}
+
+// ClientCertificates returns the number of TLS connections on which the client sent a certificate.
+func (fb *FakeBackend) ClientCertificates() int {
+	return int(fb.clientCertificates.Load())
+}
+
+// CancelRequests returns the number of CancelRequests with a valid key that the fake backend
+// has received.
+func (fb *FakeBackend) CancelRequests() int {
+	return int(fb.cancelRequests.Load())
+}
+
+// Sleeping returns the number of queries on pg_sleep that the fake backend has started.
+func (fb *FakeBackend) Sleeping() int {
+	return int(fb.sleeping.Load())
+}
+
+// fakeSecretKey returns the secret key of the connection with the process ID.
+func fakeSecretKey(processID uint32) uint32 {
+	return processID*7919 + 1
+}
+
+func (fb *FakeBackend) cancel(request []byte) {
+	var message pgproto3.CancelRequest
+	if message.Decode(request[UntypedHeaderLength:]) != nil ||
+		message.SecretKey != fakeSecretKey(message.ProcessID) {
+		return
+	}
+	if canceled, ok := fb.canceled.Load(message.ProcessID); ok {
+		fb.cancelRequests.Add(1)
+		select {
+		case canceled.(chan struct{}) <- struct{}{}: //nolint:forcetypeassert
+		default:
+		}
+	}
+}
+
+// Parsed returns true if a statement with the given name has been parsed by the backend.
+func (fb *FakeBackend) Parsed(name string) bool {
+	_, ok := fb.parsed.Load(name)
+	return ok
+}
+
+func (fb *FakeBackend) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := NewMessageReader(conn, config.DefaultChunkSize, true)
+	status := byte(TransactionStatusIdle)
+	statements := make(map[string]bool)
+	queries := make(map[string]string)
+	salt := []byte{1, 2, 3, 4}
+	var user, database, parameter, portal string
+	processID := fb.processIDs.Add(1)
+	canceled := make(chan struct{}, 1)
+	fb.canceled.Store(processID, canceled)
+	defer fb.canceled.Delete(processID)
+	ready := CreatePostgreSQLPacket('R', []byte{0, 0, 0, 0})
+	ready = append(ready, CreatePostgreSQLPacket(
+		ParameterStatusMessage, []byte("server_version\x0016.0\x00"))...)
+	ready, _ = (&pgproto3.BackendKeyData{
+		ProcessID: processID,
+		SecretKey: fakeSecretKey(processID),
+	}).Encode(ready)
+	// failed is true if an extended query message failed, until the next Sync message.
+	failed := false
+	fail := func(message string) []byte {
+		failed = true
+		return CreatePostgreSQLPacket('E', []byte("SERROR\x00M"+message+"\x00\x00"))
+	}
+	for {
+		startup := reader.IsStartup()
+		request, err := reader.ReadMessages()
+		if err != nil {
+			return
+		}
+
+		var response []byte
+		if startup {
+			switch {
+			case binary.BigEndian.Uint32(request[UntypedHeaderLength:]) == SSLRequestCode:
+				if fb.tlsConfig == nil {
+					response = []byte{'N'}
+					break
+				}
+				if _, err := conn.Write([]byte{'S'}); err != nil {
+					return
+				}
+				tlsConn := tls.Server(conn, fb.tlsConfig)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				fb.tlsConnections.Add(1)
+				if len(tlsConn.ConnectionState().PeerCertificates) > 0 {
+					fb.clientCertificates.Add(1)
+				}
+				conn = tlsConn
+				reader = NewMessageReader(conn, config.DefaultChunkSize, true)
+				continue
+			case binary.BigEndian.Uint32(request[UntypedHeaderLength:]) == CancelRequestCode:
+				fb.cancel(request)
+				return
+			case fb.password != "":
+				var message pgproto3.StartupMessage
+				if message.Decode(request[UntypedHeaderLength:]) != nil {
+					return
+				}
+				user, database = message.Parameters["user"], message.Parameters["database"]
+				response = CreatePostgreSQLPacket('R', append([]byte{0, 0, 0, 5}, salt...))
+			default:
+				var message pgproto3.StartupMessage
+				if message.Decode(request[UntypedHeaderLength:]) == nil {
+					fb.login(message.Parameters["user"], message.Parameters["database"])
+				}
+				response = append(response, ready...)
+				response = append(response, CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{status})...)
+			}
+		}
+
+		terminated := false
+		forEachMessage(request, func(msgType byte, body []byte) bool {
+			if failed && msgType != SyncMessage {
+				return true
+			}
+			switch msgType {
+			case PasswordMessage:
+				if string(body) != md5Response(md5Secret(user, fb.password), salt)+"\x00" {
+					response = append(response, CreatePostgreSQLPacket(
+						ErrorResponseMessage, []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))...)
+					break
+				}
+				fb.login(user, database)
+				response = append(response, ready...)
+				response = append(response, CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{status})...)
+			case ParseMessage:
+				name, query, _ := splitCString(body)
+				if name != "" && statements[name] {
+					response = append(response, fail("prepared statement already exists")...)
+					break
+				}
+				statements[name] = true
+				queries[name], _, _ = splitCString(query)
+				fb.parsed.Store(name, true)
+				response = append(response, CreatePostgreSQLPacket('1', nil)...)
+			case BindMessage:
+				_, rest, _ := splitCString(body)
+				name, _, _ := splitCString(rest)
+				if !statements[name] {
+					response = append(response, fail("prepared statement does not exist")...)
+					break
+				}
+				var bind pgproto3.Bind
+				if bind.Decode(body) == nil && len(bind.Parameters) > 0 {
+					parameter = string(bind.Parameters[0])
+				}
+				portal = queries[name]
+				response = append(response, CreatePostgreSQLPacket('2', nil)...)
+			case DescribeMessage:
+				response = append(response, CreatePostgreSQLPacket('n', nil)...)
+			case ExecuteMessage:
+				fb.execute(portal)
+				if secret, ok := fb.secrets[parameter]; ok && strings.Contains(queries[""], "pg_shadow") {
+					row, _ := (&pgproto3.DataRow{Values: [][]byte{[]byte(parameter), []byte(secret)}}).Encode(nil)
+					response = append(response, row...)
+				}
+				response = append(response, CreatePostgreSQLPacket('C', []byte("SELECT 1\x00"))...)
+			case CloseMessage:
+				name, _, _ := splitCString(body[1:])
+				delete(statements, name)
+				response = append(response, CreatePostgreSQLPacket('3', nil)...)
+			case QueryMessage:
+				query := strings.TrimRight(string(body), "\x00")
+				fb.execute(query)
+				if strings.HasPrefix(query, "SELECT pg_sleep") {
+					fb.sleeping.Add(1)
+					select {
+					case <-canceled:
+						response = append(response, CreatePostgreSQLPacket(ErrorResponseMessage, []byte(
+							"SERROR\x00C57014\x00Mcanceling statement due to user request\x00\x00"))...)
+						response = append(response, CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{status})...)
+						return true
+					case <-time.After(10 * time.Second):
+					}
+				}
+				if query == "SELECT pg_is_in_recovery()" {
+					row, _ := (&pgproto3.DataRow{Values: [][]byte{
+						[]byte(config.If(fb.inRecovery.Load(), "t", "f")),
+					}}).Encode(nil)
+					response = append(response, row...)
+				}
+				for _, statement := range strings.Split(query, ";") {
+					switch strings.ToUpper(strings.TrimSpace(statement)) {
+					case "BEGIN":
+						status = TransactionStatusActive
+					case "COMMIT", "ROLLBACK":
+						status = TransactionStatusIdle
+					}
+				}
+				response = append(response, CreatePostgreSQLPacket('C', []byte(query+"\x00"))...)
+				response = append(response, CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{status})...)
+			case SyncMessage:
+				failed = false
+				response = append(response, CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{status})...)
+			case TerminateMessage:
+				terminated = true
+				return false
+			}
+			return true
+		})
+		if terminated {
+			return
+		}
+
+		if len(response) > 0 {
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// NewTestIncomingConnection creates a pair of connected TCP connections. The first one is
+// wrapped as an incoming connection of the proxy, and the second one acts as the database client.
+func NewTestIncomingConnection(t *testing.T) (*ConnWrapper, net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server, ok := <-accepted
+	require.True(t, ok)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return NewConnWrapper(ConnWrapper{NetConn: server}), client
+}
+
+// testCA is a certificate authority that issues certificates for testing TLS.
+type testCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	dir      string
+	serial   int64
+}
+
+// newTestCA creates a self-signed certificate authority and writes its certificate to a file.
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "GatewayD Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir(), serial: 1}
+	ca.certFile = ca.writePEM(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// issue issues a certificate for the DNS name and writes the certificate and the key to files.
+func (ca *testCA) issue(t *testing.T, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return ca.writePEM(t, name+".pem", "CERTIFICATE", der),
+		ca.writePEM(t, name+".key", "EC PRIVATE KEY", keyDER)
+}
+
+// serverTLSConfig returns a TLS config with a certificate for the DNS name, which verifies
+// the client certificates that are signed by the CA.
+func (ca *testCA) serverTLSConfig(t *testing.T, name string) *tls.Config {
+	t.Helper()
+
+	certFile, keyFile := ca.issue(t, name)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	}
+}
+
+func (ca *testCA) writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(ca.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
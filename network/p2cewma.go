@@ -0,0 +1,68 @@
+package network
+
+import (
+	"errors"
+	"time"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// P2CEWMA selects the better of two random proxies, by the power of two choices. The cost
+// of a proxy is the moving average of its round-trip latency, weighted by the number of
+// its busy connections, so that the slow servers receive less clients without the fast
+// ones being overloaded. The proxies whose latency is unknown are preferred, unless they
+// are much busier. The latency is the time to the first response of the requests, so it
+// includes the run time of the queries, and the servers that run slower queries are
+// considered slower.
+type P2CEWMA struct {
+	proxies []IProxy
+}
+
+// NewP2CEWMA creates a new P2CEWMA instance with the given server's proxies.
+func NewP2CEWMA(server *Server) *P2CEWMA {
+	return &P2CEWMA{proxies: server.Proxies}
+}
+
+// NextProxy returns the proxy with the lower cost of two random proxies.
+func (p *P2CEWMA) NextProxy(_ IConnWrapper) (IProxy, *gerr.GatewayDError) {
+	if len(p.proxies) == 0 {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("proxy list is empty"))
+	}
+
+	// The proxies with unhealthy servers are skipped.
+	proxies := healthyProxies(p.proxies)
+	switch len(proxies) {
+	case 0:
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errNoHealthyProxies)
+	case 1:
+		return proxies[0], nil
+	}
+
+	first, err := randInt(len(proxies))
+	if err != nil {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(err)
+	}
+	// The second proxy is picked among the others, so that the two are distinct.
+	second, err := randInt(len(proxies) - 1)
+	if err != nil {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(err)
+	}
+	if second >= first {
+		second++
+	}
+
+	if cost(proxies[second]) < cost(proxies[first]) {
+		return proxies[second], nil
+	}
+	return proxies[first], nil
+}
+
+// minLatency is the latency that the cost of a proxy is computed with, if its latency is
+// lower or unknown, so that its busy connections still count.
+const minLatency = 100 * time.Microsecond
+
+// cost returns the latency of the proxy multiplied by its busy connections, plus
+// the connection that is about to be assigned.
+func cost(proxy IProxy) float64 {
+	return float64(max(proxy.Latency(), minLatency)) * float64(proxy.BusyConnectionsCount()+1)
+}
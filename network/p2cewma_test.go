@@ -0,0 +1,155 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestP2CEWMA tests that the better of two proxies is selected by the latency and
+// the busy connections, and that the proxies with unhealthy servers are skipped.
+func TestP2CEWMA(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxies  []IProxy
+		expected string
+	}{
+		{
+			"lower latency",
+			[]IProxy{
+				MockProxy{name: "proxy1", latency: 20 * time.Millisecond},
+				MockProxy{name: "proxy2", latency: 5 * time.Millisecond},
+			},
+			"proxy2",
+		},
+		{
+			"unknown latency",
+			[]IProxy{
+				MockProxy{name: "proxy1", latency: time.Millisecond},
+				MockProxy{name: "proxy2"},
+			},
+			"proxy2",
+		},
+		{
+			"zero latency with busy connections",
+			[]IProxy{
+				MockProxy{name: "proxy1", latency: 0, busy: 100},
+				MockProxy{name: "proxy2", latency: 5 * time.Millisecond, busy: 1},
+			},
+			"proxy2",
+		},
+		{
+			"busy connections",
+			[]IProxy{
+				MockProxy{name: "proxy1", latency: 5 * time.Millisecond, busy: 9},
+				MockProxy{name: "proxy2", latency: 20 * time.Millisecond, busy: 1},
+			},
+			"proxy2",
+		},
+		{
+			"unhealthy",
+			[]IProxy{
+				MockProxy{name: "proxy1", latency: 20 * time.Millisecond},
+				MockProxy{name: "proxy2", unhealthy: true},
+				MockProxy{name: "proxy3", latency: time.Millisecond, unhealthy: true},
+			},
+			"proxy1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p2c := NewP2CEWMA(&Server{Proxies: tt.proxies})
+			for range 10 {
+				proxy, err := p2c.NextProxy(nil)
+				require.Nil(t, err)
+				assert.Equal(t, tt.expected, proxy.GetName())
+			}
+		})
+	}
+
+	_, err := NewP2CEWMA(&Server{}).NextProxy(nil)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+
+	_, err = NewP2CEWMA(&Server{Proxies: []IProxy{MockProxy{unhealthy: true}}}).NextProxy(nil)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+}
+
+// TestP2CEWMADistinctChoices tests that the worst of many proxies is never selected,
+// since the two choices are distinct.
+func TestP2CEWMADistinctChoices(t *testing.T) {
+	p2c := NewP2CEWMA(&Server{
+		Proxies: []IProxy{
+			MockProxy{name: "proxy1", latency: time.Millisecond},
+			MockProxy{name: "proxy2", latency: 2 * time.Millisecond},
+			MockProxy{name: "proxy3", latency: time.Second},
+		},
+	})
+	for range 50 {
+		proxy, err := p2c.NextProxy(nil)
+		require.Nil(t, err)
+		assert.NotEqual(t, "proxy3", proxy.GetName())
+	}
+}
+
+// Test_latency tests the moving average of the round-trip latency.
+func Test_latency(t *testing.T) {
+	tracker := newLatency()
+	assert.Zero(t, tracker.value())
+
+	conn := &ConnWrapper{}
+	// The responses without a pending request are not sampled.
+	tracker.received(conn)
+	assert.Zero(t, tracker.value())
+
+	now := time.Now()
+	tracker.observe(10*time.Millisecond, now)
+	assert.InDelta(t, float64(10*time.Millisecond), float64(tracker.average), 1)
+	// A sample long after the previous one outweighs it.
+	tracker.observe(time.Millisecond, now.Add(time.Minute))
+	assert.InDelta(t, float64(time.Millisecond), tracker.average, float64(50*time.Microsecond))
+	// A sample shortly after the previous one barely moves the average.
+	tracker.observe(time.Second, now.Add(time.Minute+time.Millisecond))
+	assert.Less(t, tracker.average, float64(2*time.Millisecond))
+
+	tracker.sent(conn)
+	tracker.forget(conn)
+	assert.Empty(t, tracker.pending)
+
+	// The average decays while the proxy receives no requests.
+	tracker.updated = time.Now().Add(-time.Minute)
+	assert.Less(t, tracker.value(), 10*time.Microsecond)
+
+	assert.Zero(t, (*latency)(nil).value())
+}
+
+// TestProxyLatency tests that the proxy measures the round-trip latency of the requests
+// and counts its busy connections.
+func TestProxyLatency(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, "")
+	assert.Zero(t, proxy.Latency())
+	assert.Zero(t, proxy.BusyConnectionsCount())
+
+	pgConn, err := connectThroughProxy(t, proxy, "postgres", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, proxy.BusyConnectionsCount())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	require.NoError(t, err)
+	assert.Positive(t, proxy.Latency())
+
+	require.NoError(t, pgConn.Close(ctx))
+	assert.Eventually(t, func() bool {
+		proxy.latency.mu.Lock()
+		defer proxy.latency.mu.Unlock()
+		return proxy.BusyConnectionsCount() == 0 && len(proxy.latency.pending) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
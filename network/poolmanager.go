@@ -0,0 +1,481 @@
+package network
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/go-co-op/gocron"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+// managedClient is a server connection of a pool that is sized by a pool manager.
+type managedClient struct {
+	created time.Time
+	// idleSince is the time the server connection was put in the pool,
+	// or zero while it is assigned to an incoming connection.
+	idleSince time.Time
+	// id is the key of the server connection in the pool, while it is idle.
+	id   string
+	uses int
+}
+
+// PoolManager sizes the pool of the server connections of a proxy. The server connections
+// are dialed on demand up to the maximum size, and the ones that are idle for longer than
+// the idle timeout are closed down to the minimum size. The server connections that are
+// older than the maximum lifetime, or that have been assigned to the incoming connections
+// more often than the maximum uses, are closed instead of being put back in the pool.
+// The pool is refilled to the minimum size in the background, so that the server doesn't
+// need to be reachable when the proxy starts.
+type PoolManager struct {
+	// Pool holds the idle server connections.
+	Pool pool.IPool
+	// WaitQueue is woken up when a new server connection is put in the pool.
+	WaitQueue *WaitQueue
+	// ClientConfig is the configuration of the new server connections.
+	ClientConfig *config.Client
+	MinSize      int
+	MaxSize      int
+	// IdleTimeout is the duration after which the idle server connections are closed,
+	// as long as the pool has more than MinSize server connections.
+	IdleTimeout time.Duration
+	// MaxLifetime and MaxUses retire the server connections, if set.
+	MaxLifetime time.Duration
+	MaxUses     int
+	// Interval is the period of closing the idle and aged server connections,
+	// and of refilling the pool to the minimum size.
+	Interval time.Duration
+	// OnNewClient is called for each new server connection, if set.
+	OnNewClient func(client *Client)
+	// CircuitBreaker records the outcome of the dials, if set, which fail right away while it is open.
+	CircuitBreaker *CircuitBreaker
+	Logger         zerolog.Logger
+
+	ctx       context.Context //nolint:containedctx
+	mu        *sync.Mutex
+	clients   map[*Client]*managedClient
+	dialing   int
+	closed    bool
+	scheduler *gocron.Scheduler
+}
+
+// NewPoolManager creates a new pool manager, and schedules the maintenance of the pool.
+// The pool is filled by Fill.
+func NewPoolManager(ctx context.Context, pm PoolManager) *PoolManager {
+	managerCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewPoolManager")
+	defer span.End()
+
+	manager := &PoolManager{
+		Pool:           pm.Pool,
+		WaitQueue:      pm.WaitQueue,
+		ClientConfig:   pm.ClientConfig,
+		MinSize:        pm.MinSize,
+		MaxSize:        config.If(pm.MaxSize >= pm.MinSize, pm.MaxSize, pm.MinSize),
+		IdleTimeout:    pm.IdleTimeout,
+		MaxLifetime:    pm.MaxLifetime,
+		MaxUses:        pm.MaxUses,
+		Interval:       config.If(pm.Interval > 0, pm.Interval, config.DefaultPoolMaintenance),
+		OnNewClient:    pm.OnNewClient,
+		CircuitBreaker: pm.CircuitBreaker,
+		Logger:         pm.Logger,
+		ctx:            managerCtx,
+		mu:             &sync.Mutex{},
+		clients:        make(map[*Client]*managedClient),
+		scheduler:      gocron.NewScheduler(time.UTC),
+	}
+
+	if _, err := manager.scheduler.Every(manager.Interval).SingletonMode().StartAt(
+		time.Now().Add(manager.Interval)).Do(manager.maintain); err != nil {
+		manager.Logger.Error().Err(err).Msg("Failed to schedule the maintenance of the pool")
+		span.RecordError(err)
+	}
+	manager.scheduler.StartAsync()
+
+	return manager
+}
+
+// Size returns the number of server connections of the pool, including the assigned ones
+// and the ones that are being dialed.
+func (m *PoolManager) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.clients) + m.dialing
+}
+
+// Filled returns true if the pool has at least the minimum number of server connections,
+// not counting the ones that are being dialed.
+func (m *PoolManager) Filled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.clients) >= m.MinSize
+}
+
+// Fill dials the server connections up to the minimum size, and puts them in the pool.
+// It stops at the first server connection that fails, and returns false in that case.
+func (m *PoolManager) Fill() bool {
+	for {
+		m.mu.Lock()
+		filled := m.closed || len(m.clients)+m.dialing >= m.MinSize
+		m.mu.Unlock()
+		if filled {
+			return true
+		}
+
+		client := m.dial(false)
+		if client == nil {
+			return false
+		}
+		m.put(client)
+	}
+}
+
+// Resize changes the minimum and the maximum size of the pool, e.g. on reloading the
+// configuration. The idle server connections beyond the maximum size are closed right away,
+// and the assigned ones once they are returned. The pool is refilled to the minimum size, and
+// grown for the waiting incoming connections, in the background.
+func (m *PoolManager) Resize(minSize, maxSize int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.MinSize = minSize
+	m.MaxSize = config.If(maxSize >= minSize, maxSize, minSize)
+	m.Pool.Resize(m.MaxSize)
+	var excess []*Client
+	size := len(m.clients) + m.dialing
+	for client, managed := range m.clients {
+		if size <= m.MaxSize {
+			break
+		}
+		if managed.idleSince.IsZero() {
+			continue
+		}
+		// The server connection might have been assigned to an incoming connection in the meantime.
+		if _, ok := m.Pool.Pop(managed.id).(*Client); !ok {
+			continue
+		}
+		delete(m.clients, client)
+		excess = append(excess, client)
+		size--
+	}
+	m.mu.Unlock()
+
+	for _, client := range excess {
+		client.Close()
+	}
+	m.Logger.Info().Fields(
+		map[string]interface{}{
+			"size":    size,
+			"minSize": minSize,
+			"maxSize": m.MaxSize,
+			"closed":  len(excess),
+		},
+	).Msg("Resized the pool")
+
+	for range m.WaitQueue.Len() {
+		m.grow(false)
+	}
+	go m.Fill()
+}
+
+// canGrow returns true if the pool has room for another server connection.
+func (m *PoolManager) canGrow() bool {
+	if m == nil {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return !m.closed && len(m.clients)+m.dialing < m.MaxSize
+}
+
+// reserve reserves room in the pool for a new server connection, if the pool isn't full.
+// If authenticated is true, the new server connection must complete the startup phase,
+// which requires the user of the client configuration.
+func (m *PoolManager) reserve(authenticated bool) bool {
+	if m == nil || (authenticated && m.ClientConfig.User == "") {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed || len(m.clients)+m.dialing >= m.MaxSize {
+		return false
+	}
+	m.dialing++
+	return true
+}
+
+// dial dials a new server connection, if the pool isn't full, and returns it without putting
+// it in the pool. It returns nil if the pool is full or if the server connection fails.
+func (m *PoolManager) dial(authenticated bool) *Client {
+	if !m.reserve(authenticated) {
+		return nil
+	}
+	return m.dialReserved()
+}
+
+// grow dials a new server connection in the background, if the pool isn't full, and puts
+// it in the pool, so that it is acquired by the first waiting incoming connection.
+func (m *PoolManager) grow(authenticated bool) {
+	if !m.reserve(authenticated) {
+		return
+	}
+	go func() {
+		if client := m.dialReserved(); client != nil {
+			m.put(client)
+		}
+	}()
+}
+
+// dialReserved dials a new server connection in the room that is reserved for it.
+func (m *PoolManager) dialReserved() *Client {
+	_, span := otel.Tracer(config.TracerName).Start(m.ctx, "dial")
+	defer span.End()
+
+	client := NewClient(
+		m.ctx, m.ClientConfig, m.Logger,
+		NewRetry(
+			Retry{
+				Retries: m.ClientConfig.Retries,
+				Backoff: config.If(
+					m.ClientConfig.Backoff > 0,
+					m.ClientConfig.Backoff,
+					config.DefaultBackoff,
+				),
+				BackoffMultiplier:  m.ClientConfig.BackoffMultiplier,
+				DisableBackoffCaps: m.ClientConfig.DisableBackoffCaps,
+				CircuitBreaker:     m.CircuitBreaker,
+				Logger:             m.Logger,
+			},
+		),
+	)
+
+	m.mu.Lock()
+	m.dialing--
+	if client != nil && m.closed {
+		m.mu.Unlock()
+		client.Close()
+		return nil
+	}
+	if client != nil {
+		m.clients[client] = &managedClient{created: time.Now()}
+	}
+	size := len(m.clients) + m.dialing
+	m.mu.Unlock()
+
+	if client == nil {
+		m.Logger.Error().Msg("Failed to create a new server connection for the pool")
+		span.AddEvent("Failed to create a new server connection")
+		return nil
+	}
+
+	m.Logger.Debug().Fields(
+		map[string]interface{}{
+			"size":    size,
+			"minSize": m.MinSize,
+			"maxSize": m.MaxSize,
+		},
+	).Msg("Created a new server connection in the pool")
+	if m.OnNewClient != nil {
+		m.OnNewClient(client)
+	}
+
+	return client
+}
+
+// put puts the new server connection in the pool, and wakes up the first waiting incoming connection.
+func (m *PoolManager) put(client *Client) {
+	m.release(client)
+	if err := m.Pool.Put(client.ID, client); err != nil {
+		m.Logger.Error().Err(err).Msg("Failed to put the new server connection in the pool")
+		m.forget(client)
+		client.Close()
+		return
+	}
+	m.WaitQueue.notify()
+}
+
+// track adds the server connection, which is put in the pool by the caller,
+// to the server connections of the pool.
+func (m *PoolManager) track(client *Client) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.clients[client] = &managedClient{created: now, idleSince: now, id: client.ID}
+}
+
+// forget removes the server connection, which is closed by the caller, from the server
+// connections of the pool.
+func (m *PoolManager) forget(client *Client) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.clients, client)
+}
+
+// acquired counts the assignment of the server connection to an incoming connection.
+func (m *PoolManager) acquired(client *Client) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if managed, ok := m.clients[client]; ok {
+		managed.idleSince = time.Time{}
+		managed.uses++
+	}
+}
+
+// release marks the server connection as idle, before it is put in the pool by the caller.
+func (m *PoolManager) release(client *Client) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if managed, ok := m.clients[client]; ok {
+		managed.idleSince = time.Now()
+		managed.id = client.ID
+	}
+}
+
+// retire closes the server connection that is returned by an incoming connection, and returns
+// true, if it is disconnected, older than the maximum lifetime, used more often than the
+// maximum uses, or if the pool has shrunk below its size. Then a new server connection replaces it, if the pool has less than the
+// minimum size or if incoming connections are waiting. The server connections that aren't
+// dialed by the pool manager are never retired.
+func (m *PoolManager) retire(client *Client) bool {
+	if m == nil {
+		return false
+	}
+
+	m.mu.Lock()
+	managed, ok := m.clients[client]
+	if !ok {
+		m.mu.Unlock()
+		return false
+	}
+	retired := !client.IsConnected() ||
+		(m.MaxLifetime > 0 && time.Since(managed.created) >= m.MaxLifetime) ||
+		(m.MaxUses > 0 && managed.uses >= m.MaxUses) ||
+		len(m.clients)+m.dialing > m.MaxSize
+	if retired {
+		delete(m.clients, client)
+	}
+	belowMinSize := len(m.clients)+m.dialing < m.MinSize
+	m.mu.Unlock()
+
+	if !retired {
+		return false
+	}
+
+	if client.IsConnected() {
+		client.Close()
+	}
+	m.Logger.Debug().Fields(
+		map[string]interface{}{
+			"uses":     managed.uses,
+			"lifetime": time.Since(managed.created).String(),
+		},
+	).Msg("Retired a server connection of the pool")
+
+	if belowMinSize || m.WaitQueue.Len() > 0 {
+		m.grow(false)
+	}
+	return true
+}
+
+// maintain closes the idle server connections that are older than the maximum lifetime, and
+// the ones that have been idle for longer than the idle timeout down to the minimum size,
+// from the least recently used one. Then it refills the pool to the minimum size.
+func (m *PoolManager) maintain() {
+	_, span := otel.Tracer(config.TracerName).Start(m.ctx, "maintain")
+	defer span.End()
+
+	now := time.Now()
+	var expired []*Client
+
+	m.mu.Lock()
+	idle := make([]*Client, 0, len(m.clients))
+	for client, managed := range m.clients {
+		if !managed.idleSince.IsZero() {
+			idle = append(idle, client)
+		}
+	}
+	slices.SortFunc(idle, func(a, b *Client) int {
+		return m.clients[a].idleSince.Compare(m.clients[b].idleSince)
+	})
+	size := len(m.clients) + m.dialing
+	for _, client := range idle {
+		managed := m.clients[client]
+		aged := m.MaxLifetime > 0 && now.Sub(managed.created) >= m.MaxLifetime
+		unused := m.IdleTimeout > 0 && now.Sub(managed.idleSince) >= m.IdleTimeout && size > m.MinSize
+		if !aged && !unused {
+			continue
+		}
+		// The server connection might have been assigned to an incoming connection in the meantime.
+		if _, ok := m.Pool.Pop(managed.id).(*Client); !ok {
+			continue
+		}
+		delete(m.clients, client)
+		expired = append(expired, client)
+		size--
+	}
+	m.mu.Unlock()
+
+	for _, client := range expired {
+		client.Close()
+	}
+	if len(expired) > 0 {
+		m.Logger.Debug().Int("count", len(expired)).Msg("Closed the idle server connections of the pool")
+	}
+
+	if !m.Fill() {
+		m.Logger.Warn().Fields(
+			map[string]interface{}{
+				"size":    m.Size(),
+				"minSize": m.MinSize,
+			},
+		).Msg("Failed to fill the pool to the minimum size, retrying in the background")
+		span.AddEvent("Failed to fill the pool")
+	}
+}
+
+// Shutdown stops the maintenance of the pool, and closes the server connections that are
+// dialed afterwards. The server connections of the pool are closed by the proxy.
+func (m *PoolManager) Shutdown() {
+	if m == nil {
+		return
+	}
+
+	m.scheduler.Stop()
+	m.scheduler.Clear()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+}
@@ -0,0 +1,237 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPoolManager creates a proxy with an empty pool, which is sized by the returned
+// pool manager.
+func newTestPoolManager(t *testing.T, backend *FakeBackend, manager PoolManager) (*Proxy, *PoolManager) {
+	t.Helper()
+
+	proxy := newTestPooledProxy(t, backend, 0, config.SessionPoolMode)
+	proxy.AvailableConnections = pool.NewPool(context.Background(), manager.MaxSize)
+	manager.Pool = proxy.AvailableConnections
+	manager.ClientConfig = proxy.ClientConfig
+	manager.WaitQueue = proxy.WaitQueue
+	manager.Logger = zerolog.Nop()
+	proxy.PoolManager = NewPoolManager(context.Background(), manager)
+	t.Cleanup(proxy.PoolManager.Shutdown)
+
+	return proxy, proxy.PoolManager
+}
+
+// TestPoolManager tests that the server connections are dialed on demand up to the maximum
+// size, and that the idle ones are closed down to the minimum size.
+func TestPoolManager(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy, manager := newTestPoolManager(t, backend, PoolManager{
+		MinSize:     1,
+		MaxSize:     2,
+		IdleTimeout: 50 * time.Millisecond,
+		Interval:    20 * time.Millisecond,
+	})
+
+	require.True(t, manager.Fill())
+	assert.Equal(t, 1, manager.Size())
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	first, _ := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(first))
+	second, _ := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(second))
+	assert.Equal(t, 2, manager.Size())
+	assert.Eventually(t, func() bool { return backend.Connections() == 2 }, time.Second, time.Millisecond)
+
+	// The pool is full.
+	third, _ := NewTestIncomingConnection(t)
+	assert.Equal(t, gerr.ErrCodePoolExhausted, proxy.Connect(third).Code)
+	assert.True(t, proxy.IsExhausted())
+
+	require.Nil(t, proxy.Disconnect(first))
+	require.Nil(t, proxy.Disconnect(second))
+	assert.Equal(t, 2, proxy.AvailableConnections.Size())
+
+	// The idle server connection is closed down to the minimum size.
+	require.Eventually(t, func() bool {
+		return proxy.AvailableConnections.Size() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, manager.Size())
+}
+
+// TestPoolManagerWaitQueue tests that the new server connection is put in the pool for
+// the waiting incoming connection.
+func TestPoolManagerWaitQueue(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 0, config.SessionPoolMode)
+	proxy.WaitQueue = NewWaitQueue(context.Background(), WaitQueue{MaxLength: 1, Timeout: 5 * time.Second})
+	proxy.AvailableConnections = pool.NewPool(context.Background(), 1)
+	proxy.PoolManager = NewPoolManager(context.Background(), PoolManager{
+		Pool:         proxy.AvailableConnections,
+		WaitQueue:    proxy.WaitQueue,
+		ClientConfig: proxy.ClientConfig,
+		MaxSize:      1,
+		Logger:       zerolog.Nop(),
+	})
+
+	// The pool is empty until the first incoming connection.
+	require.True(t, proxy.PoolManager.Fill())
+	assert.Equal(t, 0, backend.Connections())
+
+	conn, _ := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(conn))
+	assert.Eventually(t, func() bool { return backend.Connections() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 0, proxy.WaitQueue.Len())
+}
+
+// TestPoolManagerRetire tests that the server connections that are used more often than the
+// maximum uses, or that are older than the maximum lifetime, are replaced by new ones.
+func TestPoolManagerRetire(t *testing.T) {
+	t.Run("maxUses", func(t *testing.T) {
+		backend := NewFakeBackend(t)
+		proxy, manager := newTestPoolManager(t, backend, PoolManager{
+			MinSize: 1,
+			MaxSize: 1,
+			MaxUses: 1,
+		})
+		require.True(t, manager.Fill())
+
+		conn, _ := NewTestIncomingConnection(t)
+		require.Nil(t, proxy.Connect(conn))
+		client := proxy.assignedClient(conn)
+		require.NotNil(t, client)
+		require.Nil(t, proxy.Disconnect(conn))
+
+		assert.False(t, client.IsConnected())
+		require.Eventually(t, func() bool {
+			return proxy.AvailableConnections.Size() == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 1, manager.Size())
+		assert.Eventually(t, func() bool { return backend.Connections() == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("maxLifetime", func(t *testing.T) {
+		backend := NewFakeBackend(t)
+		proxy, manager := newTestPoolManager(t, backend, PoolManager{
+			MinSize:     1,
+			MaxSize:     1,
+			MaxLifetime: 50 * time.Millisecond,
+			Interval:    20 * time.Millisecond,
+		})
+		require.True(t, manager.Fill())
+		client := proxy.popFirstClient()
+		require.NotNil(t, client)
+		proxy.putClient(client)
+
+		// The idle server connection is replaced by the maintenance of the pool.
+		require.Eventually(t, func() bool {
+			return backend.Connections() == 2 && proxy.AvailableConnections.Size() == 1
+		}, time.Second, 10*time.Millisecond)
+		proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
+			assert.NotSame(t, client, value)
+			return true
+		})
+	})
+}
+
+// TestPoolManagerResize tests that the pool shrinks by closing its idle server connections
+// and the returned ones, and that it is refilled to the new minimum size.
+func TestPoolManagerResize(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy, manager := newTestPoolManager(t, backend, PoolManager{
+		MinSize: 2,
+		MaxSize: 3,
+	})
+	require.True(t, manager.Fill())
+	conn, _ := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(conn))
+	client := proxy.assignedClient(conn)
+	require.NotNil(t, client)
+
+	// The idle server connection is closed right away.
+	manager.Resize(0, 1)
+	assert.Equal(t, 1, manager.Size())
+	assert.Equal(t, 0, proxy.AvailableConnections.Size())
+	assert.Equal(t, 1, proxy.AvailableConnections.Cap())
+
+	// The pool is grown, and then the returned server connection isn't retired.
+	manager.Resize(2, 2)
+	require.Eventually(t, func() bool {
+		return manager.Size() == 2 && proxy.AvailableConnections.Size() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(t, proxy.Disconnect(conn))
+	assert.True(t, client.IsConnected())
+	assert.Equal(t, 2, proxy.AvailableConnections.Size())
+
+	// The returned server connection is closed while the pool is larger than its maximum size.
+	other, _ := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(conn))
+	require.Nil(t, proxy.Connect(other))
+	client = proxy.assignedClient(conn)
+	manager.Resize(1, 1)
+	assert.Equal(t, 2, manager.Size())
+	require.Nil(t, proxy.Disconnect(conn))
+	assert.False(t, client.IsConnected())
+	assert.Equal(t, 1, manager.Size())
+	require.Nil(t, proxy.Disconnect(other))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+}
+
+// TestPoolManagerUnreachable tests that the pool is filled in the background once the
+// server becomes reachable.
+func TestPoolManagerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	manager := NewPoolManager(context.Background(), PoolManager{
+		Pool:         pool.NewPool(context.Background(), 2),
+		ClientConfig: &config.Client{Network: "tcp", Address: address, DialTimeout: time.Second},
+		MinSize:      2,
+		MaxSize:      2,
+		Interval:     20 * time.Millisecond,
+		Logger:       zerolog.Nop(),
+	})
+	t.Cleanup(manager.Shutdown)
+	require.False(t, manager.Fill())
+	assert.False(t, manager.Filled())
+
+	listener, err = net.Listen("tcp", address)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var accepted []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range accepted {
+					conn.Close()
+				}
+				return
+			}
+			accepted = append(accepted, conn)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		<-done
+	})
+
+	require.Eventually(t, func() bool {
+		return manager.Pool.Size() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, manager.Size())
+	assert.True(t, manager.Filled())
+}
@@ -1,11 +1,13 @@
 package network
 
 import (
-	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"regexp"
 	"slices"
 	"time"
 
@@ -20,6 +22,7 @@ import (
 	"github.com/gatewayd-io/gatewayd/pool"
 	"github.com/getsentry/sentry-go"
 	"github.com/go-co-op/gocron"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cast"
 	"go.opentelemetry.io/otel"
@@ -37,6 +40,11 @@ type IProxy interface {
 	AvailableConnectionsString() []string
 	BusyConnectionsString() []string
 	GetName() string
+	IsBackendHealthy() bool
+	BusyConnectionsCount() int
+	Latency() time.Duration
+	Drain(conn *ConnWrapper) bool
+	CancelRequest(request []byte) bool
 }
 
 type Proxy struct {
@@ -49,6 +57,47 @@ type Proxy struct {
 	ctx                  context.Context //nolint:containedctx
 	PluginTimeout        time.Duration
 	HealthCheckPeriod    time.Duration
+	// PoolMode determines how long a server connection is assigned to an incoming
+	// connection, that is either for the whole session, a transaction or a statement.
+	PoolMode string
+	// Authenticator authenticates the incoming connections at the proxy, if set. Otherwise,
+	// the startup and authentication messages are passed through to the server connections.
+	Authenticator *Authenticator
+	// UserPools assigns the server connections from the pools of the databases and the
+	// users of the incoming connections, if set, instead of the available connections.
+	UserPools *UserPools
+	// CancelKeys issues the keys of the BackendKeyData messages to the incoming connections,
+	// so that their CancelRequests are sent to the server connections they are assigned to.
+	CancelKeys *CancelKeys
+	// ReadProxy receives the read-only statements that are sent outside of transactions,
+	// if set, in the transaction and statement pool modes. Everything else is sent to the
+	// server connections of this proxy.
+	ReadProxy *Proxy
+	// WriteFunctions are the names of the functions whose calls force the write path.
+	WriteFunctions []string
+	writeFunctions *regexp.Regexp
+	// HealthCheck actively probes the server of the proxy, if set, so that the load
+	// balancers skip the proxy while the server is unhealthy.
+	HealthCheck *HealthCheck
+	// RoleMonitor checks whether the server of the proxy is the primary or a standby, if set,
+	// so that the load balancers skip the proxy while its server is a standby.
+	RoleMonitor *RoleMonitor
+	// WaitQueue queues the incoming connections while the pool is exhausted, if set, instead
+	// of failing them right away. It doesn't apply to the per-user pools.
+	WaitQueue *WaitQueue
+	// PoolManager dials the server connections of the pool on demand, and closes the idle
+	// and aged ones, if set. Otherwise, the pool keeps the server connections it is filled with.
+	PoolManager *PoolManager
+	// CircuitBreaker fails the new incoming connections fast while the server is known to be down,
+	// if set, so that the load balancers skip the proxy. It records the outcome of the dials and
+	// the receives of the server connections.
+	CircuitBreaker *CircuitBreaker
+	// ResetStrategy resets the server connections that are released by the incoming connections,
+	// either by reconnecting them, or by running DISCARD ALL or the ResetQuery on them.
+	ResetStrategy string
+	ResetQuery    string
+	// latency is the moving average of the round-trip latency of the requests.
+	latency *latency
 
 	// ClientConfig is used for reconnection
 	ClientConfig *config.Client
@@ -65,6 +114,7 @@ func NewProxy(
 	defer span.End()
 
 	proxy := Proxy{
+		Name:                 pxy.Name,
 		AvailableConnections: pxy.AvailableConnections,
 		busyConnections:      pool.NewPool(proxyCtx, config.EmptyPoolCapacity),
 		Logger:               pxy.Logger,
@@ -74,6 +124,21 @@ func NewProxy(
 		PluginTimeout:        pxy.PluginTimeout,
 		ClientConfig:         pxy.ClientConfig,
 		HealthCheckPeriod:    pxy.HealthCheckPeriod,
+		PoolMode:             config.If(pxy.PoolMode != "", pxy.PoolMode, config.DefaultPoolMode),
+		Authenticator:        pxy.Authenticator,
+		UserPools:            pxy.UserPools,
+		CancelKeys:           config.If(pxy.CancelKeys != nil, pxy.CancelKeys, NewCancelKeys()),
+		ReadProxy:            pxy.ReadProxy,
+		WriteFunctions:       pxy.WriteFunctions,
+		writeFunctions:       compileWriteFunctions(pxy.WriteFunctions),
+		HealthCheck:          pxy.HealthCheck,
+		RoleMonitor:          pxy.RoleMonitor,
+		WaitQueue:            pxy.WaitQueue,
+		PoolManager:          pxy.PoolManager,
+		CircuitBreaker:       pxy.CircuitBreaker,
+		ResetStrategy:        config.If(pxy.ResetStrategy != "", pxy.ResetStrategy, config.DefaultResetStrategy),
+		ResetQuery:           pxy.ResetQuery,
+		latency:              newLatency(),
 	}
 
 	startDelay := time.Now().Add(proxy.HealthCheckPeriod)
@@ -84,8 +149,14 @@ func NewProxy(
 			proxy.Logger.Trace().Msg("Running the client health check to recycle connection(s).")
 			proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
 				if client, ok := value.(*Client); ok {
+					// Authenticated connections are shared between the incoming connections,
+					// so they are not subject to the authentication timeout.
+					if client.IsAuthenticated() {
+						return true
+					}
 					// Connection is probably dead by now.
 					proxy.AvailableConnections.Remove(client.ID)
+					proxy.PoolManager.forget(client)
 					client.Close()
 					// Create a new client.
 					client = NewClient(
@@ -100,6 +171,7 @@ func NewProxy(
 								),
 								BackoffMultiplier:  proxy.ClientConfig.BackoffMultiplier,
 								DisableBackoffCaps: proxy.ClientConfig.DisableBackoffCaps,
+								CircuitBreaker:     proxy.CircuitBreaker,
 								Logger:             proxy.Logger,
 							},
 						),
@@ -109,7 +181,10 @@ func NewProxy(
 							proxy.Logger.Err(err).Msg("Failed to update the client connection")
 							// Close the client, because we don't want to have orphaned connections.
 							client.Close()
+						} else {
+							proxy.PoolManager.track(client)
 						}
+						proxy.WaitQueue.notify()
 					} else {
 						proxy.Logger.Error().Msg("Failed to create a new client connection")
 					}
@@ -126,6 +201,28 @@ func NewProxy(
 		span.RecordError(err)
 	}
 
+	// Schedule the active health checks of the server.
+	if proxy.HealthCheck != nil {
+		if _, err := proxy.scheduler.Every(proxy.HealthCheck.Interval).SingletonMode().Do(
+			proxy.HealthCheck.Check,
+		); err != nil {
+			proxy.Logger.Error().Err(err).Msg("Failed to schedule the health check of the server")
+			sentry.CaptureException(err)
+			span.RecordError(err)
+		}
+	}
+
+	// Schedule the role checks of the server.
+	if proxy.RoleMonitor != nil {
+		if _, err := proxy.scheduler.Every(proxy.RoleMonitor.Interval).SingletonMode().Do(
+			proxy.checkRole,
+		); err != nil {
+			proxy.Logger.Error().Err(err).Msg("Failed to schedule the role checks of the server")
+			sentry.CaptureException(err)
+			span.RecordError(err)
+		}
+	}
+
 	// Start the scheduler.
 	proxy.scheduler.StartAsync()
 	proxy.Logger.Info().Fields(
@@ -143,38 +240,47 @@ func (pr *Proxy) GetName() string {
 }
 
 // Connect maps a server connection from the available connection pool to a incoming connection.
-// It returns an error if the pool is exhausted.
+// It waits in the wait queue, if any, while the pool is exhausted, and returns an error if the
+// pool is still exhausted. It fails right away while the circuit breaker is open.
 func (pr *Proxy) Connect(conn *ConnWrapper) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Connect")
 	defer span.End()
 
-	var clientID string
-	// Get the first available client from the pool.
-	pr.AvailableConnections.ForEach(func(key, _ interface{}) bool {
-		if cid, ok := key.(string); ok {
-			clientID = cid
-			return false // stop the loop.
-		}
-		return true
-	})
+	// Fail fast while the server is known to be down, instead of waiting for the dials.
+	if !pr.CircuitBreaker.Ready() {
+		span.RecordError(gerr.ErrCircuitOpen)
+		return gerr.ErrCircuitOpen
+	}
 
-	var client *Client
-	if pr.IsExhausted() {
-		// Pool is exhausted
-		span.AddEvent(gerr.ErrPoolExhausted.Error())
-		return gerr.ErrPoolExhausted
+	if pr.PoolMode != config.SessionPoolMode || pr.UserPools != nil {
+		return pr.connectSession(conn)
 	}
-	// Get the client from the pool with the given clientID.
-	if cl, ok := pr.AvailableConnections.Pop(clientID).(*Client); ok {
-		client = cl
+
+	// Get the first available client from the pool.
+	client, err := pr.acquireAvailable(pr.popFirstClient, false)
+	if err != nil {
+		// Pool is exhausted
+		span.AddEvent(err.Error())
+		return err
 	}
 
-	client, err := pr.IsHealthy(client)
+	client, err = pr.IsHealthy(client)
 	if err != nil {
 		pr.Logger.Error().Err(err).Msg("Failed to connect to the client")
 		span.RecordError(err)
 	}
 
+	// The server connection is dedicated to the incoming connection in the session pool mode,
+	// so it is reconnected with the addresses of the client in the PROXY protocol header.
+	if client != nil && sendsProxyProtocol(client.ProxyProtocol) {
+		client.setProxyProtocolAddrs(conn.RemoteAddr(), conn.LocalAddr())
+		if err := client.Reconnect(); err != nil {
+			pr.Logger.Error().Err(err).Msg("Failed to reconnect with the PROXY protocol header")
+			span.RecordError(err)
+		}
+	}
+
+	conn.activity = newSession()
 	if err := pr.busyConnections.Put(conn, client); err != nil {
 		// This should never happen.
 		span.RecordError(err)
@@ -215,6 +321,9 @@ func (pr *Proxy) Disconnect(conn *ConnWrapper) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Disconnect")
 	defer span.End()
 
+	pr.CancelKeys.revoke(conn)
+	pr.latency.forget(conn)
+
 	client := pr.busyConnections.Pop(conn)
 	if client == nil {
 		// If this ever happens, it means that the client connection
@@ -224,19 +333,31 @@ func (pr *Proxy) Disconnect(conn *ConnWrapper) *gerr.GatewayDError {
 		return gerr.ErrClientNotFound
 	}
 
-	if client, ok := client.(*Client); ok {
-		// Recycle the server connection by reconnecting.
-		if err := client.Reconnect(); err != nil {
-			pr.Logger.Error().Err(err).Msg("Failed to reconnect to the client")
-			span.RecordError(err)
-		}
+	switch value := client.(type) {
+	case *Client:
+		// Recycle the server connection by resetting it, unless the pool manager retires it.
+		if !pr.PoolManager.retire(value) {
+			value.setProxyProtocolAddrs(nil, nil)
+			if err := pr.resetClient(value, true, false); err != nil {
+				pr.Logger.Error().Err(err).Msg("Failed to reconnect to the client")
+				span.RecordError(err)
+			}
 
-		// If the client is not in the pool, put it back.
-		if err := pr.AvailableConnections.Put(client.ID, client); err != nil {
-			pr.Logger.Error().Err(err).Msg("Failed to put the client back in the pool")
-			span.RecordError(err)
+			// If the client is not in the pool, put it back.
+			pr.putClient(value)
 		}
-	} else {
+	case *session:
+		// The server connection is still assigned to the session if the incoming connection
+		// is closed in the middle of a transaction or during the startup phase, so it must
+		// be recycled before it is shared with other incoming connections.
+		if client, synced, inTransaction := value.close(); client != nil {
+			if err := pr.resetClient(client, synced, inTransaction); err != nil {
+				pr.Logger.Error().Err(err).Msg("Failed to reconnect to the client")
+				span.RecordError(err)
+			}
+			pr.putSessionClient(client, value.readOnly)
+		}
+	default:
 		// This should never happen, but if it does,
 		// then there are some serious issues with the pool.
 		pr.Logger.Error().Msg("Failed to cast the client to the Client type")
@@ -268,29 +389,109 @@ func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.Gate
 	defer span.End()
 
 	var client *Client
+	var sess *session
 	// Check if the proxy has a egress client for the incoming connection.
 	if pr.busyConnections.Get(conn) == nil {
 		span.RecordError(gerr.ErrClientNotFound)
 		return gerr.ErrClientNotFound
 	}
 
-	// Get the client from the busy connection pool.
-	if cl, ok := pr.busyConnections.Get(conn).(*Client); ok {
-		client = cl
-	} else {
+	// Get the client or the session from the busy connection pool.
+	switch value := pr.busyConnections.Get(conn).(type) {
+	case *Client:
+		client = value
+	case *session:
+		sess = value
+	default:
 		span.RecordError(gerr.ErrCastFailed)
 		return gerr.ErrCastFailed
 	}
 	span.AddEvent("Got the client from the busy connection pool")
 
-	if !client.IsConnected() {
+	if sess == nil && !client.IsConnected() {
 		return gerr.ErrClientNotConnected
 	}
 
 	// Receive the request from the client.
-	request, origErr := pr.receiveTrafficFromClient(conn.Conn())
+	request, origErr := pr.receiveTrafficFromClient(conn)
 	span.AddEvent("Received traffic from client")
 
+	// The CancelRequest is sent on a new connection, which is closed afterwards.
+	if origErr == nil && isCancelRequest(request) {
+		if !pr.CancelRequest(request) {
+			pr.Logger.Debug().Msg("Ignored a cancel request with an unknown key")
+		}
+		span.AddEvent("Forwarded the cancel request")
+		return gerr.ErrClientNotConnected
+	}
+
+	// The requests to the dedicated server connection are tracked like the ones of a session,
+	// so that the incoming connection isn't drained while they are sent.
+	if sess == nil && conn.activity != nil {
+		if !conn.activity.hold() {
+			span.AddEvent("Client connection is drained")
+			return gerr.ErrClientNotConnected
+		}
+		defer conn.activity.unhold()
+	}
+
+	// The incoming connection must be authenticated by the proxy before its requests are
+	// sent to the server connection, which is already authenticated with other credentials.
+	if origErr == nil && pr.Authenticator != nil &&
+		!conn.authenticated && !postgres.IsPostgresSSLRequest(request) {
+		return pr.authenticate(conn, client, sess, request)
+	}
+
+	// The server connection of the session is picked from the pool of the database and the
+	// user of the StartupMessage, on which the incoming connection goes through the startup phase.
+	if origErr == nil && sess != nil && pr.UserPools != nil &&
+		sess.key == nil && !postgres.IsPostgresSSLRequest(request) {
+		if handled, err := pr.connectUserPool(conn, sess, request); handled || err != nil {
+			return err
+		}
+	}
+
+	// Assign a server connection to the session, if it doesn't have one already.
+	// The SSL request is answered by the proxy, so it doesn't need one.
+	if sess != nil && origErr == nil && !postgres.IsPostgresSSLRequest(request) {
+		if isTerminateRequest(request) {
+			// The server connection is shared, so it must not receive the Terminate message.
+			span.AddEvent("Client terminated the session")
+			return gerr.ErrClientNotConnected
+		}
+
+		// Transaction blocks would keep the server connection assigned to the session.
+		if pr.PoolMode == config.StatementPoolMode && isTransactionRequest(request) {
+			if rejected, err := pr.rejectTransaction(conn, sess, request); rejected || err != nil {
+				span.RecordError(gerr.ErrTransactionNotAllowed)
+				return err
+			}
+		}
+
+		// Read-only requests are sent to the read proxy, if any.
+		readOnly := false
+		if pr.splitsReads() {
+			sess.mu.Lock()
+			readOnly = sess.isReadOnlyRequest(request, pr.writeFunctions)
+			sess.mu.Unlock()
+		}
+
+		var err *gerr.GatewayDError
+		if client, err = pr.holdClient(sess, readOnly); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		defer pr.unholdClient(sess)
+		span.AddEvent("Assigned a server connection to the session")
+	}
+
+	// The server connection is reset when the incoming connection is closed, so it must not
+	// receive the Terminate message.
+	if sess == nil && origErr == nil && isTerminateRequest(request) && pr.canReset(client) {
+		span.AddEvent("Client terminated the session")
+		return gerr.ErrClientNotConnected
+	}
+
 	// Run the OnTrafficFromClient hooks.
 	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), pr.PluginTimeout)
 	defer cancel()
@@ -314,12 +515,18 @@ func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.Gate
 	}
 	span.AddEvent("Ran the OnTrafficFromClient hooks")
 
-	if origErr != nil && errors.Is(origErr, io.EOF) {
-		// Client closed the connection.
+	if origErr != nil && (errors.Is(origErr, io.EOF) || errors.Is(origErr, net.ErrClosed)) {
+		// Client closed the connection, or it is closed by the proxy.
 		span.AddEvent("Client closed the connection")
 		return gerr.ErrClientNotConnected.Wrap(origErr)
 	}
 
+	if origErr != nil && sess != nil {
+		// The partial request can't be sent to a shared server connection.
+		span.RecordError(origErr)
+		return origErr
+	}
+
 	// Check if the client sent a SSL request and the server supports SSL.
 	//nolint:nestif
 	if conn.IsTLSEnabled() && postgres.IsPostgresSSLRequest(request) {
@@ -433,8 +640,27 @@ func (pr *Proxy) PassThroughToServer(conn *ConnWrapper, stack *Stack) *gerr.Gate
 
 	stack.UpdateLastRequest(&Request{Data: request})
 
-	// Send the request to the server.
+	// Track the request before sending it, so that the response is expected.
+	if sess != nil {
+		request = pr.rewriteStatements(sess, client, request)
+
+		sess.mu.Lock()
+		sess.trackRequest(request)
+		sess.cond.Broadcast()
+		sess.mu.Unlock()
+	} else if conn.activity != nil {
+		conn.activity.mu.Lock()
+		conn.activity.trackRequest(request)
+		conn.activity.mu.Unlock()
+	}
+
+	// Send the request to the server. The time is taken beforehand, since the response
+	// might be received before the request is sent in full.
+	pr.latency.sent(conn)
 	_, err = pr.sendTrafficToServer(client, request)
+	if err != nil {
+		pr.latency.forget(conn)
+	}
 	span.AddEvent("Sent traffic to server")
 
 	pluginTimeoutCtx, cancel = context.WithTimeout(context.Background(), pr.PluginTimeout)
@@ -471,21 +697,35 @@ func (pr *Proxy) PassThroughToClient(conn *ConnWrapper, stack *Stack) *gerr.Gate
 	defer span.End()
 
 	var client *Client
+	var sess *session
 	// Check if the proxy has a egress client for the incoming connection.
 	if pr.busyConnections.Get(conn) == nil {
 		span.RecordError(gerr.ErrClientNotFound)
 		return gerr.ErrClientNotFound
 	}
 
-	// Get the client from the busy connection pool.
-	if cl, ok := pr.busyConnections.Get(conn).(*Client); ok {
-		client = cl
-	} else {
+	// Get the client or the session from the busy connection pool.
+	switch value := pr.busyConnections.Get(conn).(type) {
+	case *Client:
+		client = value
+	case *session:
+		sess = value
+	default:
 		span.RecordError(gerr.ErrCastFailed)
 		return gerr.ErrCastFailed
 	}
 	span.AddEvent("Got the client from the busy connection pool")
 
+	// Wait until a server connection is assigned to the session and a response is expected.
+	if sess != nil {
+		var err *gerr.GatewayDError
+		if client, err = pr.waitForClient(sess); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		span.AddEvent("Got the server connection of the session")
+	}
+
 	if !client.IsConnected() {
 		return gerr.ErrClientNotConnected
 	}
@@ -493,6 +733,51 @@ func (pr *Proxy) PassThroughToClient(conn *ConnWrapper, stack *Stack) *gerr.Gate
 	// Receive the response from the server.
 	received, response, err := pr.receiveTrafficFromServer(client)
 	span.AddEvent("Received traffic from server")
+	if err == nil {
+		pr.latency.received(conn)
+	}
+
+	// The dedicated server connection is tracked like a session, until the response is sent.
+	if sess == nil && err == nil && conn.activity != nil {
+		conn.activity.respond(response[:received])
+		defer conn.activity.responded()
+	}
+
+	// Return the server connection to the pool as soon as the session is idle.
+	if sess != nil && err == nil {
+		response = pr.releaseClient(sess, client, response[:received])
+		defer sess.responded()
+		received = len(response)
+		if received == 0 {
+			// The response only consists of the responses to the injected messages.
+			span.AddEvent("No data to send to client")
+			return nil
+		}
+
+		if pr.PoolMode == config.StatementPoolMode && sess.inTransaction() {
+			// The transaction block is started by a statement that is not detected beforehand,
+			// e.g. in a multi-statement query. The incoming connection is closed, so that the
+			// server connection is recycled on disconnect.
+			span.RecordError(gerr.ErrTransactionNotAllowed)
+			if err := pr.sendErrorResponse(conn, &ErrorResponse{
+				Code:     gerr.ErrCodeTransactionNotAllowed,
+				SQLState: FeatureNotSupportedCode,
+				Message:  gerr.ErrTransactionNotAllowed.Message,
+				Detail:   errorDetail(gerr.ErrTransactionNotAllowed),
+			}); err != nil {
+				span.RecordError(err)
+			}
+			stack.PopLastRequest()
+			return gerr.ErrTransactionNotAllowed
+		}
+	}
+
+	// The BackendKeyData of the server connection is replaced with a key of the proxy. The
+	// incoming connections that are authenticated by the proxy get their key from the proxy.
+	if err == nil && pr.Authenticator == nil && !conn.startupResponded {
+		response = pr.replaceBackendKeyData(conn, client, response[:received])
+		received = len(response)
+	}
 
 	// If the response is empty, don't send anything, instead just close the ingress connection.
 	if received == 0 || err != nil {
@@ -612,11 +897,132 @@ func (pr *Proxy) IsHealthy(client *Client) (*Client, *gerr.GatewayDError) {
 	return client, nil
 }
 
+// IsBackendHealthy returns false if the server of the proxy fails the active health checks,
+// if the role monitor found that it is a standby, or while the circuit breaker is open.
+// The proxy is always healthy if the health checks are disabled.
+func (pr *Proxy) IsBackendHealthy() bool {
+	return (pr.HealthCheck == nil || pr.HealthCheck.IsHealthy()) &&
+		(pr.RoleMonitor == nil || !pr.RoleMonitor.IsStandby()) && pr.CircuitBreaker.Ready()
+}
+
+// checkRole checks the role of the server of the proxy. If the server has become a standby, the
+// incoming connections are closed by the kill failover action, and are left to finish their
+// sessions otherwise. The role change is then reported.
+func (pr *Proxy) checkRole() {
+	change, changed := pr.RoleMonitor.Check()
+	if !changed {
+		return
+	}
+
+	if change.Current == RoleStandby && pr.RoleMonitor.OnFailover == config.FailoverKill {
+		closed := pr.closeBusyConnections()
+		pr.Logger.Info().Fields(
+			map[string]interface{}{
+				"proxy": pr.Name,
+				"count": closed,
+			},
+		).Msg("Closed the client connections of the proxy whose server is a standby")
+	}
+	if pr.RoleMonitor.OnChange != nil {
+		pr.RoleMonitor.OnChange(change)
+	}
+}
+
+// closeBusyConnections closes the incoming connections of the proxy, which are then disconnected
+// from their server connections by the server. It returns the number of closed connections.
+func (pr *Proxy) closeBusyConnections() int {
+	closed := 0
+	pr.busyConnections.ForEach(func(key, _ interface{}) bool {
+		if conn, ok := key.(*ConnWrapper); ok {
+			if err := conn.Close(); err != nil {
+				pr.Logger.Debug().Err(err).Msg("Failed to close the client connection")
+			}
+			closed++
+		}
+		return true
+	})
+	return closed
+}
+
+// Terminate closes the incoming connections of the proxy, after telling the clients that the
+// server is shutting down, regardless of their transactions. The connections are then
+// disconnected from their server connections by the server. It returns the number of closed
+// connections.
+func (pr *Proxy) Terminate() int {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Terminate")
+	defer span.End()
+
+	closed := 0
+	pr.busyConnections.ForEach(func(key, _ interface{}) bool {
+		if conn, ok := key.(*ConnWrapper); ok {
+			if err := pr.sendErrorResponse(conn, &ErrorResponse{
+				SQLState: AdminShutdownCode,
+				Message:  "terminating connection due to administrator command",
+			}); err != nil {
+				span.RecordError(err)
+			}
+			if err := conn.Close(); err != nil {
+				pr.Logger.Debug().Err(err).Msg("Failed to close the client connection")
+			}
+			closed++
+		}
+		return true
+	})
+	return closed
+}
+
+// Drain closes the incoming connection if it is between transactions, after telling the client
+// that the server is shutting down, like PostgreSQL does on a fast shutdown. It returns true if
+// the connection is closed, while the connections in a transaction are left to finish it.
+func (pr *Proxy) Drain(conn *ConnWrapper) bool {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Drain")
+	defer span.End()
+
+	sess, ok := pr.busyConnections.Get(conn).(*session)
+	if !ok {
+		sess = conn.activity
+	}
+	if sess == nil || !sess.drain() {
+		return false
+	}
+
+	if err := pr.sendErrorResponse(conn, &ErrorResponse{
+		SQLState: AdminShutdownCode,
+		Message:  "terminating connection due to administrator command",
+	}); err != nil {
+		span.RecordError(err)
+	}
+
+	// The pending read of the next request fails, so the incoming connection is closed by the
+	// server, which recycles its server connection.
+	if err := conn.Conn().SetReadDeadline(time.Now()); err != nil {
+		pr.Logger.Debug().Err(err).Msg("Failed to interrupt the client connection")
+		span.RecordError(err)
+	}
+
+	pr.Logger.Debug().Str("remote", RemoteAddr(conn.Conn())).Msg(
+		"Closed the client connection between transactions")
+
+	return true
+}
+
+// BusyConnectionsCount returns the number of incoming connections that are served by the proxy.
+func (pr *Proxy) BusyConnectionsCount() int {
+	return pr.busyConnections.Size()
+}
+
+// Latency returns the moving average of the round-trip latency of the requests, that is
+// the time between sending a request to the server and receiving the first response.
+func (pr *Proxy) Latency() time.Duration {
+	return pr.latency.value()
+}
+
 // IsExhausted checks if the available connection pool is exhausted.
 func (pr *Proxy) IsExhausted() bool {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "IsExhausted")
 	defer span.End()
-	return pr.AvailableConnections.Size() == 0 && pr.AvailableConnections.Cap() > 0
+	return pr.AvailableConnections.Size() == 0 && pr.AvailableConnections.Cap() > 0 &&
+		!pr.PoolManager.canGrow()
 }
 
 // Shutdown closes all connections and clears the connection pools.
@@ -624,6 +1030,7 @@ func (pr *Proxy) Shutdown() {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "Shutdown")
 	defer span.End()
 
+	pr.PoolManager.Shutdown()
 	pr.AvailableConnections.ForEach(func(_, value interface{}) bool {
 		if client, ok := value.(*Client); ok {
 			if client.IsConnected() {
@@ -652,9 +1059,29 @@ func (pr *Proxy) Shutdown() {
 				client.Close()
 			}
 		}
+		if sess, ok := value.(*session); ok {
+			if client, _, _ := sess.close(); client != nil {
+				client.Close()
+			}
+		}
 		return true
 	})
 	pr.busyConnections.Clear()
+
+	if pr.Authenticator != nil && pr.Authenticator.AuthPool != nil {
+		pr.Authenticator.AuthPool.ForEach(func(_, value interface{}) bool {
+			if client, ok := value.(*Client); ok && client.IsConnected() {
+				client.Close()
+			}
+			return true
+		})
+		pr.Authenticator.AuthPool.Clear()
+	}
+
+	if pr.UserPools != nil {
+		pr.UserPools.Shutdown()
+	}
+
 	pr.scheduler.Stop()
 	pr.scheduler.Clear()
 	pr.Logger.Debug().Msg("All busy connections have been closed")
@@ -673,6 +1100,11 @@ func (pr *Proxy) AvailableConnectionsString() []string {
 		}
 		return true
 	})
+	if pr.UserPools != nil {
+		for _, cl := range pr.UserPools.idleClients() {
+			connections = append(connections, cl.LocalAddr())
+		}
+	}
 	return connections
 }
 
@@ -693,44 +1125,30 @@ func (pr *Proxy) BusyConnectionsString() []string {
 }
 
 // receiveTrafficFromClient is a function that waits to receive data from the client.
-func (pr *Proxy) receiveTrafficFromClient(conn net.Conn) ([]byte, *gerr.GatewayDError) {
+// The data consists of whole PostgreSQL messages, so that the hooks and the stack never
+// receive truncated or merged messages.
+func (pr *Proxy) receiveTrafficFromClient(conn *ConnWrapper) ([]byte, *gerr.GatewayDError) {
 	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "receiveTrafficFromClient")
 	defer span.End()
 
 	// request contains the data from the client.
-	received := 0
-	buffer := bytes.NewBuffer(nil)
-	for {
-		chunk := make([]byte, pr.ClientConfig.ReceiveChunkSize)
-		read, err := conn.Read(chunk)
-		if read == 0 || err != nil {
-			pr.Logger.Debug().Err(err).Msg("Error reading from client")
-			span.RecordError(err)
-
-			metrics.BytesReceivedFromClient.Observe(float64(read))
-			metrics.TotalTrafficBytes.Observe(float64(read))
-
-			return chunk[:read], gerr.ErrReadFailed.Wrap(err)
-		}
-
-		received += read
-		buffer.Write(chunk[:read])
+	request, err := conn.ReadMessages(pr.ClientConfig.ReceiveChunkSize)
+	if err != nil {
+		pr.Logger.Debug().Err(err).Msg("Error reading from client")
+		span.RecordError(err)
 
-		if received == 0 || received < pr.ClientConfig.ReceiveChunkSize {
-			break
-		}
+		metrics.BytesReceivedFromClient.Observe(float64(len(request)))
+		metrics.TotalTrafficBytes.Observe(float64(len(request)))
 
-		if !pr.isConnectionHealthy(conn) {
-			break
-		}
+		return request, gerr.ErrReadFailed.Wrap(err)
 	}
 
-	length := len(buffer.Bytes())
+	length := len(request)
 	pr.Logger.Debug().Fields(
 		map[string]interface{}{
 			"length": length,
-			"local":  LocalAddr(conn),
-			"remote": RemoteAddr(conn),
+			"local":  LocalAddr(conn.Conn()),
+			"remote": RemoteAddr(conn.Conn()),
 		},
 	).Msg("Received data from client")
 
@@ -739,7 +1157,7 @@ func (pr *Proxy) receiveTrafficFromClient(conn net.Conn) ([]byte, *gerr.GatewayD
 	metrics.BytesReceivedFromClient.Observe(float64(length))
 	metrics.TotalTrafficBytes.Observe(float64(length))
 
-	return buffer.Bytes(), nil
+	return request, nil
 }
 
 // sendTrafficToServer is a function that sends data to the server.
@@ -782,6 +1200,11 @@ func (pr *Proxy) receiveTrafficFromServer(client *Client) (int, []byte, *gerr.Ga
 
 	// Receive the response from the server.
 	received, response, err := client.Receive()
+	if err == nil {
+		pr.CircuitBreaker.Success()
+	} else if isServerFailure(err) {
+		pr.CircuitBreaker.Failure()
+	}
 
 	fields := map[string]interface{}{
 		"function": "proxy.passthrough",
@@ -928,16 +1351,726 @@ func (pr *Proxy) getPluginModifiedResponse(result map[string]interface{}) ([]byt
 	return nil, 0
 }
 
-func (pr *Proxy) isConnectionHealthy(conn net.Conn) bool {
-	if n, err := conn.Read([]byte{}); n == 0 && err != nil {
-		pr.Logger.Debug().Fields(
-			map[string]interface{}{
-				"remote": RemoteAddr(conn),
-				"local":  LocalAddr(conn),
-				"reason": "read 0 bytes",
-			}).Msg("Connection to client is closed")
+// connectSession creates a session for the incoming connection and assigns a server
+// connection to it for the startup phase. The server connection is returned to the pool
+// when the session becomes idle, and is then shared with other incoming connections.
+func (pr *Proxy) connectSession(conn *ConnWrapper) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "connectSession")
+	defer span.End()
+
+	sess := newSession()
+	// In the session pool mode, the server connection of a per-user pool
+	// stays assigned to the session until the incoming connection is closed.
+	sess.pinned = pr.UserPools != nil && pr.PoolMode == config.SessionPoolMode
+	if pr.Authenticator != nil || pr.UserPools != nil {
+		// The incoming connection is either authenticated by the proxy, or its server
+		// connection depends on its StartupMessage, so a server connection is only
+		// assigned to the session on the first request.
+		if err := pr.busyConnections.Put(conn, sess); err != nil {
+			span.RecordError(err)
+			return err
+		}
+
+		metrics.ProxiedConnections.Inc()
+		return nil
+	}
+
+	// The incoming connection goes through the startup phase, so an unauthenticated
+	// server connection is preferred. Otherwise, an authenticated one is recycled.
+	client, err := pr.acquireAvailable(func() *Client {
+		if client := pr.popClient(false); client != nil {
+			return client
+		}
+		return pr.popClient(true)
+	}, false)
+	if err != nil {
+		// Pool is exhausted
+		span.AddEvent(err.Error())
+		return err
+	}
+	if client.IsAuthenticated() {
+		if err := client.Reconnect(); err != nil {
+			pr.Logger.Error().Err(err).Msg("Failed to reconnect to the client")
+			span.RecordError(err)
+			pr.putClient(client)
+			return gerr.ErrClientConnectionFailed.Wrap(err)
+		}
+	}
+
+	sess.client = client
+	if err := pr.busyConnections.Put(conn, sess); err != nil {
+		// This should never happen.
+		span.RecordError(err)
+		pr.putClient(client)
+		return err
+	}
+
+	metrics.ProxiedConnections.Inc()
+
+	fields := map[string]interface{}{
+		"function": "proxy.connect",
+		"client":   "unknown",
+		"server":   RemoteAddr(conn.Conn()),
+		"poolMode": pr.PoolMode,
+	}
+	if client.ID != "" {
+		fields["client"] = client.ID[:7]
+	}
+	pr.Logger.Debug().Fields(fields).Msg("Client has been assigned for the startup phase")
+
+	return nil
+}
+
+// resetClient resets the state of the server connection that is released by an incoming
+// connection, before it is shared with other incoming connections. The reset query of the
+// reset strategy is run on the server connection, after rolling back the transaction, if
+// inTransaction is true. The server connection is reconnected instead if the strategy is
+// reconnect, if it isn't synced or if the reset fails. It is always reconnected if the incoming
+// connection went through the startup phase on it, or if it sends the PROXY protocol header.
+func (pr *Proxy) resetClient(client *Client, synced, inTransaction bool) error {
+	if synced && pr.canReset(client) && client.IsConnected() {
+		err := client.reset(pr.resetQuery(), inTransaction)
+		if err == nil {
+			return nil
+		}
+		pr.Logger.Debug().Err(err).Str("strategy", pr.ResetStrategy).Msg(
+			"Failed to reset the server connection, reconnecting")
+	}
+
+	return client.Reconnect()
+}
+
+// canReset returns true if the server connection is reset by the reset strategy instead of
+// being reconnected.
+func (pr *Proxy) canReset(client *Client) bool {
+	return pr.resetQuery() != "" && client.IsAuthenticated() && !sendsProxyProtocol(client.ProxyProtocol)
+}
+
+// resetQuery returns the query of the reset strategy, or an empty string if the server
+// connections are reconnected.
+func (pr *Proxy) resetQuery() string {
+	switch pr.ResetStrategy {
+	case config.ResetDiscardAll:
+		return "DISCARD ALL"
+	case config.ResetQuery:
+		return pr.ResetQuery
+	default:
+		return ""
+	}
+}
+
+// authenticate authenticates the incoming connection by the proxy. The StartupMessage
+// is answered by the proxy, with the run-time parameters of the server connections.
+func (pr *Proxy) authenticate(
+	conn *ConnWrapper, client *Client, sess *session, request []byte,
+) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "authenticate")
+	defer span.End()
+
+	if !isStartupMessage(request) {
+		if err := pr.answerBeforeStartup(conn, request); err != nil {
+			span.RecordError(gerr.ErrAuthenticationFailed)
+			return gerr.ErrAuthenticationFailed.Wrap(err)
+		}
+		return nil
+	}
+
+	login, err := pr.Authenticator.Authenticate(conn, request)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	parameters := client.ServerParameters()
+	if parameters == nil {
+		parameters = pr.serverParameters()
+	}
+
+	if pr.UserPools != nil && sess != nil {
+		// The server connections of the pool are authenticated as the user of the login.
+		key := newPoolKey(login.Parameters)
+		pr.UserPools.setCredentials(key, login.credentials)
+		if pr.splitsReads() && pr.ReadProxy.UserPools != nil {
+			pr.ReadProxy.UserPools.setCredentials(key, login.credentials)
+		}
+		server, err := pr.UserPools.acquire(key, true)
+		if err != nil {
+			span.RecordError(err)
+			pr.sendConnectionError(conn, key, err)
+			return err
+		}
+		parameters = server.ServerParameters()
+
+		sess.mu.Lock()
+		sess.key = &key
+		if sess.pinned {
+			sess.client = server
+			server = nil
+		}
+		sess.mu.Unlock()
+		pr.putClient(server)
+	}
+
+	backendKey, keyErr := pr.CancelKeys.issue(pr, conn)
+	if keyErr != nil {
+		span.RecordError(keyErr)
+		return gerr.ErrAuthenticationFailed.Wrap(keyErr)
+	}
+
+	if err := pr.Authenticator.Complete(conn, login, parameters, backendKey); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	conn.authenticated = true
+
+	if sess != nil {
+		// The session is ready for queries, without a server connection.
+		sess.mu.Lock()
+		sess.startup = false
+		sess.cond.Broadcast()
+		sess.mu.Unlock()
+	} else if conn.activity != nil {
+		conn.activity.mu.Lock()
+		conn.activity.startup = false
+		conn.activity.mu.Unlock()
+	}
+
+	return nil
+}
+
+// replaceBackendKeyData records the BackendKeyData of the server connection in the startup
+// response, and replaces it with a key that is issued by the proxy to the incoming connection,
+// since the server connection might be shared with other incoming connections.
+func (pr *Proxy) replaceBackendKeyData(conn *ConnWrapper, client *Client, response []byte) []byte {
+	replaced := response[:0:0]
+	forEachMessage(response, func(msgType byte, body []byte) bool {
+		switch msgType {
+		case BackendKeyDataMessage:
+			var serverKey pgproto3.BackendKeyData
+			if err := serverKey.Decode(body); err != nil {
+				break
+			}
+			client.setBackendKey(serverKey)
+
+			key, err := pr.CancelKeys.issue(pr, conn)
+			if err != nil {
+				pr.Logger.Error().Err(err).Msg("Failed to issue a key to the client")
+				break
+			}
+			if message, err := key.Encode(nil); err == nil {
+				replaced = append(replaced, message...)
+				return true
+			}
+		case ReadyForQueryMessage:
+			// No BackendKeyData is sent after the startup phase.
+			conn.startupResponded = true
+		}
+		replaced = append(replaced, encodeMessage(msgType, body)...)
+		return true
+	})
+	return replaced
+}
+
+// CancelRequest forwards the CancelRequest to the server connection that is currently
+// assigned to the incoming connection with the key of the request, if any. The key is
+// looked up in the keys of the proxy, which might be shared with other proxies, and the
+// request is sent to the server of the proxy that the key is issued by, on a new connection,
+// so no server connection of the pool is used. It returns false if the key isn't found.
+func (pr *Proxy) CancelRequest(request []byte) bool {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "CancelRequest")
+	defer span.End()
+
+	var message pgproto3.CancelRequest
+	if err := message.Decode(request[UntypedHeaderLength:]); err != nil {
+		span.RecordError(err)
 		return false
 	}
 
+	target, ok := pr.CancelKeys.lookup(pgproto3.BackendKeyData{
+		ProcessID: message.ProcessID,
+		SecretKey: message.SecretKey,
+	})
+	if !ok {
+		return false
+	}
+
+	// The incoming connection has no query in progress if it has no server connection.
+	client := target.proxy.assignedClient(target.conn)
+	if client == nil {
+		return true
+	}
+	if err := client.cancelRequest(); err != nil {
+		pr.Logger.Error().Err(err).Msg("Failed to cancel the request")
+		span.RecordError(err)
+		return true
+	}
+
+	pr.Logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.CancelRequest",
+			"client":   RemoteAddr(target.conn.Conn()),
+			"server":   client.RemoteAddr(),
+		},
+	).Msg("Forwarded the cancel request to the server")
+
 	return true
 }
+
+// assignedClient returns the server connection that is assigned to the incoming connection.
+func (pr *Proxy) assignedClient(conn *ConnWrapper) *Client {
+	switch value := pr.busyConnections.Get(conn).(type) {
+	case *Client:
+		return value
+	case *session:
+		value.mu.Lock()
+		defer value.mu.Unlock()
+		return value.client
+	default:
+		return nil
+	}
+}
+
+// answerBeforeStartup answers the messages that the client sends before the StartupMessage,
+// when the proxy handles the startup phase instead of the server. GSSAPI encryption is
+// declined, so that the client falls back to another one, and anything else is rejected.
+func (pr *Proxy) answerBeforeStartup(conn *ConnWrapper, request []byte) *gerr.GatewayDError {
+	if len(request) >= UntypedHeaderLength+4 &&
+		binary.BigEndian.Uint32(request[UntypedHeaderLength:]) == GSSENCRequestCode {
+		if _, err := conn.Write([]byte{'N'}); err != nil {
+			return gerr.ErrServerSendFailed.Wrap(err)
+		}
+		return nil
+	}
+
+	// The client must not skip the startup phase.
+	if err := pr.sendErrorResponse(conn, &ErrorResponse{
+		Code:     gerr.ErrCodeInvalidMessage,
+		SQLState: ProtocolViolationCode,
+		Message:  "expected a startup message",
+		Detail:   errorDetail(gerr.ErrInvalidMessage),
+	}); err != nil {
+		return err
+	}
+	return gerr.ErrInvalidMessage
+}
+
+// connectUserPool assigns a server connection from the pool of the database and the user
+// of the StartupMessage to the session, on which the incoming connection then goes through
+// the startup phase. It returns true if the request is answered by the proxy instead.
+func (pr *Proxy) connectUserPool(
+	conn *ConnWrapper, sess *session, request []byte,
+) (bool, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "connectUserPool")
+	defer span.End()
+
+	var message pgproto3.StartupMessage
+	if !isStartupMessage(request) || message.Decode(request[UntypedHeaderLength:]) != nil {
+		err := pr.answerBeforeStartup(conn, request)
+		if err != nil {
+			span.RecordError(err)
+		}
+		return true, err
+	}
+
+	key := newPoolKey(message.Parameters)
+	client, err := pr.UserPools.acquire(key, false)
+	if err != nil {
+		span.RecordError(err)
+		pr.sendConnectionError(conn, key, err)
+		return true, err
+	}
+
+	sess.mu.Lock()
+	sess.key = &key
+	sess.client = client
+	sess.mu.Unlock()
+
+	pr.Logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.connectUserPool",
+			"pool":     key.String(),
+			"client":   client.ID[:7],
+			"remote":   RemoteAddr(conn.Conn()),
+		},
+	).Msg("Client has been assigned for the startup phase")
+
+	return false, nil
+}
+
+// sendConnectionError tells the client that no server connection can be assigned to it.
+func (pr *Proxy) sendConnectionError(conn *ConnWrapper, key PoolKey, err *gerr.GatewayDError) {
+	message := fmt.Sprintf("no server connection is available for %s: %s", key, err.Message)
+	code := ConnectionFailureCode
+	if errors.Is(err, gerr.ErrPoolExhausted) {
+		code = TooManyConnectionsCode
+	}
+
+	if sendErr := pr.sendErrorResponse(conn, &ErrorResponse{
+		Code:     err.Code,
+		SQLState: code,
+		Message:  message,
+		Detail:   errorDetail(err),
+	}); sendErr != nil {
+		pr.Logger.Debug().Err(sendErr).Msg("Failed to send the error response to the client")
+	}
+}
+
+// sendErrorResponse ends the session of the client with the ErrorResponse, and sends it to the
+// client unless the session is already ended.
+func (pr *Proxy) sendErrorResponse(conn *ConnWrapper, response *ErrorResponse) *gerr.GatewayDError {
+	if !conn.endSession(response) {
+		return nil
+	}
+	encoded := response.encode()
+	return pr.sendTrafficToClient(conn.Conn(), encoded, len(encoded))
+}
+
+// serverParameters returns the run-time parameters of the first server connection
+// that is authenticated with the configured credentials.
+func (pr *Proxy) serverParameters() map[string]string {
+	var parameters map[string]string
+	pr.AvailableConnections.ForEach(func(_, value interface{}) bool {
+		if client, ok := value.(*Client); ok {
+			parameters = client.ServerParameters()
+		}
+		return parameters == nil
+	})
+	return parameters
+}
+
+// popClient removes the first available client from the pool that matches the
+// given authentication state and returns it. It returns nil if there is none.
+func (pr *Proxy) popClient(authenticated bool) *Client {
+	for {
+		var clientID string
+		pr.AvailableConnections.ForEach(func(key, value interface{}) bool {
+			if client, ok := value.(*Client); ok && client.IsAuthenticated() == authenticated {
+				clientID, _ = key.(string)
+				return false // stop the loop.
+			}
+			return true
+		})
+		if clientID == "" {
+			return nil
+		}
+
+		// The client might have been popped by another incoming connection.
+		if client, ok := pr.AvailableConnections.Pop(clientID).(*Client); ok {
+			return client
+		}
+	}
+}
+
+// popFirstClient removes the first available client from the pool and returns it.
+// It returns nil if there is none.
+func (pr *Proxy) popFirstClient() *Client {
+	for {
+		var clientID string
+		pr.AvailableConnections.ForEach(func(key, _ interface{}) bool {
+			if cid, ok := key.(string); ok {
+				clientID = cid
+				return false // stop the loop.
+			}
+			return true
+		})
+		if clientID == "" {
+			return nil
+		}
+
+		// The client might have been popped by another incoming connection.
+		if client, ok := pr.AvailableConnections.Pop(clientID).(*Client); ok {
+			return client
+		}
+	}
+}
+
+// acquireAvailable removes a server connection from the pool of the available connections
+// by pop, which must not block, and returns it. If there is none, the pool manager, if any,
+// dials a new one, which is put in the pool for the wait queue, if any. If authenticated is
+// true, the new server connection must complete the startup phase.
+func (pr *Proxy) acquireAvailable(pop func() *Client, authenticated bool) (*Client, *gerr.GatewayDError) {
+	client, err := pr.WaitQueue.acquire(func() *Client {
+		if client := pop(); client != nil {
+			return client
+		}
+		if pr.WaitQueue == nil {
+			return pr.PoolManager.dial(authenticated)
+		}
+		pr.PoolManager.grow(authenticated)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pr.PoolManager.acquired(client)
+	return client, nil
+}
+
+// putClient puts the client back in the pool of the available connections,
+// unless the pool manager retires it.
+func (pr *Proxy) putClient(client *Client) {
+	if client == nil {
+		return
+	}
+
+	if pr.UserPools != nil {
+		pr.UserPools.put(client)
+		return
+	}
+
+	if pr.PoolManager.retire(client) {
+		return
+	}
+	pr.PoolManager.release(client)
+	if err := pr.AvailableConnections.Put(client.ID, client); err != nil {
+		pr.Logger.Error().Err(err).Msg("Failed to put the client back in the pool")
+	}
+	pr.WaitQueue.notify()
+}
+
+// putSessionClient puts the server connection that is released by a session back in the
+// pool of the read proxy, if it is assigned from there, or in the pool of this proxy.
+func (pr *Proxy) putSessionClient(client *Client, readOnly bool) {
+	if readOnly {
+		pr.ReadProxy.putClient(client)
+		return
+	}
+	pr.putClient(client)
+}
+
+// splitsReads returns true if the read-only requests are sent to the read proxy.
+func (pr *Proxy) splitsReads() bool {
+	return pr.ReadProxy != nil && pr.PoolMode != config.SessionPoolMode
+}
+
+// holdClient returns the server connection that is assigned to the session, and assigns
+// an authenticated one from the pool if there is none. Read-only requests are assigned a server
+// connection of the read proxy, if any, outside of transactions. A server connection of the
+// read proxy is released before a request that isn't read-only is sent, unless the server is
+// in the middle of an extended query. The server connection stays assigned to the session at
+// least until unholdClient is called.
+func (pr *Proxy) holdClient(sess *session, readOnly bool) (*Client, *gerr.GatewayDError) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if !readOnly {
+		for !sess.closed && sess.client != nil && sess.readOnly && !sess.unsynced {
+			sess.cond.Wait()
+		}
+	}
+
+	if sess.closed || sess.drained {
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	if sess.client == nil {
+		var client *Client
+		if readOnly && sess.txStatus == TransactionStatusIdle {
+			client = pr.ReadProxy.acquireReadClient(sess)
+		}
+		sess.readOnly = client != nil
+		if client == nil {
+			var err *gerr.GatewayDError
+			if client, err = pr.acquireClient(sess, true); err != nil {
+				return nil, err
+			}
+			// The session might be closed while it waits for the server connection.
+			if sess.closed {
+				pr.putClient(client)
+				return nil, gerr.ErrClientNotConnected
+			}
+		}
+		sess.client = client
+	}
+
+	if !sess.client.IsConnected() {
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	sess.holds++
+	return sess.client, nil
+}
+
+// acquireClient removes an authenticated server connection from the pool of the session and
+// returns it. If wait is true, it waits in the wait queue, if any, while the pool is exhausted,
+// or for a new server connection. The caller must hold the lock of the session, which is
+// released while waiting, so that the session can be closed in the meantime.
+func (pr *Proxy) acquireClient(sess *session, wait bool) (*Client, *gerr.GatewayDError) {
+	if pr.UserPools != nil {
+		if sess.key == nil {
+			return nil, gerr.ErrClientNotConnected
+		}
+		return pr.UserPools.acquire(*sess.key, true)
+	}
+
+	if !wait {
+		if client := pr.popClient(true); client != nil {
+			pr.PoolManager.acquired(client)
+			return client, nil
+		}
+		// The pool grows for the next requests.
+		pr.PoolManager.grow(true)
+		return nil, gerr.ErrPoolExhausted
+	}
+
+	sess.mu.Unlock()
+	defer sess.mu.Lock()
+	return pr.acquireAvailable(func() *Client { return pr.popClient(true) }, true)
+}
+
+// acquireReadClient removes an authenticated server connection from the pool of the read
+// proxy for the session and returns it. It returns nil if there is none, in which case the
+// request is sent to the server connections of the proxy of the session instead. The caller
+// must hold the lock of the session.
+func (pr *Proxy) acquireReadClient(sess *session) *Client {
+	client, err := pr.acquireClient(sess, false)
+	if err != nil {
+		pr.Logger.Trace().Err(err).Msg("No server connection of the read proxy is available")
+		return nil
+	}
+	return client
+}
+
+// unholdClient releases the hold on the server connection of the session, and returns
+// the server connection to the pool if the session is idle.
+func (pr *Proxy) unholdClient(sess *session) {
+	sess.mu.Lock()
+	sess.holds--
+	readOnly := sess.readOnly
+	client := sess.release()
+	if client != nil {
+		// The requests that wait for the server connection to be released are woken up.
+		sess.cond.Broadcast()
+	}
+	sess.mu.Unlock()
+
+	pr.putSessionClient(client, readOnly)
+}
+
+// waitForClient blocks until the server connection of the session is expected to send
+// a response, and returns the server connection.
+func (pr *Proxy) waitForClient(sess *session) (*Client, *gerr.GatewayDError) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	for !sess.closed && !sess.expectsResponse() {
+		sess.cond.Wait()
+	}
+
+	if sess.closed {
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	return sess.client, nil
+}
+
+// releaseClient tracks the response from the server connection of the session,
+// and returns the server connection to the pool if the session is idle. It returns
+// the response without the responses to the messages that are injected by the proxy,
+// which is marked as sent to the client by the caller.
+func (pr *Proxy) releaseClient(sess *session, client *Client, response []byte) []byte {
+	sess.mu.Lock()
+	if sess.trackResponse(response) && sess.client == client {
+		// The server connection has completed the startup phase,
+		// so it can be shared with other incoming connections.
+		client.authenticated.Store(true)
+	}
+	response = sess.swallowResponses(response)
+	sess.responding = true
+	readOnly := sess.readOnly
+	released := sess.release()
+	sess.cond.Broadcast()
+	sess.mu.Unlock()
+
+	pr.putSessionClient(released, readOnly)
+
+	return response
+}
+
+// rewriteStatements rewrites the names of the prepared statements in the request, so that
+// the sessions that share the server connection don't collide, and prepends the Parse messages
+// of the prepared statements that the session has created on other server connections. The
+// messages are only injected when no response is pending, and they are followed by a Sync
+// message, so that their responses can be told apart from the responses to the request.
+func (pr *Proxy) rewriteStatements(sess *session, client *Client, request []byte) []byte {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "rewriteStatements")
+	defer span.End()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	rewritten, injected, commit := sess.rewriteStatements(client, request)
+	if len(injected) == 0 {
+		return rewritten
+	}
+
+	for !sess.closed && sess.pending > 0 {
+		sess.cond.Wait()
+	}
+	if sess.closed || sess.unsynced {
+		// The server is in the middle of an extended query, so a Sync message
+		// can't be injected without changing the outcome of the query.
+		pr.Logger.Debug().Fields(
+			map[string]interface{}{
+				"function": "proxy.rewriteStatements",
+				"remote":   client.RemoteAddr(),
+			},
+		).Msg("Cannot re-create the prepared statements in the middle of an extended query")
+		return rewritten
+	}
+
+	commit()
+	sess.swallow++
+	span.AddEvent("Injected the prepared statements")
+
+	injected = append(injected, encodeMessage(SyncMessage, nil)...)
+	return append(injected, rewritten...)
+}
+
+// rejectTransaction responds to a request that starts a transaction block with an error,
+// without sending it to the server. The error is sent after the responses to the previous
+// requests of the session, so that the order of the responses is preserved. The request
+// is not rejected if the server is in the middle of an extended query, since the server
+// must receive the Sync message to respond with a ReadyForQuery message.
+func (pr *Proxy) rejectTransaction(
+	conn *ConnWrapper, sess *session, request []byte,
+) (bool, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(pr.ctx, "rejectTransaction")
+	defer span.End()
+
+	sess.mu.Lock()
+	for !sess.closed && sess.client != nil && sess.pending > 0 {
+		sess.cond.Wait()
+	}
+	closed, unsynced := sess.closed, sess.unsynced
+	sess.mu.Unlock()
+	if closed {
+		return false, gerr.ErrClientNotConnected
+	}
+	if unsynced {
+		return false, nil
+	}
+
+	response := postgres.ErrorResponse(
+		gerr.ErrTransactionNotAllowed.Message, "ERROR", FeatureNotSupportedCode, "")
+	if expectsReadyForQuery(request) {
+		var err error
+		response, err = (&pgproto3.ReadyForQuery{TxStatus: TransactionStatusIdle}).Encode(response)
+		if err != nil {
+			// This should never happen, since everything is hardcoded.
+			span.RecordError(err)
+			return false, gerr.ErrMsgEncodeError.Wrap(err)
+		}
+	}
+
+	pr.Logger.Debug().Fields(
+		map[string]interface{}{
+			"function": "proxy.passthrough",
+			"poolMode": pr.PoolMode,
+			"remote":   RemoteAddr(conn.Conn()),
+		},
+	).Msg("Rejected a transaction block")
+
+	return true, pr.sendTrafficToClient(conn.Conn(), response, len(response))
+}
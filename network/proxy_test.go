@@ -1,17 +1,23 @@
 package network
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/gatewayd-io/gatewayd/act"
 	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/gatewayd-io/gatewayd/logging"
 	"github.com/gatewayd-io/gatewayd/plugin"
 	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestNewProxy tests the creation of a new proxy with a fixed connection pool.
@@ -408,3 +414,392 @@ func BenchmarkProxyAvailableAndBusyConnectionsString(b *testing.B) {
 		proxy.BusyConnectionsString()
 	}
 }
+
+// newTestPooledProxy creates a proxy with a pool of clients that are connected
+// to the given fake backend.
+func newTestPooledProxy(t *testing.T, backend *FakeBackend, size int, poolMode string) *Proxy {
+	t.Helper()
+
+	return newTestAuthProxy(t, backend, size, poolMode, nil)
+}
+
+// newTestAuthProxy creates a proxy with a pool of clients that are connected to the given
+// fake backend, and that are authenticated with the password of the fake backend, if any.
+// The incoming connections are authenticated by the given authenticator, if any.
+func newTestAuthProxy(
+	t *testing.T, backend *FakeBackend, size int, poolMode string, authenticator *Authenticator,
+) *Proxy {
+	t.Helper()
+
+	logger := logging.NewLogger(context.Background(), logging.LoggerConfig{
+		Output:            []config.LogOutput{config.Console},
+		TimeFormat:        zerolog.TimeFormatUnix,
+		ConsoleTimeFormat: time.RFC3339,
+		Level:             zerolog.WarnLevel,
+		NoColor:           true,
+	})
+
+	clientConfig := config.Client{
+		Network:            "tcp",
+		Address:            backend.Address(),
+		ReceiveChunkSize:   config.DefaultChunkSize,
+		ReceiveDeadline:    config.DefaultReceiveDeadline,
+		ReceiveTimeout:     config.DefaultReceiveTimeout,
+		SendDeadline:       config.DefaultSendDeadline,
+		DialTimeout:        config.DefaultDialTimeout,
+		TCPKeepAlive:       false,
+		TCPKeepAlivePeriod: config.DefaultTCPKeepAlivePeriod,
+	}
+	if backend.password != "" {
+		clientConfig.User = "gatewayd"
+		clientConfig.Password = backend.password
+	}
+
+	newPool := pool.NewPool(context.Background(), size)
+	for range size {
+		client := NewClient(context.Background(), &clientConfig, logger, nil)
+		require.NotNil(t, client)
+		require.Nil(t, newPool.Put(client.ID, client))
+	}
+
+	actRegistry := act.NewActRegistry(
+		act.Registry{
+			Signals:              act.BuiltinSignals(),
+			Policies:             act.BuiltinPolicies(),
+			Actions:              act.BuiltinActions(),
+			DefaultPolicyName:    config.DefaultPolicy,
+			PolicyTimeout:        config.DefaultPolicyTimeout,
+			DefaultActionTimeout: config.DefaultActionTimeout,
+			Logger:               logger,
+		})
+
+	proxy := NewProxy(
+		context.Background(),
+		Proxy{
+			AvailableConnections: newPool,
+			PluginRegistry: plugin.NewRegistry(
+				context.Background(),
+				plugin.Registry{
+					ActRegistry:   actRegistry,
+					Compatibility: config.Loose,
+					Logger:        logger,
+				},
+			),
+			HealthCheckPeriod: config.DefaultHealthCheckPeriod,
+			PoolMode:          poolMode,
+			Authenticator:     authenticator,
+			ClientConfig:      &clientConfig,
+			Logger:            logger,
+			PluginTimeout:     config.DefaultPluginTimeout,
+		},
+	)
+	t.Cleanup(proxy.Shutdown)
+
+	return proxy
+}
+
+// roundTrip sends the request from the database client through the proxy and returns
+// the response that the database client receives.
+func roundTrip(
+	t *testing.T, proxy *Proxy, conn *ConnWrapper, client net.Conn, stack *Stack, request []byte,
+) []byte {
+	t.Helper()
+
+	_, err := client.Write(request)
+	require.NoError(t, err)
+	require.Nil(t, proxy.PassThroughToServer(conn, stack))
+	require.Nil(t, proxy.PassThroughToClient(conn, stack))
+
+	response, err := NewMessageReader(client, config.DefaultChunkSize, false).ReadMessages()
+	require.NoError(t, err)
+	return response
+}
+
+// TestProxyTransactionPoolMode tests that the server connections are shared between
+// the incoming connections, and are only assigned to them for the duration of a transaction.
+func TestProxyTransactionPoolMode(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 2, config.TransactionPoolMode)
+
+	begin := CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00"))
+	commit := CreatePostgreSQLPacket(QueryMessage, []byte("COMMIT\x00"))
+	inTransaction := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusActive})
+	idle := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})
+
+	// Both incoming connections go through the startup phase.
+	conns := make([]*ConnWrapper, 2)
+	clients := make([]net.Conn, 2)
+	stacks := make([]*Stack, 2)
+	for idx := range conns {
+		conns[idx], clients[idx] = NewTestIncomingConnection(t)
+		stacks[idx] = NewStack()
+		require.Nil(t, proxy.Connect(conns[idx]))
+		response := roundTrip(t, proxy, conns[idx], clients[idx], stacks[idx], CreatePgStartupPacket())
+		assert.True(t, bytes.HasSuffix(response, idle))
+	}
+	// The server connections are returned to the pool after the startup phase.
+	assert.Equal(t, 2, proxy.AvailableConnections.Size())
+	assert.Equal(t, 2, proxy.busyConnections.Size())
+
+	// The first incoming connection holds a server connection during the transaction.
+	response := roundTrip(t, proxy, conns[0], clients[0], stacks[0], begin)
+	assert.True(t, bytes.HasSuffix(response, inTransaction))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	// The second incoming connection uses the other server connection.
+	response = roundTrip(t, proxy, conns[1], clients[1], stacks[1],
+		CreatePostgreSQLPacket(QueryMessage, []byte("SELECT 1\x00")))
+	assert.True(t, bytes.HasSuffix(response, idle))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	response = roundTrip(t, proxy, conns[0], clients[0], stacks[0], commit)
+	assert.True(t, bytes.HasSuffix(response, idle))
+	assert.Equal(t, 2, proxy.AvailableConnections.Size())
+
+	// A Terminate message is not sent to the shared server connection.
+	_, err := clients[0].Write(CreatePgTerminatePacket())
+	require.NoError(t, err)
+	assert.Equal(t, gerr.ErrClientNotConnected, proxy.PassThroughToServer(conns[0], stacks[0]))
+	require.Nil(t, proxy.Disconnect(conns[0]))
+	assert.Equal(t, 2, proxy.AvailableConnections.Size())
+	assert.Equal(t, 1, proxy.busyConnections.Size())
+
+	// No server connection is recycled.
+	assert.Equal(t, 2, backend.Connections())
+}
+
+// TestProxyTransactionPoolModeDisconnect tests that a server connection is recycled if the
+// incoming connection is closed in the middle of a transaction.
+func TestProxyTransactionPoolModeDisconnect(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.TransactionPoolMode)
+
+	conn, client := NewTestIncomingConnection(t)
+	stack := NewStack()
+	require.Nil(t, proxy.Connect(conn))
+	roundTrip(t, proxy, conn, client, stack, CreatePgStartupPacket())
+	roundTrip(t, proxy, conn, client, stack, CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00")))
+	assert.Equal(t, 0, proxy.AvailableConnections.Size())
+
+	// The pool is exhausted, since the only server connection is in a transaction.
+	other, _ := NewTestIncomingConnection(t)
+	assert.Equal(t, gerr.ErrPoolExhausted, proxy.Connect(other))
+
+	require.Nil(t, proxy.Disconnect(conn))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+	assert.Eventually(t, func() bool { return backend.Connections() == 2 }, time.Second, time.Millisecond)
+	proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
+		if client, ok := value.(*Client); ok {
+			assert.False(t, client.IsAuthenticated())
+		}
+		return true
+	})
+}
+
+// TestProxyTerminate tests that the incoming connections are closed in the middle of their
+// transactions, after telling the clients that the server is shutting down.
+func TestProxyTerminate(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	pgConn, err := connectThroughProxy(t, proxy, "alice", "")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = pgConn.Exec(ctx, "BEGIN").ReadAll()
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, proxy.Terminate())
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, AdminShutdownCode, pgErr.Code)
+	assert.Eventually(t, func() bool { return proxy.BusyConnectionsCount() == 0 }, time.Second, time.Millisecond)
+}
+
+// TestProxyResetStrategy tests that the server connection of the incoming connection that is
+// closed in the middle of a transaction is reset, and that it is reconnected if the reset
+// leaves it in a transaction.
+func TestProxyResetStrategy(t *testing.T) {
+	tests := []struct {
+		strategy    string
+		query       string
+		executed    string
+		connections int
+	}{
+		{config.ResetReconnect, "", "", 2},
+		{config.ResetDiscardAll, "", "DISCARD ALL", 1},
+		{config.ResetQuery, "DEALLOCATE ALL; RESET ALL", "DEALLOCATE ALL; RESET ALL", 1},
+		{config.ResetQuery, "BEGIN; SELECT 1", "BEGIN; SELECT 1", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy+" "+tt.query, func(t *testing.T) {
+			backend := NewFakeBackend(t)
+			proxy := newTestPooledProxy(t, backend, 1, config.TransactionPoolMode)
+			proxy.ResetStrategy = tt.strategy
+			proxy.ResetQuery = tt.query
+
+			conn, client := NewTestIncomingConnection(t)
+			stack := NewStack()
+			require.Nil(t, proxy.Connect(conn))
+			roundTrip(t, proxy, conn, client, stack, CreatePgStartupPacket())
+			roundTrip(t, proxy, conn, client, stack, CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00")))
+
+			require.Nil(t, proxy.Disconnect(conn))
+			assert.Equal(t, 1, proxy.AvailableConnections.Size())
+			assert.Eventually(t, func() bool {
+				return backend.Connections() == tt.connections
+			}, time.Second, time.Millisecond)
+			if tt.executed != "" {
+				assert.Equal(t, 1, backend.Executed("ROLLBACK"))
+				assert.Equal(t, 1, backend.Executed(tt.executed))
+			}
+			proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
+				if client, ok := value.(*Client); ok {
+					assert.Equal(t, tt.connections == 1, client.IsAuthenticated())
+				}
+				return true
+			})
+		})
+	}
+}
+
+// TestProxyResetStrategySession tests that the server connection that is authenticated by the
+// proxy is reset in the session pool mode, instead of being reconnected for every incoming connection.
+func TestProxyResetStrategySession(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "backend-password", nil)
+	proxy := newTestAuthProxy(t, backend, 1, config.SessionPoolMode, NewAuthenticator(
+		context.Background(),
+		Authenticator{
+			Method:   config.AuthMethodSCRAMSHA256,
+			UserList: map[string]string{"alice": "password"},
+			Logger:   zerolog.Nop(),
+		},
+	))
+	proxy.ResetStrategy = config.ResetDiscardAll
+
+	for range 2 {
+		database, err := connectThroughProxy(t, proxy, "alice", "password")
+		require.NoError(t, err)
+		require.NoError(t, database.Close(context.Background()))
+		require.Eventually(t, func() bool {
+			return proxy.AvailableConnections.Size() == 1
+		}, time.Second, time.Millisecond)
+	}
+	assert.Equal(t, 2, backend.Executed("DISCARD ALL"))
+	assert.Equal(t, 1, backend.Connections())
+}
+
+// TestProxyStatementPoolMode tests that the server connection is returned to the pool
+// after each statement, and that transaction blocks are rejected.
+func TestProxyStatementPoolMode(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.StatementPoolMode)
+
+	conn, client := NewTestIncomingConnection(t)
+	stack := NewStack()
+	require.Nil(t, proxy.Connect(conn))
+	roundTrip(t, proxy, conn, client, stack, CreatePgStartupPacket())
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	response := roundTrip(t, proxy, conn, client, stack,
+		CreatePostgreSQLPacket(QueryMessage, []byte("SELECT 1\x00")))
+	assert.True(t, bytes.HasSuffix(response,
+		CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	// The transaction block is rejected by the proxy, without sending it to the server.
+	_, err := client.Write(CreatePostgreSQLPacket(QueryMessage, []byte("  begin;\x00")))
+	require.NoError(t, err)
+	require.Nil(t, proxy.PassThroughToServer(conn, stack))
+	response, err = NewMessageReader(client, config.DefaultChunkSize, false).ReadMessages()
+	require.NoError(t, err)
+	assert.Equal(t, byte('E'), response[0])
+	assert.Contains(t, string(response), gerr.ErrTransactionNotAllowed.Message)
+	assert.True(t, bytes.HasSuffix(response,
+		CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	// A transaction block that is not detected beforehand closes the incoming connection.
+	_, err = client.Write(CreatePostgreSQLPacket(QueryMessage, []byte("SELECT 1; BEGIN\x00")))
+	require.NoError(t, err)
+	require.Nil(t, proxy.PassThroughToServer(conn, stack))
+	assert.Equal(t, gerr.ErrTransactionNotAllowed, proxy.PassThroughToClient(conn, stack))
+	response, err = NewMessageReader(client, config.DefaultChunkSize, false).ReadMessages()
+	require.NoError(t, err)
+	assert.Contains(t, string(response), "FATAL")
+	assert.Equal(t, 0, proxy.AvailableConnections.Size())
+
+	// The server connection is recycled on disconnect.
+	require.Nil(t, proxy.Disconnect(conn))
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+	assert.Eventually(t, func() bool { return backend.Connections() == 2 }, time.Second, time.Millisecond)
+}
+
+// TestProxyTransactionPoolModePreparedStatements tests that the prepared statements of a
+// session are re-created when the session is moved to another server connection.
+func TestProxyTransactionPoolModePreparedStatements(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 2, config.TransactionPoolMode)
+
+	// Both server connections go through the startup phase.
+	other, otherClient := NewTestIncomingConnection(t)
+	require.Nil(t, proxy.Connect(other))
+	roundTrip(t, proxy, other, otherClient, NewStack(), CreatePgStartupPacket())
+	conn, client := NewTestIncomingConnection(t)
+	stack := NewStack()
+	require.Nil(t, proxy.Connect(conn))
+	roundTrip(t, proxy, conn, client, stack, CreatePgStartupPacket())
+
+	definition := []byte("SELECT $1\x00\x00\x00")
+	name := serverStatementName(definition)
+	parse := CreatePostgreSQLPacket(ParseMessage, append([]byte("stmt\x00"), definition...))
+	sync := CreatePostgreSQLPacket(SyncMessage, nil)
+	ready := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})
+
+	response := roundTrip(t, proxy, conn, client, stack, append(append([]byte{}, parse...), sync...))
+	assert.Equal(t, append(CreatePostgreSQLPacket('1', nil), ready...), response)
+	assert.True(t, backend.Parsed(name))
+	assert.False(t, backend.Parsed("stmt"))
+
+	// Take the server connection that has the prepared statement out of the pool,
+	// so that the session is moved to the other one.
+	var prepared *Client
+	proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
+		if cl, ok := value.(*Client); ok && cl.hasStatement(name) {
+			prepared = cl
+			return false
+		}
+		return true
+	})
+	require.NotNil(t, prepared)
+	proxy.AvailableConnections.Pop(prepared.ID)
+	defer proxy.putClient(prepared)
+
+	bind := CreatePostgreSQLPacket(BindMessage, []byte("\x00stmt\x00\x00\x00\x00\x00\x00\x00"))
+	execute := CreatePostgreSQLPacket(ExecuteMessage, []byte("\x00\x00\x00\x00\x00"))
+	request := append(append(append([]byte{}, bind...), execute...), sync...)
+	_, err := client.Write(request)
+	require.NoError(t, err)
+	require.Nil(t, proxy.PassThroughToServer(conn, stack))
+
+	// The responses to the injected messages are not sent to the client.
+	expected := append(append(CreatePostgreSQLPacket('2', nil),
+		CreatePostgreSQLPacket('C', []byte("SELECT 1\x00"))...), ready...)
+	response = make([]byte, 0, len(expected))
+	reader := NewMessageReader(client, config.DefaultChunkSize, false)
+	for len(response) < len(expected) {
+		require.Nil(t, proxy.PassThroughToClient(conn, stack))
+		received, err := reader.ReadMessages()
+		require.NoError(t, err)
+		response = append(response, received...)
+	}
+	assert.Equal(t, expected, response)
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+	proxy.AvailableConnections.ForEach(func(_, value interface{}) bool {
+		if cl, ok := value.(*Client); ok {
+			assert.True(t, cl.hasStatement(name))
+		}
+		return true
+	})
+}
@@ -0,0 +1,274 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// The PROXY protocol headers, which are sent by the L4 load balancers before the traffic of the
+// clients, so that the addresses of the clients are known to the server.
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	proxyProtocolV1Prefix    = "PROXY "
+	proxyProtocolV1MaxLength = 107
+	proxyProtocolV2HeaderLen = 16
+
+	proxyProtocolV2Version = 0x20
+	proxyProtocolV2Local   = 0x00
+	proxyProtocolV2Proxy   = 0x01
+
+	proxyProtocolV2Unspec = 0x00
+	proxyProtocolV2TCP4   = 0x11
+	proxyProtocolV2TCP6   = 0x21
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolConn is a connection whose addresses are read from the PROXY protocol header.
+// The header is consumed, and the rest of the buffered data is returned by the reads.
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+var _ net.Conn = (*proxyProtocolConn)(nil)
+
+// Read reads the data that follows the header.
+func (c *proxyProtocolConn) Read(data []byte) (int, error) {
+	return c.reader.Read(data) //nolint:wrapcheck
+}
+
+// RemoteAddr returns the address of the client, as sent in the header.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address that the client connected to, as sent in the header.
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// isTrustedProxy returns true if the address is in any of the trusted networks. No
+// address is trusted if there are none.
+func isTrustedProxy(addr net.Addr, trustedProxies []*net.IPNet) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the CIDRs of the trusted proxies, which are validated by the config.
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// readProxyProtocolHeader reads the v1 or v2 PROXY protocol header from the connection within
+// the timeout, and returns the connection with the addresses of the header. The addresses of
+// the connection are kept for the LOCAL command and the UNKNOWN protocol, e.g. for the health
+// checks of the load balancers.
+func readProxyProtocolHeader(conn net.Conn, timeout time.Duration) (net.Conn, *gerr.GatewayDError) {
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, gerr.ErrProxyProtocolFailed.Wrap(err)
+		}
+		defer conn.SetReadDeadline(time.Time{}) //nolint:errcheck
+	}
+
+	proxyConn := &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}
+	signature, err := proxyConn.reader.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, gerr.ErrProxyProtocolFailed.Wrap(err)
+	}
+
+	switch {
+	case bytes.Equal(signature, proxyProtocolV2Signature):
+		err = proxyConn.readV2()
+	case bytes.HasPrefix(signature, []byte(proxyProtocolV1Prefix)):
+		err = proxyConn.readV1()
+	default:
+		err = errors.New("the connection doesn't start with a PROXY protocol header")
+	}
+	if err != nil {
+		return nil, gerr.ErrProxyProtocolFailed.Wrap(err)
+	}
+
+	return proxyConn, nil
+}
+
+// readV1 reads the text header, e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 5432\r\n".
+func (c *proxyProtocolConn) readV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLength {
+			return errors.New("the PROXY protocol v1 header is too long")
+		}
+		char, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read the PROXY protocol v1 header: %w", err)
+		}
+		line = append(line, char)
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("invalid PROXY protocol v1 header: %q", line)
+	}
+
+	source, err := parseV1Address(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	destination, err := parseV1Address(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remoteAddr, c.localAddr = source, destination
+	return nil
+}
+
+// parseV1Address parses the IP and the port of an address of the text header.
+func parseV1Address(ip, port string) (*net.TCPAddr, error) {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return nil, fmt.Errorf("invalid address in the PROXY protocol v1 header: %s", ip)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in the PROXY protocol v1 header: %w", err)
+	}
+	return &net.TCPAddr{IP: address, Port: int(portNumber)}, nil
+}
+
+// readV2 reads the binary header, which is followed by the addresses and the TLVs, which are ignored.
+func (c *proxyProtocolConn) readV2() error {
+	header := make([]byte, proxyProtocolV2HeaderLen)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("failed to read the PROXY protocol v2 header: %w", err)
+	}
+	if header[12]&0xF0 != proxyProtocolV2Version {
+		return fmt.Errorf("unsupported PROXY protocol version: %#x", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return fmt.Errorf("failed to read the PROXY protocol v2 addresses: %w", err)
+	}
+
+	switch header[12] & 0x0F {
+	case proxyProtocolV2Local:
+		return nil
+	case proxyProtocolV2Proxy:
+	default:
+		return fmt.Errorf("unsupported PROXY protocol v2 command: %#x", header[12]&0x0F)
+	}
+
+	var ipLength int
+	switch header[13] {
+	case proxyProtocolV2TCP4:
+		ipLength = net.IPv4len
+	case proxyProtocolV2TCP6:
+		ipLength = net.IPv6len
+	default:
+		// The addresses of the other families, e.g. UNIX sockets, are ignored.
+		return nil
+	}
+	if len(body) < 2*ipLength+4 {
+		return errors.New("the PROXY protocol v2 addresses are truncated")
+	}
+
+	c.remoteAddr = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[:ipLength])),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLength:])),
+	}
+	c.localAddr = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[ipLength : 2*ipLength])),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLength+2:])),
+	}
+	return nil
+}
+
+// sendsProxyProtocol returns true if the PROXY protocol header of the version is sent
+// on the server connections.
+func sendsProxyProtocol(version string) bool {
+	return version == config.ProxyProtocolV1 || version == config.ProxyProtocolV2
+}
+
+// encodeProxyProtocolHeader encodes the PROXY protocol header of the given version with the
+// addresses of the client. The header has the LOCAL command (v2) or the UNKNOWN protocol (v1)
+// if the addresses aren't TCP addresses of the same family.
+func encodeProxyProtocolHeader(version string, source, destination net.Addr) []byte {
+	sourceAddr, sourceOK := source.(*net.TCPAddr)
+	destinationAddr, destinationOK := destination.(*net.TCPAddr)
+	ipv4 := sourceOK && destinationOK && sourceAddr.IP.To4() != nil && destinationAddr.IP.To4() != nil
+	ipv6 := sourceOK && destinationOK && !ipv4 &&
+		sourceAddr.IP.To4() == nil && destinationAddr.IP.To4() == nil
+
+	if version == config.ProxyProtocolV1 {
+		switch {
+		case ipv4:
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n",
+				sourceAddr.IP.To4(), destinationAddr.IP.To4(), sourceAddr.Port, destinationAddr.Port))
+		case ipv6:
+			return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n",
+				sourceAddr.IP.To16(), destinationAddr.IP.To16(), sourceAddr.Port, destinationAddr.Port))
+		default:
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+	}
+
+	header := bytes.Clone(proxyProtocolV2Signature)
+	var addresses []byte
+	switch {
+	case ipv4:
+		header = append(header, proxyProtocolV2Version|proxyProtocolV2Proxy, proxyProtocolV2TCP4)
+		addresses = append(addresses, sourceAddr.IP.To4()...)
+		addresses = append(addresses, destinationAddr.IP.To4()...)
+	case ipv6:
+		header = append(header, proxyProtocolV2Version|proxyProtocolV2Proxy, proxyProtocolV2TCP6)
+		addresses = append(addresses, sourceAddr.IP.To16()...)
+		addresses = append(addresses, destinationAddr.IP.To16()...)
+	default:
+		header = append(header, proxyProtocolV2Version|proxyProtocolV2Local, proxyProtocolV2Unspec)
+	}
+	if addresses != nil {
+		addresses = binary.BigEndian.AppendUint16(addresses, uint16(sourceAddr.Port))
+		addresses = binary.BigEndian.AppendUint16(addresses, uint16(destinationAddr.Port))
+	}
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
@@ -0,0 +1,175 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readHeader sends the data on one end of a pipe, and reads the PROXY protocol header
+// from the other end.
+func readHeader(t *testing.T, data []byte) (net.Conn, *gerr.GatewayDError) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	go func() {
+		_, _ = client.Write(data)
+	}()
+	return readProxyProtocolHeader(server, time.Second)
+}
+
+// Test_readProxyProtocolHeader tests that the addresses of the v1 and v2 headers replace
+// the addresses of the connection, and that the data after the header is kept.
+func Test_readProxyProtocolHeader(t *testing.T) {
+	source4 := &net.TCPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 56324}
+	destination4 := &net.TCPAddr{IP: net.ParseIP("192.168.0.11").To4(), Port: 5432}
+	source6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	destination6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5432}
+
+	tests := []struct {
+		name        string
+		header      []byte
+		source      net.Addr
+		destination net.Addr
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 5432\r\n"), source4, destination4},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 5432\r\n"), source6, destination6},
+		{"v1 unknown", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), nil, nil},
+		{"v2 tcp4", encodeProxyProtocolHeader(config.ProxyProtocolV2, source4, destination4), source4, destination4},
+		{"v2 tcp6", encodeProxyProtocolHeader(config.ProxyProtocolV2, source6, destination6), source6, destination6},
+		{"v2 local", encodeProxyProtocolHeader(config.ProxyProtocolV2, nil, nil), nil, nil},
+		{
+			"v2 tlvs",
+			append(
+				bytes.Clone(proxyProtocolV2Signature),
+				0x21, 0x11, 0, 15, 192, 168, 0, 1, 192, 168, 0, 11, 0xDC, 0x04, 0x15, 0x38, 0x03, 0, 0),
+			source4, destination4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := readHeader(t, append(tt.header, CreatePgTerminatePacket()...))
+			require.Nil(t, err)
+
+			if tt.source != nil {
+				assert.Equal(t, tt.source.String(), conn.RemoteAddr().String())
+				assert.Equal(t, tt.destination.String(), conn.LocalAddr().String())
+			} else {
+				assert.Equal(t, "pipe", conn.RemoteAddr().String())
+			}
+
+			data := make([]byte, len(CreatePgTerminatePacket()))
+			_, origErr := io.ReadFull(conn, data)
+			require.NoError(t, origErr)
+			assert.Equal(t, CreatePgTerminatePacket(), data)
+		})
+	}
+
+	for _, header := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 192.168.0.1 56324 5432\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324 65536\r\n",
+		"PROXY TCP4 " + string(make([]byte, 100)) + "\r\n",
+		string(proxyProtocolV2Signature) + "\x11\x11\x00\x00",
+	} {
+		_, err := readHeader(t, []byte(header))
+		require.NotNil(t, err, header)
+		assert.Equal(t, gerr.ErrCodeProxyProtocolFailed, err.Code)
+	}
+}
+
+// Test_encodeProxyProtocolHeader tests the v1 headers, and the headers without TCP addresses.
+func Test_encodeProxyProtocolHeader(t *testing.T) {
+	source := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	destination := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5432}
+
+	assert.Equal(t, "PROXY TCP4 10.0.0.1 10.0.0.2 1234 5432\r\n",
+		string(encodeProxyProtocolHeader(config.ProxyProtocolV1, source, destination)))
+	assert.Equal(t, "PROXY UNKNOWN\r\n",
+		string(encodeProxyProtocolHeader(config.ProxyProtocolV1, source, &net.UnixAddr{})))
+	assert.Equal(t, "PROXY UNKNOWN\r\n",
+		string(encodeProxyProtocolHeader(config.ProxyProtocolV1,
+			source, &net.TCPAddr{IP: net.ParseIP("::1"), Port: 5432})))
+
+	header := encodeProxyProtocolHeader(config.ProxyProtocolV2, nil, nil)
+	assert.Len(t, header, proxyProtocolV2HeaderLen)
+	assert.Equal(t, byte(0x20), header[12])
+}
+
+// Test_isTrustedProxy tests that the headers are only read from the trusted networks.
+func Test_isTrustedProxy(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::/32"})
+	require.NoError(t, err)
+
+	assert.True(t, isTrustedProxy(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, trustedProxies))
+	assert.True(t, isTrustedProxy(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}, trustedProxies))
+	assert.False(t, isTrustedProxy(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}, trustedProxies))
+	assert.False(t, isTrustedProxy(&net.UnixAddr{Name: "/tmp/.s.PGSQL.5432"}, trustedProxies))
+	assert.False(t, isTrustedProxy(&net.TCPAddr{IP: net.ParseIP("192.168.0.1")}, nil))
+
+	_, err = parseTrustedProxies([]string{"10.0.0.1"})
+	require.Error(t, err)
+}
+
+// TestClientProxyProtocol tests that the server connections start with the PROXY protocol
+// header, which has the addresses of the incoming connection that they are dedicated to.
+func TestClientProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if proxyConn, err := readProxyProtocolHeader(conn, time.Second); err == nil {
+				accepted <- proxyConn
+			} else {
+				conn.Close()
+			}
+		}
+	}()
+
+	client := NewClient(
+		context.Background(),
+		&config.Client{
+			Network:       "tcp",
+			Address:       listener.Addr().String(),
+			ProxyProtocol: config.ProxyProtocolV1,
+		},
+		zerolog.Nop(),
+		nil,
+	)
+	require.NotNil(t, client)
+	defer client.Close()
+
+	// The header has the UNKNOWN protocol without an incoming connection.
+	conn := <-accepted
+	assert.Equal(t, client.LocalAddr(), conn.RemoteAddr().String())
+	conn.Close()
+
+	source := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	destination := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 5432}
+	client.setProxyProtocolAddrs(source, destination)
+	require.NoError(t, client.Reconnect())
+	conn = <-accepted
+	assert.Equal(t, source.String(), conn.RemoteAddr().String())
+	assert.Equal(t, destination.String(), conn.LocalAddr().String())
+	conn.Close()
+}
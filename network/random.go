@@ -33,12 +33,18 @@ func (r *Random) NextProxy(_ IConnWrapper) (IProxy, *gerr.GatewayDError) {
 		return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("proxy list is empty"))
 	}
 
-	randomIndex, err := randInt(proxiesLen)
+	// The proxies with unhealthy servers are skipped.
+	proxies := healthyProxies(r.proxies)
+	if len(proxies) == 0 {
+		return nil, gerr.ErrNoProxiesAvailable.Wrap(errNoHealthyProxies)
+	}
+
+	randomIndex, err := randInt(len(proxies))
 	if err != nil {
 		return nil, gerr.ErrNoProxiesAvailable.Wrap(err)
 	}
 
-	return r.proxies[randomIndex], nil
+	return proxies[randomIndex], nil
 }
 
 // randInt generates a random integer between 0 and max-1 using crypto/rand.
@@ -62,6 +62,22 @@ func TestGetNextProxy(t *testing.T) {
 		// It's possible that proxy1 and proxy2 are the same, but if we run this
 		// test enough times, they should occasionally be different.
 	})
+	t.Run("Skips the unhealthy proxies", func(t *testing.T) {
+		healthy := MockProxy{name: "healthy"}
+		server := &Server{Proxies: []IProxy{MockProxy{name: "unhealthy", unhealthy: true}, healthy}}
+		random := NewRandom(server)
+
+		for range 10 {
+			proxy, err := random.NextProxy(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, healthy, proxy)
+		}
+
+		random = NewRandom(&Server{Proxies: []IProxy{MockProxy{unhealthy: true}}})
+		proxy, err := random.NextProxy(nil)
+		assert.Nil(t, proxy)
+		assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+	})
 }
 
 // TestConcurrencySafety ensures that the Random object is safe for concurrent
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// compileWriteFunctions compiles the names of the functions that force the write path into
+// a regular expression that matches their calls, e.g. nextval(...) or pg_catalog.nextval (...).
+// It returns nil if there are no functions.
+func compileWriteFunctions(names []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			quoted = append(quoted, regexp.QuoteMeta(name))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	return regexp.MustCompile(`(?i)(?:^|[^\w$])(?:` + strings.Join(quoted, "|") + `)\s*\(`)
+}
+
+// isReadOnlyQuery returns true if every statement of the query is either a SELECT that
+// doesn't lock rows, create a table or call any of the write functions, or a SHOW. The
+// statements are split naively at the semicolons, so a semicolon in a literal might make
+// a read-only query look like a write, but never the other way around.
+func isReadOnlyQuery(query []byte, writeFunctions *regexp.Regexp) bool {
+	readOnly := false
+	for _, statement := range bytes.Split(query, []byte(";")) {
+		switch firstKeyword(statement) {
+		case "":
+			if len(bytes.TrimFunc(statement, unicode.IsSpace)) > 0 {
+				// The statement starts with a parenthesis or an unterminated comment.
+				return false
+			}
+			continue
+		case "SHOW":
+		case "SELECT":
+			if isWritingSelect(statement) {
+				return false
+			}
+		default:
+			return false
+		}
+
+		if writeFunctions != nil && writeFunctions.Match(statement) {
+			return false
+		}
+		readOnly = true
+	}
+
+	return readOnly
+}
+
+// isWritingSelect returns true if the SELECT statement locks the rows it reads,
+// i.e. FOR UPDATE, FOR NO KEY UPDATE, FOR SHARE and FOR KEY SHARE, or creates
+// a table with SELECT INTO.
+func isWritingSelect(statement []byte) bool {
+	words := strings.FieldsFunc(strings.ToUpper(string(statement)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for idx, word := range words {
+		switch word {
+		case "INTO":
+			return true
+		case "FOR":
+			if idx+1 < len(words) {
+				switch words[idx+1] {
+				case "UPDATE", "SHARE", "NO", "KEY":
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isReadOnlyRequest returns true if the request only runs read-only queries, either as simple
+// queries or as extended queries whose statements are parsed in the request or are prepared
+// statements of the session. The caller must hold the lock of the session.
+func (s *session) isReadOnlyRequest(request []byte, writeFunctions *regexp.Regexp) bool {
+	readOnly := false
+	parsed := make(map[string]bool)
+	forEachMessage(request, func(msgType byte, body []byte) bool {
+		var query []byte
+		switch msgType {
+		case QueryMessage:
+			query = body
+		case ParseMessage:
+			// The statement name precedes the query.
+			name, rest, ok := splitCString(body)
+			if !ok {
+				readOnly = false
+				return false
+			}
+			parsed[name] = true
+			query = rest
+		case BindMessage:
+			// The portal name precedes the statement name.
+			_, rest, _ := splitCString(body)
+			name, _, ok := splitCString(rest)
+			switch {
+			case ok && parsed[name]:
+				// The statement is parsed in the request, so it is already classified.
+				return true
+			case !ok || s.statements[name] == nil:
+				// The unnamed statement is only known if it is parsed in the request.
+				readOnly = false
+				return false
+			}
+			// The Parse message of the prepared statement has the name on the server connections.
+			_, query, _ = splitCString(s.statements[name].parse[TypedHeaderLength:])
+		case DescribeMessage, ExecuteMessage, CloseMessage, FlushMessage, SyncMessage:
+			return true
+		default:
+			readOnly = false
+			return false
+		}
+
+		if idx := bytes.IndexByte(query, 0); idx >= 0 {
+			query = query[:idx]
+		}
+		readOnly = isReadOnlyQuery(query, writeFunctions)
+		return readOnly
+	})
+	return readOnly
+}
@@ -0,0 +1,197 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_isReadOnlyQuery tests the classification of the simple queries.
+func Test_isReadOnlyQuery(t *testing.T) {
+	writeFunctions := compileWriteFunctions([]string{"nextval", " ", "audit.log_access"})
+
+	tests := []struct {
+		query    string
+		readOnly bool
+	}{
+		{"SELECT 1", true},
+		{"  select * from users where id = $1", true},
+		{"/* comment */ SELECT 1; -- comment\n SHOW search_path;", true},
+		{"SHOW ALL", true},
+		{"SELECT * FROM users FOR UPDATE", false},
+		{"SELECT * FROM users FOR NO KEY UPDATE SKIP LOCKED", false},
+		{"select * from users for share", false},
+		{"SELECT * INTO copy FROM users", false},
+		{"SELECT for_update FROM users", true},
+		{"SELECT nextval('users_id_seq')", false},
+		{"SELECT pg_catalog.NEXTVAL ('users_id_seq')", false},
+		{"SELECT audit.log_access(1)", false},
+		{"SELECT log_access(1)", true},
+		{"SELECT my_nextval(1)", true},
+		{"SELECT 1; INSERT INTO users VALUES (1)", false},
+		{"INSERT INTO users VALUES (1)", false},
+		{"WITH deleted AS (DELETE FROM users RETURNING *) SELECT * FROM deleted", false},
+		{"BEGIN", false},
+		{"(SELECT 1)", false},
+		{"", false},
+		{";", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.readOnly, isReadOnlyQuery([]byte(tt.query), writeFunctions))
+		})
+	}
+
+	assert.Nil(t, compileWriteFunctions(nil))
+	assert.True(t, isReadOnlyQuery([]byte("SELECT nextval('users_id_seq')"), nil))
+}
+
+// Test_isReadOnlyRequest tests the classification of the requests of the extended query
+// protocol, whose statements might be prepared by earlier requests of the session.
+func Test_isReadOnlyRequest(t *testing.T) {
+	encode := func(messages ...pgproto3.FrontendMessage) []byte {
+		var request []byte
+		for _, message := range messages {
+			var err error
+			request, err = message.Encode(request)
+			require.NoError(t, err)
+		}
+		return request
+	}
+
+	sess := newSession()
+	sess.startup = false
+	rewritten, _, _ := sess.rewriteStatements(&Client{}, encode(
+		&pgproto3.Parse{Name: "read", Query: "SELECT 1"},
+		&pgproto3.Parse{Name: "write", Query: "UPDATE users SET name = $1"},
+	))
+	require.NotEmpty(t, rewritten)
+
+	tests := []struct {
+		name     string
+		request  []byte
+		readOnly bool
+	}{
+		{
+			"unnamed statement",
+			encode(&pgproto3.Parse{Query: "SELECT 1"}, &pgproto3.Bind{},
+				&pgproto3.Execute{}, &pgproto3.Sync{}),
+			true,
+		},
+		{
+			"prepared read statement",
+			encode(&pgproto3.Bind{PreparedStatement: "read"},
+				&pgproto3.Describe{ObjectType: 'P'}, &pgproto3.Execute{}, &pgproto3.Sync{}),
+			true,
+		},
+		{
+			"prepared write statement",
+			encode(&pgproto3.Bind{PreparedStatement: "write"}, &pgproto3.Execute{}, &pgproto3.Sync{}),
+			false,
+		},
+		{
+			"redefined statement",
+			encode(&pgproto3.Parse{Name: "write", Query: "SELECT 2"},
+				&pgproto3.Bind{PreparedStatement: "write"}, &pgproto3.Execute{}, &pgproto3.Sync{}),
+			true,
+		},
+		{
+			"unknown statement",
+			encode(&pgproto3.Bind{}, &pgproto3.Execute{}, &pgproto3.Sync{}),
+			false,
+		},
+		{"sync", encode(&pgproto3.Sync{}), false},
+		{"function call", encode(&pgproto3.FunctionCall{Function: 1}), false},
+		{"queries", encode(&pgproto3.Query{String: "SELECT 1"}, &pgproto3.Query{String: "SHOW ALL"}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.readOnly, sess.isReadOnlyRequest(tt.request, nil))
+		})
+	}
+}
+
+// TestProxyReadWriteSplit tests that the read-only statements outside of transactions are
+// sent to the server connections of the read proxy, and everything else to the proxy itself.
+func TestProxyReadWriteSplit(t *testing.T) {
+	for _, poolMode := range []string{config.TransactionPoolMode, config.StatementPoolMode} {
+		t.Run(poolMode, func(t *testing.T) {
+			writesBackend := NewFakeAuthBackend(t, "backend-password", nil)
+			readsBackend := NewFakeAuthBackend(t, "backend-password", nil)
+			authenticator := NewAuthenticator(context.Background(), Authenticator{
+				Method:   config.AuthMethodMD5,
+				UserList: map[string]string{"alice": "secret"},
+				Logger:   zerolog.Nop(),
+			})
+			writes := newTestAuthProxy(t, writesBackend, 2, poolMode, authenticator)
+			reads := newTestAuthProxy(t, readsBackend, 2, poolMode, nil)
+			writes.ReadProxy = reads
+			writes.writeFunctions = compileWriteFunctions([]string{"nextval"})
+
+			pgConn, err := connectThroughProxy(t, writes, "alice", "secret")
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			queries := []string{
+				"SELECT 1",
+				"SHOW search_path",
+				"SELECT * FROM users FOR UPDATE",
+				"SELECT nextval('users_id_seq')",
+				"INSERT INTO users VALUES (1)",
+				// Many statements in a single query are sent to the same server connection.
+				"SELECT 2; SELECT 3",
+				"SELECT 2; DELETE FROM users",
+			}
+			for _, query := range queries {
+				_, err := pgConn.Exec(ctx, query).ReadAll()
+				require.NoError(t, err)
+			}
+
+			_, err = pgConn.Prepare(ctx, "read", "SELECT 4", nil)
+			require.NoError(t, err)
+			_, err = pgConn.ExecPrepared(ctx, "read", nil, nil, nil).Close()
+			require.NoError(t, err)
+			_, err = pgConn.ExecParams(ctx, "SELECT 5", nil, nil, nil, nil).Close()
+			require.NoError(t, err)
+			_, err = pgConn.ExecParams(ctx, "UPDATE users SET id = 2", nil, nil, nil, nil).Close()
+			require.NoError(t, err)
+
+			for _, query := range []string{"SELECT 1", "SHOW search_path", "SELECT 2; SELECT 3", "SELECT 4", "SELECT 5"} {
+				assert.Equal(t, 1, readsBackend.Executed(query), query)
+				assert.Equal(t, 0, writesBackend.Executed(query), query)
+			}
+			for _, query := range []string{
+				"SELECT * FROM users FOR UPDATE",
+				"SELECT nextval('users_id_seq')",
+				"INSERT INTO users VALUES (1)",
+				"SELECT 2; DELETE FROM users",
+				"UPDATE users SET id = 2",
+			} {
+				assert.Equal(t, 1, writesBackend.Executed(query), query)
+				assert.Equal(t, 0, readsBackend.Executed(query), query)
+			}
+
+			if poolMode == config.TransactionPoolMode {
+				// The statements in a transaction are sent to the proxy itself.
+				for _, query := range []string{"BEGIN", "SELECT 6", "COMMIT"} {
+					_, err := pgConn.Exec(ctx, query).ReadAll()
+					require.NoError(t, err)
+				}
+				assert.Equal(t, 1, writesBackend.Executed("SELECT 6"))
+				assert.Equal(t, 0, readsBackend.Executed("SELECT 6"))
+			}
+
+			// The server connections are returned to the pools they are assigned from.
+			require.Eventually(t, func() bool {
+				return reads.AvailableConnections.Size() == 2 && writes.AvailableConnections.Size() == 2
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"time"
 
+	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/rs/zerolog"
 )
 
@@ -25,6 +26,9 @@ type Retry struct {
 	BackoffMultiplier  float64
 	DisableBackoffCaps bool
 	Logger             zerolog.Logger
+	// CircuitBreaker records the outcome of each attempt, if set, and the attempts fail
+	// right away while it is open, instead of waiting for the backoff.
+	CircuitBreaker *CircuitBreaker
 }
 
 var _ IRetry = (*Retry)(nil)
@@ -87,11 +91,18 @@ func (r *Retry) Retry(callback RetryCallback) (any, error) {
 			r.Logger.Trace().Msg("First attempt to run callback")
 		}
 
+		if !r.CircuitBreaker.Allow() {
+			r.Logger.Debug().Msg("The circuit breaker is open, so the callback isn't run")
+			return nil, gerr.ErrCircuitOpen
+		}
+
 		// Try and retry the callback.
 		object, err = callback()
 		if err == nil {
+			r.CircuitBreaker.Success()
 			return object, nil
 		}
+		r.CircuitBreaker.Failure()
 
 		time.Sleep(backoffDuration)
 	}
@@ -110,6 +121,7 @@ func NewRetry(
 		BackoffMultiplier:  rty.BackoffMultiplier,
 		DisableBackoffCaps: rty.DisableBackoffCaps,
 		Logger:             rty.Logger,
+		CircuitBreaker:     rty.CircuitBreaker,
 	}
 
 	// If the number of retries is less than 0, set it to 0 to disable retries.
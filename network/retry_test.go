@@ -30,7 +30,7 @@ func TestRetry(t *testing.T) {
 			assert.ErrorContains(t, err, "callback is nil")
 		})
 		t.Run("retry without timeout", func(t *testing.T) {
-			retry := NewRetry(Retry{0, 0, 0, false, logger})
+			retry := NewRetry(Retry{0, 0, 0, false, logger, nil})
 			assert.Equal(t, 0, retry.Retries)
 			assert.Equal(t, time.Duration(0), retry.Backoff)
 			assert.Equal(t, float64(0), retry.BackoffMultiplier)
@@ -56,6 +56,7 @@ func TestRetry(t *testing.T) {
 					config.DefaultBackoffMultiplier,
 					config.DefaultDisableBackoffCaps,
 					logger,
+					nil,
 				},
 			)
 			assert.Equal(t, config.DefaultRetries, retry.Retries)
@@ -0,0 +1,257 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/metrics"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+// Roles of the servers of the proxies.
+const (
+	// RoleUnknown is the role of the server until it is checked.
+	RoleUnknown = "unknown"
+	// RolePrimary is a server that accepts writes.
+	RolePrimary = "primary"
+	// RoleStandby is a server that is in recovery or read-only.
+	RoleStandby = "standby"
+)
+
+// RoleChangedHook is the name of the custom hook that is run with each role change of the
+// server of a proxy, as the hookName argument of the OnHook hooks.
+const RoleChangedHook = "onBackendRoleChanged"
+
+// readOnlyParameters are the run-time parameters that the server reports as on if it is
+// read-only. in_hot_standby and default_transaction_read_only are reported since PostgreSQL 14.
+var readOnlyParameters = []string{"transaction_read_only", "default_transaction_read_only", "in_hot_standby"}
+
+// RoleMonitor periodically checks whether the server of a proxy is the primary or a standby, by
+// the read-only parameters that the server reports on a new server connection and by the result
+// of pg_is_in_recovery(). The role is kept if the check fails, since the reachability of the
+// server is checked by the health checks.
+type RoleMonitor struct {
+	// GroupName and ProxyName label the metrics and the role changes.
+	GroupName string
+	ProxyName string
+	Interval  time.Duration
+	Timeout   time.Duration
+	// OnFailover is the action on the incoming connections of the proxy when its server
+	// becomes a standby, either drain or kill.
+	OnFailover string
+	// OnChange is called with each role change of the server, if set, e.g. for running the
+	// plugin hooks.
+	OnChange func(RoleChange)
+	// ClientConfig is used for connecting to the server and authenticating the server connection.
+	ClientConfig *config.Client
+	Logger       zerolog.Logger
+
+	ctx       context.Context //nolint:containedctx
+	mu        *sync.Mutex
+	role      string
+	lastCheck time.Time
+	lastError string
+}
+
+// RoleChange is a change of the role of the server of a proxy.
+type RoleChange struct {
+	GroupName string
+	ProxyName string
+	Address   string
+	Previous  string
+	Current   string
+	Time      time.Time
+}
+
+// RoleStatus is the outcome of the role checks of the server of a proxy.
+type RoleStatus struct {
+	Role      string
+	LastCheck time.Time
+	LastError string
+}
+
+// NewRoleMonitor creates a new role monitor. The role of the server is unknown until it is checked.
+func NewRoleMonitor(ctx context.Context, rm RoleMonitor) *RoleMonitor {
+	roleMonitorCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewRoleMonitor")
+	defer span.End()
+
+	return &RoleMonitor{
+		GroupName: rm.GroupName,
+		ProxyName: rm.ProxyName,
+		Interval: config.If(
+			rm.Interval > 0, rm.Interval, config.DefaultRoleMonitorInterval),
+		Timeout: config.If(
+			rm.Timeout > 0, rm.Timeout, config.DefaultRoleMonitorTimeout),
+		OnFailover:   config.If(rm.OnFailover != "", rm.OnFailover, config.DefaultFailoverAction),
+		OnChange:     rm.OnChange,
+		ClientConfig: rm.ClientConfig,
+		Logger:       rm.Logger,
+		ctx:          roleMonitorCtx,
+		mu:           &sync.Mutex{},
+		role:         RoleUnknown,
+	}
+}
+
+// Role returns the last known role of the server.
+func (r *RoleMonitor) Role() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.role
+}
+
+// IsStandby returns true if the last check found that the server is a standby.
+func (r *RoleMonitor) IsStandby() bool {
+	return r.Role() == RoleStandby
+}
+
+// Status returns the outcome of the role checks.
+func (r *RoleMonitor) Status() RoleStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return RoleStatus{Role: r.role, LastCheck: r.lastCheck, LastError: r.lastError}
+}
+
+// Check checks the role of the server once, and returns the role change, if any.
+func (r *RoleMonitor) Check() (RoleChange, bool) {
+	_, span := otel.Tracer(config.TracerName).Start(r.ctx, "RoleMonitor")
+	defer span.End()
+
+	role, err := r.probe()
+	if err != nil {
+		span.RecordError(err)
+		r.Logger.Debug().Err(err).Str("proxy", r.ProxyName).Msg("Failed to check the role of the server")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastCheck = time.Now()
+	if err != nil {
+		r.lastError = err.Error()
+		return RoleChange{}, false
+	}
+	r.lastError = ""
+	if role == r.role {
+		return RoleChange{}, false
+	}
+
+	change := RoleChange{
+		GroupName: r.GroupName,
+		ProxyName: r.ProxyName,
+		Address:   r.ClientConfig.Address,
+		Previous:  r.role,
+		Current:   role,
+		Time:      r.lastCheck,
+	}
+	r.role = role
+	metrics.ProxyBackendRoleChanges.WithLabelValues(r.GroupName, r.ProxyName, role).Inc()
+	metrics.ProxyBackendPrimary.WithLabelValues(r.GroupName, r.ProxyName).Set(
+		config.If[float64](role == RolePrimary, 1, 0))
+	r.Logger.Info().Fields(
+		map[string]interface{}{
+			"group":    r.GroupName,
+			"proxy":    r.ProxyName,
+			"address":  r.ClientConfig.Address,
+			"previous": change.Previous,
+			"role":     change.Current,
+		},
+	).Msg("The role of the server of the proxy has changed")
+
+	return change, true
+}
+
+// probe connects to the server with the credentials of the client configuration, and returns
+// its role.
+func (r *RoleMonitor) probe() (string, error) {
+	clientConfig := *r.ClientConfig
+	clientConfig.DialTimeout = r.Timeout
+	clientConfig.ReceiveDeadline = r.Timeout
+	clientConfig.SendDeadline = r.Timeout
+	if clientConfig.User == "" {
+		return "", errors.New("the role monitor requires a user")
+	}
+
+	// The failures are reported by the role monitor.
+	client := NewClient(r.ctx, &clientConfig, zerolog.Nop(), nil)
+	if client == nil {
+		return "", errors.New("failed to connect to the server or to authenticate")
+	}
+	defer client.Close()
+
+	if isReadOnly(client.ServerParameters()) {
+		return RoleStandby, nil
+	}
+
+	query, err := (&pgproto3.Query{String: "SELECT pg_is_in_recovery()"}).Encode(nil)
+	if err != nil {
+		return "", gerr.ErrMsgEncodeError.Wrap(err)
+	}
+	if _, err := client.Send(query); err != nil {
+		return "", err
+	}
+
+	var inRecovery []byte
+	for {
+		_, response, err := client.Receive()
+		if err != nil {
+			return "", err
+		}
+
+		var queryErr error
+		ready := false
+		forEachMessage(response, func(msgType byte, body []byte) bool {
+			switch msgType {
+			case DataRowMessage:
+				var row pgproto3.DataRow
+				if err := row.Decode(body); err == nil && len(row.Values) > 0 {
+					inRecovery = row.Values[0]
+				}
+			case ErrorResponseMessage:
+				var errResponse pgproto3.ErrorResponse
+				if err := errResponse.Decode(body); err != nil {
+					queryErr = err
+				} else {
+					queryErr = fmt.Errorf("%s: %s", errResponse.Severity, errResponse.Message)
+				}
+			case ReadyForQueryMessage:
+				ready = true
+			}
+			return !ready
+		})
+
+		if queryErr != nil {
+			return "", queryErr
+		}
+		if ready {
+			break
+		}
+	}
+
+	switch string(inRecovery) {
+	case "t":
+		return RoleStandby, nil
+	case "f":
+		return RolePrimary, nil
+	default:
+		return "", fmt.Errorf("unexpected result of pg_is_in_recovery(): %q", inRecovery)
+	}
+}
+
+// isReadOnly returns true if any of the read-only parameters is on.
+func isReadOnly(parameters map[string]string) bool {
+	for _, name := range readOnlyParameters {
+		if parameters[name] == "on" {
+			return true
+		}
+	}
+	return false
+}
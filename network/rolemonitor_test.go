@@ -0,0 +1,113 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRoleMonitor creates a role monitor of the given fake backend, which authenticates
+// with the password of the fake backend.
+func newTestRoleMonitor(backend *FakeBackend, onFailover string, onChange func(RoleChange)) *RoleMonitor {
+	return NewRoleMonitor(context.Background(), RoleMonitor{
+		GroupName:  config.Default,
+		ProxyName:  config.DefaultConfigurationBlock,
+		Timeout:    time.Second,
+		OnFailover: onFailover,
+		OnChange:   onChange,
+		ClientConfig: &config.Client{
+			Network:  "tcp",
+			Address:  backend.Address(),
+			User:     "gatewayd",
+			Password: "backend-password",
+		},
+		Logger: zerolog.Nop(),
+	})
+}
+
+// TestRoleMonitor tests that the role changes of the server are detected by pg_is_in_recovery(),
+// and that the role is kept while the server is unreachable.
+func TestRoleMonitor(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "backend-password", nil)
+	roleMonitor := newTestRoleMonitor(backend, "", nil)
+	assert.Equal(t, RoleUnknown, roleMonitor.Role())
+	assert.Equal(t, config.FailoverDrain, roleMonitor.OnFailover)
+
+	change, changed := roleMonitor.Check()
+	require.True(t, changed)
+	assert.Equal(t, RoleUnknown, change.Previous)
+	assert.Equal(t, RolePrimary, change.Current)
+	assert.Equal(t, backend.Address(), change.Address)
+	_, changed = roleMonitor.Check()
+	assert.False(t, changed)
+
+	backend.SetInRecovery(true)
+	change, changed = roleMonitor.Check()
+	require.True(t, changed)
+	assert.Equal(t, RolePrimary, change.Previous)
+	assert.Equal(t, RoleStandby, change.Current)
+	assert.True(t, roleMonitor.IsStandby())
+	assert.Equal(t, 3, backend.Executed("SELECT pg_is_in_recovery()"))
+
+	roleMonitor.ClientConfig.Address = closedAddress(t)
+	_, changed = roleMonitor.Check()
+	assert.False(t, changed)
+	status := roleMonitor.Status()
+	assert.Equal(t, RoleStandby, status.Role)
+	assert.NotEmpty(t, status.LastError)
+	assert.False(t, status.LastCheck.IsZero())
+}
+
+// TestProxyRoleFailover tests that the load balancers skip the proxy whose server is a standby,
+// and that its incoming connections are closed by the kill failover action only.
+func TestProxyRoleFailover(t *testing.T) {
+	for _, onFailover := range []string{config.FailoverDrain, config.FailoverKill} {
+		t.Run(onFailover, func(t *testing.T) {
+			backend := NewFakeAuthBackend(t, "backend-password", nil)
+			proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+			changes := make(chan RoleChange, 2)
+			proxy.RoleMonitor = newTestRoleMonitor(backend, onFailover, func(change RoleChange) {
+				changes <- change
+			})
+
+			proxy.checkRole()
+			assert.Equal(t, RolePrimary, (<-changes).Current)
+			assert.True(t, proxy.IsBackendHealthy())
+
+			conn, client := NewTestIncomingConnection(t)
+			require.Nil(t, proxy.Connect(conn))
+
+			backend.SetInRecovery(true)
+			proxy.checkRole()
+			assert.Equal(t, RoleStandby, (<-changes).Current)
+			assert.False(t, proxy.IsBackendHealthy())
+
+			require.NoError(t, client.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+			_, err := client.Read(make([]byte, 1))
+			var netErr net.Error
+			if onFailover == config.FailoverKill {
+				// The incoming connection is closed.
+				require.Error(t, err)
+				assert.False(t, errors.As(err, &netErr) && netErr.Timeout())
+			} else {
+				// The incoming connection is still open.
+				require.ErrorAs(t, err, &netErr)
+				assert.True(t, netErr.Timeout())
+			}
+		})
+	}
+}
+
+func Test_isReadOnly(t *testing.T) {
+	assert.False(t, isReadOnly(nil))
+	assert.False(t, isReadOnly(map[string]string{"in_hot_standby": "off", "server_version": "16.0"}))
+	assert.True(t, isReadOnly(map[string]string{"transaction_read_only": "on"}))
+	assert.True(t, isReadOnly(map[string]string{"in_hot_standby": "on"}))
+}
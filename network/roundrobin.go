@@ -21,6 +21,12 @@ func (r *RoundRobin) NextProxy(_ IConnWrapper) (IProxy, *gerr.GatewayDError) {
 	if proxiesLen == 0 {
 		return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("proxy list is empty"))
 	}
-	nextIndex := r.next.Add(1)
-	return r.proxies[nextIndex%proxiesLen], nil
+	// The proxies with unhealthy servers are skipped in the round-robin order.
+	for range proxiesLen {
+		nextIndex := r.next.Add(1)
+		if proxy := r.proxies[nextIndex%proxiesLen]; proxy.IsBackendHealthy() {
+			return proxy, nil
+		}
+	}
+	return nil, gerr.ErrNoProxiesAvailable.Wrap(errNoHealthyProxies)
 }
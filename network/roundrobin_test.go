@@ -115,3 +115,30 @@ func TestNextProxyOverflow(t *testing.T) {
 		t.Fatalf("Expected next value to be %v, got %v", expectedNextValue, actualNextValue)
 	}
 }
+
+// TestRoundRobin_SkipsUnhealthyProxies tests that the round-robin load balancer skips the proxies
+// whose servers are unhealthy, and returns an error if all of them are unhealthy.
+func TestRoundRobin_SkipsUnhealthyProxies(t *testing.T) {
+	server := &Server{Proxies: []IProxy{
+		MockProxy{name: "proxy1"},
+		MockProxy{name: "proxy2", unhealthy: true},
+		MockProxy{name: "proxy3"},
+	}}
+	roundRobin := NewRoundRobin(server)
+
+	expectedOrder := []string{"proxy3", "proxy1", "proxy3", "proxy1"}
+	for testIndex, expected := range expectedOrder {
+		proxy, err := roundRobin.NextProxy(nil)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error from NextProxy: %v", testIndex, err)
+		}
+		if proxy.GetName() != expected {
+			t.Errorf("test %d: expected proxy name %s, got %s", testIndex, expected, proxy.GetName())
+		}
+	}
+
+	roundRobin = NewRoundRobin(&Server{Proxies: []IProxy{MockProxy{name: "proxy1", unhealthy: true}}})
+	if _, err := roundRobin.NextProxy(nil); err == nil {
+		t.Error("expected an error when all the proxies are unhealthy")
+	}
+}
@@ -0,0 +1,116 @@
+package network
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+// conditionalRule is a load balancing rule with a compiled condition.
+type conditionalRule struct {
+	condition string
+	program   *vm.Program
+	strategy  LoadBalancerStrategy
+}
+
+// RuleBased selects the proxy of each client by the first load balancing rule whose condition
+// matches the StartupMessage and the connection of the client, or by the DEFAULT rule if none
+// matches. The proxy is then selected by the weighted distribution of the rule, and by the
+// consistent hashing within the distribution, if enabled.
+type RuleBased struct {
+	rules       []conditionalRule
+	defaultRule LoadBalancerStrategy
+}
+
+// NewRuleBased creates a new RuleBased load balancer from the load balancing rules of the server.
+func NewRuleBased(server *Server) (*RuleBased, *gerr.GatewayDError) {
+	ruleBased := &RuleBased{}
+	for _, rule := range server.LoadbalancerRules {
+		var strategy LoadBalancerStrategy = NewWeightedRoundRobin(server, rule)
+		if server.LoadbalancerConsistentHash != nil {
+			strategy = NewConsistentHash(server, strategy)
+		}
+		if rule.Condition == config.DefaultLoadBalancerCondition {
+			if ruleBased.defaultRule == nil {
+				ruleBased.defaultRule = strategy
+			}
+			continue
+		}
+
+		program, err := config.CompileCondition(rule.Condition)
+		if err != nil {
+			return nil, gerr.ErrInvalidLoadBalancerRule.Wrap(err)
+		}
+		ruleBased.rules = append(ruleBased.rules, conditionalRule{
+			condition: rule.Condition,
+			program:   program,
+			strategy:  strategy,
+		})
+	}
+
+	return ruleBased, nil
+}
+
+// NextProxy evaluates the conditions of the rules in order, and selects the proxy by the
+// distribution of the first rule that matches.
+func (r *RuleBased) NextProxy(conn IConnWrapper) (IProxy, *gerr.GatewayDError) {
+	env := newLoadBalancingRuleEnv(conn)
+	for _, rule := range r.rules {
+		matched, err := expr.Run(rule.program, env)
+		if err != nil {
+			return nil, gerr.ErrNoProxiesAvailable.Wrap(
+				fmt.Errorf("failed to evaluate the condition %q: %w", rule.condition, err))
+		}
+		if matched == true {
+			return rule.strategy.NextProxy(conn)
+		}
+	}
+
+	if r.defaultRule != nil {
+		return r.defaultRule.NextProxy(conn)
+	}
+	return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("no load balancing rule matches the client"))
+}
+
+// hasConditions returns true if any of the rules has a condition other than DEFAULT.
+func hasConditions(rules []config.LoadBalancingRule) bool {
+	for _, rule := range rules {
+		if rule.Condition != config.DefaultLoadBalancerCondition {
+			return true
+		}
+	}
+	return false
+}
+
+// newLoadBalancingRuleEnv returns the environment of the conditions for the client.
+func newLoadBalancingRuleEnv(conn IConnWrapper) config.LoadBalancingRuleEnv {
+	parameters := conn.StartupParameters()
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+
+	env := config.LoadBalancingRuleEnv{
+		Database:        parameters["database"],
+		User:            parameters["user"],
+		ApplicationName: parameters["application_name"],
+		Parameters:      parameters,
+	}
+	// The database defaults to the user name, like in PostgreSQL.
+	if env.Database == "" {
+		env.Database = env.User
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			env.ClientIP = host
+		}
+	}
+	_, env.TLS = conn.Conn().(*tls.Conn)
+
+	return env
+}
@@ -0,0 +1,229 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStartupConnection creates an incoming connection whose StartupMessage with the
+// given parameters is already read.
+func newTestStartupConnection(t *testing.T, parameters map[string]string) *ConnWrapper {
+	t.Helper()
+
+	conn, _ := NewTestIncomingConnection(t)
+	startup, err := (&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      parameters,
+	}).Encode(nil)
+	require.NoError(t, err)
+	conn.unreadStartup(startup)
+	return conn
+}
+
+// TestRuleBased tests that the conditions of the rules are evaluated in order against the
+// StartupMessage and the connection of the client.
+func TestRuleBased(t *testing.T) {
+	server := &Server{
+		Proxies: []IProxy{
+			MockProxy{name: "analytics"},
+			MockProxy{name: "reports"},
+			MockProxy{name: "local"},
+			MockProxy{name: "default"},
+		},
+		LoadbalancerStrategyName: config.WeightedRoundRobinStrategy,
+		LoadbalancerRules: []config.LoadBalancingRule{
+			{
+				Condition:    `database == "analytics"`,
+				Distribution: []config.Distribution{{ProxyName: "analytics", Weight: 1}},
+			},
+			{
+				Condition:    `application_name startsWith "report" || parameters["options"] contains "reports"`,
+				Distribution: []config.Distribution{{ProxyName: "reports", Weight: 1}},
+			},
+			{
+				Condition:    config.DefaultLoadBalancerCondition,
+				Distribution: []config.Distribution{{ProxyName: "default", Weight: 1}},
+			},
+			{
+				Condition:    `client_ip == "127.0.0.1" && !tls && user == "alice"`,
+				Distribution: []config.Distribution{{ProxyName: "local", Weight: 1}},
+			},
+		},
+	}
+	strategy, err := NewLoadBalancerStrategy(server)
+	require.Nil(t, err)
+	require.IsType(t, &RuleBased{}, strategy)
+
+	tests := []struct {
+		parameters map[string]string
+		proxy      string
+	}{
+		{map[string]string{"user": "alice", "database": "analytics"}, "analytics"},
+		// The database defaults to the user name.
+		{map[string]string{"user": "analytics"}, "analytics"},
+		{map[string]string{"user": "bob", "application_name": "reporting"}, "reports"},
+		{map[string]string{"user": "bob", "options": "-c role=reports"}, "reports"},
+		{map[string]string{"user": "alice", "database": "app"}, "local"},
+		{map[string]string{"user": "bob", "database": "app"}, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.parameters), func(t *testing.T) {
+			proxy, err := strategy.NextProxy(newTestStartupConnection(t, tt.parameters))
+			require.Nil(t, err)
+			assert.Equal(t, tt.proxy, proxy.GetName())
+		})
+	}
+}
+
+// TestRuleBasedNoMatch tests that the clients that match no rule are rejected,
+// if there is no DEFAULT rule.
+func TestRuleBasedNoMatch(t *testing.T) {
+	server := &Server{
+		Proxies: []IProxy{MockProxy{name: "analytics"}},
+		LoadbalancerRules: []config.LoadBalancingRule{
+			{
+				Condition:    `database == "analytics"`,
+				Distribution: []config.Distribution{{ProxyName: "analytics", Weight: 1}},
+			},
+		},
+	}
+	strategy, err := NewRuleBased(server)
+	require.Nil(t, err)
+
+	_, err = strategy.NextProxy(newTestStartupConnection(t, map[string]string{"user": "alice"}))
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+
+	server.LoadbalancerRules[0].Condition = `database ==`
+	_, err = NewRuleBased(server)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeInvalidLoadBalancerRule, err.Code)
+}
+
+// TestServerReadStartup tests that the server answers the SSLRequest and the GSSENCRequest,
+// and keeps the StartupMessage for the proxy.
+func TestServerReadStartup(t *testing.T) {
+	server := &Server{Logger: zerolog.Nop()}
+	conn, client := NewTestIncomingConnection(t)
+
+	sslRequest, err := (&pgproto3.SSLRequest{}).Encode(nil)
+	require.NoError(t, err)
+	gssEncRequest, err := (&pgproto3.GSSEncRequest{}).Encode(nil)
+	require.NoError(t, err)
+	startup := CreatePgStartupPacket()
+
+	done := make(chan *ConnWrapper)
+	go func() {
+		assert.Nil(t, server.readStartup(conn))
+		done <- conn
+	}()
+
+	response := make([]byte, 1)
+	for _, request := range [][]byte{sslRequest, gssEncRequest} {
+		_, err = client.Write(request)
+		require.NoError(t, err)
+		_, err = client.Read(response)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{'N'}, response)
+	}
+	_, err = client.Write(startup)
+	require.NoError(t, err)
+
+	<-done
+	assert.Equal(t, "postgres", conn.StartupParameters()["database"])
+	request, err := conn.ReadMessages(config.DefaultChunkSize)
+	require.NoError(t, err)
+	assert.Equal(t, startup, request)
+}
+
+// TestServerRoutesByStartup tests that the server selects the proxy of each client by the
+// conditions of the load balancing rules, once the StartupMessage is received.
+func TestServerRoutesByStartup(t *testing.T) {
+	analyticsBackend := NewFakeBackend(t)
+	appBackend := NewFakeBackend(t)
+	analytics := newTestPooledProxy(t, analyticsBackend, 2, config.SessionPoolMode)
+	analytics.Name = "analytics"
+	app := newTestPooledProxy(t, appBackend, 2, config.SessionPoolMode)
+	app.Name = "app"
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:15432",
+			Proxies:                  []IProxy{analytics, app},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           analytics.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.WeightedRoundRobinStrategy,
+			LoadbalancerRules: []config.LoadBalancingRule{
+				{
+					Condition:    `database == "analytics"`,
+					Distribution: []config.Distribution{{ProxyName: "analytics", Weight: 1}},
+				},
+				{
+					Condition:    `database == "app"`,
+					Distribution: []config.Distribution{{ProxyName: "app", Weight: 1}},
+				},
+			},
+		},
+	)
+	require.NotNil(t, server)
+	// The server doesn't shut down when the connections are closed.
+	server.Status = config.Running
+
+	connect := func(database string) error {
+		conn, client := NewTestIncomingConnection(t)
+		_, action := server.OnOpen(conn)
+		require.Equal(t, None, action)
+		// No proxy is selected before the StartupMessage is received.
+		_, exists := server.GetProxyForConnection(conn)
+		assert.False(t, exists)
+
+		// Both goroutines that pass the traffic stop the connection.
+		stopConnection := make(chan struct{}, 2)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.OnTraffic(conn, stopConnection)
+			server.OnClose(conn, nil)
+		}()
+		// Stop passing the traffic before the proxies are shut down.
+		t.Cleanup(func() {
+			client.Close()
+			<-done
+		})
+
+		pgConfig, err := pgconn.ParseConfig(
+			fmt.Sprintf("postgres://alice@127.0.0.1/%s?sslmode=disable", database))
+		require.NoError(t, err)
+		pgConfig.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+			return client, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err = pgconn.ConnectConfig(ctx, pgConfig)
+		return err
+	}
+
+	require.NoError(t, connect("analytics"))
+	require.NoError(t, connect("app"))
+	require.NoError(t, connect("app"))
+	assert.Equal(t, 1, analyticsBackend.Logins("alice", "analytics"))
+	assert.Equal(t, 2, appBackend.Logins("alice", "app"))
+
+	// The client is disconnected if no rule matches.
+	require.Error(t, connect("other"))
+}
@@ -0,0 +1,340 @@
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// SCRAMSHA256 is the name of the SASL mechanism that is supported by the proxy.
+	SCRAMSHA256 = "SCRAM-SHA-256"
+	// SCRAMIterations is the iteration count of the secrets that are derived from plain
+	// text passwords, which is the same as the default of PostgreSQL.
+	SCRAMIterations = 4096
+
+	scramSaltLength  = 16
+	scramNonceLength = 18
+	// scramGS2Header is the GS2 header of the clients that don't support channel binding.
+	scramGS2Header = "n,,"
+	md5Prefix      = "md5"
+)
+
+var (
+	errInvalidSCRAMMessage = errors.New("invalid SCRAM message")
+	errInvalidSCRAMNonce   = errors.New("invalid SCRAM nonce")
+	errInvalidSCRAMProof   = errors.New("invalid SCRAM proof")
+)
+
+// scramSecret is the SCRAM-SHA-256 secret of a user, as stored by PostgreSQL in the
+// form of SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>.
+type scramSecret struct {
+	iterations int
+	salt       []byte
+	storedKey  []byte
+	serverKey  []byte
+}
+
+// parseSCRAMSecret parses a SCRAM-SHA-256 secret. It returns false if the secret is not
+// a SCRAM-SHA-256 secret, e.g. if it is a plain text password or an md5 secret.
+func parseSCRAMSecret(secret string) (*scramSecret, bool) {
+	mechanism, rest, ok := strings.Cut(secret, "$")
+	if !ok || mechanism != SCRAMSHA256 {
+		return nil, false
+	}
+
+	params, keys, ok := strings.Cut(rest, "$")
+	if !ok {
+		return nil, false
+	}
+	iterations, salt, ok := strings.Cut(params, ":")
+	if !ok {
+		return nil, false
+	}
+	storedKey, serverKey, ok := strings.Cut(keys, ":")
+	if !ok {
+		return nil, false
+	}
+
+	var err error
+	parsed := &scramSecret{}
+	if parsed.iterations, err = strconv.Atoi(iterations); err != nil || parsed.iterations <= 0 {
+		return nil, false
+	}
+	if parsed.salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return nil, false
+	}
+	if parsed.storedKey, err = base64.StdEncoding.DecodeString(storedKey); err != nil {
+		return nil, false
+	}
+	if parsed.serverKey, err = base64.StdEncoding.DecodeString(serverKey); err != nil {
+		return nil, false
+	}
+	if len(parsed.storedKey) != sha256.Size || len(parsed.serverKey) != sha256.Size {
+		return nil, false
+	}
+
+	return parsed, true
+}
+
+// newSCRAMSecret derives a SCRAM-SHA-256 secret from a plain text password.
+func newSCRAMSecret(password string, salt []byte, iterations int) *scramSecret {
+	_, storedKey, serverKey := scramKeys(saltPassword(password, salt, iterations))
+	return &scramSecret{
+		iterations: iterations,
+		salt:       salt,
+		storedKey:  storedKey,
+		serverKey:  serverKey,
+	}
+}
+
+// String encodes the secret in the format of PostgreSQL.
+func (s *scramSecret) String() string {
+	return fmt.Sprintf("%s$%d:%s$%s:%s",
+		SCRAMSHA256,
+		s.iterations,
+		base64.StdEncoding.EncodeToString(s.salt),
+		base64.StdEncoding.EncodeToString(s.storedKey),
+		base64.StdEncoding.EncodeToString(s.serverKey),
+	)
+}
+
+// saltPassword returns the SaltedPassword of RFC 5802.
+func saltPassword(password string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+}
+
+// scramKeys returns the ClientKey, StoredKey and ServerKey of RFC 5802.
+func scramKeys(saltedPassword []byte) ([]byte, []byte, []byte) {
+	clientKey := computeHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := computeHMAC(saltedPassword, []byte("Server Key"))
+	return clientKey, storedKey[:], serverKey
+}
+
+func computeHMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// randomBytes returns cryptographically secure random bytes.
+func randomBytes(length int) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	return data, nil
+}
+
+// randomNonce returns a random nonce, which only consists of printable characters.
+func randomNonce() (string, error) {
+	data, err := randomBytes(scramNonceLength)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// parseSCRAMAttributes parses a comma-separated list of SCRAM attributes, e.g. r=...,s=...
+func parseSCRAMAttributes(message string) map[byte]string {
+	attributes := make(map[byte]string)
+	for _, attribute := range strings.Split(message, ",") {
+		if len(attribute) < 2 || attribute[1] != '=' {
+			continue
+		}
+		attributes[attribute[0]] = attribute[2:]
+	}
+	return attributes
+}
+
+// scramClientKeys are the ClientKey and the ServerKey of a user. The ClientKey is recovered
+// from the proof of a client, so that the proxy can authenticate to the server as the user
+// without knowing the password, as long as the server has the same secret.
+type scramClientKeys struct {
+	clientKey []byte
+	serverKey []byte
+}
+
+// scramServer is the server side of a SCRAM-SHA-256 exchange, which authenticates
+// a client against the secret of the user.
+type scramServer struct {
+	secret          *scramSecret
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	// keys are set once the proof of the client is verified.
+	keys *scramClientKeys
+}
+
+// ServerFirst handles the client-first-message and returns the server-first-message.
+func (s *scramServer) ServerFirst(clientFirst []byte) ([]byte, error) {
+	message := string(clientFirst)
+	// Channel binding is not supported, so the client must not require it.
+	if !strings.HasPrefix(message, scramGS2Header) && !strings.HasPrefix(message, "y,,") {
+		return nil, errInvalidSCRAMMessage
+	}
+	s.gs2Header = message[:len(scramGS2Header)]
+	s.clientFirstBare = message[len(scramGS2Header):]
+
+	clientNonce := parseSCRAMAttributes(s.clientFirstBare)['r']
+	if clientNonce == "" {
+		return nil, errInvalidSCRAMNonce
+	}
+
+	serverNonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	s.nonce = clientNonce + serverNonce
+	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d",
+		s.nonce, base64.StdEncoding.EncodeToString(s.secret.salt), s.secret.iterations)
+
+	return []byte(s.serverFirst), nil
+}
+
+// ServerFinal verifies the proof of the client-final-message and returns the
+// server-final-message, which proves to the client that the server knows the secret.
+func (s *scramServer) ServerFinal(clientFinal []byte) ([]byte, error) {
+	message := string(clientFinal)
+	idx := strings.LastIndex(message, ",p=")
+	if idx < 0 {
+		return nil, errInvalidSCRAMMessage
+	}
+	withoutProof := message[:idx]
+	attributes := parseSCRAMAttributes(withoutProof)
+
+	if attributes['c'] != base64.StdEncoding.EncodeToString([]byte(s.gs2Header)) {
+		return nil, errInvalidSCRAMMessage
+	}
+	if attributes['r'] != s.nonce {
+		return nil, errInvalidSCRAMNonce
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(message[idx+len(",p="):])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, errInvalidSCRAMProof
+	}
+
+	authMessage := []byte(s.clientFirstBare + "," + s.serverFirst + "," + withoutProof)
+	clientSignature := computeHMAC(s.secret.storedKey, authMessage)
+	clientKey := make([]byte, sha256.Size)
+	subtle.XORBytes(clientKey, proof, clientSignature)
+	storedKey := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(storedKey[:], s.secret.storedKey) != 1 {
+		return nil, errInvalidSCRAMProof
+	}
+	s.keys = &scramClientKeys{clientKey: clientKey, serverKey: s.secret.serverKey}
+
+	serverSignature := computeHMAC(s.secret.serverKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// scramClient is the client side of a SCRAM-SHA-256 exchange, which authenticates
+// the proxy to the server with a plain text password, or with the keys of a user.
+type scramClient struct {
+	password        string
+	keys            *scramClientKeys
+	clientFirstBare string
+	clientNonce     string
+	serverSignature []byte
+}
+
+// ClientFirst returns the client-first-message. The user name is sent in the
+// StartupMessage, so it is left empty, like PostgreSQL clients do.
+func (c *scramClient) ClientFirst() ([]byte, error) {
+	nonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	c.clientNonce = nonce
+	c.clientFirstBare = "n=,r=" + nonce
+	return []byte(scramGS2Header + c.clientFirstBare), nil
+}
+
+// ClientFinal handles the server-first-message and returns the client-final-message.
+func (c *scramClient) ClientFinal(serverFirst []byte) ([]byte, error) {
+	attributes := parseSCRAMAttributes(string(serverFirst))
+
+	nonce := attributes['r']
+	if !strings.HasPrefix(nonce, c.clientNonce) || len(nonce) == len(c.clientNonce) {
+		return nil, errInvalidSCRAMNonce
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes['s'])
+	if err != nil {
+		return nil, errInvalidSCRAMMessage
+	}
+	iterations, err := strconv.Atoi(attributes['i'])
+	if err != nil || iterations <= 0 {
+		return nil, errInvalidSCRAMMessage
+	}
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(scramGS2Header)) + ",r=" + nonce
+	authMessage := []byte(c.clientFirstBare + "," + string(serverFirst) + "," + withoutProof)
+
+	var clientKey, storedKey, serverKey []byte
+	if c.keys != nil {
+		hash := sha256.Sum256(c.keys.clientKey)
+		clientKey, storedKey, serverKey = c.keys.clientKey, hash[:], c.keys.serverKey
+	} else {
+		clientKey, storedKey, serverKey = scramKeys(saltPassword(c.password, salt, iterations))
+	}
+	clientSignature := computeHMAC(storedKey, authMessage)
+	proof := make([]byte, sha256.Size)
+	subtle.XORBytes(proof, clientKey, clientSignature)
+	c.serverSignature = computeHMAC(serverKey, authMessage)
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// Verify verifies the server signature of the server-final-message.
+func (c *scramClient) Verify(serverFinal []byte) error {
+	signature, err := base64.StdEncoding.DecodeString(parseSCRAMAttributes(string(serverFinal))['v'])
+	if err != nil || !hmac.Equal(signature, c.serverSignature) {
+		return errInvalidSCRAMProof
+	}
+	return nil
+}
+
+// md5Secret returns the md5 secret of a user, as stored by PostgreSQL.
+func md5Secret(user, password string) string {
+	hash := md5.Sum([]byte(password + user)) //nolint:gosec
+	return md5Prefix + hex.EncodeToString(hash[:])
+}
+
+// md5Response returns the response to an md5 challenge with the given salt.
+func md5Response(secret string, salt []byte) string {
+	hash := md5.Sum(append([]byte(strings.TrimPrefix(secret, md5Prefix)), salt...)) //nolint:gosec
+	return md5Prefix + hex.EncodeToString(hash[:])
+}
+
+// isMD5Secret returns true if the secret is an md5 secret rather than a plain text password.
+func isMD5Secret(secret string) bool {
+	if len(secret) != len(md5Prefix)+md5.Size*2 || !strings.HasPrefix(secret, md5Prefix) {
+		return false
+	}
+	_, err := hex.DecodeString(secret[len(md5Prefix):])
+	return err == nil
+}
+
+// verifyMD5 verifies the response of a client to an md5 challenge against the secret or
+// the plain text password of the user.
+func verifyMD5(user, secret string, salt []byte, response []byte) bool {
+	if !isMD5Secret(secret) {
+		secret = md5Secret(user, secret)
+	}
+	expected := md5Response(secret, salt)
+	return subtle.ConstantTimeCompare([]byte(expected), bytes.TrimRight(response, "\x00")) == 1
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_scramExchange tests the SCRAM-SHA-256 exchange between the client and the server.
+func Test_scramExchange(t *testing.T) {
+	secret := newSCRAMSecret("password", []byte("0123456789abcdef"), SCRAMIterations)
+
+	tests := []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{name: "valid password", password: "password"},
+		{name: "invalid password", password: "wrong", err: errInvalidSCRAMProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &scramClient{password: tt.password}
+			server := &scramServer{secret: secret}
+
+			clientFirst, err := client.ClientFirst()
+			require.NoError(t, err)
+			serverFirst, err := server.ServerFirst(clientFirst)
+			require.NoError(t, err)
+			clientFinal, err := client.ClientFinal(serverFirst)
+			require.NoError(t, err)
+
+			serverFinal, err := server.ServerFinal(clientFinal)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NoError(t, client.Verify(serverFinal))
+		})
+	}
+}
+
+// Test_scramServerNonce tests that the server rejects a client-final-message with another nonce.
+func Test_scramServerNonce(t *testing.T) {
+	server := &scramServer{secret: newSCRAMSecret("password", []byte("salt"), 1)}
+
+	_, err := server.ServerFirst([]byte("n,,n=,r=abc"))
+	require.NoError(t, err)
+	_, err = server.ServerFinal([]byte("c=biws,r=abcdef,p=AAAA"))
+	assert.ErrorIs(t, err, errInvalidSCRAMNonce)
+
+	// Channel binding is not supported.
+	_, err = server.ServerFirst([]byte("p=tls-server-end-point,,n=,r=abc"))
+	assert.ErrorIs(t, err, errInvalidSCRAMMessage)
+}
+
+// Test_parseSCRAMSecret tests parsing the SCRAM-SHA-256 secrets of PostgreSQL.
+func Test_parseSCRAMSecret(t *testing.T) {
+	secret := newSCRAMSecret("password", []byte("0123456789abcdef"), SCRAMIterations)
+
+	parsed, ok := parseSCRAMSecret(secret.String())
+	require.True(t, ok)
+	assert.Equal(t, secret, parsed)
+
+	for _, invalid := range []string{
+		"",
+		"password",
+		md5Secret("user", "password"),
+		"SCRAM-SHA-256$4096:c2FsdA==",
+		"SCRAM-SHA-256$0:c2FsdA==$AAAA:AAAA",
+		"SCRAM-SHA-256$4096:c2FsdA==$AAAA:AAAA",
+	} {
+		_, ok := parseSCRAMSecret(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+// Test_verifyMD5 tests verifying the responses to md5 challenges.
+func Test_verifyMD5(t *testing.T) {
+	salt := []byte{1, 2, 3, 4}
+	response := []byte(md5Response(md5Secret("user", "password"), salt) + "\x00")
+
+	assert.True(t, isMD5Secret(md5Secret("user", "password")))
+	assert.False(t, isMD5Secret("md5password"))
+	assert.True(t, verifyMD5("user", "password", salt, response))
+	assert.True(t, verifyMD5("user", md5Secret("user", "password"), salt, response))
+	assert.False(t, verifyMD5("user", "wrong", salt, response))
+	assert.False(t, verifyMD5("other", "password", salt, response))
+}
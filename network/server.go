@@ -3,6 +3,7 @@ package network
 import (
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -28,6 +29,10 @@ type Option struct {
 
 type Action int
 
+// drainPollInterval is the interval of closing the incoming connections that are between
+// transactions, while the server drains.
+const drainPollInterval = 100 * time.Millisecond
+
 const (
 	None Action = iota
 	Close
@@ -43,6 +48,7 @@ type IServer interface {
 	OnTick() (time.Duration, Action)
 	Run() *gerr.GatewayDError
 	Shutdown()
+	Drain() int
 	IsRunning() bool
 	CountConnections() int
 }
@@ -66,12 +72,40 @@ type Server struct {
 	CertFile         string
 	KeyFile          string
 	HandshakeTimeout time.Duration
+	MinTLSVersion    string
+	MaxTLSVersion    string
+	CipherSuites     []string
+	ClientCAFile     string
+	ClientAuth       string
+
+	// AcceptProxyProtocol requires a PROXY protocol header on the connections from the
+	// TrustedProxies, and from no address if there are none, whose addresses are then
+	// replaced by the addresses of the clients in the header.
+	AcceptProxyProtocol bool
+	TrustedProxies      []string
+	trustedProxies      []*net.IPNet
+
+	// DrainTimeout is how long the clients may finish their transactions once the server
+	// is drained, before it is shut down.
+	DrainTimeout time.Duration
+
+	// Listener is inherited, i.e. taken over from the GatewayD process that is upgraded or
+	// passed by systemd, and the server accepts the connections on it instead of listening
+	// on the address.
+	Listener net.Listener
+
+	// ErrorResponses override the ErrorResponses that are sent to the clients whose sessions
+	// are ended by GatewayD errors, by the names of the errors.
+	ErrorResponses map[string]config.ErrorResponse
+	errorResponses map[gerr.ErrCode]config.ErrorResponse
 
 	listener    net.Listener
 	host        string
 	port        int
 	connections uint32
 	running     *atomic.Bool
+	draining    *atomic.Bool
+	handedOff   *atomic.Bool
 	stopServer  chan struct{}
 
 	// loadbalancer
@@ -132,6 +166,7 @@ func (s *Server) OnBoot() Action {
 
 // OnOpen is called when a new connection is opened. It calls the OnOpening and OnOpened hooks.
 // It also checks if the server is at the soft or hard limit and closes the connection if it is.
+// The hooks run before the TLS handshake, so they don't carry the client certificate.
 func (s *Server) OnOpen(conn *ConnWrapper) ([]byte, Action) {
 	_, span := otel.Tracer("gatewayd").Start(s.ctx, "OnOpen")
 	defer span.End()
@@ -143,10 +178,7 @@ func (s *Server) OnOpen(conn *ConnWrapper) ([]byte, Action) {
 	defer cancel()
 	// Run the OnOpening hooks.
 	onOpeningData := map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn.Conn()),
-			"remote": RemoteAddr(conn.Conn()),
-		},
+		"client": clientData(conn.Conn()),
 	}
 	_, err := s.PluginRegistry.Run(
 		pluginTimeoutCtx, onOpeningData, v1.HookName_HOOK_NAME_ON_OPENING)
@@ -156,54 +188,163 @@ func (s *Server) OnOpen(conn *ConnWrapper) ([]byte, Action) {
 	}
 	span.AddEvent("Ran the OnOpening hooks")
 
+	// Run the OnOpened hooks.
+	pluginTimeoutCtx, cancel = context.WithTimeout(context.Background(), s.PluginTimeout)
+	defer cancel()
+
+	onOpenedData := map[string]interface{}{
+		"client": clientData(conn.Conn()),
+	}
+	_, err = s.PluginRegistry.Run(
+		pluginTimeoutCtx, onOpenedData, v1.HookName_HOOK_NAME_ON_OPENED)
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to run OnOpened hook")
+		span.RecordError(err)
+	}
+	span.AddEvent("Ran the OnOpened hooks")
+
+	metrics.ClientConnections.Inc()
+
+	return nil, None
+}
+
+// connectProxy selects the proxy of the incoming connection by the load balancer, and connects
+// the incoming connection to a server connection of the proxy.
+func (s *Server) connectProxy(conn *ConnWrapper) Action {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "connectProxy")
+	defer span.End()
+
 	// Attempt to retrieve the next proxy.
-	proxy, err := s.loadbalancerStrategy.NextProxy(conn)
+	strategy, _ := s.loadBalancer()
+	proxy, err := strategy.NextProxy(conn)
 	if err != nil {
 		span.RecordError(err)
 		s.Logger.Error().Err(err).Msg("failed to retrieve next proxy")
-		return nil, Close
+		s.sendStartupError(conn, newErrorResponse(s.errorResponses, err))
+		return Close
 	}
 
 	// Use the proxy to connect to the backend. Close the connection if the pool is exhausted.
 	// This effectively get a connection from the pool and puts both the incoming and the server
 	// connections in the pool of the busy connections.
 	if err := proxy.Connect(conn); err != nil {
-		if errors.Is(err, gerr.ErrPoolExhausted) {
-			span.RecordError(err)
-			return nil, Close
-		}
-
-		// This should never happen.
-		// TODO: Send error to client or retry connection
-		s.Logger.Error().Err(err).Msg("Failed to connect to proxy")
 		span.RecordError(err)
-		return nil, None
+		switch {
+		case errors.Is(err, gerr.ErrPoolExhausted):
+			s.Logger.Debug().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+				"No server connection is available for the client")
+		case errors.Is(err, gerr.ErrCircuitOpen):
+			s.Logger.Debug().Err(err).Str("from", RemoteAddr(conn.Conn())).Msg(
+				"The server of the proxy is unavailable for the client")
+		default:
+			s.Logger.Error().Err(err).Msg("Failed to connect to proxy")
+		}
+		s.sendStartupError(conn, newErrorResponse(s.errorResponses, err))
+		return Close
 	}
 
 	// Assign connection to proxy
+	s.mu.Lock()
 	s.connectionToProxyMap[conn] = proxy
+	s.mu.Unlock()
 
-	// Run the OnOpened hooks.
-	pluginTimeoutCtx, cancel = context.WithTimeout(context.Background(), s.PluginTimeout)
-	defer cancel()
+	return None
+}
 
-	onOpenedData := map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn.Conn()),
-			"remote": RemoteAddr(conn.Conn()),
-		},
+// sendStartupError tells the client that no server connection is available for it, e.g. since
+// there are too many clients or since the server is unavailable. Like PostgreSQL, the error is
+// sent in response to the StartupMessage, which is read first if it isn't already, so that the
+// client receives the error before the connection is closed. The SSLRequest and GSSENCRequest
+// that precede it are answered beforehand, since the client would take the error for their
+// answer otherwise. The CancelRequests aren't answered.
+func (s *Server) sendStartupError(conn *ConnWrapper, response *ErrorResponse) {
+	if response == nil {
+		return
 	}
-	_, err = s.PluginRegistry.Run(
-		pluginTimeoutCtx, onOpenedData, v1.HookName_HOOK_NAME_ON_OPENED)
-	if err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to run OnOpened hook")
-		span.RecordError(err)
+	if conn.startup == nil {
+		if s.HandshakeTimeout > 0 {
+			if err := conn.Conn().SetReadDeadline(time.Now().Add(s.HandshakeTimeout)); err == nil {
+				defer conn.Conn().SetReadDeadline(time.Time{}) //nolint:errcheck
+			}
+		}
+		if err := s.readStartup(conn); err != nil {
+			s.Logger.Debug().Err(err).Msg("Failed to read the startup message")
+			return
+		}
 	}
-	span.AddEvent("Ran the OnOpened hooks")
 
-	metrics.ClientConnections.Inc()
+	if isCancelRequest(conn.startup) {
+		return
+	}
 
-	return nil, None
+	// https://www.postgresql.org/docs/current/errcodes-appendix.html
+	if err := conn.sendErrorResponse(response); err != nil {
+		s.Logger.Debug().Err(err).Msg("Failed to send the error response to the client")
+	}
+}
+
+// cancelRequest forwards the CancelRequest by the proxy whose keys include the key of the
+// request, which then sends it to the server of the proxy that issued the key.
+func (s *Server) cancelRequest(request []byte) {
+	_, proxies := s.loadBalancer()
+	for _, proxy := range proxies {
+		if proxy.CancelRequest(request) {
+			return
+		}
+	}
+	s.Logger.Debug().Msg("Ignored a cancel request with an unknown key")
+}
+
+// requiresClientCert returns true if the clients must present a certificate in the TLS handshake.
+func (s *Server) requiresClientCert() bool {
+	return s.EnableTLS &&
+		(s.ClientAuth == config.ClientAuthRequire || s.ClientAuth == config.ClientAuthVerify)
+}
+
+// endSession ends the session of the client by the error of either direction of its traffic. The
+// client is told by the ErrorResponse of the error, unless its session is already ended.
+func (s *Server) endSession(conn *ConnWrapper, err *gerr.GatewayDError) {
+	if err := conn.sendErrorResponse(newErrorResponse(s.errorResponses, err)); err != nil {
+		s.Logger.Debug().Err(err).Msg("Failed to send the error response to the client")
+	}
+}
+
+// readStartup reads the messages of the incoming connection up to the StartupMessage, before
+// the proxy is selected, so that the load balancer can select it by the StartupMessage and the
+// CancelRequests are forwarded without a server connection. The SSLRequest and the
+// GSSENCRequest are answered like the proxy does, and the StartupMessage is kept for the proxy.
+func (s *Server) readStartup(conn *ConnWrapper) *gerr.GatewayDError {
+	for {
+		request, err := conn.ReadMessages(config.DefaultChunkSize)
+		if err != nil {
+			return gerr.ErrReadFailed.Wrap(err)
+		}
+
+		var code uint32
+		if len(request) >= UntypedHeaderLength+4 {
+			code = binary.BigEndian.Uint32(request[UntypedHeaderLength:])
+		}
+		switch {
+		case code == SSLRequestCode && conn.IsTLSEnabled():
+			// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-FLOW-SSL
+			if err := conn.UpgradeToTLS(func(net.Conn) {
+				if _, err := conn.Write([]byte{'S'}); err != nil {
+					s.Logger.Error().Err(err).Msg("Failed to acknowledge the SSL request")
+				}
+			}); err != nil {
+				return err
+			}
+			metrics.TLSConnections.Inc()
+		case code == SSLRequestCode, code == GSSENCRequestCode:
+			// The client continues in plaintext.
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return gerr.ErrServerSendFailed.Wrap(err)
+			}
+		default:
+			conn.unreadStartup(request)
+			return nil
+		}
+	}
 }
 
 // OnClose is called when a connection is closed. It calls the OnClosing and OnClosed hooks.
@@ -220,15 +361,16 @@ func (s *Server) OnClose(conn *ConnWrapper, err error) Action {
 	defer cancel()
 
 	data := map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn.Conn()),
-			"remote": RemoteAddr(conn.Conn()),
-		},
-		"error": "",
+		"client": clientData(conn.Conn()),
+		"error":  "",
 	}
 	if err != nil {
 		data["error"] = err.Error()
 	}
+	// The plugins are told why the session of the client is ended by GatewayD.
+	if response := conn.ErrorResponse(); response != nil {
+		data["errorResponse"] = response.data()
+	}
 	_, gatewaydErr := s.PluginRegistry.Run(
 		pluginTimeoutCtx, data, v1.HookName_HOOK_NAME_ON_CLOSING)
 	if gatewaydErr != nil {
@@ -246,24 +388,24 @@ func (s *Server) OnClose(conn *ConnWrapper, err error) Action {
 
 	// Find the proxy associated with the given connection
 	proxy, exists := s.GetProxyForConnection(conn)
-	if !exists {
-		// Log an error and return Close if no matching proxy is found
-		s.Logger.Error().Msg("Failed to find proxy to disconnect it")
-		return Close
-	}
+	if exists {
+		// Disconnect the connection from the proxy. This effectively removes the mapping between
+		// the incoming and the server connections in the pool of the busy connections and either
+		// recycles or disconnects the connections.
+		if err := proxy.Disconnect(conn); err != nil {
+			s.Logger.Error().Err(err).Msg("Failed to disconnect the server connection")
+			span.RecordError(err)
+			return Close
+		}
 
-	// Disconnect the connection from the proxy. This effectively removes the mapping between
-	// the incoming and the server connections in the pool of the busy connections and either
-	// recycles or disconnects the connections.
-	if err := proxy.Disconnect(conn); err != nil {
-		s.Logger.Error().Err(err).Msg("Failed to disconnect the server connection")
-		span.RecordError(err)
-		return Close
+		// remove a connection from proxy connention map
+		s.RemoveConnectionFromMap(conn)
+	} else {
+		// The connection is closed before it is connected to a proxy, e.g. if no load balancing
+		// rule matches the client, or if the pool of the proxy stays exhausted.
+		span.AddEvent("Closed the connection before connecting it to a proxy")
 	}
 
-	// remove a connection from proxy connention map
-	s.RemoveConnectionFromMap(conn)
-
 	if conn.IsTLSEnabled() {
 		metrics.TLSConnections.Dec()
 	}
@@ -280,11 +422,8 @@ func (s *Server) OnClose(conn *ConnWrapper, err error) Action {
 	defer cancel()
 
 	data = map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn.Conn()),
-			"remote": RemoteAddr(conn.Conn()),
-		},
-		"error": "",
+		"client": clientData(conn.Conn()),
+		"error":  "",
 	}
 	if err != nil {
 		data["error"] = err.Error()
@@ -303,7 +442,9 @@ func (s *Server) OnClose(conn *ConnWrapper, err error) Action {
 }
 
 // OnTraffic is called when data is received from the client. It calls the OnTraffic hooks.
-// It then passes the traffic to the proxied connection.
+// It then passes the traffic to the proxied connection. The hooks run once, before the
+// TLS handshake, so they don't carry the client certificate, unlike the traffic hooks of
+// the proxy.
 func (s *Server) OnTraffic(conn *ConnWrapper, stopConnection chan struct{}) Action {
 	_, span := otel.Tracer("gatewayd").Start(s.ctx, "OnTraffic")
 	defer span.End()
@@ -313,10 +454,7 @@ func (s *Server) OnTraffic(conn *ConnWrapper, stopConnection chan struct{}) Acti
 	defer cancel()
 
 	onTrafficData := map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn.Conn()),
-			"remote": RemoteAddr(conn.Conn()),
-		},
+		"client": clientData(conn.Conn()),
 	}
 	_, err := s.PluginRegistry.Run(
 		pluginTimeoutCtx, onTrafficData, v1.HookName_HOOK_NAME_ON_TRAFFIC)
@@ -326,6 +464,41 @@ func (s *Server) OnTraffic(conn *ConnWrapper, stopConnection chan struct{}) Acti
 	}
 	span.AddEvent("Ran the OnTraffic hooks")
 
+	// Select the proxy of the connection, which the load balancer might select by the StartupMessage.
+	// This is done here instead of OnOpen, so that reading the StartupMessage, or waiting for a
+	// server connection while the pool is exhausted, doesn't block accepting connections.
+	if _, exists := s.GetProxyForConnection(conn); !exists {
+		if err := s.readStartup(conn); err != nil {
+			s.Logger.Debug().Err(err).Msg("Failed to read the startup message")
+			span.RecordError(err)
+			return Close
+		}
+		// The CancelRequest is forwarded on a new connection to the server, instead of
+		// through a server connection of the pool, which might be exhausted by the very
+		// queries that are canceled.
+		if isCancelRequest(conn.startup) {
+			s.cancelRequest(conn.startup)
+			span.AddEvent("Forwarded the cancel request")
+			return Close
+		}
+		// The client certificates are only verified in the TLS handshake, which the clients
+		// would skip by starting in plaintext.
+		if s.requiresClientCert() && conn.tlsConn == nil {
+			s.Logger.Debug().Str("from", RemoteAddr(conn.Conn())).Msg(
+				"Refused the client that started without TLS")
+			if err := conn.sendErrorResponse(&ErrorResponse{
+				SQLState: InvalidAuthorizationCode,
+				Message:  "SSL is required for the client certificate authentication",
+			}); err != nil {
+				s.Logger.Debug().Err(err).Msg("Failed to send the error response to the client")
+			}
+			return Close
+		}
+		if action := s.connectProxy(conn); action != None {
+			return Close
+		}
+	}
+
 	stack := NewStack()
 
 	// Pass the traffic from the client to server.
@@ -345,6 +518,7 @@ func (s *Server) OnTraffic(conn *ConnWrapper, stopConnection chan struct{}) Acti
 			if err := proxy.PassThroughToServer(conn, stack); err != nil {
 				server.Logger.Trace().Err(err).Msg("Failed to pass through traffic")
 				span.RecordError(err)
+				server.endSession(conn, err)
 				stopConnection <- struct{}{}
 				break
 			}
@@ -367,6 +541,7 @@ func (s *Server) OnTraffic(conn *ConnWrapper, stopConnection chan struct{}) Acti
 			if err := proxy.PassThroughToClient(conn, stack); err != nil {
 				server.Logger.Trace().Err(err).Msg("Failed to pass through traffic")
 				span.RecordError(err)
+				server.endSession(conn, err)
 				stopConnection <- struct{}{}
 				break
 			}
@@ -400,7 +575,8 @@ func (s *Server) OnShutdown() {
 	span.AddEvent("Ran the OnShutdown hooks")
 
 	// Shutdown proxies.
-	for _, proxy := range s.Proxies {
+	_, proxies := s.loadBalancer()
+	for _, proxy := range proxies {
 		proxy.Shutdown()
 	}
 
@@ -485,10 +661,19 @@ func (s *Server) Run() *gerr.GatewayDError {
 		return nil
 	}
 
-	listener, origErr := net.Listen(s.Network, addr)
-	if origErr != nil {
-		s.Logger.Error().Err(origErr).Msg("Server failed to start listening")
-		return gerr.ErrServerListenFailed.Wrap(origErr)
+	// The inherited listener keeps the connections of the clients in its queue, which are
+	// accepted once the server is running, so the clients are never refused on upgrades.
+	listener := s.Listener
+	if listener == nil {
+		var origErr error
+		listener, origErr = net.Listen(s.Network, addr)
+		if origErr != nil {
+			s.Logger.Error().Err(origErr).Msg("Server failed to start listening")
+			return gerr.ErrServerListenFailed.Wrap(origErr)
+		}
+	} else {
+		s.Logger.Info().Str("address", listener.Addr().String()).Msg("Using the inherited listener")
+		span.AddEvent("Using the inherited listener")
 	}
 	s.mu.Lock()
 	s.listener = listener
@@ -501,6 +686,7 @@ func (s *Server) Run() *gerr.GatewayDError {
 	}
 
 	var port string
+	var origErr error
 	s.host, port, origErr = net.SplitHostPort(s.listener.Addr().String())
 	if origErr != nil {
 		s.Logger.Error().Err(origErr).Msg("Failed to split host and port")
@@ -545,7 +731,15 @@ func (s *Server) Run() *gerr.GatewayDError {
 
 	var tlsConfig *tls.Config
 	if s.EnableTLS {
-		tlsConfig, origErr = CreateTLSConfig(s.CertFile, s.KeyFile)
+		tlsConfig, origErr = CreateTLSConfig(TLSOptions{
+			CertFile:     s.CertFile,
+			KeyFile:      s.KeyFile,
+			MinVersion:   s.MinTLSVersion,
+			MaxVersion:   s.MaxTLSVersion,
+			CipherSuites: s.CipherSuites,
+			ClientCAFile: s.ClientCAFile,
+			ClientAuth:   s.ClientAuth,
+		})
 		if origErr != nil {
 			s.Logger.Error().Err(origErr).Msg("Failed to create TLS config")
 			return gerr.ErrGetTLSConfigFailed.Wrap(origErr)
@@ -563,58 +757,92 @@ func (s *Server) Run() *gerr.GatewayDError {
 		default:
 			netConn, err := s.listener.Accept()
 			if err != nil {
-				if !s.running.Load() {
+				if !s.running.Load() || s.handedOff.Load() {
 					return nil
 				}
 				s.Logger.Error().Err(err).Msg("Failed to accept connection")
 				return gerr.ErrAcceptFailed.Wrap(err)
 			}
 
-			conn := NewConnWrapper(ConnWrapper{
-				NetConn:          netConn,
-				TLSConfig:        tlsConfig,
-				HandshakeTimeout: s.HandshakeTimeout,
-			})
-
-			if out, action := s.OnOpen(conn); action != None {
-				if _, err := conn.Write(out); err != nil {
-					s.Logger.Error().Err(err).Msg("Failed to write to connection")
-				}
-				_ = conn.Close()
-				if action == Shutdown {
-					s.OnShutdown()
-					return nil
-				}
-			}
-			s.mu.Lock()
-			s.connections++
-			s.mu.Unlock()
-
-			// For every new connection, a new unbuffered channel is created to help
-			// stop the proxy, recycle the server connection and close stale connections.
-			stopConnection := make(chan struct{})
-			go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
-				if action := server.OnTraffic(conn, stopConnection); action == Close {
-					stopConnection <- struct{}{}
-				}
-			}(s, conn, stopConnection)
-
-			go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
-				for {
-					select {
-					case <-stopConnection:
-						server.mu.Lock()
-						server.connections--
-						server.mu.Unlock()
-						server.OnClose(conn, err)
-						return
-					case <-server.stopServer:
+			// The PROXY protocol header is read before the connection is wrapped, so that the
+			// addresses of the client are used everywhere. It is read within the timeout by the
+			// goroutine of the connection, so that a peer that doesn't send it doesn't block
+			// accepting the other connections.
+			if s.AcceptProxyProtocol && isTrustedProxy(netConn.RemoteAddr(), s.trustedProxies) {
+				go func(netConn net.Conn) {
+					proxyConn, headerErr := readProxyProtocolHeader(netConn, s.HandshakeTimeout)
+					if headerErr != nil {
+						s.Logger.Error().Err(headerErr).Str(
+							"remote", RemoteAddr(netConn)).Msg("Failed to read the PROXY protocol header")
+						_ = netConn.Close()
 						return
 					}
-				}
-			}(s, conn, stopConnection)
+					s.serveConnection(proxyConn, tlsConfig)
+				}(netConn)
+				continue
+			}
+
+			if action := s.serveConnection(netConn, tlsConfig); action == Shutdown {
+				return nil
+			}
+		}
+	}
+}
+
+// serveConnection wraps the accepted connection and passes its traffic in new goroutines, or
+// refuses it while the server drains. It returns Shutdown if the server is shut down instead.
+func (s *Server) serveConnection(netConn net.Conn, tlsConfig *tls.Config) Action {
+	conn := NewConnWrapper(ConnWrapper{
+		NetConn:          netConn,
+		TLSConfig:        tlsConfig,
+		HandshakeTimeout: s.HandshakeTimeout,
+	})
+
+	// The new clients are refused while the server drains.
+	if s.draining.Load() {
+		go s.refuseConnection(conn)
+		return None
+	}
+
+	if out, action := s.OnOpen(conn); action != None {
+		if _, err := conn.Write(out); err != nil {
+			s.Logger.Error().Err(err).Msg("Failed to write to connection")
+		}
+		_ = conn.Close()
+		if action == Shutdown {
+			s.OnShutdown()
+			return Shutdown
 		}
 	}
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+
+	// For every new connection, a new unbuffered channel is created to help
+	// stop the proxy, recycle the server connection and close stale connections.
+	stopConnection := make(chan struct{})
+	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
+		if action := server.OnTraffic(conn, stopConnection); action == Close {
+			stopConnection <- struct{}{}
+		}
+	}(s, conn, stopConnection)
+
+	go func(server *Server, conn *ConnWrapper, stopConnection chan struct{}) {
+		for {
+			select {
+			case <-stopConnection:
+				server.mu.Lock()
+				server.connections--
+				server.mu.Unlock()
+				server.OnClose(conn, nil)
+				return
+			case <-server.stopServer:
+				return
+			}
+		}
+	}(s, conn, stopConnection)
+
+	return None
 }
 
 // Shutdown stops the server.
@@ -622,7 +850,8 @@ func (s *Server) Shutdown() {
 	_, span := otel.Tracer("gatewayd").Start(s.ctx, "Shutdown")
 	defer span.End()
 
-	for _, proxy := range s.Proxies {
+	_, proxies := s.loadBalancer()
+	for _, proxy := range proxies {
 		// Shutdown the proxy.
 		proxy.Shutdown()
 	}
@@ -636,7 +865,10 @@ func (s *Server) Shutdown() {
 	var err error
 	s.running.Store(false)
 	if s.listener != nil {
-		if err = s.listener.Close(); err != nil {
+		// The listener is already closed if it is handed off.
+		if err = s.listener.Close(); errors.Is(err, net.ErrClosed) {
+			err = nil
+		} else if err != nil {
 			s.Logger.Error().Err(err).Msg("Failed to close listener")
 		}
 	} else {
@@ -657,6 +889,157 @@ func (s *Server) Shutdown() {
 	}
 }
 
+// Drain stops the server from taking new connections, whose clients are told that the server is
+// shutting down, and closes the incoming connections as soon as they are between transactions.
+// It returns once all of them are closed or the DrainTimeout expires, with the number of the
+// connections that are left, which are closed by shutting the server down afterwards. The
+// OnShutdown hooks are run with the progress of the drain.
+func (s *Server) Drain() int {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "Drain")
+	defer span.End()
+
+	if !s.draining.CompareAndSwap(false, true) {
+		return s.CountConnections()
+	}
+
+	start := time.Now()
+	deadline := start.Add(s.DrainTimeout)
+	connections := s.CountConnections()
+	s.Logger.Info().Fields(
+		map[string]interface{}{
+			"connections": connections,
+			"timeout":     s.DrainTimeout.String(),
+		},
+	).Msg("GatewayD is draining")
+	s.onDrain(connections, 0)
+
+	for {
+		s.mu.RLock()
+		proxies := make(map[*ConnWrapper]IProxy, len(s.connectionToProxyMap))
+		for conn, proxy := range s.connectionToProxyMap {
+			proxies[conn] = proxy
+		}
+		s.mu.RUnlock()
+
+		for conn, proxy := range proxies {
+			proxy.Drain(conn)
+		}
+
+		// The progress is reported whenever the number of the connections changes.
+		if count := s.CountConnections(); count != connections {
+			connections = count
+			s.onDrain(connections, time.Since(start))
+		}
+
+		remaining := time.Until(deadline)
+		if connections == 0 || remaining <= 0 || !s.running.Load() {
+			break
+		}
+		time.Sleep(min(drainPollInterval, remaining))
+	}
+
+	span.SetAttributes(attribute.Int("connections", connections))
+	if connections > 0 {
+		s.Logger.Warn().Int("connections", connections).Msg(
+			"The drain timeout expired, the remaining client connections are closed")
+	} else {
+		s.Logger.Info().Str("duration", time.Since(start).String()).Msg("GatewayD is drained")
+	}
+
+	return connections
+}
+
+// onDrain runs the OnShutdown hooks with the progress of the drain.
+func (s *Server) onDrain(connections int, elapsed time.Duration) {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "onDrain")
+	defer span.End()
+
+	pluginTimeoutCtx, cancel := context.WithTimeout(context.Background(), s.PluginTimeout)
+	defer cancel()
+
+	_, err := s.PluginRegistry.Run(
+		pluginTimeoutCtx,
+		map[string]interface{}{
+			"connections": connections,
+			"draining":    true,
+			"elapsed":     elapsed.String(),
+			"timeout":     s.DrainTimeout.String(),
+		},
+		v1.HookName_HOOK_NAME_ON_SHUTDOWN)
+	if err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to run OnShutdown hook")
+		span.RecordError(err)
+	}
+}
+
+// refuseConnection tells the client of a new connection that the server is shutting down, like
+// PostgreSQL does, and closes the connection.
+func (s *Server) refuseConnection(conn *ConnWrapper) {
+	s.sendStartupError(conn, &ErrorResponse{
+		SQLState: CannotConnectNowCode,
+		Message:  "the database system is shutting down",
+	})
+	if conn.tlsConn != nil {
+		metrics.TLSConnections.Dec()
+	}
+	if err := conn.Close(); err != nil {
+		s.Logger.Debug().Err(err).Msg("Failed to close the refused connection")
+	}
+}
+
+// ListenerFile returns a duplicate of the file descriptor of the listener, which is handed off
+// to the GatewayD process that takes over the server.
+func (s *Server) ListenerFile() (*os.File, *gerr.GatewayDError) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.listener == nil || !s.running.Load() || s.handedOff.Load() {
+		return nil, gerr.ErrListenerHandoffFailed.Wrap(
+			fmt.Errorf("the listener of %s is not available", s.Address))
+	}
+
+	file, err := listenerFile(s.listener)
+	if err != nil {
+		return nil, gerr.ErrListenerHandoffFailed.Wrap(err)
+	}
+	return file, nil
+}
+
+// StopAccepting closes the listener once it is handed off, so that the new connections are only
+// accepted by the GatewayD process that took it over. The connections of the server are kept.
+func (s *Server) StopAccepting() {
+	_, span := otel.Tracer("gatewayd").Start(s.ctx, "StopAccepting")
+	defer span.End()
+
+	if !s.handedOff.CompareAndSwap(false, true) {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return
+	}
+	if err := closeListener(s.listener); err != nil {
+		s.Logger.Error().Err(err).Msg("Failed to close the listener that is handed off")
+		span.RecordError(err)
+		return
+	}
+	s.Logger.Info().Str("address", s.listener.Addr().String()).Msg(
+		"Stopped accepting connections, since the listener is handed off")
+}
+
+// IsDraining returns true if the server is drained, so that it takes no new connections.
+func (s *Server) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// IsListening returns true once the server accepts the connections on its listener, until it
+// is shut down or the listener is handed off.
+func (s *Server) IsListening() bool {
+	return s.running.Load() && !s.handedOff.Load()
+}
+
 // IsRunning returns true if the server is running.
 func (s *Server) IsRunning() bool {
 	_, span := otel.Tracer("gatewayd").Start(s.ctx, "IsRunning")
@@ -688,6 +1071,17 @@ func NewServer(
 		CertFile:                   srv.CertFile,
 		KeyFile:                    srv.KeyFile,
 		HandshakeTimeout:           srv.HandshakeTimeout,
+		MinTLSVersion:              srv.MinTLSVersion,
+		MaxTLSVersion:              srv.MaxTLSVersion,
+		CipherSuites:               srv.CipherSuites,
+		ClientCAFile:               srv.ClientCAFile,
+		ClientAuth:                 srv.ClientAuth,
+		AcceptProxyProtocol:        srv.AcceptProxyProtocol,
+		TrustedProxies:             srv.TrustedProxies,
+		DrainTimeout:               srv.DrainTimeout,
+		Listener:                   srv.Listener,
+		ErrorResponses:             srv.ErrorResponses,
+		errorResponses:             newErrorResponses(srv.ErrorResponses),
 		Proxies:                    srv.Proxies,
 		Logger:                     srv.Logger,
 		PluginRegistry:             srv.PluginRegistry,
@@ -695,6 +1089,8 @@ func NewServer(
 		mu:                         &sync.RWMutex{},
 		connections:                0,
 		running:                    &atomic.Bool{},
+		draining:                   &atomic.Bool{},
+		handedOff:                  &atomic.Bool{},
 		stopServer:                 make(chan struct{}),
 		connectionToProxyMap:       make(map[*ConnWrapper]IProxy),
 		LoadbalancerStrategyName:   srv.LoadbalancerStrategyName,
@@ -702,6 +1098,16 @@ func NewServer(
 		LoadbalancerConsistentHash: srv.LoadbalancerConsistentHash,
 	}
 
+	// The trusted proxies are validated by the config. If they are invalid nonetheless,
+	// the PROXY protocol is disabled instead of trusting any address.
+	if trustedProxies, err := parseTrustedProxies(srv.TrustedProxies); err != nil {
+		srv.Logger.Error().Err(err).Msg("Failed to parse the trusted proxies, the PROXY protocol is disabled")
+		span.RecordError(err)
+		server.AcceptProxyProtocol = false
+	} else {
+		server.trustedProxies = trustedProxies
+	}
+
 	// Try to resolve the address and log an error if it can't be resolved.
 	addr, err := Resolve(server.Network, server.Address, srv.Logger)
 	if err != nil {
@@ -728,6 +1134,53 @@ func NewServer(
 	return &server
 }
 
+// Reload replaces the proxies and the load balancer of the server, e.g. on reloading the
+// configuration. Only the new connections are assigned to the new proxies, so the proxies that
+// are left out keep serving their connections, and they are shut down by the caller. The load
+// balancer is kept if the new one can't be created.
+func (s *Server) Reload(srv Server) *gerr.GatewayDError {
+	_, span := otel.Tracer(config.TracerName).Start(s.ctx, "Reload")
+	defer span.End()
+
+	server := Server{
+		Proxies:                    srv.Proxies,
+		LoadbalancerStrategyName:   srv.LoadbalancerStrategyName,
+		LoadbalancerRules:          srv.LoadbalancerRules,
+		LoadbalancerConsistentHash: srv.LoadbalancerConsistentHash,
+	}
+	strategy, err := NewLoadBalancerStrategy(&server)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	s.mu.Lock()
+	s.Proxies = srv.Proxies
+	s.LoadbalancerStrategyName = srv.LoadbalancerStrategyName
+	s.LoadbalancerRules = srv.LoadbalancerRules
+	s.LoadbalancerConsistentHash = srv.LoadbalancerConsistentHash
+	s.loadbalancerStrategy = strategy
+	s.mu.Unlock()
+
+	s.Logger.Info().Fields(
+		map[string]interface{}{
+			"proxies":  len(srv.Proxies),
+			"strategy": srv.LoadbalancerStrategyName,
+		},
+	).Msg("Reloaded the proxies and the load balancer of the server")
+
+	return nil
+}
+
+// loadBalancer returns the load balancer strategy and the proxies of the server, which are
+// replaced by Reload.
+func (s *Server) loadBalancer() (LoadBalancerStrategy, []IProxy) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.loadbalancerStrategy, s.Proxies
+}
+
 // CountConnections returns the current number of connections.
 func (s *Server) CountConnections() int {
 	s.mu.RLock()
@@ -737,11 +1190,17 @@ func (s *Server) CountConnections() int {
 
 // GetProxyForConnection returns the proxy associated with the given connection.
 func (s *Server) GetProxyForConnection(conn *ConnWrapper) (IProxy, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	proxy, exists := s.connectionToProxyMap[conn]
 	return proxy, exists
 }
 
 // RemoveConnectionFromMap removes the given connection from the connection-to-proxy map.
 func (s *Server) RemoveConnectionFromMap(conn *ConnWrapper) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	delete(s.connectionToProxyMap, conn)
 }
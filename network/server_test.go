@@ -3,8 +3,10 @@ package network
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -16,6 +18,8 @@ import (
 	"github.com/gatewayd-io/gatewayd/logging"
 	"github.com/gatewayd-io/gatewayd/plugin"
 	"github.com/gatewayd-io/gatewayd/pool"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -23,6 +27,683 @@ import (
 	"google.golang.org/grpc"
 )
 
+// TestServerReload tests that the new connections are assigned to the proxies of the reloaded
+// load balancer, and that the load balancer is kept if the new one is invalid.
+func TestServerReload(t *testing.T) {
+	server := NewServer(context.Background(), Server{
+		Network:                  "tcp",
+		Address:                  "127.0.0.1:15432",
+		Proxies:                  []IProxy{MockProxy{name: "old"}},
+		Logger:                   zerolog.Nop(),
+		LoadbalancerStrategyName: config.RoundRobinStrategy,
+	})
+	require.NotNil(t, server)
+
+	require.Nil(t, server.Reload(Server{
+		Proxies:                  []IProxy{MockProxy{name: "new1"}, MockProxy{name: "new2"}},
+		LoadbalancerStrategyName: config.WeightedRoundRobinStrategy,
+		LoadbalancerRules: []config.LoadBalancingRule{
+			{
+				Condition: config.DefaultLoadBalancerCondition,
+				Distribution: []config.Distribution{
+					{ProxyName: "new2", Weight: 1},
+				},
+			},
+		},
+	}))
+	strategy, proxies := server.loadBalancer()
+	assert.Len(t, proxies, 2)
+	proxy, err := strategy.NextProxy(nil)
+	require.Nil(t, err)
+	assert.Equal(t, "new2", proxy.GetName())
+
+	err = server.Reload(Server{
+		Proxies:                  []IProxy{MockProxy{name: "new3"}},
+		LoadbalancerStrategyName: "unknown",
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, config.WeightedRoundRobinStrategy, server.LoadbalancerStrategyName)
+	proxy, err = server.loadbalancerStrategy.NextProxy(nil)
+	require.Nil(t, err)
+	assert.Equal(t, "new2", proxy.GetName())
+}
+
+// TestServerDrain tests that the draining server refuses the new clients, closes the idle
+// connections right away and lets the connections in a transaction finish it, and that the
+// OnShutdown hooks are run with the progress of the drain.
+func TestServerDrain(t *testing.T) {
+	for _, poolMode := range []string{config.SessionPoolMode, config.TransactionPoolMode} {
+		t.Run(poolMode, func(t *testing.T) {
+			backend := NewFakeBackend(t)
+			proxy := newTestPooledProxy(t, backend, 2, poolMode)
+
+			var progress []map[string]interface{}
+			var progressMu sync.Mutex
+			proxy.PluginRegistry.AddHook(v1.HookName_HOOK_NAME_ON_SHUTDOWN, 1,
+				func(_ context.Context, params *v1.Struct, _ ...grpc.CallOption) (*v1.Struct, error) {
+					progressMu.Lock()
+					defer progressMu.Unlock()
+					progress = append(progress, params.AsMap())
+					return params, nil
+				})
+
+			server := NewServer(
+				context.Background(),
+				Server{
+					Network:                  "tcp",
+					Address:                  "127.0.0.1:0",
+					Proxies:                  []IProxy{proxy},
+					Logger:                   zerolog.Nop(),
+					PluginRegistry:           proxy.PluginRegistry,
+					PluginTimeout:            config.DefaultPluginTimeout,
+					HandshakeTimeout:         config.DefaultHandshakeTimeout,
+					DrainTimeout:             10 * time.Second,
+					LoadbalancerStrategyName: config.RoundRobinStrategy,
+				},
+			)
+			require.NotNil(t, server)
+
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				assert.Nil(t, server.Run())
+			}()
+			var address string
+			require.Eventually(t, func() bool {
+				server.mu.RLock()
+				defer server.mu.RUnlock()
+				if server.listener != nil {
+					address = server.listener.Addr().String()
+				}
+				return address != "" && server.running.Load()
+			}, 5*time.Second, 10*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			connect := func() (*pgconn.PgConn, error) {
+				return pgconn.Connect(ctx, "postgres://alice@"+address+"/postgres?sslmode=disable")
+			}
+
+			idle, err := connect()
+			require.NoError(t, err)
+			defer idle.Close(context.Background())
+			busy, err := connect()
+			require.NoError(t, err)
+			defer busy.Close(context.Background())
+			_, err = busy.Exec(ctx, "BEGIN").ReadAll()
+			require.NoError(t, err)
+
+			drained := make(chan int)
+			go func() {
+				drained <- server.Drain()
+			}()
+			require.Eventually(t, server.IsDraining, time.Second, time.Millisecond)
+			require.Eventually(t, func() bool { return server.CountConnections() == 1 },
+				5*time.Second, 10*time.Millisecond)
+
+			// The idle client is told that its connection is closed.
+			_, err = idle.Exec(ctx, "SELECT 1").ReadAll()
+			var pgErr *pgconn.PgError
+			require.True(t, errors.As(err, &pgErr), err)
+			assert.Equal(t, AdminShutdownCode, pgErr.Code)
+
+			// The new clients are refused.
+			_, err = connect()
+			require.True(t, errors.As(err, &pgErr), err)
+			assert.Equal(t, CannotConnectNowCode, pgErr.Code)
+
+			// The transaction is finished, after which the connection is closed.
+			_, err = busy.Exec(ctx, "SELECT 1").ReadAll()
+			require.NoError(t, err)
+			_, err = busy.Exec(ctx, "COMMIT").ReadAll()
+			require.NoError(t, err)
+			select {
+			case connections := <-drained:
+				assert.Zero(t, connections)
+			case <-time.After(5 * time.Second):
+				t.Fatal("the server isn't drained")
+			}
+			_, err = busy.Exec(ctx, "SELECT 1").ReadAll()
+			require.True(t, errors.As(err, &pgErr), err)
+			assert.Equal(t, AdminShutdownCode, pgErr.Code)
+
+			progressMu.Lock()
+			require.NotEmpty(t, progress)
+			assert.Equal(t, true, progress[0]["draining"])
+			assert.InDelta(t, 2, progress[0]["connections"], 0)
+			assert.InDelta(t, 0, progress[len(progress)-1]["connections"], 0)
+			progressMu.Unlock()
+
+			// The server is drained only once.
+			assert.Zero(t, server.Drain())
+
+			// The proxy is shut down by the cleanup, so only the listener is closed.
+			server.running.Store(false)
+			server.mu.RLock()
+			require.NoError(t, server.listener.Close())
+			server.mu.RUnlock()
+			<-stopped
+		})
+	}
+}
+
+// TestServerClientCertRequiresTLS tests that the clients that start in plaintext are refused
+// when the client certificates are verified, instead of skipping the verification.
+func TestServerClientCertRequiresTLS(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost")
+	clientCert, clientKey := ca.issue(t, "service.internal")
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+			EnableTLS:                true,
+			CertFile:                 serverCert,
+			KeyFile:                  serverKey,
+			ClientCAFile:             ca.certFile,
+			ClientAuth:               config.ClientAuthVerify,
+		},
+	)
+	require.NotNil(t, server)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, server.Run())
+	}()
+	var address string
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		if server.listener != nil {
+			address = server.listener.Addr().String()
+		}
+		return address != "" && server.running.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The plaintext StartupMessage is refused before a server connection is assigned.
+	_, err := pgconn.Connect(ctx, "postgres://alice@"+address+"/postgres?sslmode=disable")
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, "FATAL", pgErr.Severity)
+	assert.Equal(t, InvalidAuthorizationCode, pgErr.Code)
+	assert.Equal(t, 1, proxy.AvailableConnections.Size())
+
+	// The client with a verified certificate is connected.
+	pgConfig, err := pgconn.ParseConfig("postgres://alice@" + address + "/postgres?sslmode=disable")
+	require.NoError(t, err)
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	rootCAs := ca.serverTLSConfig(t, "localhost").ClientCAs
+	pgConfig.TLSConfig = &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		ServerName:   "localhost",
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{cert},
+	}
+	pgConn, err := pgconn.ConnectConfig(ctx, pgConfig)
+	require.NoError(t, err)
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	require.NoError(t, err)
+	require.NoError(t, pgConn.Close(ctx))
+	require.Eventually(t, func() bool {
+		return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The proxy is shut down by the cleanup, so only the listener is closed.
+	server.running.Store(false)
+	server.mu.RLock()
+	require.NoError(t, server.listener.Close())
+	server.mu.RUnlock()
+	<-stopped
+}
+
+// TestServerClientCertHooks tests which hooks carry the verified client certificate, which
+// is only available after the TLS handshake.
+func TestServerClientCertHooks(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	hooks := []v1.HookName{
+		v1.HookName_HOOK_NAME_ON_OPENING,
+		v1.HookName_HOOK_NAME_ON_OPENED,
+		v1.HookName_HOOK_NAME_ON_TRAFFIC,
+		v1.HookName_HOOK_NAME_ON_TRAFFIC_FROM_CLIENT,
+		v1.HookName_HOOK_NAME_ON_TRAFFIC_TO_CLIENT,
+		v1.HookName_HOOK_NAME_ON_CLOSING,
+		v1.HookName_HOOK_NAME_ON_CLOSED,
+	}
+	subjects := map[v1.HookName][]string{}
+	var subjectsMu sync.Mutex
+	for _, hook := range hooks {
+		proxy.PluginRegistry.AddHook(hook, 1,
+			func(_ context.Context, params *v1.Struct, _ ...grpc.CallOption) (*v1.Struct, error) {
+				subject := ""
+				if client, ok := params.AsMap()["client"].(map[string]interface{}); ok {
+					if certificate, ok := client["certificate"].(map[string]interface{}); ok {
+						subject, _ = certificate["subject"].(string)
+					}
+				}
+				subjectsMu.Lock()
+				defer subjectsMu.Unlock()
+				subjects[hook] = append(subjects[hook], subject)
+				return params, nil
+			})
+	}
+
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost")
+	clientCert, clientKey := ca.issue(t, "service.internal")
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+			EnableTLS:                true,
+			CertFile:                 serverCert,
+			KeyFile:                  serverKey,
+			ClientCAFile:             ca.certFile,
+			ClientAuth:               config.ClientAuthVerify,
+		},
+	)
+	require.NotNil(t, server)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, server.Run())
+	}()
+	var address string
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		if server.listener != nil {
+			address = server.listener.Addr().String()
+		}
+		return address != "" && server.running.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pgConfig, err := pgconn.ParseConfig("postgres://alice@" + address + "/postgres?sslmode=disable")
+	require.NoError(t, err)
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	pgConfig.TLSConfig = &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		ServerName:   "localhost",
+		RootCAs:      ca.serverTLSConfig(t, "localhost").ClientCAs,
+		Certificates: []tls.Certificate{cert},
+	}
+	pgConn, err := pgconn.ConnectConfig(ctx, pgConfig)
+	require.NoError(t, err)
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	require.NoError(t, err)
+	require.NoError(t, pgConn.Close(ctx))
+	require.Eventually(t, func() bool {
+		subjectsMu.Lock()
+		defer subjectsMu.Unlock()
+		return len(subjects[v1.HookName_HOOK_NAME_ON_CLOSED]) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	subjectsMu.Lock()
+	// The hooks that run before the handshake don't carry the certificate.
+	for _, hook := range hooks[:3] {
+		assert.Equal(t, []string{""}, subjects[hook], hook.String())
+	}
+	// The hooks that run after the handshake carry it.
+	for _, hook := range hooks[3:] {
+		require.NotEmpty(t, subjects[hook], hook.String())
+		for _, subject := range subjects[hook] {
+			assert.Equal(t, "CN=service.internal", subject, hook.String())
+		}
+	}
+	subjectsMu.Unlock()
+
+	// The proxy is shut down by the cleanup, so only the listener is closed.
+	server.running.Store(false)
+	server.mu.RLock()
+	require.NoError(t, server.listener.Close())
+	server.mu.RUnlock()
+	<-stopped
+}
+
+// TestServerCancelRequestPoolExhausted tests that the CancelRequests are forwarded without a
+// server connection of the pool, so that the queries are canceled while the pool is exhausted.
+func TestServerCancelRequestPoolExhausted(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+		},
+	)
+	require.NotNil(t, server)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, server.Run())
+	}()
+	var address string
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		if server.listener != nil {
+			address = server.listener.Addr().String()
+		}
+		return address != "" && server.running.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pgConn, err := pgconn.Connect(ctx, "postgres://alice@"+address+"/postgres?sslmode=disable")
+	require.NoError(t, err)
+	assert.True(t, proxy.IsExhausted())
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := pgConn.Exec(ctx, "SELECT pg_sleep(10)").ReadAll()
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return backend.Sleeping() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, pgConn.CancelRequest(ctx))
+	select {
+	case err := <-result:
+		var pgErr *pgconn.PgError
+		require.True(t, errors.As(err, &pgErr), err)
+		assert.Equal(t, "57014", pgErr.Code)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the query is not canceled")
+	}
+	assert.Equal(t, 1, backend.CancelRequests())
+
+	require.NoError(t, pgConn.Close(ctx))
+	require.Eventually(t, func() bool {
+		return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The proxy is shut down by the cleanup, so only the listener is closed.
+	server.running.Store(false)
+	server.mu.RLock()
+	require.NoError(t, server.listener.Close())
+	server.mu.RUnlock()
+	<-stopped
+}
+
+// TestServerCancelRequestTwoProxies tests that the CancelRequest is forwarded by the proxy
+// that issued its key to its own server, when the proxies don't share the keys.
+func TestServerCancelRequestTwoProxies(t *testing.T) {
+	backends := []*FakeBackend{NewFakeBackend(t), NewFakeBackend(t)}
+	proxies := []*Proxy{
+		newTestPooledProxy(t, backends[0], 1, config.SessionPoolMode),
+		newTestPooledProxy(t, backends[1], 1, config.SessionPoolMode),
+	}
+	require.NotSame(t, proxies[0].CancelKeys, proxies[1].CancelKeys)
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxies[0], proxies[1]},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxies[0].PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+		},
+	)
+	require.NotNil(t, server)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, server.Run())
+	}()
+	var address string
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		if server.listener != nil {
+			address = server.listener.Addr().String()
+		}
+		return address != "" && server.running.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	first, err := pgconn.Connect(ctx, "postgres://alice@"+address+"/postgres?sslmode=disable")
+	require.NoError(t, err)
+	firstOnSecond := proxies[1].BusyConnectionsCount() == 1
+	second, err := pgconn.Connect(ctx, "postgres://bob@"+address+"/postgres?sslmode=disable")
+	require.NoError(t, err)
+	require.Equal(t, 1, proxies[0].BusyConnectionsCount())
+	require.Equal(t, 1, proxies[1].BusyConnectionsCount())
+
+	// Cancel the query of the connection of the second proxy, whose key is unknown to the first.
+	pgConn := second
+	if firstOnSecond {
+		pgConn = first
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := pgConn.Exec(ctx, "SELECT pg_sleep(10)").ReadAll()
+		result <- err
+	}()
+	require.Eventually(t, func() bool { return backends[1].Sleeping() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, pgConn.CancelRequest(ctx))
+	select {
+	case err := <-result:
+		var pgErr *pgconn.PgError
+		require.True(t, errors.As(err, &pgErr), err)
+		assert.Equal(t, "57014", pgErr.Code)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the query is not canceled")
+	}
+	assert.Equal(t, 0, backends[0].CancelRequests())
+	assert.Equal(t, 1, backends[1].CancelRequests())
+
+	require.NoError(t, first.Close(ctx))
+	require.NoError(t, second.Close(ctx))
+	for _, proxy := range proxies {
+		require.Eventually(t, func() bool {
+			return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// The proxies are shut down by the cleanup, so only the listener is closed.
+	server.running.Store(false)
+	server.mu.RLock()
+	require.NoError(t, server.listener.Close())
+	server.mu.RUnlock()
+	<-stopped
+}
+
+// TestServerStartupErrorAfterSSLRequest tests that the client that starts with an SSLRequest
+// gets its answer before the ErrorResponse of the StartupMessage, when no server connection is
+// available for it.
+func TestServerStartupErrorAfterSSLRequest(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+		},
+	)
+	require.NotNil(t, server)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, server.Run())
+	}()
+	var address string
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		if server.listener != nil {
+			address = server.listener.Addr().String()
+		}
+		return address != "" && server.running.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pgConn, err := pgconn.Connect(ctx, "postgres://alice@"+address+"/postgres?sslmode=disable")
+	require.NoError(t, err)
+	assert.True(t, proxy.IsExhausted())
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	sslRequest, err := (&pgproto3.SSLRequest{}).Encode(nil)
+	require.NoError(t, err)
+	_, err = conn.Write(sslRequest)
+	require.NoError(t, err)
+	answer := make([]byte, 1)
+	_, err = io.ReadFull(conn, answer)
+	require.NoError(t, err)
+	assert.Equal(t, byte('N'), answer[0])
+
+	_, err = conn.Write(CreatePgStartupPacket())
+	require.NoError(t, err)
+	message, err := pgproto3.NewFrontend(conn, conn).Receive()
+	require.NoError(t, err)
+	errorResponse, ok := message.(*pgproto3.ErrorResponse)
+	require.True(t, ok, message)
+	assert.Equal(t, "FATAL", errorResponse.Severity)
+	assert.Equal(t, TooManyConnectionsCode, errorResponse.Code)
+
+	require.NoError(t, pgConn.Close(ctx))
+	require.Eventually(t, func() bool {
+		return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The proxy is shut down by the cleanup, so only the listener is closed.
+	server.running.Store(false)
+	server.mu.RLock()
+	require.NoError(t, server.listener.Close())
+	server.mu.RUnlock()
+	<-stopped
+}
+
+// TestServerProxyProtocolSilentPeer tests that a trusted peer that doesn't send the PROXY
+// protocol header doesn't block accepting the other connections.
+func TestServerProxyProtocolSilentPeer(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:0",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         time.Minute,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+			AcceptProxyProtocol:      true,
+			TrustedProxies:           []string{"127.0.0.1/32"},
+		},
+	)
+	require.NotNil(t, server)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, server.Run())
+	}()
+	var address string
+	require.Eventually(t, func() bool {
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		if server.listener != nil {
+			address = server.listener.Addr().String()
+		}
+		return address != "" && server.running.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The silent peer is waited for until the handshake timeout.
+	silent, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer silent.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pgConfig, err := pgconn.ParseConfig("postgres://alice@" + address + "/postgres?sslmode=disable")
+	require.NoError(t, err)
+	pgConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write([]byte("PROXY TCP4 10.1.2.3 10.0.0.1 5000 5432\r\n")); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	pgConn, err := pgconn.ConnectConfig(ctx, pgConfig)
+	require.NoError(t, err)
+	_, err = pgConn.Exec(ctx, "SELECT 1").ReadAll()
+	require.NoError(t, err)
+	require.NoError(t, pgConn.Close(ctx))
+	require.Eventually(t, func() bool {
+		return proxy.BusyConnectionsCount() == 0 && proxy.AvailableConnections.Size() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The proxy is shut down by the cleanup, so only the listener is closed.
+	server.running.Store(false)
+	server.mu.RLock()
+	require.NoError(t, server.listener.Close())
+	server.mu.RUnlock()
+	<-stopped
+}
+
 // TestRunServer tests an entire server run with a single client connection and hooks.
 func TestRunServer(t *testing.T) {
 	// Reset prometheus metrics.
@@ -0,0 +1,204 @@
+package network
+
+import (
+	"sync"
+)
+
+// session tracks the state of an incoming connection when the proxy is not in the
+// session pool mode. In that case, a server connection is only assigned to the incoming
+// connection for as long as the protocol requires, e.g. for the duration of a transaction,
+// and is returned to the pool of the available connections afterwards. It is also used
+// with per-user pools, in which the server connection is only assigned once the
+// StartupMessage is received.
+type session struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// client is the server connection that is currently assigned to the session, if any.
+	client *Client
+	// pending is the number of requests that are waiting for a ReadyForQuery message.
+	pending int
+	// unsynced is true if extended query messages are sent without a Sync message.
+	unsynced bool
+	// holds is the number of requests that are about to be sent to the server connection.
+	holds int
+	// txStatus is the transaction status of the last ReadyForQuery message.
+	txStatus byte
+	// startup is true until the first ReadyForQuery message is received, that is while
+	// the client and the server are going through the startup and authentication phase.
+	startup bool
+	// closed is true when the incoming connection is closed.
+	closed bool
+	// statements maps the names of the prepared statements of the session
+	// to the prepared statements on the server connections.
+	statements map[string]*preparedStatement
+	// swallow is the number of injected Sync messages, whose responses must not be
+	// sent to the client.
+	swallow int
+	// key is the key of the per-user pool of the session, once the StartupMessage is received.
+	key *PoolKey
+	// pinned is true if the server connection stays assigned to the session until it is closed.
+	pinned bool
+	// readOnly is true if the server connection is assigned from the read proxy,
+	// and must be returned to its pool.
+	readOnly bool
+	// responding is true while a response of the server connection is sent to the client.
+	responding bool
+	// drained is true once the incoming connection is closed between transactions,
+	// since the server drains.
+	drained bool
+}
+
+// newSession creates a new session for an incoming connection.
+func newSession() *session {
+	sess := &session{
+		txStatus: TransactionStatusIdle,
+		startup:  true,
+	}
+	sess.cond = sync.NewCond(&sess.mu)
+	return sess
+}
+
+// trackRequest updates the state of the session with the messages that are about
+// to be sent to the server. The caller must hold the lock.
+func (s *session) trackRequest(request []byte) {
+	forEachMessage(request, func(msgType byte, _ []byte) bool {
+		switch msgType {
+		case QueryMessage, FunctionCallMessage:
+			s.pending++
+		case SyncMessage:
+			s.pending++
+			s.unsynced = false
+		case ParseMessage, BindMessage, DescribeMessage, ExecuteMessage, CloseMessage, FlushMessage:
+			s.unsynced = true
+		}
+		return true
+	})
+}
+
+// trackResponse updates the state of the session with the messages that are received
+// from the server. It returns true if a ReadyForQuery message is received. The caller
+// must hold the lock.
+func (s *session) trackResponse(response []byte) bool {
+	ready := false
+	forEachMessage(response, func(msgType byte, body []byte) bool {
+		if msgType != ReadyForQueryMessage || len(body) == 0 {
+			return true
+		}
+		ready = true
+		if s.pending > 0 {
+			s.pending--
+		}
+		s.txStatus = body[0]
+		s.startup = false
+		return true
+	})
+	return ready
+}
+
+// expectsResponse returns true if the server connection is expected to send messages
+// to the client. The caller must hold the lock.
+func (s *session) expectsResponse() bool {
+	return s.client != nil && (s.startup || s.pending > 0 || s.unsynced)
+}
+
+// inTransaction returns true if the last ReadyForQuery message indicates a transaction block.
+func (s *session) inTransaction() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.txStatus != TransactionStatusIdle
+}
+
+// isIdle returns true if the server connection can be returned to the pool, that is when
+// there is no transaction in progress and no response is expected from the server.
+// The caller must hold the lock.
+func (s *session) isIdle() bool {
+	return s.holds == 0 &&
+		!s.startup &&
+		s.pending == 0 &&
+		!s.unsynced &&
+		s.txStatus == TransactionStatusIdle
+}
+
+// release unassigns the server connection from the session if the session is idle and
+// returns it, so that it can be put back in the pool. The caller must hold the lock.
+func (s *session) release() *Client {
+	if s.client == nil || s.pinned || !s.isIdle() {
+		return nil
+	}
+
+	client := s.client
+	s.client = nil
+	return client
+}
+
+// close marks the session as closed, unassigns the server connection and returns it.
+// It also returns whether the server connection is synced, that is if it has completed the
+// startup phase and isn't expected to send any more messages, and if it is in a transaction.
+// It wakes up the goroutines that are waiting for the session.
+func (s *session) close() (client *Client, synced, inTransaction bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	synced = !s.expectsResponse() && s.holds == 0
+	inTransaction = s.txStatus != TransactionStatusIdle
+	client = s.client
+	s.client = nil
+	s.closed = true
+	s.cond.Broadcast()
+	return client, synced, inTransaction
+}
+
+// hold marks a request of the incoming connection as about to be sent to its dedicated server
+// connection, so that the session isn't drained meanwhile. It returns false if the session is
+// already drained.
+func (s *session) hold() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.drained {
+		return false
+	}
+	s.holds++
+	return true
+}
+
+// unhold marks the request of the incoming connection as sent to its dedicated server connection.
+func (s *session) unhold() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holds--
+}
+
+// respond tracks the response of the dedicated server connection of the incoming connection,
+// which is about to be sent to the client.
+func (s *session) respond(response []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trackResponse(response)
+	s.responding = true
+}
+
+// responded marks the response of the server connection as sent to the client.
+func (s *session) responded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responding = false
+}
+
+// drain marks the session as drained if it is idle and its last response is sent to the client,
+// so that it takes no more requests. It returns true if the session is drained by the call.
+func (s *session) drain() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.drained || s.closed || s.responding || !s.isIdle() {
+		return false
+	}
+	s.drained = true
+	return true
+}
@@ -0,0 +1,105 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_session_Transaction tests that the session is only idle outside of transactions.
+func Test_session_Transaction(t *testing.T) {
+	sess := newSession()
+	sess.client = &Client{}
+	ready := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})
+	inTransaction := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusActive})
+
+	// The startup phase ends with the first ReadyForQuery message.
+	assert.True(t, sess.expectsResponse())
+	assert.Nil(t, sess.release())
+	assert.True(t, sess.trackResponse(ready))
+	assert.False(t, sess.expectsResponse())
+
+	sess.trackRequest(CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00")))
+	assert.True(t, sess.expectsResponse())
+	assert.True(t, sess.trackResponse(inTransaction))
+	assert.False(t, sess.expectsResponse())
+	assert.Nil(t, sess.release(), "The server connection must stay assigned in a transaction")
+
+	sess.trackRequest(CreatePostgreSQLPacket(QueryMessage, []byte("COMMIT\x00")))
+	assert.True(t, sess.trackResponse(ready))
+	assert.NotNil(t, sess.release())
+	assert.Nil(t, sess.client)
+}
+
+// Test_session_ExtendedQuery tests that the session is not idle until the
+// extended query messages are synced and the ReadyForQuery message is received.
+func Test_session_ExtendedQuery(t *testing.T) {
+	sess := newSession()
+	sess.client = &Client{}
+	sess.trackResponse(CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle}))
+
+	sess.trackRequest(CreatePostgreSQLPacket(ParseMessage, []byte("\x00SELECT 1\x00\x00\x00")))
+	assert.True(t, sess.expectsResponse())
+	assert.Nil(t, sess.release())
+
+	sess.trackRequest(CreatePostgreSQLPacket(SyncMessage, nil))
+	assert.False(t, sess.unsynced)
+	assert.Equal(t, 1, sess.pending)
+	assert.False(t, sess.trackResponse(CreatePostgreSQLPacket('1', nil)))
+	assert.Nil(t, sess.release())
+
+	assert.True(t, sess.trackResponse(
+		CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})))
+	assert.NotNil(t, sess.release())
+}
+
+// Test_session_Close tests that closing the session returns the assigned server connection,
+// and whether it can be reset.
+func Test_session_Close(t *testing.T) {
+	sess := newSession()
+	client := &Client{}
+	sess.client = client
+
+	// The server connection is in the startup phase.
+	closed, synced, _ := sess.close()
+	assert.Equal(t, client, closed)
+	assert.False(t, synced)
+	assert.True(t, sess.closed)
+	closed, _, _ = sess.close()
+	assert.Nil(t, closed)
+
+	sess = newSession()
+	sess.client = client
+	sess.trackRequest(CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00")))
+	sess.trackResponse(CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusActive}))
+	closed, synced, inTransaction := sess.close()
+	assert.Equal(t, client, closed)
+	assert.True(t, synced)
+	assert.True(t, inTransaction)
+}
+
+// Test_session_Drain tests that the session is only drained between transactions, once the last
+// response is sent to the client, and that it takes no more requests afterwards.
+func Test_session_Drain(t *testing.T) {
+	sess := newSession()
+	inTransaction := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusActive})
+	ready := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})
+
+	// The startup phase isn't over until the first ReadyForQuery message.
+	assert.False(t, sess.drain())
+	sess.respond(inTransaction)
+	sess.responded()
+	assert.False(t, sess.drain())
+
+	assert.True(t, sess.hold())
+	sess.trackRequest(CreatePostgreSQLPacket(QueryMessage, []byte("COMMIT\x00")))
+	sess.unhold()
+	assert.False(t, sess.drain())
+
+	sess.respond(ready)
+	assert.False(t, sess.drain(), "The response must be sent to the client first")
+	sess.responded()
+	assert.True(t, sess.drain())
+	assert.False(t, sess.drain())
+	assert.False(t, sess.hold())
+}
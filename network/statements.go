@@ -0,0 +1,178 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// StatementNamePrefix is the prefix of the names of the prepared statements on the
+// server connections, when the server connections are shared between sessions.
+const StatementNamePrefix = "gd_"
+
+// preparedStatement is a named prepared statement that is created by a session.
+type preparedStatement struct {
+	// name is the name of the prepared statement on the server connections.
+	name string
+	// parse is the Parse message that creates the prepared statement with that name.
+	parse []byte
+}
+
+// serverStatementName returns the name of a prepared statement on the server connections.
+// The name is derived from the query and the parameter types, so the sessions that prepare
+// the same statement share it, and the sessions that reuse a name for different statements
+// don't collide.
+func serverStatementName(definition []byte) string {
+	hash := sha256.Sum256(definition)
+	return StatementNamePrefix + hex.EncodeToString(hash[:8])
+}
+
+// encodeMessage encodes a typed message with the given type and body.
+func encodeMessage(msgType byte, body []byte) []byte {
+	message := make([]byte, TypedHeaderLength, TypedHeaderLength+len(body))
+	message[0] = msgType
+	binary.BigEndian.PutUint32(message[1:TypedHeaderLength], uint32(len(body)+UntypedHeaderLength))
+	return append(message, body...)
+}
+
+// splitCString splits the data into the first null-terminated string and the rest.
+func splitCString(data []byte) (string, []byte, bool) {
+	idx := bytes.IndexByte(data, 0)
+	if idx < 0 {
+		return "", data, false
+	}
+	return string(data[:idx]), data[idx+1:], true
+}
+
+// cString encodes the string as a null-terminated string.
+func cString(str string) []byte {
+	return append([]byte(str), 0)
+}
+
+// rewriteStatements rewrites the names of the prepared statements in the request to the
+// names on the server connections, and keeps track of the prepared statements of the session
+// and of the server connection. It also returns the messages that must be sent to the server
+// connection before the request, so that the prepared statements that are created on other
+// server connections exist on this one. The injected messages are only valid if they are sent,
+// so commit must be called after sending them. The caller must hold the lock.
+func (s *session) rewriteStatements(client *Client, request []byte) ([]byte, []byte, func()) {
+	if s.startup {
+		// The startup-phase messages are not typed messages.
+		return request, nil, func() {}
+	}
+
+	if s.statements == nil {
+		s.statements = make(map[string]*preparedStatement)
+	}
+
+	var injected []byte
+	var injectedNames []string
+	prepared := make(map[string]bool)
+	rewritten := make([]byte, 0, len(request))
+
+	// prepare makes sure that the prepared statement exists on the server connection. The
+	// statement is closed first, since it might exist with the same name from another session.
+	prepare := func(stmt *preparedStatement, parse bool) {
+		if prepared[stmt.name] {
+			return
+		}
+		prepared[stmt.name] = true
+		injected = append(injected, encodeMessage(
+			CloseMessage, append([]byte{'S'}, cString(stmt.name)...))...)
+		if parse {
+			injected = append(injected, stmt.parse...)
+			injectedNames = append(injectedNames, stmt.name)
+		}
+	}
+
+	forEachMessage(request, func(msgType byte, body []byte) bool {
+		message := encodeMessage(msgType, body)
+
+		switch msgType {
+		case ParseMessage:
+			name, definition, ok := splitCString(body)
+			if !ok || name == "" {
+				// The unnamed statement is not shared between sessions.
+				break
+			}
+			stmt := &preparedStatement{name: serverStatementName(definition)}
+			stmt.parse = encodeMessage(ParseMessage, append(cString(stmt.name), definition...))
+			if client.hasStatement(stmt.name) {
+				// The statement must be closed before it is created again.
+				prepare(stmt, false)
+			}
+			prepared[stmt.name] = true
+			s.statements[name] = stmt
+			client.setStatement(stmt.name, true)
+			message = stmt.parse
+		case BindMessage:
+			portal, rest, ok := splitCString(body)
+			if !ok {
+				break
+			}
+			name, rest, ok := splitCString(rest)
+			stmt := s.statements[name]
+			if !ok || stmt == nil {
+				break
+			}
+			if !client.hasStatement(stmt.name) {
+				prepare(stmt, true)
+			}
+			message = encodeMessage(BindMessage,
+				append(append(cString(portal), cString(stmt.name)...), rest...))
+		case DescribeMessage, CloseMessage:
+			if len(body) == 0 || body[0] != 'S' {
+				// Portals are not shared between sessions.
+				break
+			}
+			name, rest, ok := splitCString(body[1:])
+			stmt := s.statements[name]
+			if !ok || stmt == nil {
+				break
+			}
+			if msgType == CloseMessage {
+				delete(s.statements, name)
+				delete(prepared, stmt.name)
+				client.setStatement(stmt.name, false)
+			} else if !client.hasStatement(stmt.name) {
+				prepare(stmt, true)
+			}
+			message = encodeMessage(msgType,
+				append(append([]byte{'S'}, cString(stmt.name)...), rest...))
+		}
+
+		rewritten = append(rewritten, message...)
+		return true
+	})
+
+	commit := func() {
+		for _, name := range injectedNames {
+			client.setStatement(name, true)
+		}
+	}
+
+	return rewritten, injected, commit
+}
+
+// swallowResponses removes the responses to the injected messages from the response.
+// The injected messages are followed by a Sync message, so the responses to them end
+// with a ReadyForQuery message. The caller must hold the lock.
+func (s *session) swallowResponses(response []byte) []byte {
+	if s.swallow == 0 {
+		return response
+	}
+
+	remaining := response[:0:0]
+	forEachMessage(response, func(msgType byte, body []byte) bool {
+		if s.swallow > 0 {
+			if msgType == ReadyForQueryMessage {
+				s.swallow--
+			}
+			return true
+		}
+		remaining = append(remaining, encodeMessage(msgType, body)...)
+		return true
+	})
+	return remaining
+}
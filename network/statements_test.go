@@ -0,0 +1,88 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_session_rewriteStatements tests that the names of the prepared statements are
+// rewritten, and that the missing prepared statements are injected.
+func Test_session_rewriteStatements(t *testing.T) {
+	definition := []byte("SELECT $1\x00\x00\x00")
+	name := serverStatementName(definition)
+	parse := CreatePostgreSQLPacket(ParseMessage, append([]byte("stmt\x00"), definition...))
+	bind := CreatePostgreSQLPacket(BindMessage, []byte("\x00stmt\x00\x00\x00\x00\x00\x00\x00"))
+	sync := CreatePostgreSQLPacket(SyncMessage, nil)
+
+	sess := newSession()
+	sess.startup = false
+	first := &Client{}
+	rewritten, injected, _ := sess.rewriteStatements(first, append(append([]byte{}, parse...), sync...))
+	assert.Empty(t, injected)
+	assert.Equal(t,
+		append(CreatePostgreSQLPacket(ParseMessage, append([]byte(name+"\x00"), definition...)), sync...),
+		rewritten)
+	assert.True(t, first.hasStatement(name))
+
+	// The prepared statement is re-created on another server connection.
+	second := &Client{}
+	rewritten, injected, commit := sess.rewriteStatements(second, bind)
+	assert.Equal(t,
+		CreatePostgreSQLPacket(BindMessage, []byte("\x00"+name+"\x00\x00\x00\x00\x00\x00\x00")),
+		rewritten)
+	assert.Equal(t,
+		append(
+			CreatePostgreSQLPacket(CloseMessage, []byte("S"+name+"\x00")),
+			CreatePostgreSQLPacket(ParseMessage, append([]byte(name+"\x00"), definition...))...),
+		injected)
+	assert.False(t, second.hasStatement(name))
+	commit()
+	assert.True(t, second.hasStatement(name))
+
+	// Closing the prepared statement removes it from the session and the server connection.
+	rewritten, injected, _ = sess.rewriteStatements(
+		second, CreatePostgreSQLPacket(CloseMessage, []byte("Sstmt\x00")))
+	assert.Empty(t, injected)
+	assert.Equal(t, CreatePostgreSQLPacket(CloseMessage, []byte("S"+name+"\x00")), rewritten)
+	assert.False(t, second.hasStatement(name))
+	assert.Empty(t, sess.statements)
+}
+
+// Test_session_rewriteStatements_Collision tests that the sessions that use the same name
+// for different statements don't collide, and that the unnamed statement is not rewritten.
+func Test_session_rewriteStatements_Collision(t *testing.T) {
+	client := &Client{}
+	first, second := newSession(), newSession()
+	first.startup, second.startup = false, false
+
+	firstParse, _, _ := first.rewriteStatements(client,
+		CreatePostgreSQLPacket(ParseMessage, []byte("stmt\x00SELECT 1\x00\x00\x00")))
+	secondParse, _, _ := second.rewriteStatements(client,
+		CreatePostgreSQLPacket(ParseMessage, []byte("stmt\x00SELECT 2\x00\x00\x00")))
+	assert.NotEqual(t, first.statements["stmt"].name, second.statements["stmt"].name)
+	assert.NotEqual(t, firstParse, secondParse)
+
+	unnamed := CreatePostgreSQLPacket(ParseMessage, []byte("\x00SELECT 1\x00\x00\x00"))
+	rewritten, injected, _ := first.rewriteStatements(client, unnamed)
+	assert.Empty(t, injected)
+	assert.Equal(t, unnamed, rewritten)
+}
+
+// Test_session_swallowResponses tests that the responses to the injected messages are removed.
+func Test_session_swallowResponses(t *testing.T) {
+	sess := newSession()
+	sess.swallow = 1
+
+	bindComplete := CreatePostgreSQLPacket('2', nil)
+	ready := CreatePostgreSQLPacket(ReadyForQueryMessage, []byte{TransactionStatusIdle})
+
+	// The responses to the injected messages might arrive in multiple reads.
+	assert.Empty(t, sess.swallowResponses(CreatePostgreSQLPacket('3', nil)))
+	response := append(append(CreatePostgreSQLPacket('1', nil), ready...), bindComplete...)
+	remaining := sess.swallowResponses(response)
+	require.Equal(t, 0, sess.swallow)
+	assert.Equal(t, bindComplete, remaining)
+	assert.Equal(t, ready, sess.swallowResponses(ready))
+}
@@ -0,0 +1,188 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+)
+
+const (
+	// listenFdsStart is the first file descriptor of the sockets that are passed by systemd.
+	listenFdsStart = 3
+	// watchdogPingsPerInterval is how many times the watchdog of systemd is pinged within its
+	// interval, so that a late ping doesn't make systemd restart GatewayD.
+	watchdogPingsPerInterval = 2
+)
+
+// SystemdSockets are the listening sockets that are passed by systemd with socket activation,
+// which the servers accept the connections on instead of listening on their addresses.
+type SystemdSockets struct {
+	Listeners []net.Listener
+	// Names are the names of the sockets, i.e. the FileDescriptorName= of the socket units,
+	// which default to the names of the socket units.
+	Names []string
+
+	used []bool
+}
+
+// ActivateSockets returns the sockets that are passed by systemd, or nil if GatewayD isn't
+// socket activated. The environment variables of the sockets are removed, so that they aren't
+// passed to the plugins.
+func ActivateSockets() (*SystemdSockets, *gerr.GatewayDError) {
+	return activateSockets(listenFdsStart)
+}
+
+// activateSockets returns the sockets that are passed by systemd from the given file descriptor.
+func activateSockets(start int) (*SystemdSockets, *gerr.GatewayDError) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(name)
+	}
+
+	// The sockets are passed to another process, e.g. the parent of GatewayD.
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, gerr.ErrSocketActivationFailed.Wrap(
+			fmt.Errorf("invalid number of sockets: %s", fds))
+	}
+
+	sockets := &SystemdSockets{
+		Listeners: make([]net.Listener, 0, count),
+		Names:     make([]string, 0, count),
+		used:      make([]bool, count),
+	}
+	fdNames := strings.Split(names, ":")
+	for i := range count {
+		name := "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+
+		// The listener gets its own duplicate of the file descriptor.
+		file := os.NewFile(uintptr(start+i), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			for _, listener := range sockets.Listeners {
+				_ = listener.Close()
+			}
+			return nil, gerr.ErrSocketActivationFailed.Wrap(
+				fmt.Errorf("the socket %s isn't a listening socket: %w", name, err))
+		}
+		sockets.Listeners = append(sockets.Listeners, listener)
+		sockets.Names = append(sockets.Names, name)
+	}
+
+	return sockets, nil
+}
+
+// Listener returns the socket of the server of the given name, which is the socket of the same
+// name, or else the one that listens on the address of the server. Each socket is used once.
+func (s *SystemdSockets) Listener(name, network, address string) net.Listener {
+	if s == nil {
+		return nil
+	}
+
+	for i, socketName := range s.Names {
+		if !s.used[i] && socketName == name {
+			s.used[i] = true
+			return s.Listeners[i]
+		}
+	}
+	for i, listener := range s.Listeners {
+		if !s.used[i] && listensOn(listener, network, address) {
+			s.used[i] = true
+			return listener
+		}
+	}
+	return nil
+}
+
+// CloseUnused closes the sockets that aren't used by any server, and returns their names.
+func (s *SystemdSockets) CloseUnused() []string {
+	if s == nil {
+		return nil
+	}
+
+	var unused []string
+	for i, listener := range s.Listeners {
+		if !s.used[i] {
+			_ = listener.Close()
+			s.used[i] = true
+			unused = append(unused, s.Names[i])
+		}
+	}
+	return unused
+}
+
+// listensOn returns true if the listener listens on the given address. The unspecified
+// addresses, e.g. 0.0.0.0 and [::], are the same.
+func listensOn(listener net.Listener, network, address string) bool {
+	switch addr := listener.Addr().(type) {
+	case *net.TCPAddr:
+		tcpAddr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return false
+		}
+		ip := tcpAddr.IP
+		return addr.Port == tcpAddr.Port &&
+			(addr.IP.Equal(ip) || addr.IP.IsUnspecified() && (ip == nil || ip.IsUnspecified()))
+	case *net.UnixAddr:
+		return network == "unix" && addr.Name == address
+	default:
+		return false
+	}
+}
+
+// SdNotify sends the state, e.g. READY=1, to systemd if GatewayD is run by a service of
+// Type=notify. It returns false if it isn't.
+func SdNotify(state string) (bool, *gerr.GatewayDError) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// The abstract sockets start with a null byte.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, gerr.ErrSystemdNotifyFailed.Wrap(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, gerr.ErrSystemdNotifyFailed.Wrap(err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often the watchdog of systemd is pinged with WATCHDOG=1, or zero
+// if the watchdog isn't enabled by WatchdogSec= of the service.
+func WatchdogInterval() (time.Duration, *gerr.GatewayDError) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	// The watchdog is enabled for another process, e.g. the parent of GatewayD.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	interval, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || interval <= 0 {
+		return 0, gerr.ErrSystemdNotifyFailed.Wrap(errors.New("invalid watchdog interval: " + usec))
+	}
+	return time.Duration(interval) * time.Microsecond / watchdogPingsPerInterval, nil
+}
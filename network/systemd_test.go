@@ -0,0 +1,160 @@
+//go:build !windows
+// +build !windows
+
+package network
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passSocket passes a duplicate of the listener like systemd does, and returns the first file
+// descriptor of the sockets. The file descriptor isn't owned by an os.File, whose finalizer
+// would close it again once it is closed by activateSockets and reused by other tests.
+func passSocket(t *testing.T, listener net.Listener, names string) int {
+	t.Helper()
+
+	tcpListener, ok := listener.(*net.TCPListener)
+	require.True(t, ok)
+	file, err := tcpListener.File()
+	require.NoError(t, err)
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", names)
+	return fd
+}
+
+// TestActivateSockets tests that the sockets passed by systemd are used by the servers of
+// their names, or else by the servers that listen on their addresses.
+func TestActivateSockets(t *testing.T) {
+	sockets, err := activateSockets(listenFdsStart)
+	require.Nil(t, err)
+	assert.Nil(t, sockets)
+	assert.Nil(t, sockets.Listener("default", "tcp", "127.0.0.1:15432"))
+
+	listener, origErr := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, origErr)
+	defer listener.Close()
+	address := listener.Addr().String()
+
+	// The sockets of another process are ignored.
+	fd := passSocket(t, listener, "default")
+	t.Setenv("LISTEN_PID", "1")
+	sockets, err = ActivateSockets()
+	require.Nil(t, err)
+	assert.Nil(t, sockets)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+	require.NoError(t, syscall.Close(fd))
+
+	sockets, err = activateSockets(passSocket(t, listener, "default"))
+	require.Nil(t, err)
+	require.NotNil(t, sockets)
+	assert.Equal(t, []string{"default"}, sockets.Names)
+	assert.Empty(t, os.Getenv("LISTEN_PID"))
+	assert.Nil(t, sockets.Listener("test", "tcp", "127.0.0.1:1"))
+	activated := sockets.Listener("default", "tcp", "127.0.0.1:1")
+	require.NotNil(t, activated)
+	assert.Equal(t, address, activated.Addr().String())
+	assert.Nil(t, sockets.Listener("default", "tcp", address))
+	assert.Empty(t, sockets.CloseUnused())
+	require.NoError(t, activated.Close())
+
+	sockets, err = activateSockets(passSocket(t, listener, ""))
+	require.Nil(t, err)
+	require.NotNil(t, sockets)
+	assert.Equal(t, []string{"unknown"}, sockets.Names)
+	activated = sockets.Listener("default", "tcp", address)
+	require.NotNil(t, activated)
+	require.NoError(t, activated.Close())
+
+	sockets, err = activateSockets(passSocket(t, listener, "removed"))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"removed"}, sockets.CloseUnused())
+}
+
+func Test_listensOn(t *testing.T) {
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	assert.True(t, listensOn(listener, "tcp", "0.0.0.0:"+port))
+	assert.True(t, listensOn(listener, "tcp", ":"+port))
+	assert.False(t, listensOn(listener, "tcp", "127.0.0.1:"+port))
+	assert.False(t, listensOn(listener, "tcp", "0.0.0.0:1"))
+	assert.False(t, listensOn(listener, "unix", "/tmp/gatewayd.sock"))
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer local.Close()
+	assert.True(t, listensOn(local, "tcp", local.Addr().String()))
+	assert.False(t, listensOn(local, "tcp", ":"+strconv.Itoa(local.Addr().(*net.TCPAddr).Port)))
+}
+
+// TestSdNotify tests that the states are sent to the notification socket of systemd.
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	notified, err := SdNotify("READY=1")
+	require.Nil(t, err)
+	assert.False(t, notified)
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, origErr := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, origErr)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	notified, err = SdNotify("READY=1")
+	require.Nil(t, err)
+	assert.True(t, notified)
+
+	state := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	received, _, origErr := conn.ReadFrom(state)
+	require.NoError(t, origErr)
+	assert.Equal(t, "READY=1", string(state[:received]))
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	notified, err = SdNotify("READY=1")
+	require.NotNil(t, err)
+	assert.False(t, notified)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	interval, err := WatchdogInterval()
+	require.Nil(t, err)
+	assert.Zero(t, interval)
+
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	interval, err = WatchdogInterval()
+	require.Nil(t, err)
+	assert.Equal(t, time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, err = WatchdogInterval()
+	require.Nil(t, err)
+	assert.Equal(t, time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", "1")
+	interval, err = WatchdogInterval()
+	require.Nil(t, err)
+	assert.Zero(t, interval)
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "never")
+	_, err = WatchdogInterval()
+	require.NotNil(t, err)
+}
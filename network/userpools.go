@@ -0,0 +1,423 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/go-co-op/gocron"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+)
+
+// PoolKey identifies the pool of the server connections of a database and a user.
+type PoolKey struct {
+	Database string
+	User     string
+}
+
+// String returns the key in the form of user@database.
+func (k PoolKey) String() string {
+	return k.User + "@" + k.Database
+}
+
+// newPoolKey returns the pool key of the parameters of a StartupMessage.
+// The database defaults to the user name, like in PostgreSQL.
+func newPoolKey(parameters map[string]string) PoolKey {
+	return PoolKey{
+		Database: config.If(parameters["database"] != "", parameters["database"], parameters["user"]),
+		User:     parameters["user"],
+	}
+}
+
+// credentials authenticate the server connections as a user. The password is either
+// a plain text password or an md5 secret, and the SCRAM keys are used instead, if set.
+type credentials struct {
+	password  string
+	scramKeys *scramClientKeys
+}
+
+// idleClient is a server connection that is not assigned to an incoming connection.
+type idleClient struct {
+	client *Client
+	since  time.Time
+}
+
+// perUserPool holds the server connections of a database and a user.
+type perUserPool struct {
+	key PoolKey
+	// idle are the server connections that are not assigned to an incoming connection,
+	// from the least to the most recently used one.
+	idle []idleClient
+	// size is the number of server connections of the pool, including the assigned ones.
+	size int
+	// credentials are the credentials of the last incoming connection that is authenticated
+	// by the proxy. Without them, the pool only holds the server connections on which the
+	// incoming connections went through the startup phase themselves.
+	credentials *credentials
+}
+
+// pop removes the most recently used idle server connection that matches the given
+// authentication state from the pool and returns it. It returns nil if there is none.
+func (p *perUserPool) pop(authenticated bool) *Client {
+	for idx := len(p.idle) - 1; idx >= 0; idx-- {
+		if client := p.idle[idx].client; client.IsAuthenticated() == authenticated {
+			p.idle = append(p.idle[:idx], p.idle[idx+1:]...)
+			return client
+		}
+	}
+	return nil
+}
+
+// UserPools creates the pools of the server connections on demand, for each database and
+// user of the incoming connections, as sent in their StartupMessage. Each pool holds a
+// limited number of server connections, and the number of server connections of all pools
+// is limited as well, so that the server is not overwhelmed by the number of tenants.
+// Idle server connections are closed after the idle timeout.
+type UserPools struct {
+	// ClientConfig is the configuration of the server connections. Its user,
+	// password and database are replaced by the ones of the pools.
+	ClientConfig *config.Client
+	// PoolSize is the maximum number of server connections of each pool.
+	PoolSize int
+	// MaxServerConnections is the maximum number of server connections of all pools.
+	MaxServerConnections int
+	// IdleTimeout is the duration after which the idle server connections are closed.
+	IdleTimeout time.Duration
+	// CircuitBreaker records the outcome of the dials, if set, which fail right away while it is open.
+	CircuitBreaker *CircuitBreaker
+	Logger         zerolog.Logger
+
+	ctx       context.Context //nolint:containedctx
+	mu        *sync.Mutex
+	pools     map[PoolKey]*perUserPool
+	clients   map[*Client]*perUserPool
+	size      int
+	closed    bool
+	scheduler *gocron.Scheduler
+}
+
+// NewUserPools creates a new set of per-user pools, and schedules the closing of the idle
+// server connections.
+func NewUserPools(ctx context.Context, userPools UserPools) *UserPools {
+	poolsCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewUserPools")
+	defer span.End()
+
+	pools := &UserPools{
+		ClientConfig:         userPools.ClientConfig,
+		PoolSize:             config.If(userPools.PoolSize > 0, userPools.PoolSize, config.DefaultUserPoolSize),
+		MaxServerConnections: userPools.MaxServerConnections,
+		IdleTimeout:          userPools.IdleTimeout,
+		CircuitBreaker:       userPools.CircuitBreaker,
+		Logger:               userPools.Logger,
+		ctx:                  poolsCtx,
+		mu:                   &sync.Mutex{},
+		pools:                make(map[PoolKey]*perUserPool),
+		clients:              make(map[*Client]*perUserPool),
+		scheduler:            gocron.NewScheduler(time.UTC),
+	}
+
+	if pools.IdleTimeout > 0 {
+		if _, err := pools.scheduler.Every(pools.IdleTimeout).SingletonMode().StartAt(
+			time.Now().Add(pools.IdleTimeout)).Do(pools.closeIdle); err != nil {
+			pools.Logger.Error().Err(err).Msg("Failed to schedule the closing of idle server connections")
+			span.RecordError(err)
+		}
+		pools.scheduler.StartAsync()
+	}
+
+	return pools
+}
+
+// setCredentials sets the credentials with which the new server connections of the pool
+// are authenticated, and creates the pool if it doesn't exist.
+func (u *UserPools) setCredentials(key PoolKey, creds *credentials) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.getPool(key).credentials = creds
+}
+
+// getPool returns the pool of the key, and creates it if it doesn't exist.
+// The caller must hold the lock.
+func (u *UserPools) getPool(key PoolKey) *perUserPool {
+	userPool, ok := u.pools[key]
+	if !ok {
+		userPool = &perUserPool{key: key}
+		u.pools[key] = userPool
+		u.Logger.Debug().Str("pool", key.String()).Msg("Created a new per-user pool")
+	}
+	return userPool
+}
+
+// acquire removes a server connection from the pool of the key and returns it. If the
+// server connection must be authenticated, it is either an idle one, or a new one that
+// is authenticated with the credentials of the pool. Otherwise, it is a server connection
+// on which the incoming connection can go through the startup phase, which is either
+// an idle unauthenticated one, a new one, or a recycled authenticated one.
+func (u *UserPools) acquire(key PoolKey, authenticated bool) (*Client, *gerr.GatewayDError) {
+	_, span := otel.Tracer(config.TracerName).Start(u.ctx, "acquire")
+	defer span.End()
+
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		return nil, gerr.ErrClientNotConnected
+	}
+
+	userPool := u.getPool(key)
+	if client := userPool.pop(authenticated); client != nil {
+		u.mu.Unlock()
+		return client, nil
+	}
+
+	var evicted *Client
+	var creds *credentials
+	canCreate := userPool.size < u.PoolSize && (!authenticated || userPool.credentials != nil)
+	if canCreate && u.MaxServerConnections > 0 && u.size >= u.MaxServerConnections {
+		// Make room for the server connection by closing an idle one of another pool.
+		if evicted = u.evictIdle(); evicted == nil {
+			canCreate = false
+		}
+	}
+	if !canCreate {
+		var recycled *Client
+		if !authenticated {
+			recycled = userPool.pop(true)
+		}
+		u.prune(userPool)
+		u.mu.Unlock()
+
+		if recycled == nil {
+			span.RecordError(gerr.ErrPoolExhausted)
+			return nil, gerr.ErrPoolExhausted
+		}
+		// The incoming connection goes through the startup phase on a new connection.
+		if err := recycled.Reconnect(); err != nil {
+			span.RecordError(err)
+			u.put(recycled)
+			return nil, gerr.ErrClientConnectionFailed.Wrap(err)
+		}
+		return recycled, nil
+	}
+
+	// The pool might have been pruned when its last idle server connection was evicted.
+	u.pools[key] = userPool
+	userPool.size++
+	u.size++
+	if authenticated {
+		creds = userPool.credentials
+	}
+	u.mu.Unlock()
+
+	if evicted != nil {
+		evicted.Close()
+	}
+
+	client, err := u.newClient(key, creds)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err == nil && u.closed {
+		client.Close()
+		err = gerr.ErrClientNotConnected
+	}
+	if err != nil {
+		userPool.size--
+		u.size--
+		u.prune(userPool)
+		span.RecordError(err)
+		return nil, err
+	}
+
+	u.clients[client] = userPool
+	u.Logger.Debug().Fields(
+		map[string]interface{}{
+			"pool":          key.String(),
+			"size":          userPool.size,
+			"total":         u.size,
+			"authenticated": authenticated,
+		},
+	).Msg("Created a new server connection in the per-user pool")
+
+	return client, nil
+}
+
+// newClient creates a new server connection, and authenticates it with the credentials
+// as the user of the key, if any.
+func (u *UserPools) newClient(key PoolKey, creds *credentials) (*Client, *gerr.GatewayDError) {
+	clientConfig := *u.ClientConfig
+	clientConfig.User, clientConfig.Password, clientConfig.Database = "", "", ""
+
+	client := NewClient(
+		u.ctx, &clientConfig, u.Logger,
+		NewRetry(
+			Retry{
+				Retries: clientConfig.Retries,
+				Backoff: config.If(
+					clientConfig.Backoff > 0,
+					clientConfig.Backoff,
+					config.DefaultBackoff,
+				),
+				BackoffMultiplier:  clientConfig.BackoffMultiplier,
+				DisableBackoffCaps: clientConfig.DisableBackoffCaps,
+				CircuitBreaker:     u.CircuitBreaker,
+				Logger:             u.Logger,
+			},
+		),
+	)
+	if client == nil {
+		return nil, gerr.ErrClientConnectionFailed
+	}
+	if creds == nil {
+		return client, nil
+	}
+
+	client.User = key.User
+	client.Database = key.Database
+	client.password = creds.password
+	client.scramKeys = creds.scramKeys
+	if err := client.authenticate(); err != nil {
+		u.Logger.Error().Err(err).Str("pool", key.String()).Msg(
+			"Failed to authenticate the new connection")
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// evictIdle removes the least recently used idle server connection of all pools, so that
+// it can be closed. It returns nil if there is none. The caller must hold the lock.
+func (u *UserPools) evictIdle() *Client {
+	var oldest *perUserPool
+	for _, userPool := range u.pools {
+		if len(userPool.idle) > 0 &&
+			(oldest == nil || userPool.idle[0].since.Before(oldest.idle[0].since)) {
+			oldest = userPool
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+
+	client := oldest.idle[0].client
+	oldest.idle = oldest.idle[1:]
+	u.remove(oldest, client)
+	return client
+}
+
+// remove removes the server connection from its pool, so that it can be closed.
+// The caller must hold the lock.
+func (u *UserPools) remove(userPool *perUserPool, client *Client) {
+	delete(u.clients, client)
+	userPool.size--
+	u.size--
+	u.prune(userPool)
+}
+
+// prune deletes the pool if it has neither server connections nor credentials, so that
+// the pools of the users that are gone don't pile up. The caller must hold the lock.
+func (u *UserPools) prune(userPool *perUserPool) {
+	if userPool.size == 0 && len(userPool.idle) == 0 && userPool.credentials == nil {
+		delete(u.pools, userPool.key)
+	}
+}
+
+// put puts the server connection back in its pool, or closes it if it is broken.
+func (u *UserPools) put(client *Client) {
+	if client == nil {
+		return
+	}
+
+	u.mu.Lock()
+	userPool, ok := u.clients[client]
+	if ok && !u.closed && client.IsConnected() {
+		userPool.idle = append(userPool.idle, idleClient{client: client, since: time.Now()})
+		u.mu.Unlock()
+		return
+	}
+	if ok {
+		u.remove(userPool, client)
+	}
+	u.mu.Unlock()
+
+	if client.IsConnected() {
+		client.Close()
+	}
+}
+
+// closeIdle closes the server connections that have been idle for longer than the idle timeout.
+func (u *UserPools) closeIdle() {
+	_, span := otel.Tracer(config.TracerName).Start(u.ctx, "closeIdle")
+	defer span.End()
+
+	deadline := time.Now().Add(-u.IdleTimeout)
+	var expired []*Client
+
+	u.mu.Lock()
+	for _, userPool := range u.pools {
+		count := 0
+		for count < len(userPool.idle) && userPool.idle[count].since.Before(deadline) {
+			count++
+		}
+		for _, idle := range userPool.idle[:count] {
+			expired = append(expired, idle.client)
+		}
+		userPool.idle = userPool.idle[count:]
+		for _, client := range expired[len(expired)-count:] {
+			u.remove(userPool, client)
+		}
+	}
+	u.mu.Unlock()
+
+	for _, client := range expired {
+		client.Close()
+	}
+
+	if len(expired) > 0 {
+		u.Logger.Debug().Int("count", len(expired)).Msg("Closed the idle server connections")
+	}
+}
+
+// idleClients returns the idle server connections of all pools.
+func (u *UserPools) idleClients() []*Client {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	clients := make([]*Client, 0)
+	for _, userPool := range u.pools {
+		for _, idle := range userPool.idle {
+			clients = append(clients, idle.client)
+		}
+	}
+	return clients
+}
+
+// Shutdown closes the idle server connections of all pools. The server connections that
+// are assigned to incoming connections are closed when they are put back.
+func (u *UserPools) Shutdown() {
+	u.scheduler.Stop()
+	u.scheduler.Clear()
+
+	u.mu.Lock()
+	u.closed = true
+	var idle []*Client
+	for _, userPool := range u.pools {
+		for _, client := range userPool.idle {
+			idle = append(idle, client.client)
+			u.remove(userPool, client.client)
+		}
+		userPool.idle = nil
+	}
+	u.mu.Unlock()
+
+	for _, client := range idle {
+		if client.IsConnected() {
+			client.Close()
+		}
+	}
+}
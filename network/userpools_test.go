@@ -0,0 +1,203 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUserPools creates per-user pools of server connections to the fake backend.
+func newTestUserPools(
+	t *testing.T, backend *FakeBackend, poolSize, maxServerConnections int,
+) *UserPools {
+	t.Helper()
+
+	userPools := NewUserPools(context.Background(), UserPools{
+		ClientConfig: &config.Client{
+			Network:          "tcp",
+			Address:          backend.Address(),
+			ReceiveChunkSize: config.DefaultChunkSize,
+			DialTimeout:      config.DefaultDialTimeout,
+		},
+		PoolSize:             poolSize,
+		MaxServerConnections: maxServerConnections,
+		Logger:               zerolog.Nop(),
+	})
+	t.Cleanup(userPools.Shutdown)
+
+	return userPools
+}
+
+// TestUserPools tests the size limit of each per-user pool and the limit
+// of the server connections of all pools.
+func TestUserPools(t *testing.T) {
+	backend := NewFakeBackend(t)
+	userPools := newTestUserPools(t, backend, 2, 3)
+
+	alice := PoolKey{Database: "app", User: "alice"}
+	bob := PoolKey{Database: "app", User: "bob"}
+
+	first, err := userPools.acquire(alice, false)
+	require.Nil(t, err)
+	second, err := userPools.acquire(alice, false)
+	require.Nil(t, err)
+	_, err = userPools.acquire(alice, false)
+	assert.Equal(t, gerr.ErrPoolExhausted, err)
+
+	// The authenticated server connections are reused by the same user.
+	first.authenticated.Store(true)
+	userPools.put(first)
+	client, err := userPools.acquire(alice, true)
+	require.Nil(t, err)
+	assert.Same(t, first, client)
+
+	// Without credentials, the pool can't create authenticated server connections.
+	_, err = userPools.acquire(bob, true)
+	assert.Equal(t, gerr.ErrPoolExhausted, err)
+
+	third, err := userPools.acquire(bob, false)
+	require.Nil(t, err)
+
+	// The server is at its limit, until an idle server connection of another pool is closed.
+	_, err = userPools.acquire(bob, false)
+	assert.Equal(t, gerr.ErrPoolExhausted, err)
+	userPools.put(second)
+	fourth, err := userPools.acquire(bob, false)
+	require.Nil(t, err)
+	assert.False(t, second.IsConnected())
+	assert.Eventually(t, func() bool { return backend.Connections() == 4 }, time.Second, 10*time.Millisecond)
+
+	userPools.put(first)
+	userPools.put(third)
+	userPools.put(fourth)
+	assert.Len(t, userPools.idleClients(), 3)
+}
+
+// TestUserPoolsCloseIdle tests that the idle server connections are closed after the
+// idle timeout, and that the empty pools are removed.
+func TestUserPoolsCloseIdle(t *testing.T) {
+	backend := NewFakeBackend(t)
+	userPools := newTestUserPools(t, backend, 2, 0)
+	userPools.IdleTimeout = 50 * time.Millisecond
+
+	key := PoolKey{Database: "app", User: "alice"}
+	first, err := userPools.acquire(key, false)
+	require.Nil(t, err)
+	second, err := userPools.acquire(key, false)
+	require.Nil(t, err)
+
+	userPools.put(first)
+	time.Sleep(2 * userPools.IdleTimeout)
+	userPools.put(second)
+
+	userPools.closeIdle()
+	// The server connection that is put back last is still fresh.
+	assert.Equal(t, []*Client{second}, userPools.idleClients())
+	assert.False(t, first.IsConnected())
+	assert.True(t, second.IsConnected())
+
+	time.Sleep(2 * userPools.IdleTimeout)
+	userPools.closeIdle()
+	assert.Empty(t, userPools.idleClients())
+	assert.False(t, second.IsConnected())
+	assert.Empty(t, userPools.pools)
+}
+
+// newTestUserPoolsProxy creates a proxy that assigns the server connections
+// from per-user pools to the incoming connections.
+func newTestUserPoolsProxy(
+	t *testing.T, backend *FakeBackend, poolMode string, authenticator *Authenticator,
+) *Proxy {
+	t.Helper()
+
+	proxy := newTestAuthProxy(t, backend, 0, poolMode, authenticator)
+	proxy.UserPools = NewUserPools(context.Background(), UserPools{
+		ClientConfig:         proxy.ClientConfig,
+		PoolSize:             2,
+		MaxServerConnections: 10,
+		Logger:               zerolog.Nop(),
+	})
+
+	return proxy
+}
+
+// TestProxyUserPools tests that the incoming connections go through the startup phase
+// on the server connections of their database and user, which are then only shared with
+// the incoming connections of the same database and user.
+func TestProxyUserPools(t *testing.T) {
+	for _, poolMode := range []string{config.SessionPoolMode, config.TransactionPoolMode} {
+		t.Run(poolMode, func(t *testing.T) {
+			backend := NewFakeBackend(t)
+			proxy := newTestUserPoolsProxy(t, backend, poolMode, nil)
+
+			for _, login := range []PoolKey{
+				{Database: "app", User: "alice"},
+				{Database: "app", User: "alice"},
+				{Database: "reports", User: "alice"},
+				{Database: "app", User: "bob"},
+			} {
+				pgConn, err := connectToDatabase(t, proxy, login.User, "", login.Database)
+				assertAuthenticated(t, pgConn, err)
+			}
+
+			assert.Equal(t, 2, backend.Logins("alice", "app"))
+			assert.Equal(t, 1, backend.Logins("alice", "reports"))
+			assert.Equal(t, 1, backend.Logins("bob", "app"))
+			assert.Len(t, proxy.UserPools.pools, 3)
+
+			// The pool of alice@app is full, since the server connections
+			// go through the startup phase with each incoming connection.
+			_, err := connectToDatabase(t, proxy, "alice", "", "app")
+			if poolMode == config.SessionPoolMode {
+				var pgErr *pgconn.PgError
+				require.True(t, errors.As(err, &pgErr), err)
+				assert.Equal(t, TooManyConnectionsCode, pgErr.Code)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestProxyUserPoolsAuthentication tests that the server connections of the per-user pools
+// are authenticated as the users that are authenticated by the proxy.
+func TestProxyUserPoolsAuthentication(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "secret", nil)
+	proxy := newTestUserPoolsProxy(t, backend, config.TransactionPoolMode, NewAuthenticator(
+		context.Background(),
+		Authenticator{
+			Method: config.AuthMethodMD5,
+			UserList: map[string]string{
+				"alice": "secret",
+				"bob":   md5Secret("bob", "secret"),
+				"carol": "wrong",
+			},
+			Logger: zerolog.Nop(),
+		},
+	))
+
+	for range 3 {
+		pgConn, err := connectToDatabase(t, proxy, "alice", "secret", "app")
+		assertAuthenticated(t, pgConn, err)
+	}
+	pgConn, err := connectToDatabase(t, proxy, "bob", "secret", "app")
+	assertAuthenticated(t, pgConn, err)
+
+	// The server connections are shared by the incoming connections of the same user.
+	assert.Equal(t, 1, backend.Logins("alice", "app"))
+	assert.Equal(t, 1, backend.Logins("bob", "app"))
+
+	// The password of the user list is not accepted by the server.
+	_, err = connectToDatabase(t, proxy, "carol", "wrong", "app")
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, ConnectionFailureCode, pgErr.Code)
+}
@@ -54,20 +54,25 @@ func trafficData(
 	fields []Field,
 	err interface{},
 ) map[string]interface{} {
-	if conn == nil || client == nil {
+	if conn == nil {
 		return nil
 	}
 
+	// The client might not be assigned to the incoming connection, if the
+	// proxy is not in the session pool mode.
+	server := map[string]interface{}{
+		"local":  "",
+		"remote": "",
+	}
+	if client != nil {
+		server["local"] = client.LocalAddr()
+		server["remote"] = client.RemoteAddr()
+	}
+
 	data := map[string]interface{}{
-		"client": map[string]interface{}{
-			"local":  LocalAddr(conn),
-			"remote": RemoteAddr(conn),
-		},
-		"server": map[string]interface{}{
-			"local":  client.LocalAddr(),
-			"remote": client.RemoteAddr(),
-		},
-		"error": "",
+		"client": clientData(conn),
+		"server": server,
+		"error":  "",
 	}
 
 	for _, field := range fields {
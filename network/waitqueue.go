@@ -0,0 +1,187 @@
+package network
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/gatewayd-io/gatewayd/metrics"
+	"go.opentelemetry.io/otel"
+)
+
+// The results of waiting in the queue, which label the wait duration metric.
+const (
+	waitResultAcquired = "acquired"
+	waitResultTimeout  = "timeout"
+	waitResultRejected = "rejected"
+)
+
+// WaitQueue is a bounded FIFO queue of the incoming connections that wait for a server
+// connection of a proxy while its pool is exhausted. The first waiting connection is woken
+// up when a server connection is returned to the pool, and the connections that arrive
+// in the meantime are queued behind the waiting ones. A connection gives up after the
+// timeout, or immediately if the queue is full.
+type WaitQueue struct {
+	// GroupName and ProxyName label the metrics of the wait queue.
+	GroupName string
+	ProxyName string
+	// MaxLength is the maximum number of waiting connections.
+	MaxLength int
+	// Timeout is how long a connection waits for a server connection.
+	Timeout time.Duration
+
+	ctx     context.Context //nolint:containedctx
+	mu      *sync.Mutex
+	waiters *list.List
+}
+
+// NewWaitQueue creates a new wait queue.
+func NewWaitQueue(ctx context.Context, wq WaitQueue) *WaitQueue {
+	waitQueueCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewWaitQueue")
+	defer span.End()
+
+	waitQueue := &WaitQueue{
+		GroupName: wq.GroupName,
+		ProxyName: wq.ProxyName,
+		MaxLength: wq.MaxLength,
+		Timeout:   config.If(wq.Timeout > 0, wq.Timeout, config.DefaultWaitTimeout),
+		ctx:       waitQueueCtx,
+		mu:        &sync.Mutex{},
+		waiters:   list.New(),
+	}
+	metrics.PoolWaitQueueLength.WithLabelValues(waitQueue.GroupName, waitQueue.ProxyName).Set(0)
+
+	return waitQueue
+}
+
+// Len returns the number of waiting connections.
+func (q *WaitQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.waiters.Len()
+}
+
+// acquire returns the server connection that is removed from the pool by pop, which must
+// not block. If there is none, or if other connections are already waiting, it waits in
+// the queue until pop returns one, and returns ErrPoolExhausted after the timeout or if
+// the queue is full. Without a queue, it returns ErrPoolExhausted right away. The error
+// isn't wrapped, so that it's matched by errors.Is.
+func (q *WaitQueue) acquire(pop func() *Client) (*Client, *gerr.GatewayDError) {
+	if q == nil {
+		if client := pop(); client != nil {
+			return client, nil
+		}
+		return nil, gerr.ErrPoolExhausted
+	}
+
+	_, span := otel.Tracer(config.TracerName).Start(q.ctx, "WaitQueue")
+	defer span.End()
+
+	q.mu.Lock()
+	if q.waiters.Len() == 0 {
+		if client := pop(); client != nil {
+			q.mu.Unlock()
+			return client, nil
+		}
+	}
+	if q.waiters.Len() >= q.MaxLength {
+		q.mu.Unlock()
+		q.observe(waitResultRejected, 0)
+		span.AddEvent("The wait queue is full")
+		return nil, gerr.ErrPoolExhausted
+	}
+	waiter := make(chan struct{}, 1)
+	element := q.waiters.PushBack(waiter)
+	q.updateLength()
+	q.mu.Unlock()
+	span.AddEvent("Queued for a server connection")
+
+	start := time.Now()
+	timer := time.NewTimer(q.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-waiter:
+			q.mu.Lock()
+			client := pop()
+			if client != nil {
+				q.remove(element)
+				// The pool might have more server connections for the next waiting connection.
+				signalWaiter(q.waiters.Front())
+			} else {
+				// The server connection is taken by a connection that doesn't wait, or it
+				// doesn't suit this one, so the next waiting connection tries it instead.
+				signalWaiter(element.Next())
+			}
+			q.mu.Unlock()
+
+			if client != nil {
+				q.observe(waitResultAcquired, time.Since(start))
+				return client, nil
+			}
+		case <-timer.C:
+			q.mu.Lock()
+			q.remove(element)
+			// The server connection that is returned in the meantime goes to the next one.
+			select {
+			case <-waiter:
+				signalWaiter(q.waiters.Front())
+			default:
+			}
+			q.mu.Unlock()
+
+			q.observe(waitResultTimeout, time.Since(start))
+			span.AddEvent("Timed out waiting for a server connection")
+			return nil, gerr.ErrPoolExhausted
+		}
+	}
+}
+
+// notify wakes up the first waiting connection, after a server connection is returned to the pool.
+func (q *WaitQueue) notify() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	signalWaiter(q.waiters.Front())
+}
+
+// remove removes the waiting connection from the queue. The caller must hold the lock.
+func (q *WaitQueue) remove(element *list.Element) {
+	q.waiters.Remove(element)
+	q.updateLength()
+}
+
+// updateLength updates the queue length metric. The caller must hold the lock.
+func (q *WaitQueue) updateLength() {
+	metrics.PoolWaitQueueLength.WithLabelValues(q.GroupName, q.ProxyName).Set(float64(q.waiters.Len()))
+}
+
+// observe records how long a connection waited in the queue, and the result.
+func (q *WaitQueue) observe(result string, waited time.Duration) {
+	metrics.PoolWaitDuration.WithLabelValues(q.GroupName, q.ProxyName, result).Observe(waited.Seconds())
+}
+
+// signalWaiter wakes up the waiting connection of the element, if any, unless it is already woken up.
+func signalWaiter(element *list.Element) {
+	if element == nil {
+		return
+	}
+	if waiter, ok := element.Value.(chan struct{}); ok {
+		select {
+		case waiter <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPool is a pool of clients that are popped by the wait queue.
+type testPool struct {
+	mu      sync.Mutex
+	clients []*Client
+}
+
+func (p *testPool) pop() *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.clients) == 0 {
+		return nil
+	}
+	client := p.clients[0]
+	p.clients = p.clients[1:]
+	return client
+}
+
+func (p *testPool) put(queue *WaitQueue, client *Client) {
+	p.mu.Lock()
+	p.clients = append(p.clients, client)
+	p.mu.Unlock()
+	queue.notify()
+}
+
+// TestWaitQueue tests that the waiting connections acquire the returned clients in order,
+// and that they are rejected if the queue is full, or after the timeout.
+func TestWaitQueue(t *testing.T) {
+	queue := NewWaitQueue(context.Background(), WaitQueue{MaxLength: 2, Timeout: time.Second})
+	pool := &testPool{}
+
+	// The client is acquired right away while no connection waits.
+	first := &Client{ID: "first"}
+	pool.put(queue, first)
+	client, err := queue.acquire(pool.pop)
+	require.Nil(t, err)
+	assert.Same(t, first, client)
+
+	acquired := make(chan *Client, 2)
+	for range 2 {
+		length := queue.Len()
+		go func() {
+			client, err := queue.acquire(pool.pop)
+			assert.Nil(t, err)
+			acquired <- client
+		}()
+		require.Eventually(t, func() bool { return queue.Len() == length+1 }, time.Second, time.Millisecond)
+	}
+
+	// The queue is full.
+	_, err = queue.acquire(pool.pop)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodePoolExhausted, err.Code)
+
+	second, third := &Client{ID: "second"}, &Client{ID: "third"}
+	pool.put(queue, second)
+	assert.Same(t, second, <-acquired)
+	pool.put(queue, third)
+	assert.Same(t, third, <-acquired)
+	assert.Equal(t, 0, queue.Len())
+}
+
+// TestWaitQueueTimeout tests that the waiting connection gives up after the timeout, and
+// that the connections that arrive later are queued behind the waiting ones.
+func TestWaitQueueTimeout(t *testing.T) {
+	queue := NewWaitQueue(context.Background(), WaitQueue{MaxLength: 1, Timeout: 50 * time.Millisecond})
+	pool := &testPool{}
+
+	start := time.Now()
+	_, err := queue.acquire(pool.pop)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodePoolExhausted, err.Code)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, 0, queue.Len())
+
+	// Without a queue, the pool exhaustion is reported right away.
+	var noQueue *WaitQueue
+	_, err = noQueue.acquire(pool.pop)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodePoolExhausted, err.Code)
+}
+
+// TestProxyWaitQueue tests that the incoming connection waits for the server connection of
+// the exhausted pool, until it is returned to the pool.
+func TestProxyWaitQueue(t *testing.T) {
+	for _, poolMode := range []string{config.SessionPoolMode, config.TransactionPoolMode} {
+		t.Run(poolMode, func(t *testing.T) {
+			backend := NewFakeBackend(t)
+			proxy := newTestPooledProxy(t, backend, 1, poolMode)
+			proxy.WaitQueue = NewWaitQueue(context.Background(), WaitQueue{MaxLength: 1, Timeout: 5 * time.Second})
+
+			conn, client := NewTestIncomingConnection(t)
+			stack := NewStack()
+			require.Nil(t, proxy.Connect(conn))
+			roundTrip(t, proxy, conn, client, stack, CreatePgStartupPacket())
+			roundTrip(t, proxy, conn, client, stack, CreatePostgreSQLPacket(QueryMessage, []byte("BEGIN\x00")))
+
+			other, _ := NewTestIncomingConnection(t)
+			connected := make(chan *gerr.GatewayDError)
+			go func() {
+				connected <- proxy.Connect(other)
+			}()
+			require.Eventually(t, func() bool { return proxy.WaitQueue.Len() == 1 }, time.Second, time.Millisecond)
+
+			// The queue is full.
+			rejected, _ := NewTestIncomingConnection(t)
+			assert.Equal(t, gerr.ErrCodePoolExhausted, proxy.Connect(rejected).Code)
+
+			if poolMode == config.SessionPoolMode {
+				require.Nil(t, proxy.Disconnect(conn))
+			} else {
+				roundTrip(t, proxy, conn, client, stack, CreatePostgreSQLPacket(QueryMessage, []byte("COMMIT\x00")))
+			}
+			select {
+			case err := <-connected:
+				require.Nil(t, err)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "the incoming connection isn't connected")
+			}
+			assert.Equal(t, 0, proxy.WaitQueue.Len())
+			assert.Equal(t, 0, proxy.AvailableConnections.Size())
+		})
+	}
+}
+
+// TestProxyWaitQueueTransaction tests that the request of an authenticated session waits for a
+// server connection while the only one is in the transaction of another session.
+func TestProxyWaitQueueTransaction(t *testing.T) {
+	backend := NewFakeAuthBackend(t, "backend-password", nil)
+	proxy := newTestAuthProxy(t, backend, 1, config.TransactionPoolMode, NewAuthenticator(
+		context.Background(),
+		Authenticator{
+			Method:   config.AuthMethodSCRAMSHA256,
+			UserList: map[string]string{"alice": "password"},
+			Logger:   zerolog.Nop(),
+		},
+	))
+	proxy.WaitQueue = NewWaitQueue(context.Background(), WaitQueue{MaxLength: 1, Timeout: 5 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first, err := connectThroughProxy(t, proxy, "alice", "password")
+	require.NoError(t, err)
+	second, err := connectThroughProxy(t, proxy, "alice", "password")
+	require.NoError(t, err)
+
+	_, err = first.Exec(ctx, "BEGIN").ReadAll()
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := second.Exec(ctx, "SELECT 1").ReadAll()
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return proxy.WaitQueue.Len() == 1 }, time.Second, time.Millisecond)
+
+	_, err = first.Exec(ctx, "COMMIT").ReadAll()
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, proxy.WaitQueue.Len())
+}
+
+// TestServerPoolExhausted tests that the client is sent a too_many_connections error if the
+// pool stays exhausted, and that it's connected once a server connection is returned to the pool.
+func TestServerPoolExhausted(t *testing.T) {
+	backend := NewFakeBackend(t)
+	proxy := newTestPooledProxy(t, backend, 1, config.SessionPoolMode)
+	proxy.WaitQueue = NewWaitQueue(
+		context.Background(), WaitQueue{MaxLength: 1, Timeout: 100 * time.Millisecond})
+
+	server := NewServer(
+		context.Background(),
+		Server{
+			Network:                  "tcp",
+			Address:                  "127.0.0.1:15432",
+			Proxies:                  []IProxy{proxy},
+			Logger:                   zerolog.Nop(),
+			PluginRegistry:           proxy.PluginRegistry,
+			PluginTimeout:            config.DefaultPluginTimeout,
+			HandshakeTimeout:         config.DefaultHandshakeTimeout,
+			LoadbalancerStrategyName: config.RoundRobinStrategy,
+		},
+	)
+	require.NotNil(t, server)
+	// The server doesn't shut down when the connections are closed.
+	server.Status = config.Running
+
+	connect := func() (*pgconn.PgConn, error) {
+		conn, client := NewTestIncomingConnection(t)
+		_, action := server.OnOpen(conn)
+		require.Equal(t, None, action)
+
+		// Both goroutines that pass the traffic stop the connection.
+		stopConnection := make(chan struct{}, 2)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.OnTraffic(conn, stopConnection)
+			server.OnClose(conn, nil)
+		}()
+		// Stop passing the traffic before the proxy is shut down.
+		t.Cleanup(func() {
+			client.Close()
+			<-done
+		})
+
+		pgConfig, err := pgconn.ParseConfig("postgres://alice@127.0.0.1/postgres?sslmode=disable")
+		require.NoError(t, err)
+		pgConfig.DialFunc = func(context.Context, string, string) (net.Conn, error) {
+			return client, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return pgconn.ConnectConfig(ctx, pgConfig)
+	}
+
+	first, err := connect()
+	require.NoError(t, err)
+
+	// The pool stays exhausted for longer than the wait timeout.
+	_, err = connect()
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr), err)
+	assert.Equal(t, TooManyConnectionsCode, pgErr.Code)
+
+	// The server connection is returned to the pool within the wait timeout.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		first.Close(context.Background())
+	}()
+	second, err := connect()
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.Logins("alice", "postgres"))
+	second.Close(context.Background())
+}
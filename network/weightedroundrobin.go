@@ -59,10 +59,17 @@ func (r *WeightedRoundRobin) NextProxy(_ IConnWrapper) (IProxy, *gerr.GatewayDEr
 	}
 
 	var selected *weightedProxy
+	// The proxies with unhealthy servers are skipped, so the weights
+	// are only distributed among the healthy ones.
+	totalWeight := 0
 
 	// Adjust weights and select the proxy with the highest current weight.
 	for _, p := range r.proxies {
+		if !p.proxy.IsBackendHealthy() {
+			continue
+		}
 		p.currentWeight += p.effectiveWeight
+		totalWeight += p.effectiveWeight
 		if selected == nil || p.currentWeight > selected.currentWeight {
 			selected = p
 		}
@@ -70,11 +77,11 @@ func (r *WeightedRoundRobin) NextProxy(_ IConnWrapper) (IProxy, *gerr.GatewayDEr
 
 	// Reduce the selected proxy's current weight by the total weight.
 	if selected != nil {
-		selected.currentWeight -= r.totalWeight
+		selected.currentWeight -= totalWeight
 		return selected.proxy, nil
 	}
 
-	return nil, gerr.ErrNoProxiesAvailable.Wrap(errors.New("no proxy selected"))
+	return nil, gerr.ErrNoProxiesAvailable.Wrap(errNoHealthyProxies)
 }
 
 // findProxyByName locates a proxy by its name in the provided list of proxies.
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/gatewayd-io/gatewayd/config"
+	gerr "github.com/gatewayd-io/gatewayd/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -152,6 +153,38 @@ func TestWeightedRoundRobinNextProxy(t *testing.T) {
 	}
 }
 
+// TestWeightedRoundRobinSkipsUnhealthyProxies verifies that the requests are only distributed
+// among the proxies whose servers are healthy, according to their weights.
+func TestWeightedRoundRobinSkipsUnhealthyProxies(t *testing.T) {
+	proxies := []IProxy{
+		MockProxy{name: "proxy1"},
+		MockProxy{name: "proxy2", unhealthy: true},
+		MockProxy{name: "proxy3"},
+	}
+	loadBalancingRule := config.LoadBalancingRule{
+		Condition: config.DefaultLoadBalancerCondition,
+		Distribution: []config.Distribution{
+			{ProxyName: "proxy1", Weight: 3},
+			{ProxyName: "proxy2", Weight: 5},
+			{ProxyName: "proxy3", Weight: 1},
+		},
+	}
+	weightedRR := NewWeightedRoundRobin(&Server{Proxies: proxies}, loadBalancingRule)
+
+	counts := map[string]int{}
+	for range 400 {
+		proxy, err := weightedRR.NextProxy(nil)
+		require.Nil(t, err)
+		counts[proxy.GetName()]++
+	}
+	assert.Equal(t, map[string]int{"proxy1": 300, "proxy3": 100}, counts)
+
+	weightedRR = NewWeightedRoundRobin(&Server{Proxies: proxies[1:2]}, loadBalancingRule)
+	_, err := weightedRR.NextProxy(nil)
+	require.NotNil(t, err)
+	assert.Equal(t, gerr.ErrCodeNoProxiesAvailable, err.Code)
+}
+
 // TestWeightedRoundRobinConcurrentAccess tests the thread-safety of the
 // WeightedRoundRobin algorithm by simulating concurrent access through
 // multiple goroutines and ensuring the expected proxy distribution.
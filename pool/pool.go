@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gatewayd-io/gatewayd/config"
 	gerr "github.com/gatewayd-io/gatewayd/errors"
@@ -22,12 +23,14 @@ type IPool interface {
 	Size() int
 	Clear()
 	Cap() int
+	Resize(cap int)
 }
 
 type Pool struct {
 	pool sync.Map
-	cap  int
-	ctx  context.Context //nolint:containedctx
+	// cap is changed by Resize while the pool is used.
+	cap atomic.Int64
+	ctx context.Context //nolint:containedctx
 }
 
 var _ IPool = (*Pool)(nil)
@@ -53,7 +56,7 @@ func (p *Pool) Pool() *sync.Map {
 func (p *Pool) Put(key, value interface{}) *gerr.GatewayDError {
 	_, span := otel.Tracer(config.TracerName).Start(p.ctx, "Put")
 	defer span.End()
-	if p.cap > 0 && p.Size() >= p.cap {
+	if capacity := int(p.cap.Load()); capacity > 0 && p.Size() >= capacity {
 		span.RecordError(gerr.ErrPoolExhausted)
 		return gerr.ErrPoolExhausted
 	}
@@ -82,7 +85,7 @@ func (p *Pool) Get(key interface{}) interface{} {
 func (p *Pool) GetOrPut(key, value interface{}) (interface{}, bool, *gerr.GatewayDError) {
 	_, span := otel.Tracer(config.TracerName).Start(p.ctx, "GetOrPut")
 	defer span.End()
-	if p.cap > 0 && p.Size() >= p.cap {
+	if capacity := int(p.cap.Load()); capacity > 0 && p.Size() >= capacity {
 		span.RecordError(gerr.ErrPoolExhausted)
 		return nil, false, gerr.ErrPoolExhausted
 	}
@@ -145,7 +148,17 @@ func (p *Pool) Clear() {
 func (p *Pool) Cap() int {
 	_, span := otel.Tracer(config.TracerName).Start(p.ctx, "Cap")
 	defer span.End()
-	return p.cap
+	return int(p.cap.Load())
+}
+
+// Resize changes the capacity of the pool. The key/value pairs beyond the new capacity are
+// kept, but no more are added until the pool is below it.
+//
+//nolint:predeclared
+func (p *Pool) Resize(cap int) {
+	_, span := otel.Tracer(config.TracerName).Start(p.ctx, "Resize")
+	defer span.End()
+	p.cap.Store(int64(cap))
 }
 
 // NewPool creates a new pool with the given capacity.
@@ -155,9 +168,10 @@ func NewPool(ctx context.Context, cap int) *Pool {
 	poolCtx, span := otel.Tracer(config.TracerName).Start(ctx, "NewPool")
 	defer span.End()
 
-	return &Pool{
+	pool := &Pool{
 		pool: sync.Map{},
-		cap:  cap,
 		ctx:  poolCtx,
 	}
+	pool.cap.Store(int64(cap))
+	return pool
 }
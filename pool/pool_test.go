@@ -225,6 +225,24 @@ func TestPool_Cap(t *testing.T) {
 	assert.Equal(t, 1, pool.Cap())
 }
 
+func TestPool_Resize(t *testing.T) {
+	pool := NewPool(context.Background(), 2)
+	assert.Nil(t, pool.Put("client1.ID", "client1"))
+	assert.Nil(t, pool.Put("client2.ID", "client2"))
+
+	// The clients beyond the capacity are kept.
+	pool.Resize(1)
+	assert.Equal(t, 1, pool.Cap())
+	assert.Equal(t, 2, pool.Size())
+	assert.NotNil(t, pool.Put("client3.ID", "client3"))
+
+	pool.Resize(3)
+	assert.Equal(t, 3, pool.Cap())
+	assert.Nil(t, pool.Put("client3.ID", "client3"))
+	assert.Equal(t, 3, pool.Size())
+	pool.Clear()
+}
+
 func BenchmarkNewPool(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		NewPool(context.Background(), config.EmptyPoolCapacity)